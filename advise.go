@@ -0,0 +1,145 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// adviseMinRows is the number of rows a table must have before missing indexes matter
+	adviseMinRows = 10000
+	// adviseMinDeadRows is the number of dead rows a table must have before it is considered bloated
+	adviseMinDeadRows = 1000
+	// adviseDeadFraction is the fraction of dead rows that is considered bloated
+	adviseDeadFraction = 0.2
+	// adviseMaxValueLength is the length of the longest value that is not considered oversized
+	adviseMaxValueLength = 1 << 20
+	// adviseMaxRowSize is the size of a single HSTORE row that is considered too large,
+	// since the entire row is rewritten for every change
+	adviseMaxRowSize = 256 << 20
+	// adviseMinUnusedProps is the number of unused properties that is considered a bloated prop set
+	adviseMinUnusedProps = 100
+)
+
+// Advice is a suggestion for a data structure, found by Advise
+type Advice struct {
+	Kind       string // the kind of the data structure, like "hashmap2"
+	Name       string // the name that was given to the constructor
+	Problem    string // what was found
+	Suggestion string // what can be done about it
+}
+
+// String returns the advice as a single line
+func (a Advice) String() string {
+	return fmt.Sprintf("%s %s: %s. %s", a.Kind, a.Name, a.Problem, a.Suggestion)
+}
+
+// tableStats are the statistics PostgreSQL keeps for a table
+type tableStats struct {
+	seqScans, indexScans int64
+	liveRows, deadRows   int64
+	totalSize            int64
+	hasIndex, hasGIN     bool
+}
+
+// tableStats returns the statistics for the given quoted table
+func (host *Host) tableStats(quotedTable string) (tableStats, error) {
+	var stats tableStats
+	query := "SELECT COALESCE(s.seq_scan, 0), COALESCE(s.idx_scan, 0), COALESCE(s.n_live_tup, 0), COALESCE(s.n_dead_tup, 0), pg_total_relation_size(c.oid), " +
+		"EXISTS (SELECT 1 FROM pg_index AS i WHERE i.indrelid = c.oid AND NOT i.indisunique), " +
+		"EXISTS (SELECT 1 FROM pg_index AS i JOIN pg_class AS ic ON ic.oid = i.indexrelid JOIN pg_am AS a ON a.oid = ic.relam WHERE i.indrelid = c.oid AND a.amname = 'gin') " +
+		"FROM pg_class AS c LEFT JOIN pg_stat_user_tables AS s ON s.relid = c.oid WHERE c.oid = to_regclass($1)"
+	err := host.queryRow(query, quotedTable).Scan(&stats.seqScans, &stats.indexScans, &stats.liveRows, &stats.deadRows, &stats.totalSize, &stats.hasIndex, &stats.hasGIN)
+	return stats, err
+}
+
+// Advise inspects the statistics that PostgreSQL keeps for the tables of all
+// data structures that are found by Discover, and returns suggestions for
+// missing indexes, bloated tables and prop sets, and oversized values.
+// The statistics are collected by PostgreSQL over time, so the advice is only
+// useful for a database that has been in use for a while.
+func (host *Host) Advise() ([]Advice, error) {
+	structures, err := host.Discover()
+	if err != nil {
+		return []Advice{}, err
+	}
+	advice := []Advice{}
+	for _, s := range structures {
+		found, err := host.advise(s)
+		if err != nil {
+			return advice, err
+		}
+		advice = append(advice, found...)
+	}
+	return advice, nil
+}
+
+// advise returns the advice for a single data structure
+func (host *Host) advise(s Structure) ([]Advice, error) {
+	advice := []Advice{}
+	add := func(problem, suggestion string) {
+		advice = append(advice, Advice{s.Kind, s.Name, problem, suggestion})
+	}
+	stats, err := host.tableStats(s.Table)
+	if err != nil {
+		return advice, err
+	}
+
+	// Missing indexes
+	scanned := stats.liveRows >= adviseMinRows && stats.seqScans > stats.indexScans
+	switch {
+	case s.Kind == "hashmap" && scanned && !stats.hasGIN:
+		add(fmt.Sprintf("%d rows are scanned sequentially, %d times", stats.liveRows, stats.seqScans), "Use WithIndex to create a GIN index, which speeds up AllWhere.")
+	case s.Kind == "set" && scanned && !stats.hasIndex:
+		add(fmt.Sprintf("%d rows are scanned sequentially, %d times", stats.liveRows, stats.seqScans), "Use WithIndex to create an index, which speeds up Has and Add.")
+	}
+
+	// Dead rows
+	if stats.deadRows >= adviseMinDeadRows && float64(stats.deadRows) > adviseDeadFraction*float64(stats.liveRows) {
+		add(fmt.Sprintf("%d of %d rows are dead", stats.deadRows, stats.liveRows+stats.deadRows), "Run VACUUM, and use WithFillFactor or WithAutovacuumScaleFactor to keep up with the updates.")
+	}
+
+	// Oversized values and rows
+	var longest int64
+	switch s.Kind {
+	case "keyvalue", "hashmap2":
+		if stats.totalSize > adviseMaxRowSize {
+			add(fmt.Sprintf("all keys are stored in a single row of %d MiB, which is rewritten for every change", stats.totalSize>>20), "Move the data to a HashMap, which has one row per owner.")
+		}
+		err = host.queryRow(fmt.Sprintf("SELECT COALESCE(MAX(length(e.value)), 0) FROM %s AS t, each(t.attr) AS e", s.Table)).Scan(&longest)
+	case "hashmap":
+		err = host.queryRow(fmt.Sprintf("SELECT COALESCE(MAX(length(e.value)), 0) FROM %s AS t, each(t.attr) AS e", s.Table)).Scan(&longest)
+	case "list":
+		err = host.queryRow(fmt.Sprintf("SELECT COALESCE(MAX(length(%s)), 0) FROM %s", listCol, s.Table)).Scan(&longest)
+	case "set":
+		err = host.queryRow(fmt.Sprintf("SELECT COALESCE(MAX(length(%s)), 0) FROM %s", setCol, s.Table)).Scan(&longest)
+	}
+	if err != nil {
+		return advice, err
+	}
+	if longest > adviseMaxValueLength {
+		add(fmt.Sprintf("the longest value is %d KiB", longest>>10), "Store large values in a BlobStore, or use WithInterning if they are repeated.")
+	}
+
+	// Unused properties
+	if s.Kind == "hashmap2" {
+		var propTable string
+		err := host.queryRow(fmt.Sprintf("SELECT tbl FROM %s WHERE kind = 'set' AND name = $1 AND to_regclass(tbl) IS NOT NULL", metaTable), s.Name+"_encountered_property_keys").Scan(&propTable)
+		if err == sql.ErrNoRows {
+			return advice, nil
+		} else if err != nil {
+			return advice, err
+		}
+		var props, used int64
+		if err := host.queryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", propTable)).Scan(&props); err != nil {
+			return advice, err
+		}
+		if err := host.queryRow(fmt.Sprintf("SELECT COUNT(DISTINCT split_part(skeys, '%s', 2)) FROM (SELECT skeys(attr) FROM %s) AS temp", fieldSep, s.Table)).Scan(&used); err != nil {
+			return advice, err
+		}
+		if unused := props - used; unused >= adviseMinUnusedProps && unused > used {
+			add(fmt.Sprintf("%d of the %d encountered properties are not used by any owner", unused, props), "Run Fsck with repair to remove them.")
+		}
+	}
+	return advice, nil
+}
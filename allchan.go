@@ -0,0 +1,113 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sendAll runs the given query and sends the selected strings, decoded with
+// the given function, on the returned channel, which has room for bufSize
+// strings. The rows are read from the database as the strings are received,
+// so a slow receiver slows down the query instead of filling up the memory.
+// When all strings have been sent, or an error occurs, the string channel is
+// closed and the error, or nil, is sent on the error channel. Cancelling ctx
+// cancels the query, and ctx.Err() is then sent on the error channel.
+// If the host is bound to a transaction, all rows are read before the strings
+// are decoded and sent, since decoding may run queries of its own, which the
+// connection of the transaction can not run while it is sending rows.
+func (host *Host) sendAll(ctx context.Context, bufSize int, query string, decode func(*string) error) (<-chan string, <-chan error) {
+	values := make(chan string, bufSize)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(values)
+		errs <- host.WithContext(ctx).sendRows(ctx, values, query, decode)
+	}()
+	return values, errs
+}
+
+// sendRows sends the decoded strings that are selected by the query on the given channel
+func (host *Host) sendRows(ctx context.Context, values chan<- string, query string, decode func(*string) error) error {
+	rows, err := host.query(query)
+	if err != nil {
+		return err
+	}
+	// Closing the rows after ctx is cancelled does not wait for the rest of
+	// the rows, since the driver then also cancels the query on the server
+	defer rows.Close()
+	var (
+		value    sql.NullString
+		buffered []string
+	)
+	for rows.Next() {
+		if err := rows.Scan(&value); err != nil {
+			return err
+		}
+		s := value.String
+		if host.tx != nil {
+			buffered = append(buffered, s)
+			continue
+		}
+		if err := decode(&s); err != nil {
+			return err
+		}
+		select {
+		case values <- s:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		// The query may have been stopped with a driver error instead
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, s := range buffered {
+		if err := decode(&s); err != nil {
+			return err
+		}
+		select {
+		case values <- s:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// AllChan sends all elements of the list, in order, on the returned channel,
+// as they are read from the database. See Set.AllChan.
+func (l *List) AllChan(ctx context.Context, bufSize int) (<-chan string, <-chan error) {
+	return l.host.sendAll(ctx, bufSize, fmt.Sprintf("SELECT %s FROM %s ORDER BY id", listCol, l.tableName()), l.decode)
+}
+
+// AllChan sends all elements of the set on the returned channel, as they are
+// read from the database, for going through very large sets without holding
+// them in memory. The channel has room for bufSize elements, and the query
+// only reads more rows as the elements are received. The channel is closed
+// when all elements have been sent, and then the error channel receives nil,
+// or the error that stopped the query. Cancelling ctx stops the query.
+func (s *Set) AllChan(ctx context.Context, bufSize int) (<-chan string, <-chan error) {
+	return s.host.sendAll(ctx, bufSize, fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s", setCol, s.tableName(), setLive), s.decode)
+}
+
+// AllChan sends all keys that have not expired on the returned channel, as they are read from the
+// database. See Set.AllChan.
+func (kv *KeyValue) AllChan(ctx context.Context, bufSize int) (<-chan string, <-chan error) {
+	return kv.host.sendAll(ctx, bufSize, fmt.Sprintf("SELECT DISTINCT k FROM %s AS t CROSS JOIN skeys(t.attr) AS k WHERE %s", kv.tableName(), liveKey("k")), func(*string) error { return nil })
+}
+
+// AllChan sends all owners on the returned channel, as they are read from
+// the database. See Set.AllChan.
+func (hm2 *HashMap2) AllChan(ctx context.Context, bufSize int) (<-chan string, <-chan error) {
+	kv := hm2.keyValue()
+	query := fmt.Sprintf("SELECT DISTINCT split_part(skeys, '%s', 1) FROM (SELECT skeys(attr) FROM %s) AS temp WHERE position('%s' in skeys) > 0", fieldSep, kv.tableName(), fieldSep)
+	if hm2.columnLayout {
+		query = fmt.Sprintf("SELECT DISTINCT owner FROM %s", hm2.columnsTable())
+	}
+	return kv.host.sendAll(ctx, bufSize, query, func(*string) error { return nil })
+}
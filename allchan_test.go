@@ -0,0 +1,46 @@
+package simplehstore
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestAllChan(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, err := NewList(host, "allchan_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clear()
+	for i := 0; i < 100; i++ {
+		l.Add(strconv.Itoa(i))
+	}
+
+	values, errs := l.AllChan(context.Background(), 10)
+	i := 0
+	for value := range values {
+		if value != strconv.Itoa(i) {
+			t.Errorf("Error, expected %d, got %s", i, value)
+		}
+		i++
+	}
+	if err := <-errs; err != nil || i != 100 {
+		t.Errorf("Error, expected 100 elements, got %d (%v)", i, err)
+	}
+
+	// Cancelling stops the query
+	ctx, cancel := context.WithCancel(context.Background())
+	values, errs = l.AllChan(ctx, 1)
+	<-values
+	cancel()
+	for range values {
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("Error, expected context.Canceled, got %v", err)
+	}
+
+	l.Remove()
+}
@@ -0,0 +1,134 @@
+package simplehstore
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// apiKeyBytes is the number of random bytes in an API key
+const apiKeyBytes = 32
+
+// ErrInvalidKey is returned when an API key does not exist or has been revoked
+var ErrInvalidKey = errors.New("invalid API key")
+
+// APIKeyStore keeps API keys for programmatic access, together with
+// the owner and scopes of each key. Only a SHA-256 hash of each key is stored,
+// so a key can not be recovered after it has been handed out.
+type APIKeyStore struct {
+	dbDatastructure
+}
+
+// NewAPIKeyStore creates a new APIKeyStore
+func NewAPIKeyStore(provider HostProvider, name string, opts ...Option) (*APIKeyStore, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	a := &APIKeyStore{newDatastructure(host, pq.QuoteIdentifier(name), opts)}
+	if err := a.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := a.exists(a.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key_hash TEXT PRIMARY KEY, owner TEXT NOT NULL, scopes TEXT[] NOT NULL, created TIMESTAMPTZ NOT NULL DEFAULT now())", a.tableName())
+	if _, err := a.host.exec(query); err != nil {
+		return nil, err
+	}
+	if _, err := a.host.exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (owner)", pq.QuoteIdentifier(name+"_owner_idx"), a.tableName())); err != nil {
+		return nil, err
+	}
+	if err := a.register("apikeystore", name, a.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if a.verbose() {
+		a.host.Logger().Println("Created API key table " + a.tableName() + " in database " + host.dbname)
+	}
+	return a, nil
+}
+
+// CreateKey creates a new API key for the given owner, with the given scopes.
+// The returned key is only available at this point.
+func (a *APIKeyStore) CreateKey(owner string, scopes []string) (string, error) {
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	if scopes == nil {
+		scopes = []string{}
+	}
+	key := hex.EncodeToString(b)
+	_, err := a.host.exec(fmt.Sprintf("INSERT INTO %s (key_hash, owner, scopes) VALUES ($1, $2, $3)", a.tableName()), hashToken(key), owner, pq.Array(scopes))
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// Validate checks the given API key, and returns the owner and scopes of the key
+func (a *APIKeyStore) Validate(key string) (string, []string, error) {
+	var (
+		owner  string
+		scopes []string
+	)
+	err := a.host.queryRow(fmt.Sprintf("SELECT owner, scopes FROM %s WHERE key_hash = $1", a.tableName()), hashToken(key)).Scan(&owner, pq.Array(&scopes))
+	if err == sql.ErrNoRows {
+		return "", nil, ErrInvalidKey
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return owner, scopes, nil
+}
+
+// Revoke the given API key
+func (a *APIKeyStore) Revoke(key string) error {
+	result, err := a.host.exec(fmt.Sprintf("DELETE FROM %s WHERE key_hash = $1", a.tableName()), hashToken(key))
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// RevokeAll revokes all API keys for the given owner, and returns how many were revoked
+func (a *APIKeyStore) RevokeAll(owner string) (int64, error) {
+	result, err := a.host.exec(fmt.Sprintf("DELETE FROM %s WHERE owner = $1", a.tableName()), owner)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Count returns the number of API keys for the given owner
+func (a *APIKeyStore) Count(owner string) (int, error) {
+	var count int
+	err := a.host.queryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE owner = $1", a.tableName()), owner).Scan(&count)
+	return count, err
+}
+
+// Remove the table with API keys
+func (a *APIKeyStore) Remove() error {
+	if _, err := a.host.exec(fmt.Sprintf("DROP TABLE %s", a.tableName())); err != nil {
+		return err
+	}
+	return a.unregister("apikeystore", a.tableName())
+}
+
+// Clear all API keys
+func (a *APIKeyStore) Clear() error {
+	_, err := a.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", a.tableName()))
+	return err
+}
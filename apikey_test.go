@@ -0,0 +1,42 @@
+package simplehstore
+
+import (
+	"testing"
+)
+
+func TestAPIKeyStore(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	keys, err := NewAPIKeyStore(host, "apikey_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.Clear()
+
+	key, err := keys.CreateKey("bob", []string{"read", "write"})
+	if err != nil {
+		t.Fatalf("Error, could not create key! %s", err)
+	}
+	owner, scopes, err := keys.Validate(key)
+	if err != nil || owner != "bob" || len(scopes) != 2 || scopes[1] != "write" {
+		t.Errorf("Error, unexpected result from Validate: %s %v %v", owner, scopes, err)
+	}
+	if _, _, err := keys.Validate("not a key"); err != ErrInvalidKey {
+		t.Errorf("Error, expected ErrInvalidKey, got %v", err)
+	}
+	if err := keys.Revoke(key); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := keys.Validate(key); err != ErrInvalidKey {
+		t.Error("Error, a revoked key should not validate")
+	}
+
+	keys.CreateKey("alice", nil)
+	keys.CreateKey("alice", []string{"read"})
+	if n, err := keys.RevokeAll("alice"); err != nil || n != 2 {
+		t.Errorf("Error, expected 2 revoked keys, got %d (%v)", n, err)
+	}
+
+	keys.Remove()
+}
@@ -0,0 +1,94 @@
+package simplehstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ListArchive gives read access to the elements that have been moved out of a List by ArchiveOlderThan
+type ListArchive struct {
+	dbDatastructure
+}
+
+// Archive returns a handle to the archive of this list. The archive table is
+// created the first time ArchiveOlderThan is called.
+func (l *List) Archive() *ListArchive {
+	// strip double quotes from l.table and add _archive at the end
+	archiveTableName := strings.TrimSuffix(strings.TrimPrefix(l.table, "\""), "\"") + "_archive"
+	return &ListArchive{dbDatastructure{l.host, pq.QuoteIdentifier(archiveTableName), l.options}}
+}
+
+// ArchiveOlderThan moves all elements that were added before t to the archive
+// table of the list, in one statement, and returns how many were moved.
+// Lists that were created by earlier versions of this package do not record
+// when elements are added, so for those lists, all existing elements are
+// counted as being added the first time this function is called.
+func (l *List) ArchiveOlderThan(t time.Time) (int64, error) {
+	if err := l.addCreatedColumn(); err != nil {
+		return 0, err
+	}
+	archive := l.Archive()
+	if _, err := l.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, %s %s, created TIMESTAMPTZ NOT NULL, archived TIMESTAMPTZ NOT NULL DEFAULT now())", archive.tableName(), listCol, defaultStringType)); err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("WITH moved AS (DELETE FROM %s WHERE created < $1 RETURNING id, %s, created) INSERT INTO %s (id, %s, created) SELECT id, %s, created FROM moved", l.tableName(), listCol, archive.tableName(), listCol, listCol)
+	result, err := l.host.exec(query, t)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// addCreatedColumn adds the created column to lists that were created by earlier versions
+func (l *List) addCreatedColumn() error {
+	return l.addColumn(l.tableName(), "created", "TIMESTAMPTZ NOT NULL DEFAULT now()")
+}
+
+// values runs a query that returns one column of encoded values, and returns the decoded values
+func (a *ListArchive) values(query string, args ...interface{}) ([]string, error) {
+	var values []string
+	rows, err := a.host.query(query, args...)
+	if err != nil {
+		return values, err
+	}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return values, err
+		}
+		values = append(values, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return values, err
+	}
+	return values, a.decodeAll(values)
+}
+
+// All returns all archived elements, in the order they were added to the list
+func (a *ListArchive) All() ([]string, error) {
+	return a.values(fmt.Sprintf("SELECT %s FROM %s ORDER BY id", listCol, a.tableName()))
+}
+
+// Between returns the archived elements that were added to the list from
+// "from" and up to, but not including, "to", in the order they were added
+func (a *ListArchive) Between(from, to time.Time) ([]string, error) {
+	return a.values(fmt.Sprintf("SELECT %s FROM %s WHERE created >= $1 AND created < $2 ORDER BY id", listCol, a.tableName()), from, to)
+}
+
+// Count returns the number of archived elements
+func (a *ListArchive) Count() (int, error) {
+	var count int
+	err := a.host.queryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", a.tableName())).Scan(&count)
+	return count, err
+}
+
+// Remove the archive table
+func (a *ListArchive) Remove() error {
+	_, err := a.host.exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", a.tableName()))
+	return err
+}
@@ -0,0 +1,39 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListArchive(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, err := NewList(host, "archive_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clear()
+	archive := l.Archive()
+	archive.Remove()
+
+	l.Add("old1")
+	l.Add("old2")
+	n, err := l.ArchiveOlderThan(time.Now().Add(time.Minute))
+	if err != nil || n != 2 {
+		t.Fatalf("Error, expected two archived elements, got %d (%v)", n, err)
+	}
+	l.Add("new")
+	if n, _ := l.ArchiveOlderThan(time.Now().Add(-time.Minute)); n != 0 {
+		t.Errorf("Error, expected no archived elements, got %d", n)
+	}
+	if all, _ := l.All(); len(all) != 1 || all[0] != "new" {
+		t.Errorf("Error, expected only the new element in the list, got %v", all)
+	}
+	if all, err := archive.All(); err != nil || len(all) != 2 || all[0] != "old1" {
+		t.Errorf("Error, expected the old elements in the archive, got %v (%v)", all, err)
+	}
+
+	archive.Remove()
+	l.Remove()
+}
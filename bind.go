@@ -0,0 +1,103 @@
+package simplehstore
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+// Bound gives lightweight access to data structures, where all operations
+// inherit the context that was given to Host.Bind. No tables are created
+// when a data structure is retrieved, so the data structure must already
+// have been created with one of the regular constructors, like NewList.
+type Bound struct {
+	host *Host
+}
+
+// Bind returns a Bound, for retrieving data structures where all operations
+// are run with the given context. This is useful for request-scoped handles
+// in web handlers, where the request context should be respected.
+func (host *Host) Bind(ctx context.Context) *Bound {
+	return &Bound{host.WithContext(ctx)}
+}
+
+// Host returns the host that all bound data structures use
+func (b *Bound) Host() *Host {
+	return b.host
+}
+
+// List returns a bound handle to an existing List, which uses the codec the
+// List was written with. The options should be the same as when the List was created.
+func (b *Bound) List(name string, opts ...Option) (*List, error) {
+	l := &List{newDatastructure(b.host, pq.QuoteIdentifier(name), opts)}
+	return l, l.load("list", l.tableName())
+}
+
+// Set returns a bound handle to an existing Set, which uses the codec the
+// Set was written with. The options should be the same as when the Set was created.
+func (b *Bound) Set(name string, opts ...Option) (*Set, error) {
+	s := &Set{newDatastructure(b.host, pq.QuoteIdentifier(name), opts)}
+	return s, s.load("set", s.tableName())
+}
+
+// KeyValue returns a bound handle to an existing KeyValue, which uses the
+// codec the KeyValue was written with. The options should be the same as
+// when the KeyValue was created.
+func (b *Bound) KeyValue(name string, opts ...Option) (*KeyValue, error) {
+	kv := &KeyValue{newDatastructure(b.host, name, opts)}
+	return kv, kv.load("keyvalue", kv.tableName())
+}
+
+// HashMap returns a bound handle to an existing HashMap, which uses the
+// codec the HashMap was written with. The options should be the same as
+// when the HashMap was created.
+func (b *Bound) HashMap(name string, opts ...Option) (*HashMap, error) {
+	h := &HashMap{newDatastructure(b.host, pq.QuoteIdentifier(name), opts)}
+	return h, h.load("hashmap", h.tableName())
+}
+
+// HashMap2 returns a bound handle to an existing HashMap2, which uses the
+// codec the HashMap2 was written with. The options should be the same as
+// when the HashMap2 was created.
+func (b *Bound) HashMap2(name string, opts ...Option) (*HashMap2, error) {
+	var hm2 HashMap2
+	hm2.dbDatastructure = newDatastructure(b.host, name+"_properties_HSTORE_map", opts)
+	hm2.seenPropTable = pq.QuoteIdentifier(name + "_encountered_property_keys")
+	// The codec is pinned by the KeyValue the HashMap2 is built on
+	return &hm2, hm2.load("keyvalue", hm2.keyValue().tableName())
+}
+
+// WithContext returns a copy of the list, where all operations are run with the given context
+func (l *List) WithContext(ctx context.Context) *List {
+	boundList := *l
+	boundList.host = l.host.WithContext(ctx)
+	return &boundList
+}
+
+// WithContext returns a copy of the set, where all operations are run with the given context
+func (s *Set) WithContext(ctx context.Context) *Set {
+	boundSet := *s
+	boundSet.host = s.host.WithContext(ctx)
+	return &boundSet
+}
+
+// WithContext returns a copy of the key/value, where all operations are run with the given context
+func (kv *KeyValue) WithContext(ctx context.Context) *KeyValue {
+	boundKV := *kv
+	boundKV.host = kv.host.WithContext(ctx)
+	return &boundKV
+}
+
+// WithContext returns a copy of the hash map, where all operations are run with the given context
+func (h *HashMap) WithContext(ctx context.Context) *HashMap {
+	boundHashMap := *h
+	boundHashMap.host = h.host.WithContext(ctx)
+	return &boundHashMap
+}
+
+// WithContext returns a copy of the hash map, where all operations are run with the given context
+func (hm2 *HashMap2) WithContext(ctx context.Context) *HashMap2 {
+	boundHM2 := *hm2
+	boundHM2.host = hm2.host.WithContext(ctx)
+	return &boundHM2
+}
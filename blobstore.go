@@ -0,0 +1,189 @@
+package simplehstore
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrNoSuchBlob is returned when a blob with the given name does not exist
+var ErrNoSuchBlob = errors.New("no such blob")
+
+// BlobStore stores named blobs of bytes, like uploaded files or avatars,
+// together with their content type, size and SHA-256 checksum.
+// The contents are stored in chunks and can be streamed in and out.
+type BlobStore struct {
+	dbDatastructure
+	chunkTable string // quoted name of the table with the contents
+}
+
+// BlobInfo is the metadata for a blob
+type BlobInfo struct {
+	Name        string
+	ContentType string
+	Size        int64
+	SHA256      string // hex encoded
+	Modified    time.Time
+}
+
+// NewBlobStore creates a new BlobStore
+func NewBlobStore(provider HostProvider, name string, opts ...Option) (*BlobStore, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	b := &BlobStore{newDatastructure(host, pq.QuoteIdentifier(name), opts), pq.QuoteIdentifier(name + "_chunks")}
+	if err := b.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := b.exists(b.tableName())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY, content_type TEXT NOT NULL, size BIGINT NOT NULL, sha256 TEXT NOT NULL, modified TIMESTAMPTZ NOT NULL DEFAULT now())", b.tableName())); err != nil {
+		return nil, err
+	}
+	if err := b.chunks().create(); err != nil {
+		return nil, err
+	}
+	if err := b.register("blobstore", name, b.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if b.verbose() {
+		b.host.Logger().Println("Created blob store table " + b.tableName() + " in database " + host.dbname)
+	}
+	return b, nil
+}
+
+// chunks returns the chunk table for the contents of the blobs
+func (b *BlobStore) chunks() *chunkTable {
+	return &chunkTable{b.host, b.qualify(b.chunkTable)}
+}
+
+// Put stores a blob by reading from r until EOF, replacing any existing blob with the same name.
+// The contents and the metadata are stored in a single transaction.
+func (b *BlobStore) Put(name, contentType string, r io.Reader) (*BlobInfo, error) {
+	ctx := b.host.context()
+	transaction, err := b.host.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	size, err := b.chunks().write(ctx, transaction, name, io.TeeReader(r, hasher))
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	info := &BlobInfo{
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hasher.Sum(nil)),
+	}
+	query := fmt.Sprintf("INSERT INTO %s (name, content_type, size, sha256) VALUES ($1, $2, $3, $4) ON CONFLICT (name) DO UPDATE SET content_type = EXCLUDED.content_type, size = EXCLUDED.size, sha256 = EXCLUDED.sha256, modified = now() RETURNING modified", b.tableName())
+	if err := transaction.QueryRowContext(ctx, query, info.Name, info.ContentType, info.Size, info.SHA256).Scan(&info.Modified); err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Stat returns the metadata for a blob, or ErrNoSuchBlob
+func (b *BlobStore) Stat(name string) (*BlobInfo, error) {
+	info := &BlobInfo{Name: name}
+	err := b.host.queryRow(fmt.Sprintf("SELECT content_type, size, sha256, modified FROM %s WHERE name = $1", b.tableName()), name).Scan(&info.ContentType, &info.Size, &info.SHA256, &info.Modified)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSuchBlob
+	}
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Get writes the contents of a blob to w, one chunk at a time, and returns the metadata
+func (b *BlobStore) Get(name string, w io.Writer) (*BlobInfo, error) {
+	info, err := b.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.chunks().read(name, w); err != nil {
+		if err == ErrNoLargeValue {
+			return nil, ErrNoSuchBlob
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+// Has checks if a blob with the given name exists
+func (b *BlobStore) Has(name string) (bool, error) {
+	if _, err := b.Stat(name); err != nil {
+		if err == ErrNoSuchBlob {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// All returns the names of all blobs, sorted by name
+func (b *BlobStore) All() ([]string, error) {
+	var (
+		values []string
+		value  string
+	)
+	rows, err := b.host.query(fmt.Sprintf("SELECT name FROM %s ORDER BY name", b.tableName()))
+	if err != nil {
+		return values, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&value); err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// Del removes a blob
+func (b *BlobStore) Del(name string) error {
+	ctx := b.host.context()
+	transaction, err := b.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE name = $1", b.tableName()), name); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE key = $1", b.chunks().table), name); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+// Remove this blob store
+func (b *BlobStore) Remove() error {
+	if _, err := b.host.exec(fmt.Sprintf("DROP TABLE %s, %s", b.tableName(), b.chunks().table)); err != nil {
+		return err
+	}
+	return b.unregister("blobstore", b.tableName())
+}
+
+// Clear removes all blobs
+func (b *BlobStore) Clear() error {
+	_, err := b.host.exec(fmt.Sprintf("TRUNCATE TABLE %s, %s", b.tableName(), b.chunks().table))
+	return err
+}
@@ -0,0 +1,47 @@
+package simplehstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBlobStore(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	blobs, err := NewBlobStore(host, "blobstore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs.Clear()
+
+	data := []byte("not really a png")
+	sum := sha256.Sum256(data)
+	info, err := blobs.Put("avatar.png", "image/png", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error, could not store blob! %s", err)
+	}
+	if info.Size != int64(len(data)) || info.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Error, unexpected blob metadata: %+v", info)
+	}
+
+	var buf bytes.Buffer
+	info, err = blobs.Get("avatar.png", &buf)
+	if err != nil {
+		t.Fatalf("Error, could not retrieve blob! %s", err)
+	}
+	if info.ContentType != "image/png" || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Error, unexpected blob: %+v %q", info, buf.String())
+	}
+
+	if err := blobs.Del("avatar.png"); err != nil {
+		t.Errorf("Error, could not remove blob! %s", err)
+	}
+	if _, err := blobs.Stat("avatar.png"); err != ErrNoSuchBlob {
+		t.Errorf("Error, expected ErrNoSuchBlob, got %v", err)
+	}
+
+	blobs.Remove()
+}
@@ -0,0 +1,209 @@
+package simplehstore
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultBloomCacheTTL is how long the filter is cached by MayContain, by default
+const DefaultBloomCacheTTL = 10 * time.Second
+
+// BloomFilter is a persisted Bloom filter, stored as a BYTEA value. A Bloom
+// filter can tell if a value is definitely not in a large set, without asking
+// the database, while a positive answer must be checked against the set.
+// The filter is cached locally by MayContain, so values that are added by other
+// processes may not be seen until the cache is refreshed.
+type BloomFilter struct {
+	dbDatastructure
+	m, k     uint32 // the number of bits, and the number of hashes per value
+	cacheTTL time.Duration
+
+	mut      sync.RWMutex
+	cache    []byte
+	loadedAt time.Time
+}
+
+// bloomParameters returns the number of bits and the number of hash functions
+// that gives the given false positive rate for the given number of values
+func bloomParameters(expected int, falsePositiveRate float64) (m, k uint32) {
+	if expected < 1 {
+		expected = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	bits := math.Ceil(-float64(expected) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	m = uint32(math.Max(8, bits))
+	k = uint32(math.Max(1, math.Round(float64(m)/float64(expected)*math.Ln2)))
+	return m, k
+}
+
+// bloomPositions returns the k bit positions for the given value, using double hashing
+func bloomPositions(value string, m, k uint32) []uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+	positions := make([]uint32, k)
+	for i := uint32(0); i < k; i++ {
+		positions[i] = (h1 + i*h2) % m
+	}
+	return positions
+}
+
+// NewBloomFilter creates a new Bloom filter that is sized for the given number
+// of values and false positive rate. If the filter already exists, it keeps
+// the size it was created with.
+func NewBloomFilter(provider HostProvider, name string, expected int, falsePositiveRate float64, opts ...Option) (*BloomFilter, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	bf := &BloomFilter{dbDatastructure: newDatastructure(host, pq.QuoteIdentifier(name), opts), cacheTTL: DefaultBloomCacheTTL}
+	if err := bf.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := bf.exists(bf.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("%s %s (single BOOLEAN PRIMARY KEY DEFAULT true CHECK (single), m INTEGER NOT NULL, k INTEGER NOT NULL, bits BYTEA NOT NULL)", bf.createTable(), bf.tableName())
+	if _, err := bf.host.exec(query); err != nil {
+		return nil, err
+	}
+	m, k := bloomParameters(expected, falsePositiveRate)
+	query = fmt.Sprintf("INSERT INTO %s (m, k, bits) VALUES ($1, $2, $3) ON CONFLICT (single) DO NOTHING", bf.tableName())
+	if _, err := bf.host.exec(query, m, k, make([]byte, (m+7)/8)); err != nil {
+		return nil, err
+	}
+	if err := bf.host.queryRow(fmt.Sprintf("SELECT m, k FROM %s", bf.tableName())).Scan(&bf.m, &bf.k); err != nil {
+		return nil, err
+	}
+	if err := bf.register("bloomfilter", name, bf.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if bf.verbose() {
+		bf.host.Logger().Println("Created bloom filter table " + bf.tableName() + " in database " + host.dbname)
+	}
+	return bf, nil
+}
+
+// SetCacheTTL sets how long the filter is cached by MayContain. 0 disables caching.
+func (bf *BloomFilter) SetCacheTTL(ttl time.Duration) {
+	bf.mut.Lock()
+	bf.cacheTTL = ttl
+	bf.cache = nil
+	bf.mut.Unlock()
+}
+
+// Add a value to the filter. The bits are set in the database, and in the
+// local cache, if it has been loaded.
+func (bf *BloomFilter) Add(value string) error {
+	positions := bloomPositions(value, bf.m, bf.k)
+	expr := "bits"
+	args := make([]interface{}, len(positions))
+	for i, pos := range positions {
+		expr = fmt.Sprintf("set_bit(%s, $%d, 1)", expr, i+1)
+		args[i] = pos
+	}
+	if _, err := bf.host.exec(fmt.Sprintf("UPDATE %s SET bits = %s", bf.tableName(), expr), args...); err != nil {
+		return err
+	}
+	bf.mut.Lock()
+	if bf.cache != nil {
+		// Copy the cache, since it may be in use by MayContain
+		cache := append([]byte(nil), bf.cache...)
+		for _, pos := range positions {
+			cache[pos/8] |= 1 << (pos % 8)
+		}
+		bf.cache = cache
+	}
+	bf.mut.Unlock()
+	return nil
+}
+
+// MayContain returns false if the value has definitely not been added to the
+// filter, and true if it may have been added. The filter is read from the
+// database at most once per cache TTL.
+func (bf *BloomFilter) MayContain(value string) (bool, error) {
+	bits, err := bf.bits()
+	if err != nil {
+		return false, err
+	}
+	for _, pos := range bloomPositions(value, bf.m, bf.k) {
+		if bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// bits returns the cached bits, or reads them from the database if the cache has expired
+func (bf *BloomFilter) bits() ([]byte, error) {
+	bf.mut.RLock()
+	cache, loadedAt, ttl := bf.cache, bf.loadedAt, bf.cacheTTL
+	bf.mut.RUnlock()
+	if cache != nil && time.Since(loadedAt) < ttl {
+		return cache, nil
+	}
+	var bits []byte
+	if err := bf.host.queryRow(fmt.Sprintf("SELECT bits FROM %s", bf.tableName())).Scan(&bits); err != nil {
+		return nil, err
+	}
+	if uint32(len(bits))*8 < bf.m {
+		return nil, fmt.Errorf("the bloom filter in %s has %d bytes, expected %d", bf.tableName(), len(bits), (bf.m+7)/8)
+	}
+	bf.mut.Lock()
+	bf.cache = bits
+	bf.loadedAt = time.Now()
+	bf.mut.Unlock()
+	return bits, nil
+}
+
+// Rebuild replaces the contents of the filter with all the elements of the
+// given set, in a single update. This is useful after removing elements from
+// the set, since values can not be removed from a Bloom filter.
+func (bf *BloomFilter) Rebuild(s *Set) error {
+	values, err := s.All()
+	if err != nil {
+		return err
+	}
+	bits := make([]byte, (bf.m+7)/8)
+	for _, value := range values {
+		for _, pos := range bloomPositions(value, bf.m, bf.k) {
+			bits[pos/8] |= 1 << (pos % 8)
+		}
+	}
+	if _, err := bf.host.exec(fmt.Sprintf("UPDATE %s SET bits = $1", bf.tableName()), bits); err != nil {
+		return err
+	}
+	bf.mut.Lock()
+	bf.cache = bits
+	bf.loadedAt = time.Now()
+	bf.mut.Unlock()
+	return nil
+}
+
+// Clear the filter, by unsetting all bits
+func (bf *BloomFilter) Clear() error {
+	if _, err := bf.host.exec(fmt.Sprintf("UPDATE %s SET bits = $1", bf.tableName()), make([]byte, (bf.m+7)/8)); err != nil {
+		return err
+	}
+	bf.mut.Lock()
+	bf.cache = nil
+	bf.mut.Unlock()
+	return nil
+}
+
+// Remove the filter
+func (bf *BloomFilter) Remove() error {
+	if _, err := bf.host.exec(fmt.Sprintf("DROP TABLE %s", bf.tableName())); err != nil {
+		return err
+	}
+	return bf.unregister("bloomfilter", bf.tableName())
+}
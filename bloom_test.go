@@ -0,0 +1,69 @@
+package simplehstore
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBloomParameters(t *testing.T) {
+	// One million values with a 1% false positive rate needs about 9.6 million bits and 7 hashes
+	m, k := bloomParameters(1000000, 0.01)
+	if m < 9500000 || m > 9700000 || k != 7 {
+		t.Errorf("Error, unexpected bloom filter parameters: %d bits and %d hashes", m, k)
+	}
+	positions := bloomPositions("bob", m, k)
+	if len(positions) != int(k) {
+		t.Errorf("Error, expected %d positions, got %d", k, len(positions))
+	}
+	for i, pos := range bloomPositions("bob", m, k) {
+		if pos >= m || pos != positions[i] {
+			t.Errorf("Error, unexpected bit position: %d", pos)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	bf, err := NewBloomFilter(host, "bloom_test", 1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bf.Clear()
+	for i := 0; i < 100; i++ {
+		if err := bf.Add("user" + strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if found, err := bf.MayContain("user" + strconv.Itoa(i)); err != nil || !found {
+			t.Errorf("Error, expected user%d to maybe be in the filter (%v)", i, err)
+		}
+	}
+	falsePositives := 0
+	for i := 100; i < 1100; i++ {
+		if found, _ := bf.MayContain("user" + strconv.Itoa(i)); found {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("Error, too many false positives: %d", falsePositives)
+	}
+
+	set, err := NewSet(host, "bloom_test_set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Clear()
+	set.Add("alice")
+	if err := bf.Rebuild(set); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := bf.MayContain("alice"); err != nil || !found {
+		t.Errorf("Error, expected alice to maybe be in the filter after rebuilding (%v)", err)
+	}
+
+	set.Remove()
+	bf.Remove()
+}
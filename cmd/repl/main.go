@@ -68,10 +68,9 @@ func main() {
 	}
 	defer host.Close()
 
-	host.SetRawUTF8(true)
 	simplehstore.Verbose = true
 
-	hashmap, err := simplehstore.NewHashMap2(host, "devices")
+	hashmap, err := simplehstore.NewHashMap2(host, "devices", simplehstore.WithCodec(simplehstore.RawCodec))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return
@@ -89,6 +88,20 @@ LOOP:
 				} else {
 					fmt.Println("all")
 				}
+			case "advise":
+				if len(fields) == 1 {
+					if advice, err := host.Advise(); err != nil {
+						checkError(err)
+					} else if len(advice) == 0 {
+						fmt.Println("no advice")
+					} else {
+						for _, a := range advice {
+							fmt.Println(a)
+						}
+					}
+				} else {
+					fmt.Println("advise")
+				}
 			case "allwhere":
 				if len(fields) == 3 {
 					checkSliceError(hashmap.AllWhere(fields[1], fields[2]))
@@ -101,6 +114,16 @@ LOOP:
 				} else {
 					fmt.Println("clear")
 				}
+			case "config":
+				if len(fields) == 1 {
+					if config, err := host.Config(); err != nil {
+						checkError(err)
+					} else {
+						fmt.Print(config)
+					}
+				} else {
+					fmt.Println("config")
+				}
 			case "d":
 				if len(fields) == 2 {
 					checkError(hashmap.Del(fields[1]))
@@ -141,6 +164,26 @@ LOOP:
 					fmt.Println("has o k")
 				}
 
+			case "import":
+				if len(fields) == 2 {
+					checkError(host.ImportFixtures(fields[1]))
+				} else {
+					fmt.Println("import f")
+				}
+			case "export":
+				if len(fields) == 2 {
+					if f, err := host.ExportFixtures(); err != nil {
+						checkError(err)
+					} else if file, err := os.Create(fields[1]); err != nil {
+						checkError(err)
+					} else {
+						err = f.Write(file)
+						file.Close()
+						checkError(err)
+					}
+				} else {
+					fmt.Println("export f")
+				}
 			case "keys":
 				if len(fields) == 2 {
 					checkSliceError(hashmap.Keys(fields[1]))
@@ -175,6 +218,22 @@ LOOP:
 				} else {
 					fmt.Println("p")
 				}
+			case "fsck":
+				if len(fields) == 1 || (len(fields) == 2 && fields[1] == "repair") {
+					report, err := hashmap.Fsck(len(fields) == 2)
+					if err != nil {
+						checkError(err)
+					} else {
+						fmt.Printf("%d keys checked\n", report.Keys)
+						fmt.Printf("keys without separator: %v\n", report.MissingSeparator)
+						fmt.Printf("missing properties: %v\n", report.MissingProps)
+						fmt.Printf("orphaned properties: %v\n", report.OrphanedProps)
+						fmt.Printf("wrongly encoded values: %v\n", report.Misencoded)
+						fmt.Printf("%d problems repaired\n", report.Repaired)
+					}
+				} else {
+					fmt.Println("fsck [repair]")
+				}
 			case "get":
 				if len(fields) == 3 {
 					checkStringError(hashmap.Get(fields[1], fields[2]))
@@ -243,25 +302,30 @@ LOOP:
 					fmt.Println("remove")
 				}
 				fmt.Println("creating a new one")
-				hashmap, err = simplehstore.NewHashMap2(host, "devices")
+				hashmap, err = simplehstore.NewHashMap2(host, "devices", simplehstore.WithCodec(simplehstore.RawCodec))
 				checkError(err)
 			case "exit", "quit":
 				fmt.Println(strings.Title(fields[0]))
 				break LOOP
 			case "help", "?", "h":
+				fmt.Println("advise - suggest improvements, based on the table statistics")
 				fmt.Println("all - list all owners")
 				fmt.Println("allwhere k v - list all owners where k == v")
 				fmt.Println("clear - remove all data in this table")
+				fmt.Println("config - show the configuration of the database connection")
 				fmt.Println("d o [k]")
 				fmt.Println("del o")
 				fmt.Println("delkey o k")
 				fmt.Println("empty - check if the table is empty")
 				fmt.Println("exists o - check if owner exists")
 				fmt.Println("exit - exit the repl")
+				fmt.Println("export f - write all lists, sets, key/values and hash maps to a JSON fixture file")
+				fmt.Println("fsck [repair] - check the hash map for problems, and optionally repair them")
 				fmt.Println("get o k - get the value of a key of an owner")
 				fmt.Println("getmap o k k k ... - from an owner, get a map of keys and values")
 				fmt.Println("has o k - check if an owner has a key")
 				fmt.Println("help - this text")
+				fmt.Println("import f - load a JSON fixture file")
 				fmt.Println("keys o - list all keys for an owner")
 				fmt.Println("l [o] [k] - list all owners, all keys for an owner or the value for a key")
 				fmt.Println("p - list all encountered properties")
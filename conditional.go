@@ -0,0 +1,82 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ensureRow creates the single row of the key/value table, if the table is empty
+func (kv *KeyValue) ensureRow() error {
+	_, err := kv.host.exec(fmt.Sprintf("INSERT INTO %s (attr) VALUES (hstore('')) ON CONFLICT ((true)) DO NOTHING", kv.tableName()))
+	return err
+}
+
+// SetNX sets the key to the value, but only if the key does not exist, and
+// returns true if the value was set. Keys that have expired count as missing.
+func (kv *KeyValue) SetNX(key, value string) (bool, error) {
+	if err := kv.encode(&value); err != nil {
+		return false, err
+	}
+	query := fmt.Sprintf("INSERT INTO %s AS t (attr) VALUES (hstore($1, $2)) ON CONFLICT ((true)) DO UPDATE SET attr = t.attr || EXCLUDED.attr, expires = delete(t.expires, $1) WHERE NOT t.attr ? $1 OR (t.expires -> $1)::timestamptz <= now()", kv.tableName())
+	result, err := kv.host.exec(query, key, value)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetSet sets the key to the value, and returns the previous value, or "" if
+// the key did not exist. The previous value is read while holding the row
+// lock, so that it is the value that is replaced.
+func (kv *KeyValue) GetSet(key, value string) (string, error) {
+	if err := kv.encode(&value); err != nil {
+		return "", err
+	}
+	if err := kv.ensureRow(); err != nil {
+		return "", err
+	}
+	ctx := kv.host.context()
+	transaction, err := kv.host.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	var previous sql.NullString
+	query := fmt.Sprintf("SELECT CASE WHEN (expires -> $1)::timestamptz <= now() THEN NULL ELSE attr -> $1 END FROM %s FOR UPDATE", kv.tableName())
+	if err := transaction.QueryRowContext(ctx, query, key).Scan(&previous); err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+	query = fmt.Sprintf("UPDATE %s SET attr = attr || hstore($1, $2), expires = delete(expires, $1)", kv.tableName())
+	if _, err := transaction.ExecContext(ctx, query, key, value); err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+	if err := transaction.Commit(); err != nil {
+		return "", err
+	}
+	s := previous.String
+	if err := kv.decode(&s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// CAS sets the key to newValue, but only if the current value is oldValue,
+// and returns true if the value was set. Keys that do not exist or that have
+// expired never match.
+func (kv *KeyValue) CAS(key, oldValue, newValue string) (bool, error) {
+	if err := kv.encode(&oldValue); err != nil {
+		return false, err
+	}
+	if err := kv.encode(&newValue); err != nil {
+		return false, err
+	}
+	query := fmt.Sprintf("UPDATE %s SET attr = attr || hstore($1, $3), expires = delete(expires, $1) WHERE attr -> $1 = $2 AND NOT COALESCE((expires -> $1)::timestamptz <= now(), false)", kv.tableName())
+	result, err := kv.host.exec(query, key, oldValue, newValue)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
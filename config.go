@@ -0,0 +1,224 @@
+package simplehstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config is a configuration store on top of a KeyValue, with typed getters,
+// validation and change notifications, so that services can reload their
+// configuration without being restarted.
+type Config struct {
+	kv      *KeyValue
+	channel string
+
+	mut        sync.RWMutex
+	validators map[string]func(value string) error
+	handlers   []func(key, value string)
+}
+
+// NewConfig creates a new configuration store
+func NewConfig(provider HostProvider, name string, opts ...Option) (*Config, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := NewKeyValue(host, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{
+		kv:         kv,
+		channel:    "simplehstore_config_" + name,
+		validators: make(map[string]func(string) error),
+	}, nil
+}
+
+// SetValidator registers a function that must accept a value before it can be set for the given key
+func (c *Config) SetValidator(key string, validate func(value string) error) {
+	c.mut.Lock()
+	c.validators[key] = validate
+	c.mut.Unlock()
+}
+
+// validate checks the value for the given key with the registered validator, if any
+func (c *Config) validate(key, value string) error {
+	c.mut.RLock()
+	validate, ok := c.validators[key]
+	c.mut.RUnlock()
+	if !ok {
+		return nil
+	}
+	if err := validate(value); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return nil
+}
+
+// Validate checks all stored settings with the registered validators
+func (c *Config) Validate() error {
+	settings, err := c.Snapshot()
+	if err != nil {
+		return err
+	}
+	for key, value := range settings {
+		if err := c.validate(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set validates and stores a setting, and notifies all watchers
+func (c *Config) Set(key, value string) error {
+	if err := c.validate(key, value); err != nil {
+		return err
+	}
+	if err := c.kv.Set(key, value); err != nil {
+		return err
+	}
+	return c.kv.host.notify(c.channel, key)
+}
+
+// SetInt stores an integer setting
+func (c *Config) SetInt(key string, value int) error {
+	return c.Set(key, strconv.Itoa(value))
+}
+
+// SetBool stores a boolean setting
+func (c *Config) SetBool(key string, value bool) error {
+	return c.Set(key, strconv.FormatBool(value))
+}
+
+// SetDuration stores a duration setting
+func (c *Config) SetDuration(key string, value time.Duration) error {
+	return c.Set(key, value.String())
+}
+
+// Del removes a setting, and notifies all watchers
+func (c *Config) Del(key string) error {
+	if err := c.kv.Del(key); err != nil {
+		return err
+	}
+	return c.kv.host.notify(c.channel, key)
+}
+
+// get returns the value of a setting, and false if it is not set
+func (c *Config) get(key string) (string, bool, error) {
+	value, err := c.kv.Get(key)
+	if err != nil {
+		if noResult(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// String returns a setting, or the given default value if it is not set
+func (c *Config) String(key, defaultValue string) (string, error) {
+	value, ok, err := c.get(key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	return value, nil
+}
+
+// Int returns an integer setting, or the given default value if it is not set
+func (c *Config) Int(key string, defaultValue int) (int, error) {
+	value, ok, err := c.get(key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("setting %s is not an integer: %s", key, value)
+	}
+	return i, nil
+}
+
+// Float returns a floating point setting, or the given default value if it is not set
+func (c *Config) Float(key string, defaultValue float64) (float64, error) {
+	value, ok, err := c.get(key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue, fmt.Errorf("setting %s is not a number: %s", key, value)
+	}
+	return f, nil
+}
+
+// Bool returns a boolean setting, or the given default value if it is not set
+func (c *Config) Bool(key string, defaultValue bool) (bool, error) {
+	value, ok, err := c.get(key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("setting %s is not a boolean: %s", key, value)
+	}
+	return b, nil
+}
+
+// Duration returns a duration setting, or the given default value if it is not set
+func (c *Config) Duration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, ok, err := c.get(key)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("setting %s is not a duration: %s", key, value)
+	}
+	return d, nil
+}
+
+// Snapshot returns all settings
+func (c *Config) Snapshot() (map[string]string, error) {
+	return c.kv.pairs()
+}
+
+// OnChange registers a function that is called by Watch when a setting is changed.
+// The value is empty if the setting has been removed. The key is empty if
+// changes may have been missed, in which case all settings should be reloaded.
+func (c *Config) OnChange(fn func(key, value string)) {
+	c.mut.Lock()
+	c.handlers = append(c.handlers, fn)
+	c.mut.Unlock()
+}
+
+// Watch listens for changes to the settings, by this or any other process,
+// and calls the functions registered with OnChange, until ctx is cancelled.
+func (c *Config) Watch(ctx context.Context) error {
+	return c.kv.host.listen(ctx, c.channel, func(key string) {
+		var value string
+		if key != "" {
+			value, _, _ = c.get(key)
+		}
+		c.mut.RLock()
+		handlers := c.handlers
+		c.mut.RUnlock()
+		for _, fn := range handlers {
+			fn(key, value)
+		}
+	})
+}
+
+// Remove all settings and the underlying KeyValue
+func (c *Config) Remove() error {
+	return c.kv.Remove()
+}
+
+// Clear all settings
+func (c *Config) Clear() error {
+	if err := c.kv.Clear(); err != nil {
+		return err
+	}
+	return c.kv.host.notify(c.channel, "")
+}
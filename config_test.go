@@ -0,0 +1,51 @@
+package simplehstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	config, err := NewConfig(host, "config_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Clear()
+
+	if workers, err := config.Int("workers", 4); err != nil || workers != 4 {
+		t.Errorf("Error, expected the default value 4, got %d (%v)", workers, err)
+	}
+	config.SetValidator("workers", func(value string) error {
+		if value == "0" {
+			return errors.New("there must be at least one worker")
+		}
+		return nil
+	})
+	if err := config.SetInt("workers", 0); err == nil {
+		t.Error("Error, 0 workers should not be valid")
+	}
+	if err := config.SetInt("workers", 8); err != nil {
+		t.Fatal(err)
+	}
+	if workers, err := config.Int("workers", 4); err != nil || workers != 8 {
+		t.Errorf("Error, expected 8, got %d (%v)", workers, err)
+	}
+	config.SetDuration("timeout", 3*time.Second)
+	if timeout, err := config.Duration("timeout", time.Second); err != nil || timeout != 3*time.Second {
+		t.Errorf("Error, expected 3s, got %s (%v)", timeout, err)
+	}
+	config.Set("debug", "maybe")
+	if _, err := config.Bool("debug", false); err == nil {
+		t.Error("Error, maybe is not a boolean")
+	}
+	snapshot, err := config.Snapshot()
+	if err != nil || len(snapshot) != 3 || snapshot["workers"] != "8" {
+		t.Errorf("Error, unexpected snapshot: %v (%v)", snapshot, err)
+	}
+
+	config.Remove()
+}
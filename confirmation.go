@@ -0,0 +1,203 @@
+package simplehstore
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// confirmationCodeBytes is the number of random bytes in a confirmation code
+const confirmationCodeBytes = 10
+
+// ErrNoSuchCode is returned when a confirmation code is not found
+var ErrNoSuchCode = errors.New("no such confirmation code")
+
+// ConfirmationStore keeps track of e-mail confirmation codes for users.
+// Each user can have at most one pending code, and each code is unique,
+// which is enforced by the database. When a code is confirmed, the
+// "confirmed" property of the user is set to "true" in the users HashMap2,
+// in the same transaction as the code is consumed.
+type ConfirmationStore struct {
+	dbDatastructure
+	users *HashMap2
+}
+
+// NewConfirmationStore creates a new ConfirmationStore, for the given users
+func NewConfirmationStore(users *HashMap2, name string, opts ...Option) (*ConfirmationStore, error) {
+	c := &ConfirmationStore{newDatastructure(users.host, pq.QuoteIdentifier(name), opts), users}
+	if err := c.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := c.exists(c.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (code TEXT PRIMARY KEY, username TEXT NOT NULL UNIQUE, created TIMESTAMPTZ NOT NULL DEFAULT now())", c.tableName())
+	if _, err := c.host.exec(query); err != nil {
+		return nil, err
+	}
+	if err := c.register("confirmationstore", name, c.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if c.verbose() {
+		c.host.Logger().Println("Created confirmation code table " + c.tableName() + " in database " + c.host.dbname)
+	}
+	return c, nil
+}
+
+// newConfirmationCode returns a new random confirmation code
+func newConfirmationCode() (string, error) {
+	b := make([]byte, confirmationCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AddUnconfirmed stores the given confirmation code for a user,
+// replacing any pending code for the same user.
+func (c *ConfirmationStore) AddUnconfirmed(username, code string) error {
+	_, err := c.host.exec(fmt.Sprintf("INSERT INTO %s (code, username) VALUES ($1, $2) ON CONFLICT (username) DO UPDATE SET code = EXCLUDED.code, created = now()", c.tableName()), code, username)
+	return err
+}
+
+// GenerateCode generates a unique confirmation code for the given user, and stores it.
+// Any pending code for the same user is replaced.
+func (c *ConfirmationStore) GenerateCode(username string) (string, error) {
+	for {
+		code, err := newConfirmationCode()
+		if err != nil {
+			return "", err
+		}
+		err = c.AddUnconfirmed(username, code)
+		if isUniqueViolation(err) {
+			// The code is already in use by another user, try again
+			continue
+		}
+		return code, err
+	}
+}
+
+// FindByCode returns the username that the given confirmation code belongs to
+func (c *ConfirmationStore) FindByCode(code string) (string, error) {
+	var username string
+	err := c.host.queryRow(fmt.Sprintf("SELECT username FROM %s WHERE code = $1", c.tableName()), code).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSuchCode
+	}
+	return username, err
+}
+
+// HasCode checks if the given confirmation code is pending
+func (c *ConfirmationStore) HasCode(code string) (bool, error) {
+	if _, err := c.FindByCode(code); err != nil {
+		if err == ErrNoSuchCode {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ConfirmationCode returns the pending confirmation code for a user
+func (c *ConfirmationStore) ConfirmationCode(username string) (string, error) {
+	var code string
+	err := c.host.queryRow(fmt.Sprintf("SELECT code FROM %s WHERE username = $1", c.tableName()), username).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSuchCode
+	}
+	return code, err
+}
+
+// Confirm consumes the given confirmation code and marks the user as confirmed,
+// in one transaction. The username is returned.
+func (c *ConfirmationStore) Confirm(code string) (string, error) {
+	ctx := c.host.context()
+	transaction, err := c.host.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	var username string
+	err = transaction.QueryRowContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE code = $1 RETURNING username", c.tableName()), code).Scan(&username)
+	if err != nil {
+		transaction.Rollback()
+		if err == sql.ErrNoRows {
+			return "", ErrNoSuchCode
+		}
+		return "", err
+	}
+	users := *c.users
+	users.host = c.host.withTx(transaction)
+	if err := users.Set(username, "confirmed", "true"); err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+	return username, transaction.Commit()
+}
+
+// IsConfirmed checks if the user has been confirmed
+func (c *ConfirmationStore) IsConfirmed(username string) (bool, error) {
+	value, err := c.users.Get(username, "confirmed")
+	if err != nil {
+		if noResult(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return value == "true", nil
+}
+
+// RemoveUnconfirmed removes the pending confirmation code for a user, if any
+func (c *ConfirmationStore) RemoveUnconfirmed(username string) error {
+	_, err := c.host.exec(fmt.Sprintf("DELETE FROM %s WHERE username = $1", c.tableName()), username)
+	return err
+}
+
+// AllUnconfirmed returns the usernames of all users with a pending confirmation code
+func (c *ConfirmationStore) AllUnconfirmed() ([]string, error) {
+	var (
+		values []string
+		value  string
+	)
+	rows, err := c.host.query(fmt.Sprintf("SELECT username FROM %s ORDER BY created", c.tableName()))
+	if err != nil {
+		return values, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&value); err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// ExpireOldCodes removes all confirmation codes that are older than the given duration,
+// and returns how many were removed
+func (c *ConfirmationStore) ExpireOldCodes(ttl time.Duration) (int64, error) {
+	result, err := c.host.exec(fmt.Sprintf("DELETE FROM %s WHERE created < now() - $1 * interval '1 microsecond'", c.tableName()), ttl.Microseconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Remove the table with confirmation codes
+func (c *ConfirmationStore) Remove() error {
+	if _, err := c.host.exec(fmt.Sprintf("DROP TABLE %s", c.tableName())); err != nil {
+		return err
+	}
+	return c.unregister("confirmationstore", c.tableName())
+}
+
+// Clear all confirmation codes
+func (c *ConfirmationStore) Clear() error {
+	_, err := c.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", c.tableName()))
+	return err
+}
@@ -0,0 +1,53 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfirmationStore(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "confirmation_test_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	confirmations, err := NewConfirmationStore(users, "confirmation_test_codes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmations.Clear()
+
+	code, err := confirmations.GenerateCode("bob")
+	if err != nil {
+		t.Fatalf("Error, could not generate code! %s", err)
+	}
+	if username, err := confirmations.FindByCode(code); err != nil || username != "bob" {
+		t.Errorf("Error, expected bob, got %s (%v)", username, err)
+	}
+	if confirmed, _ := confirmations.IsConfirmed("bob"); confirmed {
+		t.Error("Error, bob should not be confirmed yet")
+	}
+	if username, err := confirmations.Confirm(code); err != nil || username != "bob" {
+		t.Errorf("Error, could not confirm bob: %s (%v)", username, err)
+	}
+	if confirmed, _ := confirmations.IsConfirmed("bob"); !confirmed {
+		t.Error("Error, bob should be confirmed")
+	}
+	if _, err := confirmations.Confirm(code); err != ErrNoSuchCode {
+		t.Errorf("Error, expected the code to be consumed, got %v", err)
+	}
+
+	confirmations.GenerateCode("alice")
+	if n, err := confirmations.ExpireOldCodes(0); err != nil || n != 1 {
+		t.Errorf("Error, expected one code to expire, got %d (%v)", n, err)
+	}
+	if _, err := confirmations.ExpireOldCodes(time.Hour); err != nil {
+		t.Error(err)
+	}
+
+	confirmations.Remove()
+	users.Remove()
+}
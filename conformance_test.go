@@ -0,0 +1,18 @@
+package simplehstore
+
+import (
+	"testing"
+
+	"github.com/colinf/pinterface"
+	"github.com/colinf/simplehstore/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	storetest.TestCreator(t, NewCreator(host))
+	storetest.TestIHashMap2(t, func(name string) (pinterface.IHashMap2, error) {
+		return NewHashMap2(host, name)
+	})
+}
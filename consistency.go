@@ -0,0 +1,44 @@
+package simplehstore
+
+import (
+	"context"
+	"time"
+)
+
+// replayPollInterval is how often a standby is asked how far it has replayed the WAL
+var replayPollInterval = 10 * time.Millisecond
+
+// ConsistencyToken returns the current WAL position of the host, as a string.
+// The token can be stored after writing to a primary, and later be given to
+// WaitForToken on a Host that is connected to a read replica, in order to read
+// your own writes.
+func (host *Host) ConsistencyToken() (string, error) {
+	var lsn string
+	if err := host.queryRow("SELECT pg_current_wal_lsn()::text").Scan(&lsn); err != nil {
+		return "", err
+	}
+	return lsn, nil
+}
+
+// WaitForToken blocks until the host has replayed the WAL up to the position
+// in the given token, or until the context is done. If the host is not a
+// standby, it has all of its own writes and WaitForToken returns at once.
+func (host *Host) WaitForToken(ctx context.Context, token string) error {
+	if token == "" {
+		return nil
+	}
+	for {
+		var inRecovery, caughtUp bool
+		if err := host.queryer().QueryRowContext(ctx, "SELECT pg_is_in_recovery(), COALESCE(pg_last_wal_replay_lsn() >= $1::pg_lsn, false)", token).Scan(&inRecovery, &caughtUp); err != nil {
+			return err
+		}
+		if !inRecovery || caughtUp {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(replayPollInterval):
+		}
+	}
+}
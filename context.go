@@ -0,0 +1,115 @@
+package simplehstore
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+type contextKey int
+
+const actorKey contextKey = iota
+
+// WithActor returns a copy of ctx that carries the given actor, for instance
+// the ID of the user that is performing an operation. When a Host is bound to
+// the returned context with WithContext, all queries are tagged with a
+// /* actor: ... */ comment, which makes the actor visible in pg_stat_activity,
+// in the PostgreSQL log (including the slow query log) and to triggers,
+// by using current_query().
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFromContext returns the actor that was stored in ctx by WithActor, if any
+func ActorFromContext(ctx context.Context) (string, bool) {
+	actor, ok := ctx.Value(actorKey).(string)
+	return actor, ok && actor != ""
+}
+
+// WithContext returns a shallow copy of the host, where all queries are run
+// with the given context. Data structures created with the returned host
+// inherit the context.
+func (host *Host) WithContext(ctx context.Context) *Host {
+	boundHost := *host
+	boundHost.ctx = ctx
+	return &boundHost
+}
+
+// context returns the context the host is bound to, or context.Background()
+func (host *Host) context() context.Context {
+	if host.ctx != nil {
+		return host.ctx
+	}
+	return context.Background()
+}
+
+// sanitizeActor replaces all characters that are not safe to place within an SQL comment
+func sanitizeActor(actor string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("@._-:", r):
+			return r
+		}
+		return '_'
+	}, actor)
+}
+
+// pkgPrefix is the prefix of the names of all functions in this package, as reported by the runtime
+var pkgPrefix = strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(sanitizeActor).Pointer()).Name(), "sanitizeActor")
+
+// operation returns a stable name for the outermost method or constructor of
+// this package on the call stack, like "hashmap2.set" for (*HashMap2).Set,
+// or "newlist" for NewList
+func operation() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	name := "unknown"
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPrefix) {
+			if name != "unknown" {
+				break
+			}
+		} else {
+			f := strings.TrimPrefix(frame.Function, pkgPrefix)
+			if strings.HasPrefix(f, "(*") {
+				// A method, like (*HashMap2).Set
+				if fields := strings.Split(f, "."); len(fields) == 2 && fields[1] != "" && unicode.IsUpper(rune(fields[1][0])) {
+					name = strings.ToLower(strings.Trim(fields[0], "(*)") + "." + fields[1])
+				}
+			} else if strings.HasPrefix(f, "New") && !strings.Contains(f, ".") {
+				name = strings.ToLower(f)
+			}
+		}
+		if !more {
+			break
+		}
+	}
+	return name
+}
+
+// annotate prefixes the query with comments that identify the operation, if query tags
+// are enabled for the host, and the actor in ctx, if any
+func (host *Host) annotate(ctx context.Context, query string) string {
+	if host.queryTags {
+		query = "/* simplehstore:" + operation() + " */ " + query
+	}
+	if actor, ok := ActorFromContext(ctx); ok && Verbose {
+		host.Logger().Printf("[%s] %s\n", actor, query)
+	}
+	return annotate(ctx, query)
+}
+
+// annotate prefixes the query with a comment that identifies the actor in ctx, if any
+func annotate(ctx context.Context, query string) string {
+	actor, ok := ActorFromContext(ctx)
+	if !ok {
+		return query
+	}
+	return "/* actor: " + sanitizeActor(actor) + " */ " + query
+}
@@ -0,0 +1,123 @@
+package simplehstore
+
+import (
+	"context"
+	"testing"
+)
+
+func TestActor(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := ActorFromContext(ctx); ok {
+		t.Error("Error, expected no actor in an empty context")
+	}
+	if q := annotate(ctx, "SELECT 1"); q != "SELECT 1" {
+		t.Errorf("Error, expected the query to be unchanged, got %s", q)
+	}
+	ctx = WithActor(ctx, "bob */ DROP TABLE x; /*")
+	actor, ok := ActorFromContext(ctx)
+	if !ok || actor != "bob */ DROP TABLE x; /*" {
+		t.Errorf("Error, could not retrieve the actor, got %s", actor)
+	}
+	if q := annotate(ctx, "SELECT 1"); q != "/* actor: bob____DROP_TABLE_x____ */ SELECT 1" {
+		t.Errorf("Error, the actor was not sanitized: %s", q)
+	}
+}
+
+func TestBind(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	list, err := NewList(host, listname)
+	if err != nil {
+		t.Error(err)
+	}
+	list.Clear()
+	list.Add(testdata1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bound, err := host.Bind(ctx).List(listname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, err := bound.All(); err != nil || len(items) != 1 {
+		t.Errorf("Error, expected one item from the bound list, got %v (%v)", items, err)
+	}
+	cancel()
+	if _, err := bound.All(); err == nil {
+		t.Error("Error, expected an error when using a list bound to a canceled context")
+	}
+
+	list.Remove()
+}
+
+func TestBindCodec(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "bind_codec_test", WithCodec(RawCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	kv.Set("greeting", "hello")
+
+	// Opened without the option, with the default hex codec of the host
+	bound, err := host.Bind(context.Background()).KeyValue("bind_codec_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bound.getCodec().Name() != RawCodec.Name() {
+		t.Errorf("Error, expected the bound key/value to use the %q codec, got %q", RawCodec.Name(), bound.getCodec().Name())
+	}
+	if v, err := bound.Get("greeting"); err != nil || v != "hello" {
+		t.Errorf("Error, expected hello, got %q (%v)", v, err)
+	}
+
+	kv.Remove()
+}
+
+type tagProbe struct{}
+
+func (p *tagProbe) Outer() string {
+	return p.inner()
+}
+
+func (p *tagProbe) inner() string {
+	host := &Host{queryTags: true}
+	return host.annotate(context.Background(), "SELECT 1")
+}
+
+func TestQueryTags(t *testing.T) {
+	if query := (&tagProbe{}).Outer(); query != "/* simplehstore:tagprobe.outer */ SELECT 1" {
+		t.Errorf("Error, unexpected query: %s", query)
+	}
+	d := newDatastructure(&Host{}, "t", []Option{WithQueryTags()})
+	if !d.host.queryTags {
+		t.Error("Error, expected query tags to be enabled for the data structure")
+	}
+}
+
+func TestContextMethods(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "context_methods_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	ctx := context.Background()
+	if err := users.SetContext(ctx, "bob", "email", "bob@example.com"); err != nil {
+		t.Error(err)
+	}
+	if v, err := users.GetContext(ctx, "bob", "email"); err != nil || v != "bob@example.com" {
+		t.Errorf("Error, expected bob@example.com, got %s (%v)", v, err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := users.GetContext(canceled, "bob", "email"); err == nil {
+		t.Error("Error, expected an error when the context is canceled")
+	}
+
+	users.Remove()
+}
@@ -0,0 +1,229 @@
+package simplehstore
+
+import (
+	"context"
+)
+
+// The methods in this file are the same as the methods without the Context
+// suffix, but run all their queries with the given context, so that deadlines
+// and cancellation are respected. This is the same as calling WithContext first.
+
+// AddContext adds an element to the list, using the given context
+func (l *List) AddContext(ctx context.Context, value string) error {
+	return l.WithContext(ctx).Add(value)
+}
+
+// AllContext returns all elements in the list, using the given context
+func (l *List) AllContext(ctx context.Context) ([]string, error) {
+	return l.WithContext(ctx).All()
+}
+
+// HasContext checks if the list has the given element, using the given context
+func (l *List) HasContext(ctx context.Context, value string) (bool, error) {
+	return l.WithContext(ctx).Has(value)
+}
+
+// LastContext returns the last element of the list, using the given context
+func (l *List) LastContext(ctx context.Context) (string, error) {
+	return l.WithContext(ctx).Last()
+}
+
+// LastNContext returns the last n elements of the list, using the given context
+func (l *List) LastNContext(ctx context.Context, n int) ([]string, error) {
+	return l.WithContext(ctx).LastN(n)
+}
+
+// CountContext counts the number of elements in the list, using the given context
+func (l *List) CountContext(ctx context.Context) (int, error) {
+	return l.WithContext(ctx).Count()
+}
+
+// ClearContext removes all elements from the list, using the given context
+func (l *List) ClearContext(ctx context.Context) error {
+	return l.WithContext(ctx).Clear()
+}
+
+// AddContext adds an element to the set, using the given context
+func (s *Set) AddContext(ctx context.Context, value string) error {
+	return s.WithContext(ctx).Add(value)
+}
+
+// HasContext checks if the given value is in the set, using the given context
+func (s *Set) HasContext(ctx context.Context, value string) (bool, error) {
+	return s.WithContext(ctx).Has(value)
+}
+
+// AllContext returns all elements in the set, using the given context
+func (s *Set) AllContext(ctx context.Context) ([]string, error) {
+	return s.WithContext(ctx).All()
+}
+
+// DelContext removes an element from the set, using the given context
+func (s *Set) DelContext(ctx context.Context, value string) error {
+	return s.WithContext(ctx).Del(value)
+}
+
+// CountContext counts the number of elements in the set, using the given context
+func (s *Set) CountContext(ctx context.Context) (int, error) {
+	return s.WithContext(ctx).Count()
+}
+
+// ClearContext removes all elements from the set, using the given context
+func (s *Set) ClearContext(ctx context.Context) error {
+	return s.WithContext(ctx).Clear()
+}
+
+// GetContext gets the value of the given key, using the given context
+func (kv *KeyValue) GetContext(ctx context.Context, key string) (string, error) {
+	return kv.WithContext(ctx).Get(key)
+}
+
+// SetContext sets a key and value, using the given context
+func (kv *KeyValue) SetContext(ctx context.Context, key, value string) error {
+	return kv.WithContext(ctx).Set(key, value)
+}
+
+// HasContext checks if the given key exists, using the given context
+func (kv *KeyValue) HasContext(ctx context.Context, key string) (bool, error) {
+	return kv.WithContext(ctx).Has(key)
+}
+
+// DelContext removes the given key, using the given context
+func (kv *KeyValue) DelContext(ctx context.Context, key string) error {
+	return kv.WithContext(ctx).Del(key)
+}
+
+// IncContext increases the value of the given key, using the given context
+func (kv *KeyValue) IncContext(ctx context.Context, key string) (string, error) {
+	return kv.WithContext(ctx).Inc(key)
+}
+
+// AllContext returns all keys, using the given context
+func (kv *KeyValue) AllContext(ctx context.Context) ([]string, error) {
+	return kv.WithContext(ctx).All()
+}
+
+// CountContext counts the number of keys, using the given context
+func (kv *KeyValue) CountContext(ctx context.Context) (int, error) {
+	return kv.WithContext(ctx).Count()
+}
+
+// ClearContext removes all keys, using the given context
+func (kv *KeyValue) ClearContext(ctx context.Context) error {
+	return kv.WithContext(ctx).Clear()
+}
+
+// GetContext gets the value of a key of an owner, using the given context
+func (h *HashMap) GetContext(ctx context.Context, owner, key string) (string, error) {
+	return h.WithContext(ctx).Get(owner, key)
+}
+
+// SetContext sets the value of a key of an owner, using the given context
+func (h *HashMap) SetContext(ctx context.Context, owner, key, value string) error {
+	return h.WithContext(ctx).Set(owner, key, value)
+}
+
+// HasContext checks if the owner has the given key, using the given context
+func (h *HashMap) HasContext(ctx context.Context, owner, key string) (bool, error) {
+	return h.WithContext(ctx).Has(owner, key)
+}
+
+// ExistsContext checks if the owner exists, using the given context
+func (h *HashMap) ExistsContext(ctx context.Context, owner string) (bool, error) {
+	return h.WithContext(ctx).Exists(owner)
+}
+
+// KeysContext returns all keys of an owner, using the given context
+func (h *HashMap) KeysContext(ctx context.Context, owner string) ([]string, error) {
+	return h.WithContext(ctx).Keys(owner)
+}
+
+// AllContext returns all owners, using the given context
+func (h *HashMap) AllContext(ctx context.Context) ([]string, error) {
+	return h.WithContext(ctx).All()
+}
+
+// AllWhereContext returns all owners that have the given key set to the given value, using the given context
+func (h *HashMap) AllWhereContext(ctx context.Context, key, value string) ([]string, error) {
+	return h.WithContext(ctx).AllWhere(key, value)
+}
+
+// DelKeyContext removes a key of an owner, using the given context
+func (h *HashMap) DelKeyContext(ctx context.Context, owner, key string) error {
+	return h.WithContext(ctx).DelKey(owner, key)
+}
+
+// DelContext removes an owner, using the given context
+func (h *HashMap) DelContext(ctx context.Context, owner string) error {
+	return h.WithContext(ctx).Del(owner)
+}
+
+// ClearContext removes all owners, using the given context
+func (h *HashMap) ClearContext(ctx context.Context) error {
+	return h.WithContext(ctx).Clear()
+}
+
+// GetContext gets the value of a key of an owner, using the given context
+func (hm2 *HashMap2) GetContext(ctx context.Context, owner, key string) (string, error) {
+	return hm2.WithContext(ctx).Get(owner, key)
+}
+
+// GetMapContext gets the values of several keys of an owner, using the given context
+func (hm2 *HashMap2) GetMapContext(ctx context.Context, owner string, keys []string) (map[string]string, error) {
+	return hm2.WithContext(ctx).GetMap(owner, keys)
+}
+
+// SetContext sets the value of a key of an owner, using the given context
+func (hm2 *HashMap2) SetContext(ctx context.Context, owner, key, value string) error {
+	return hm2.WithContext(ctx).Set(owner, key, value)
+}
+
+// SetMapContext sets several keys and values of an owner, using the given context
+func (hm2 *HashMap2) SetMapContext(ctx context.Context, owner string, m map[string]string) error {
+	return hm2.WithContext(ctx).SetMap(owner, m)
+}
+
+// HasContext checks if the owner has the given key, using the given context
+func (hm2 *HashMap2) HasContext(ctx context.Context, owner, key string) (bool, error) {
+	return hm2.WithContext(ctx).Has(owner, key)
+}
+
+// ExistsContext checks if the owner exists, using the given context
+func (hm2 *HashMap2) ExistsContext(ctx context.Context, owner string) (bool, error) {
+	return hm2.WithContext(ctx).Exists(owner)
+}
+
+// KeysContext returns all keys of an owner, using the given context
+func (hm2 *HashMap2) KeysContext(ctx context.Context, owner string) ([]string, error) {
+	return hm2.WithContext(ctx).Keys(owner)
+}
+
+// AllContext returns all owners, using the given context
+func (hm2 *HashMap2) AllContext(ctx context.Context) ([]string, error) {
+	return hm2.WithContext(ctx).All()
+}
+
+// AllWhereContext returns all owners that have the given key set to the given value, using the given context
+func (hm2 *HashMap2) AllWhereContext(ctx context.Context, key, value string) ([]string, error) {
+	return hm2.WithContext(ctx).AllWhere(key, value)
+}
+
+// CountContext counts the number of owners, using the given context
+func (hm2 *HashMap2) CountContext(ctx context.Context) (int64, error) {
+	return hm2.WithContext(ctx).Count()
+}
+
+// DelKeyContext removes a key of an owner, using the given context
+func (hm2 *HashMap2) DelKeyContext(ctx context.Context, owner, key string) error {
+	return hm2.WithContext(ctx).DelKey(owner, key)
+}
+
+// DelContext removes an owner, using the given context
+func (hm2 *HashMap2) DelContext(ctx context.Context, owner string) error {
+	return hm2.WithContext(ctx).Del(owner)
+}
+
+// ClearContext removes all owners, using the given context
+func (hm2 *HashMap2) ClearContext(ctx context.Context) error {
+	return hm2.WithContext(ctx).Clear()
+}
@@ -0,0 +1,95 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Counter is a single 64-bit counter. Each change is a single statement that
+// returns the new value, so that concurrent changes from several processes
+// are never lost, without the lock and transaction that KeyValue.IncBy needs.
+type Counter struct {
+	dbDatastructure
+}
+
+// NewCounter creates a new counter, which starts at 0
+func NewCounter(provider HostProvider, name string, opts ...Option) (*Counter, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	c := &Counter{newDatastructure(host, pq.QuoteIdentifier(name), opts)}
+	if err := c.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := c.exists(c.tableName())
+	if err != nil {
+		return nil, err
+	}
+	// The single row is created by the first change
+	query := fmt.Sprintf("%s %s (id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id), value BIGINT NOT NULL)", c.createTable(), c.tableName())
+	if _, err := c.host.exec(query); err != nil {
+		return nil, err
+	}
+	if err := c.setStorageParameters(c.tableName()); err != nil {
+		return nil, err
+	}
+	if err := c.register("counter", name, c.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if c.verbose() {
+		c.host.Logger().Println("Created counter table " + c.tableName() + " in database " + host.dbname)
+	}
+	return c, nil
+}
+
+// Inc increases the counter by 1, and returns the new value
+func (c *Counter) Inc() (int64, error) {
+	return c.Add(1)
+}
+
+// Dec decreases the counter by 1, and returns the new value
+func (c *Counter) Dec() (int64, error) {
+	return c.Add(-1)
+}
+
+// Add adds n to the counter, which may be negative, and returns the new value
+func (c *Counter) Add(n int64) (int64, error) {
+	var value int64
+	query := fmt.Sprintf("INSERT INTO %s AS c (value) VALUES ($1) ON CONFLICT (id) DO UPDATE SET value = c.value + EXCLUDED.value RETURNING value", c.tableName())
+	err := c.host.queryRow(query, n).Scan(&value)
+	return value, err
+}
+
+// Set sets the counter to n
+func (c *Counter) Set(n int64) error {
+	query := fmt.Sprintf("INSERT INTO %s (value) VALUES ($1) ON CONFLICT (id) DO UPDATE SET value = EXCLUDED.value", c.tableName())
+	_, err := c.host.exec(query, n)
+	return err
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() (int64, error) {
+	var value int64
+	err := c.host.queryRow(fmt.Sprintf("SELECT value FROM %s", c.tableName())).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return value, err
+}
+
+// Remove the counter
+func (c *Counter) Remove() error {
+	if _, err := c.host.exec(fmt.Sprintf("DROP TABLE %s", c.tableName())); err != nil {
+		return err
+	}
+	return c.unregister("counter", c.tableName())
+}
+
+// Clear resets the counter to 0
+func (c *Counter) Clear() error {
+	_, err := c.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", c.tableName()))
+	return err
+}
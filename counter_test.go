@@ -0,0 +1,49 @@
+package simplehstore
+
+import (
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	c, err := NewCounter(host, "counter_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Clear()
+	if n, err := c.Value(); err != nil || n != 0 {
+		t.Errorf("Error, expected a new counter to be 0, got %d (%v)", n, err)
+	}
+
+	// Concurrent increments are not lost
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 10; j++ {
+				if _, err := c.Inc(); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := c.Value(); err != nil || n != 100 {
+		t.Errorf("Error, expected 100, got %d (%v)", n, err)
+	}
+	if n, err := c.Add(-50); err != nil || n != 50 {
+		t.Errorf("Error, expected 50, got %d (%v)", n, err)
+	}
+	if n, err := c.Dec(); err != nil || n != 49 {
+		t.Errorf("Error, expected 49, got %d (%v)", n, err)
+	}
+
+	c.Remove()
+}
@@ -8,34 +8,36 @@ import (
 // The main purpose is to implement pinterface.ICreator.
 type PostgresCreator struct {
 	host *Host
+	opts []Option
 }
 
 // NewCreator can be used to create a new PostgresCreator.
+// The given options are used for all data structures that are created.
 // The main purpose is to implement pinterface.ICreator.
-func NewCreator(host *Host) *PostgresCreator {
-	return &PostgresCreator{host}
+func NewCreator(host *Host, opts ...Option) *PostgresCreator {
+	return &PostgresCreator{host, opts}
 }
 
 // NewList can be used to create a new pinterface.IList.
 // The main purpose is to implement pinterface.ICreator.
 func (m *PostgresCreator) NewList(id string) (pinterface.IList, error) {
-	return NewList(m.host, id)
+	return NewList(m.host, id, m.opts...)
 }
 
 // NewSet can be used to create a new pinterface.ISet.
 // The main purpose is to implement pinterface.ICreator.
 func (m *PostgresCreator) NewSet(id string) (pinterface.ISet, error) {
-	return NewSet(m.host, id)
+	return NewSet(m.host, id, m.opts...)
 }
 
 // NewHashMap can be used to create a new pinterface.IHashMap.
 // The main purpose is to implement pinterface.ICreator.
 func (m *PostgresCreator) NewHashMap(id string) (pinterface.IHashMap, error) {
-	return NewHashMap(m.host, id)
+	return NewHashMap(m.host, id, m.opts...)
 }
 
 // NewKeyValue can be used to create a new pinterface.IKeyValue.
 // The main purpose is to implement pinterface.ICreator.
 func (m *PostgresCreator) NewKeyValue(id string) (pinterface.IKeyValue, error) {
-	return NewKeyValue(m.host, id)
+	return NewKeyValue(m.host, id, m.opts...)
 }
@@ -0,0 +1,115 @@
+package simplehstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// dictMarker starts a stored value that is a code from the dictionary of the data structure
+const dictMarker = "\x02"
+
+// dictionary is the in-memory copy of the dictionary table of a data structure.
+// Codes are never changed or reused, so the copy never needs to be invalidated.
+type dictionary struct {
+	mut    sync.RWMutex
+	codes  map[string]int64
+	values map[int64]string
+}
+
+// dictionaries are the in-memory dictionaries, by database name and dictionary table
+var dictionaries sync.Map
+
+// WithDictionary stores each distinct value only once, in a dictionary table
+// that belongs to the data structure, and stores a short code in place of the
+// value. This is meant for low-cardinality values, like countries or plans,
+// where it makes the table smaller and speeds up AllWhere, since the short
+// codes are compared instead of the values. The dictionary is kept in memory,
+// and new values are added to it automatically.
+//
+// Values that were stored before the option was given are still read as they
+// are, but the option must be given every time the data structure is opened,
+// or the codes are returned instead of the values.
+func WithDictionary() Option {
+	return func(o *options) {
+		o.dictionary = true
+	}
+}
+
+// dictTable returns the quoted and possibly schema qualified name of the dictionary table
+func (d *dbDatastructure) dictTable() string {
+	return d.qualify(pq.QuoteIdentifier(strings.TrimSuffix(strings.TrimPrefix(d.table, "\""), "\"") + "_dict"))
+}
+
+// createDictTable creates the dictionary table, if dictionary encoding is enabled
+func (d *dbDatastructure) createDictTable() error {
+	if !d.dictionary {
+		return nil
+	}
+	_, err := d.host.exec(fmt.Sprintf("%s %s (code BIGSERIAL PRIMARY KEY, value TEXT NOT NULL UNIQUE)", d.createTable(), d.dictTable()))
+	return err
+}
+
+// dict returns the in-memory dictionary for this data structure
+func (d *dbDatastructure) dict() *dictionary {
+	key := d.host.dbname + "." + d.dictTable()
+	if found, ok := dictionaries.Load(key); ok {
+		return found.(*dictionary)
+	}
+	found, _ := dictionaries.LoadOrStore(key, &dictionary{codes: make(map[string]int64), values: make(map[int64]string)})
+	return found.(*dictionary)
+}
+
+// dictEncode replaces an encoded value with its code, adding the value to the
+// dictionary if it is new
+func (d *dbDatastructure) dictEncode(value *string) error {
+	if !d.dictionary {
+		return nil
+	}
+	dict := d.dict()
+	dict.mut.RLock()
+	code, ok := dict.codes[*value]
+	dict.mut.RUnlock()
+	if !ok {
+		query := fmt.Sprintf("INSERT INTO %s (value) VALUES ($1) ON CONFLICT (value) DO UPDATE SET value = EXCLUDED.value RETURNING code", d.dictTable())
+		if err := d.host.queryRow(query, *value).Scan(&code); err != nil {
+			return err
+		}
+		dict.mut.Lock()
+		dict.codes[*value] = code
+		dict.values[code] = *value
+		dict.mut.Unlock()
+	}
+	*value = dictMarker + strconv.FormatInt(code, 36)
+	return nil
+}
+
+// dictDecode replaces a code with the value it stands for
+func (d *dbDatastructure) dictDecode(value *string) error {
+	if !d.dictionary || !strings.HasPrefix(*value, dictMarker) {
+		return nil
+	}
+	code, err := strconv.ParseInt(strings.TrimPrefix(*value, dictMarker), 36, 64)
+	if err != nil {
+		return mark(ErrEncoding, "invalid dictionary code: %v", err)
+	}
+	dict := d.dict()
+	dict.mut.RLock()
+	found, ok := dict.values[code]
+	dict.mut.RUnlock()
+	if !ok {
+		// Added by another process
+		if err := d.host.queryRow(fmt.Sprintf("SELECT value FROM %s WHERE code = $1", d.dictTable()), code).Scan(&found); err != nil {
+			return err
+		}
+		dict.mut.Lock()
+		dict.codes[found] = code
+		dict.values[code] = found
+		dict.mut.Unlock()
+	}
+	*value = found
+	return nil
+}
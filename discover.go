@@ -0,0 +1,61 @@
+package simplehstore
+
+import (
+	"fmt"
+)
+
+// Structure describes a data structure that was found in the database by Discover
+type Structure struct {
+	Kind  string // "list", "set", "keyvalue", "hashmap", "hashmap2", "multiset", "counter" or "queue"
+	Name  string // the name that was given to the constructor
+	Table string // the quoted and possibly schema qualified table name
+	Codec string // the name of the codec the values are stored with
+	Count int64  // the number of elements, keys or owners, depending on the kind
+}
+
+// countQuery returns a query that counts the elements of the structure
+func (s *Structure) countQuery() string {
+	switch s.Kind {
+	case "keyvalue":
+		return fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT skeys(attr) FROM %s) AS temp", s.Table)
+	case "hashmap":
+		return fmt.Sprintf("SELECT COUNT(DISTINCT %s) FROM %s", ownerCol, s.Table)
+	case "hashmap2":
+		return fmt.Sprintf("SELECT COUNT(DISTINCT split_part(skeys, '%s', 1)) FROM (SELECT skeys(attr) FROM %s) AS temp WHERE position('%s' in skeys) > 0", fieldSep, s.Table, fieldSep)
+	}
+	return fmt.Sprintf("SELECT COUNT(*) FROM %s", s.Table)
+}
+
+// Discover returns descriptors of all data structures in the database that
+// were created by this package, and that still exist, sorted by kind and name.
+// The KeyValue and Set that each HashMap2 is built on are not included.
+func (host *Host) Discover() ([]Structure, error) {
+	var found bool
+	if err := host.queryRow("SELECT to_regclass($1) IS NOT NULL", metaTable).Scan(&found); err != nil || !found {
+		return []Structure{}, err
+	}
+	query := fmt.Sprintf("SELECT m.kind, m.name, m.tbl, m.codec FROM %s AS m WHERE to_regclass(m.tbl) IS NOT NULL AND NOT (m.kind = 'keyvalue' AND EXISTS (SELECT 1 FROM %s AS h WHERE h.kind = 'hashmap2' AND h.tbl = m.tbl)) AND NOT (m.kind = 'set' AND EXISTS (SELECT 1 FROM %s AS h WHERE h.kind = 'hashmap2' AND h.name || '_encountered_property_keys' = m.name)) ORDER BY m.kind, m.name", metaTable, metaTable, metaTable)
+	rows, err := host.query(query)
+	if err != nil {
+		return []Structure{}, err
+	}
+	structures := []Structure{}
+	for rows.Next() {
+		var s Structure
+		if err := rows.Scan(&s.Kind, &s.Name, &s.Table, &s.Codec); err != nil {
+			rows.Close()
+			return structures, err
+		}
+		structures = append(structures, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return structures, err
+	}
+	for i := range structures {
+		if err := host.queryRow(structures[i].countQuery()).Scan(&structures[i].Count); err != nil {
+			return structures, err
+		}
+	}
+	return structures, nil
+}
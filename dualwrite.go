@@ -0,0 +1,284 @@
+package simplehstore
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/colinf/pinterface"
+)
+
+// DualWriteMode decides what happens when a mutation fails on the secondary Host
+type DualWriteMode int
+
+const (
+	// DualWriteBestEffort reports the divergence, but does not return an error
+	DualWriteBestEffort DualWriteMode = iota
+	// DualWriteStrict reports the divergence, and also returns the error.
+	// The mutation has then already been applied to the primary Host.
+	DualWriteStrict
+)
+
+// Divergence describes a mutation that succeeded on the primary Host, but
+// failed on the secondary Host, so that the two have diverged
+type Divergence struct {
+	Kind      string   // "list", "set", "hashmap" or "keyvalue"
+	Name      string   // the name of the data structure
+	Operation string   // the method, like "Set"
+	Args      []string // the arguments that were given to the method
+	Err       error    // the error from the secondary Host
+}
+
+// Error returns a description of the divergence
+func (d Divergence) Error() string {
+	return fmt.Sprintf("%s %s: %s %q failed on the secondary host: %v", d.Kind, d.Name, d.Operation, d.Args, d.Err)
+}
+
+// Unwrap returns the error from the secondary Host
+func (d Divergence) Unwrap() error {
+	return d.Err
+}
+
+// DualWriteCreator implements pinterface.ICreator, for data structures that
+// mirror all mutations to a secondary Host, for instance while migrating to
+// a new database. All reads are served by the primary Host, and mutations are
+// only mirrored if they succeed on the primary Host.
+type DualWriteCreator struct {
+	primary, secondary *PostgresCreator
+	mode               DualWriteMode
+	onDivergence       func(Divergence)
+	divergences        int64
+}
+
+// NewDualWriteCreator creates a DualWriteCreator, where the data structures
+// are created on both hosts with the given options. onDivergence, if not nil,
+// is called for each mutation that fails on the secondary Host.
+func NewDualWriteCreator(primary, secondary *Host, mode DualWriteMode, onDivergence func(Divergence), opts ...Option) *DualWriteCreator {
+	return &DualWriteCreator{primary: NewCreator(primary, opts...), secondary: NewCreator(secondary, opts...), mode: mode, onDivergence: onDivergence}
+}
+
+// Divergences returns the number of mutations that have failed on the secondary Host
+func (c *DualWriteCreator) Divergences() int64 {
+	return atomic.LoadInt64(&c.divergences)
+}
+
+// mirror reports an error from the secondary Host, if any, and returns it in strict mode
+func (c *DualWriteCreator) mirror(kind, name, operation string, err error, args ...string) error {
+	if err == nil {
+		return nil
+	}
+	atomic.AddInt64(&c.divergences, 1)
+	d := Divergence{kind, name, operation, args, err}
+	if c.onDivergence != nil {
+		c.onDivergence(d)
+	}
+	if c.mode == DualWriteStrict {
+		return d
+	}
+	return nil
+}
+
+// NewList creates a list on both hosts
+func (c *DualWriteCreator) NewList(id string) (pinterface.IList, error) {
+	primary, err := c.primary.NewList(id)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := c.secondary.NewList(id)
+	if err != nil {
+		return nil, err
+	}
+	return &dualList{primary, secondary, c, id}, nil
+}
+
+// NewSet creates a set on both hosts
+func (c *DualWriteCreator) NewSet(id string) (pinterface.ISet, error) {
+	primary, err := c.primary.NewSet(id)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := c.secondary.NewSet(id)
+	if err != nil {
+		return nil, err
+	}
+	return &dualSet{primary, secondary, c, id}, nil
+}
+
+// NewHashMap creates a hash map on both hosts
+func (c *DualWriteCreator) NewHashMap(id string) (pinterface.IHashMap, error) {
+	primary, err := c.primary.NewHashMap(id)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := c.secondary.NewHashMap(id)
+	if err != nil {
+		return nil, err
+	}
+	return &dualHashMap{primary, secondary, c, id}, nil
+}
+
+// NewKeyValue creates a key/value on both hosts
+func (c *DualWriteCreator) NewKeyValue(id string) (pinterface.IKeyValue, error) {
+	primary, err := c.primary.NewKeyValue(id)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := c.secondary.NewKeyValue(id)
+	if err != nil {
+		return nil, err
+	}
+	return &dualKeyValue{primary, secondary, c, id}, nil
+}
+
+// dualList reads from the primary list, and mirrors mutations to the secondary list
+type dualList struct {
+	pinterface.IList
+	secondary pinterface.IList
+	c         *DualWriteCreator
+	name      string
+}
+
+func (l *dualList) Add(value string) error {
+	if err := l.IList.Add(value); err != nil {
+		return err
+	}
+	return l.c.mirror("list", l.name, "Add", l.secondary.Add(value), value)
+}
+
+func (l *dualList) Clear() error {
+	if err := l.IList.Clear(); err != nil {
+		return err
+	}
+	return l.c.mirror("list", l.name, "Clear", l.secondary.Clear())
+}
+
+func (l *dualList) Remove() error {
+	if err := l.IList.Remove(); err != nil {
+		return err
+	}
+	return l.c.mirror("list", l.name, "Remove", l.secondary.Remove())
+}
+
+// dualSet reads from the primary set, and mirrors mutations to the secondary set
+type dualSet struct {
+	pinterface.ISet
+	secondary pinterface.ISet
+	c         *DualWriteCreator
+	name      string
+}
+
+func (s *dualSet) Add(value string) error {
+	if err := s.ISet.Add(value); err != nil {
+		return err
+	}
+	return s.c.mirror("set", s.name, "Add", s.secondary.Add(value), value)
+}
+
+func (s *dualSet) Del(value string) error {
+	if err := s.ISet.Del(value); err != nil {
+		return err
+	}
+	return s.c.mirror("set", s.name, "Del", s.secondary.Del(value), value)
+}
+
+func (s *dualSet) Clear() error {
+	if err := s.ISet.Clear(); err != nil {
+		return err
+	}
+	return s.c.mirror("set", s.name, "Clear", s.secondary.Clear())
+}
+
+func (s *dualSet) Remove() error {
+	if err := s.ISet.Remove(); err != nil {
+		return err
+	}
+	return s.c.mirror("set", s.name, "Remove", s.secondary.Remove())
+}
+
+// dualHashMap reads from the primary hash map, and mirrors mutations to the secondary hash map
+type dualHashMap struct {
+	pinterface.IHashMap
+	secondary pinterface.IHashMap
+	c         *DualWriteCreator
+	name      string
+}
+
+func (h *dualHashMap) Set(owner, key, value string) error {
+	if err := h.IHashMap.Set(owner, key, value); err != nil {
+		return err
+	}
+	return h.c.mirror("hashmap", h.name, "Set", h.secondary.Set(owner, key, value), owner, key, value)
+}
+
+func (h *dualHashMap) DelKey(owner, key string) error {
+	if err := h.IHashMap.DelKey(owner, key); err != nil {
+		return err
+	}
+	return h.c.mirror("hashmap", h.name, "DelKey", h.secondary.DelKey(owner, key), owner, key)
+}
+
+func (h *dualHashMap) Del(owner string) error {
+	if err := h.IHashMap.Del(owner); err != nil {
+		return err
+	}
+	return h.c.mirror("hashmap", h.name, "Del", h.secondary.Del(owner), owner)
+}
+
+func (h *dualHashMap) Clear() error {
+	if err := h.IHashMap.Clear(); err != nil {
+		return err
+	}
+	return h.c.mirror("hashmap", h.name, "Clear", h.secondary.Clear())
+}
+
+func (h *dualHashMap) Remove() error {
+	if err := h.IHashMap.Remove(); err != nil {
+		return err
+	}
+	return h.c.mirror("hashmap", h.name, "Remove", h.secondary.Remove())
+}
+
+// dualKeyValue reads from the primary key/value, and mirrors mutations to the secondary key/value
+type dualKeyValue struct {
+	pinterface.IKeyValue
+	secondary pinterface.IKeyValue
+	c         *DualWriteCreator
+	name      string
+}
+
+func (kv *dualKeyValue) Set(key, value string) error {
+	if err := kv.IKeyValue.Set(key, value); err != nil {
+		return err
+	}
+	return kv.c.mirror("keyvalue", kv.name, "Set", kv.secondary.Set(key, value), key, value)
+}
+
+// Inc increments the value on the primary host, and sets the secondary host
+// to the same value, so that a diverged counter is corrected by the next increment
+func (kv *dualKeyValue) Inc(key string) (string, error) {
+	value, err := kv.IKeyValue.Inc(key)
+	if err != nil {
+		return value, err
+	}
+	return value, kv.c.mirror("keyvalue", kv.name, "Inc", kv.secondary.Set(key, value), key)
+}
+
+func (kv *dualKeyValue) Del(key string) error {
+	if err := kv.IKeyValue.Del(key); err != nil {
+		return err
+	}
+	return kv.c.mirror("keyvalue", kv.name, "Del", kv.secondary.Del(key), key)
+}
+
+func (kv *dualKeyValue) Clear() error {
+	if err := kv.IKeyValue.Clear(); err != nil {
+		return err
+	}
+	return kv.c.mirror("keyvalue", kv.name, "Clear", kv.secondary.Clear())
+}
+
+func (kv *dualKeyValue) Remove() error {
+	if err := kv.IKeyValue.Remove(); err != nil {
+		return err
+	}
+	return kv.c.mirror("keyvalue", kv.name, "Remove", kv.secondary.Remove())
+}
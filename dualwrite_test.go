@@ -0,0 +1,67 @@
+package simplehstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/colinf/pinterface"
+)
+
+// memList is an in-memory pinterface.IList, that can be made to fail
+type memList struct {
+	values []string
+	err    error
+}
+
+func (l *memList) Add(value string) error {
+	if l.err != nil {
+		return l.err
+	}
+	l.values = append(l.values, value)
+	return nil
+}
+
+func (l *memList) All() ([]string, error)        { return l.values, nil }
+func (l *memList) Clear() error                  { l.values = nil; return l.err }
+func (l *memList) LastN(n int) ([]string, error) { return l.values[len(l.values)-n:], nil }
+func (l *memList) Last() (string, error)         { return l.values[len(l.values)-1], nil }
+func (l *memList) Remove() error                 { return l.err }
+
+func TestDualWriteDivergence(t *testing.T) {
+	errDown := errors.New("the secondary host is down")
+	var reported []Divergence
+	c := &DualWriteCreator{onDivergence: func(d Divergence) { reported = append(reported, d) }}
+
+	primary, secondary := &memList{}, &memList{}
+	var list pinterface.IList = &dualList{primary, secondary, c, "greetings"}
+	list.Add("hello")
+	if len(primary.values) != 1 || len(secondary.values) != 1 {
+		t.Errorf("Error, expected the value to be added to both lists, got %v and %v", primary.values, secondary.values)
+	}
+
+	// Best effort
+	secondary.err = errDown
+	if err := list.Add("hi"); err != nil {
+		t.Errorf("Error, expected no error in best effort mode, got %v", err)
+	}
+	if len(reported) != 1 || reported[0].Operation != "Add" || reported[0].Name != "greetings" || c.Divergences() != 1 {
+		t.Errorf("Error, expected one divergence to be reported, got %v", reported)
+	}
+
+	// Strict
+	c.mode = DualWriteStrict
+	if err := list.Add("hey"); !errors.Is(err, errDown) {
+		t.Errorf("Error, expected the error from the secondary list in strict mode, got %v", err)
+	}
+	if all, _ := list.All(); len(all) != 3 {
+		t.Errorf("Error, expected the primary list to have all values, got %v", all)
+	}
+
+	// Nothing is mirrored if the primary fails
+	primary.err = errDown
+	secondary.err = nil
+	list.Add("bye")
+	if len(secondary.values) != 1 {
+		t.Errorf("Error, expected nothing to be mirrored, got %v", secondary.values)
+	}
+}
@@ -0,0 +1,198 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultRenewInterval is how often the leader renews its leadership, by default
+	DefaultRenewInterval = 5 * time.Second
+
+	electionTable = "simplehstore_elections"
+)
+
+// ErrNoLeader is returned by Leader when no candidate holds the leadership
+var ErrNoLeader = errors.New("no leader")
+
+// Election makes sure that only one of several candidates, typically one per
+// replica of a service, runs a function at a time. Leadership is held by a
+// session level advisory lock on a dedicated connection, which PostgreSQL
+// releases if the connection is lost. The leader is recorded in a heartbeat
+// row, which is renewed periodically and can be inspected with Leader.
+type Election struct {
+	dbDatastructure
+	name      string
+	candidate string
+}
+
+// NewElection creates a new Election with the given name, for the given candidate.
+// All candidates must use the same name, and each candidate should have a unique ID,
+// for instance the hostname and process ID. See WithRenewInterval.
+func NewElection(provider HostProvider, name, candidate string, opts ...Option) (*Election, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	e := &Election{newDatastructure(host, pq.QuoteIdentifier(electionTable), opts), name, candidate}
+	if e.renewInterval <= 0 {
+		e.renewInterval = DefaultRenewInterval
+	}
+	if err := e.createSchema(); err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (name TEXT PRIMARY KEY, leader TEXT NOT NULL, elected TIMESTAMPTZ NOT NULL, renewed TIMESTAMPTZ NOT NULL)", e.tableName())
+	if _, err := e.host.exec(query); err != nil {
+		return nil, err
+	}
+	if e.verbose() {
+		e.host.Logger().Println("Created election table " + e.tableName() + " in database " + host.dbname)
+	}
+	return e, nil
+}
+
+// lockKey returns the advisory lock key for the election
+func (e *Election) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(electionTable + fieldSep + e.name))
+	return int64(h.Sum64())
+}
+
+// campaign tries to acquire leadership, and returns the connection that holds it, or nil
+func (e *Election) campaign(ctx context.Context) (*sql.Conn, error) {
+	if e.host.db == nil {
+		return nil, ErrNestedTransaction
+	}
+	conn, err := e.host.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, e.host.annotate(ctx, "SELECT pg_try_advisory_lock($1)"), e.lockKey()).Scan(&acquired); err != nil || !acquired {
+		conn.Close()
+		return nil, err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (name, leader, elected, renewed) VALUES ($1, $2, now(), now()) ON CONFLICT (name) DO UPDATE SET leader = EXCLUDED.leader, elected = EXCLUDED.elected, renewed = EXCLUDED.renewed", e.tableName())
+	if _, err := conn.ExecContext(ctx, e.host.annotate(ctx, query), e.name, e.candidate); err != nil {
+		e.resign(conn)
+		return nil, err
+	}
+	if e.verbose() {
+		e.host.Logger().Printf("%s is now the leader of %s\n", e.candidate, e.name)
+	}
+	return conn, nil
+}
+
+// renew updates the heartbeat row, using the connection that holds the leadership
+func (e *Election) renew(ctx context.Context, conn *sql.Conn) error {
+	query := fmt.Sprintf("UPDATE %s SET renewed = now() WHERE name = $1 AND leader = $2", e.tableName())
+	result, err := conn.ExecContext(ctx, e.host.annotate(ctx, query), e.name, e.candidate)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("the heartbeat row for %s is gone", e.name)
+	}
+	return nil
+}
+
+// resign releases the leadership and closes the connection
+func (e *Election) resign(conn *sql.Conn) {
+	// Use a fresh context, since the context of RunWhenLeader may be cancelled
+	ctx := context.Background()
+	query := fmt.Sprintf("DELETE FROM %s WHERE name = $1 AND leader = $2", e.tableName())
+	conn.ExecContext(ctx, e.host.annotate(ctx, query), e.name, e.candidate)
+	conn.ExecContext(ctx, e.host.annotate(ctx, "SELECT pg_advisory_unlock($1)"), e.lockKey())
+	conn.Close()
+}
+
+// RunWhenLeader waits until this candidate is elected leader, and then runs fn.
+// Leadership is renewed periodically while fn runs. If leadership is lost,
+// it is released right away, so that another candidate can be elected, and
+// the context given to fn is cancelled. When fn has returned, this candidate
+// campaigns again. When fn returns by itself, leadership is released and the
+// error from fn is returned. If ctx is cancelled, leadership is released when
+// fn has returned, and ctx.Err() is returned.
+//
+// fn must return soon after its context is cancelled, since RunWhenLeader
+// waits for it, and since another candidate may be running its fn as soon as
+// leadership has been lost.
+func (e *Election) RunWhenLeader(ctx context.Context, fn func(ctx context.Context) error) error {
+	for {
+		conn, err := e.campaign(ctx)
+		if err != nil && e.verbose() {
+			e.host.Logger().Println("Election campaign: " + err.Error())
+		}
+		if conn == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(e.renewInterval):
+				continue
+			}
+		}
+		leaderCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- fn(leaderCtx)
+		}()
+		lost := false
+		ticker := time.NewTicker(e.renewInterval)
+	renewing:
+		for {
+			select {
+			case err := <-done:
+				ticker.Stop()
+				cancel()
+				e.resign(conn)
+				return err
+			case <-ticker.C:
+				if err := e.renew(ctx, conn); err != nil {
+					if e.verbose() {
+						e.host.Logger().Printf("%s lost the leadership of %s: %s\n", e.candidate, e.name, err)
+					}
+					lost = true
+					break renewing
+				}
+			case <-ctx.Done():
+				break renewing
+			}
+		}
+		ticker.Stop()
+		cancel()
+		if lost {
+			e.resign(conn)
+			<-done
+			continue
+		}
+		<-done
+		e.resign(conn)
+		return ctx.Err()
+	}
+}
+
+// Leader returns the current leader and when leadership was last renewed.
+// ErrNoLeader is returned if there is no leader, or if the leader has not
+// renewed its leadership within three renew intervals.
+func (e *Election) Leader() (string, time.Time, error) {
+	var (
+		leader  string
+		renewed time.Time
+	)
+	query := fmt.Sprintf("SELECT leader, renewed FROM %s WHERE name = $1 AND renewed > now() - $2 * interval '1 microsecond'", e.tableName())
+	err := e.host.queryRow(query, e.name, (3*e.renewInterval).Microseconds()).Scan(&leader, &renewed)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, ErrNoLeader
+	}
+	return leader, renewed, err
+}
@@ -0,0 +1,55 @@
+package simplehstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestElection(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	first, err := NewElection(host, "election_test", "first", WithRenewInterval(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewElection(host, "election_test", "second", WithRenewInterval(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elected := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go first.RunWhenLeader(ctx, func(ctx context.Context) error {
+		close(elected)
+		<-ctx.Done()
+		return nil
+	})
+	<-elected
+	if leader, _, err := second.Leader(); err != nil || leader != "first" {
+		t.Errorf("Error, expected first to be the leader, got %s (%v)", leader, err)
+	}
+
+	// The second candidate should not be elected while the first one is the leader
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer shortCancel()
+	ran := false
+	if err := second.RunWhenLeader(shortCtx, func(ctx context.Context) error {
+		ran = true
+		return nil
+	}); err != context.DeadlineExceeded || ran {
+		t.Errorf("Error, the second candidate should not have been elected: %v", err)
+	}
+
+	// When the first candidate resigns, the second one should be elected
+	cancel()
+	longCtx, longCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer longCancel()
+	if err := second.RunWhenLeader(longCtx, func(ctx context.Context) error {
+		ran = true
+		return nil
+	}); err != nil || !ran {
+		t.Errorf("Error, the second candidate should have been elected: %v", err)
+	}
+}
@@ -44,3 +44,48 @@ func Decode(code *string) error {
 	*code = string(decompressedBytes)
 	return nil
 }
+
+// Codec can encode values before they are stored in the database,
+// and decode them after they have been retrieved.
+type Codec interface {
+	// Name is a short name that identifies the codec
+	Name() string
+	// Encode a value before it is stored
+	Encode(value string) (string, error)
+	// Decode a value after it has been retrieved
+	Decode(value string) (string, error)
+}
+
+var (
+	// HexCodec compresses and hex encodes values, so that any UTF-8 string can be stored safely.
+	// This is the default codec.
+	HexCodec Codec = hexCodec{}
+
+	// RawCodec stores values as they are. This is slightly faster,
+	// but malformed UTF-8 strings can potentially cause problems.
+	RawCodec Codec = rawCodec{}
+)
+
+// hexCodec uses Encode and Decode
+type hexCodec struct{}
+
+func (hexCodec) Name() string { return "hex" }
+
+func (hexCodec) Encode(value string) (string, error) {
+	err := Encode(&value)
+	return value, err
+}
+
+func (hexCodec) Decode(value string) (string, error) {
+	err := Decode(&value)
+	return value, err
+}
+
+// rawCodec leaves values untouched
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return "raw" }
+
+func (rawCodec) Encode(value string) (string, error) { return value, nil }
+
+func (rawCodec) Decode(value string) (string, error) { return value, nil }
@@ -0,0 +1,65 @@
+package simplehstore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrNotFound is returned when a key, owner or element does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrTableMissing is returned when the table for a data structure does not exist,
+	// for instance because it has been removed
+	ErrTableMissing = errors.New("the table does not exist")
+	// ErrEncoding is returned when a value can not be encoded or decoded,
+	// which only happens for data structures that are created WithStrict
+	ErrEncoding = errors.New("could not encode or decode value")
+)
+
+// undefinedTable is the PostgreSQL error code for a missing table
+const undefinedTable = "42P01"
+
+// uniqueViolation is the PostgreSQL error code for a duplicate key
+const uniqueViolation = "23505"
+
+// isUniqueViolation checks if err is a duplicate key error from the driver
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// markedError is an error that keeps its message, while also matching one
+// of the exported sentinel errors with errors.Is
+type markedError struct {
+	sentinel error
+	err      error
+}
+
+func (e *markedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *markedError) Unwrap() error {
+	return e.err
+}
+
+func (e *markedError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+// mark returns an error with the given message, that matches the given sentinel error
+func mark(sentinel error, format string, args ...interface{}) error {
+	return &markedError{sentinel, fmt.Errorf(format, args...)}
+}
+
+// wrapDriverError makes errors from the database driver match the sentinel
+// errors, where possible. The driver error is still available with errors.As.
+func wrapDriverError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == undefinedTable {
+		return &markedError{ErrTableMissing, err}
+	}
+	return err
+}
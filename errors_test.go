@@ -0,0 +1,66 @@
+package simplehstore
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	err := mark(ErrNotFound, "key does not exist: %s", "bob")
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrTableMissing) {
+		t.Errorf("Error, expected the error to only match ErrNotFound: %v", err)
+	}
+	if err.Error() != "key does not exist: bob" {
+		t.Errorf("Error, expected the message to be kept, got %s", err)
+	}
+	if !noResult(err) {
+		t.Error("Error, expected a missing key to be no result")
+	}
+
+	driverErr := &pq.Error{Code: undefinedTable, Message: `relation "a_kv_test" does not exist`}
+	err = wrapDriverError(driverErr)
+	if !errors.Is(err, ErrTableMissing) {
+		t.Errorf("Error, expected a missing table to match ErrTableMissing: %v", err)
+	}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr != driverErr {
+		t.Error("Error, expected the driver error to still be available")
+	}
+	if err := wrapDriverError(&pq.Error{Code: "23505"}); errors.Is(err, ErrTableMissing) {
+		t.Error("Error, expected a unique violation to not match ErrTableMissing")
+	}
+	if wrapDriverError(nil) != nil {
+		t.Error("Error, expected nil to stay nil")
+	}
+}
+
+func TestErrNotFound(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "errors_test", WithStrict())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	kv.Set("a", "a")
+	if _, err := kv.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound, got %v", err)
+	}
+	kv.Remove()
+	if _, err := kv.Get("a"); !errors.Is(err, ErrTableMissing) {
+		t.Errorf("Error, expected ErrTableMissing, got %v", err)
+	}
+
+	// Methods that read a single row also wrap the error
+	c, err := NewCounter(host, "errors_test_counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Remove()
+	if _, err := c.Value(); !errors.Is(err, ErrTableMissing) {
+		t.Errorf("Error, expected ErrTableMissing from a single row, got %v", err)
+	}
+}
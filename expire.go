@@ -0,0 +1,157 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SetExpiring sets a key and value, like Set, where the key expires after the
+// given duration. Expired keys are no longer returned by Get, Has, All, Count
+// or Checksum, but they are kept in the table until they are removed by
+// ExpireKeys or RunExpiry. Setting the key again with Set removes the expiry.
+// If the value is not written because of the conflict policy, the expiry of
+// the existing key is left as it is.
+func (kv *KeyValue) SetExpiring(key, value string, ttl time.Duration) error {
+	ctx := kv.host.context()
+	transaction, err := kv.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	txKV := *kv
+	txKV.host = kv.host.withTx(transaction)
+	written, err := txKV.set(key, value)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if !written {
+		return transaction.Commit()
+	}
+	if err := txKV.expire(key, ttl); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+// expire sets the expiry of the given key, if it exists
+func (kv *KeyValue) expire(key string, ttl time.Duration) error {
+	query := fmt.Sprintf("UPDATE %s SET expires = expires || hstore($1, (now() + $2 * interval '1 microsecond')::text) WHERE attr ? $1", kv.tableName())
+	result, err := kv.host.exec(query, key, ttl.Microseconds())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return mark(ErrNotFound, "key does not exist: %s", key)
+	}
+	return nil
+}
+
+// TTL returns the time until the given key expires, and true, or false if the
+// key does not expire. Keys that have expired return 0 and true.
+func (kv *KeyValue) TTL(key string) (time.Duration, bool, error) {
+	var seconds sql.NullFloat64
+	query := fmt.Sprintf("SELECT GREATEST(extract(epoch FROM (expires -> $1)::timestamptz - now()), 0) FROM %s", kv.tableName())
+	if err := kv.host.queryRow(query, key).Scan(&seconds); err != nil {
+		if noResult(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if !seconds.Valid {
+		return 0, false, nil
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second)), true, nil
+}
+
+// Persist removes the expiry of the given key, so that it is kept until it is removed
+func (kv *KeyValue) Persist(key string) error {
+	_, err := kv.host.exec(fmt.Sprintf("UPDATE %s SET expires = delete(expires, $1)", kv.tableName()), key)
+	return err
+}
+
+// ExpireKeys removes all keys that have expired, and returns the number of keys that were removed
+func (kv *KeyValue) ExpireKeys() (int64, error) {
+	query := fmt.Sprintf("WITH expired AS (SELECT e.key FROM %s AS t, each(t.expires) AS e WHERE e.value::timestamptz <= now()), removed AS (UPDATE %s SET attr = delete(attr, ARRAY(SELECT key FROM expired)), expires = delete(expires, ARRAY(SELECT key FROM expired))) SELECT COUNT(*) FROM expired", kv.tableName(), kv.tableName())
+	var n int64
+	err := kv.host.queryRow(query).Scan(&n)
+	return n, err
+}
+
+// expireKeys removes all keys that have expired, in one transaction, and
+// returns them together with their decoded values. The row is locked while
+// the keys are found, so that concurrent calls never return the same key.
+func (kv *KeyValue) expireKeys() (map[string]string, error) {
+	ctx := kv.host.context()
+	transaction, err := kv.host.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txKV := *kv
+	txKV.host = kv.host.withTx(transaction)
+	pairs, err := txKV.scanPairs(fmt.Sprintf("SELECT e.key, t.attr -> e.key FROM %s AS t, each(t.expires) AS e WHERE e.value::timestamptz <= now() FOR UPDATE OF t", kv.tableName()))
+	if err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	if err := kv.decodePairs(pairs); err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	expired := make(map[string]string, len(pairs))
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		expired[pair[0]] = pair[1]
+		keys = append(keys, pair[0])
+	}
+	if len(keys) == 0 {
+		return expired, transaction.Commit()
+	}
+	if _, err := txKV.host.exec(fmt.Sprintf("UPDATE %s SET attr = delete(attr, $1::text[]), expires = delete(expires, $1::text[])", kv.tableName()), pq.Array(keys)); err != nil {
+		transaction.Rollback()
+		return nil, err
+	}
+	return expired, transaction.Commit()
+}
+
+// RunExpiry removes expired keys every interval, until ctx is cancelled.
+// It is not started by default, and is meant to be run in its own goroutine,
+// by one of the processes that use the key/value. If onExpire is not nil, it
+// is called with each key that is removed, and the value it had, so that the
+// application can react to the expiry, for instance by marking the user of
+// an expired session as logged out. Each key is reported once, also when
+// several processes run RunExpiry. onExpire is called from the goroutine of
+// RunExpiry, after the keys have been removed.
+func (kv *KeyValue) RunExpiry(ctx context.Context, interval time.Duration, onExpire func(key, value string)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if onExpire == nil {
+				if _, err := kv.ExpireKeys(); err != nil && kv.verbose() {
+					kv.host.Logger().Println("Expire keys: " + err.Error())
+				}
+				continue
+			}
+			expired, err := kv.expireKeys()
+			if err != nil {
+				if kv.verbose() {
+					kv.host.Logger().Println("Expire keys: " + err.Error())
+				}
+				continue
+			}
+			for key, value := range expired {
+				onExpire(key, value)
+			}
+		}
+	}
+}
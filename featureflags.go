@@ -0,0 +1,194 @@
+package simplehstore
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFlagCacheTTL is how long flags are cached by IsEnabled, by default
+const DefaultFlagCacheTTL = 10 * time.Second
+
+// FeatureFlags is a feature flag store on top of a KeyValue. A flag can be
+// enabled for everyone, for a percentage of owners or for specific owners.
+// Which owners are included in a percentage rollout is decided by a
+// deterministic hash of the flag and the owner, so an owner keeps getting
+// the same result as long as the percentage is not lowered.
+type FeatureFlags struct {
+	kv       *KeyValue
+	channel  string
+	cacheTTL time.Duration
+
+	mut      sync.RWMutex
+	cache    map[string]string
+	loadedAt time.Time
+}
+
+// NewFeatureFlags creates a new FeatureFlags store
+func NewFeatureFlags(provider HostProvider, name string, opts ...Option) (*FeatureFlags, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	kv, err := NewKeyValue(host, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &FeatureFlags{kv: kv, channel: "simplehstore_flags_" + name, cacheTTL: DefaultFlagCacheTTL}, nil
+}
+
+// SetCacheTTL sets how long flags are cached by IsEnabled. 0 disables caching.
+func (ff *FeatureFlags) SetCacheTTL(ttl time.Duration) {
+	ff.mut.Lock()
+	ff.cacheTTL = ttl
+	ff.cache = nil
+	ff.mut.Unlock()
+}
+
+// Invalidate the cached flags, so that they are read from the database the next time
+func (ff *FeatureFlags) Invalidate() {
+	ff.mut.Lock()
+	ff.cache = nil
+	ff.mut.Unlock()
+}
+
+// ownerKey returns the key that is used for enabling a flag for a specific owner
+func ownerKey(flag, owner string) string {
+	return flag + fieldSep + owner
+}
+
+// rolloutBucket returns a number from 0 to 99 for the given flag and owner
+func rolloutBucket(flag, owner string) int {
+	h := fnv.New32a()
+	h.Write([]byte(ownerKey(flag, owner)))
+	return int(h.Sum32() % 100)
+}
+
+// changed invalidates the cache and notifies any watchers that the flag has changed
+func (ff *FeatureFlags) changed(flag string) error {
+	ff.Invalidate()
+	return ff.kv.host.notify(ff.channel, flag)
+}
+
+// Enable the flag for everyone
+func (ff *FeatureFlags) Enable(flag string) error {
+	return ff.EnableForPercent(flag, 100)
+}
+
+// Disable the flag for everyone, except owners that it has been enabled for with EnableForOwner
+func (ff *FeatureFlags) Disable(flag string) error {
+	if err := ff.kv.Del(flag); err != nil {
+		return err
+	}
+	return ff.changed(flag)
+}
+
+// EnableForPercent enables the flag for the given percentage of owners, from 0 to 100
+func (ff *FeatureFlags) EnableForPercent(flag string, pct int) error {
+	if pct < 0 || pct > 100 {
+		return fmt.Errorf("percentage must be from 0 to 100, not %d", pct)
+	}
+	if strings.Contains(flag, fieldSep) {
+		return fmt.Errorf("flag can not contain %s", fieldSep)
+	}
+	if err := ff.kv.Set(flag, strconv.Itoa(pct)); err != nil {
+		return err
+	}
+	return ff.changed(flag)
+}
+
+// EnableForOwner enables the flag for the given owner, regardless of the percentage
+func (ff *FeatureFlags) EnableForOwner(flag, owner string) error {
+	if strings.Contains(flag, fieldSep) {
+		return fmt.Errorf("flag can not contain %s", fieldSep)
+	}
+	if err := ff.kv.Set(ownerKey(flag, owner), "1"); err != nil {
+		return err
+	}
+	return ff.changed(flag)
+}
+
+// DisableForOwner removes an owner that the flag was enabled for with EnableForOwner
+func (ff *FeatureFlags) DisableForOwner(flag, owner string) error {
+	if err := ff.kv.Del(ownerKey(flag, owner)); err != nil {
+		return err
+	}
+	return ff.changed(flag)
+}
+
+// flags returns all stored flags, from the cache if it is fresh
+func (ff *FeatureFlags) flags() (map[string]string, error) {
+	ff.mut.RLock()
+	cache, loadedAt, ttl := ff.cache, ff.loadedAt, ff.cacheTTL
+	ff.mut.RUnlock()
+	if cache != nil && time.Since(loadedAt) < ttl {
+		return cache, nil
+	}
+	m, err := ff.kv.pairs()
+	if err != nil {
+		return nil, err
+	}
+	ff.mut.Lock()
+	ff.cache = m
+	ff.loadedAt = time.Now()
+	ff.mut.Unlock()
+	return m, nil
+}
+
+// IsEnabled checks if the flag is enabled for the given owner
+func (ff *FeatureFlags) IsEnabled(flag, owner string) (bool, error) {
+	m, err := ff.flags()
+	if err != nil {
+		return false, err
+	}
+	if m[ownerKey(flag, owner)] != "" {
+		return true, nil
+	}
+	pct, err := strconv.Atoi(m[flag])
+	if err != nil {
+		// Not set, or not a percentage
+		return false, nil
+	}
+	return rolloutBucket(flag, owner) < pct, nil
+}
+
+// Percent returns the percentage of owners the flag is enabled for
+func (ff *FeatureFlags) Percent(flag string) (int, error) {
+	m, err := ff.flags()
+	if err != nil {
+		return 0, err
+	}
+	pct, _ := strconv.Atoi(m[flag])
+	return pct, nil
+}
+
+// Watch calls fn with the name of each flag that is changed, by this or any
+// other process, until ctx is cancelled. The cache is invalidated for every
+// change, so that IsEnabled sees the change right away. fn may be called with
+// an empty flag name if changes may have been missed.
+func (ff *FeatureFlags) Watch(ctx context.Context, fn func(flag string)) error {
+	return ff.kv.host.listen(ctx, ff.channel, func(flag string) {
+		ff.Invalidate()
+		if fn != nil {
+			fn(flag)
+		}
+	})
+}
+
+// Remove all flags and the underlying KeyValue
+func (ff *FeatureFlags) Remove() error {
+	ff.Invalidate()
+	return ff.kv.Remove()
+}
+
+// Clear all flags
+func (ff *FeatureFlags) Clear() error {
+	if err := ff.kv.Clear(); err != nil {
+		return err
+	}
+	return ff.changed("")
+}
@@ -0,0 +1,58 @@
+package simplehstore
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestRolloutBucket(t *testing.T) {
+	if rolloutBucket("beta", "bob") != rolloutBucket("beta", "bob") {
+		t.Error("Error, the rollout bucket should be deterministic")
+	}
+	counter := 0
+	for i := 0; i < 1000; i++ {
+		if rolloutBucket("beta", "user"+strconv.Itoa(i)) < 50 {
+			counter++
+		}
+	}
+	if counter < 400 || counter > 600 {
+		t.Errorf("Error, expected roughly half of the owners to be included, got %d of 1000", counter)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	ff, err := NewFeatureFlags(host, "featureflags_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff.Clear()
+
+	if enabled, _ := ff.IsEnabled("beta", "bob"); enabled {
+		t.Error("Error, beta should not be enabled yet")
+	}
+	if err := ff.Enable("beta"); err != nil {
+		t.Fatal(err)
+	}
+	if enabled, err := ff.IsEnabled("beta", "bob"); err != nil || !enabled {
+		t.Errorf("Error, beta should be enabled: %v", err)
+	}
+	ff.EnableForPercent("beta", 0)
+	if enabled, _ := ff.IsEnabled("beta", "bob"); enabled {
+		t.Error("Error, beta should not be enabled for 0%")
+	}
+	ff.EnableForOwner("beta", "bob")
+	if enabled, _ := ff.IsEnabled("beta", "bob"); !enabled {
+		t.Error("Error, beta should be enabled for bob")
+	}
+	if enabled, _ := ff.IsEnabled("beta", "alice"); enabled {
+		t.Error("Error, beta should not be enabled for alice")
+	}
+	if err := ff.EnableForPercent("beta", 101); err == nil {
+		t.Error("Error, 101% should not be a valid percentage")
+	}
+
+	ff.Remove()
+}
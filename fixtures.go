@@ -0,0 +1,186 @@
+package simplehstore
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/colinf/pinterface"
+)
+
+// Fixtures is a JSON friendly collection of data structures and their
+// contents, for seeding a database with test users, admin accounts and
+// similar data that should be kept under version control. The data
+// structures are the ones that are available through pinterface.ICreator,
+// so that fixtures can be loaded the same way as permissions2 style
+// applications create their data structures.
+//
+// Example of a fixture file:
+//
+//	{
+//	  "sets": {"usernames": ["admin"]},
+//	  "hashmaps": {"users": {"admin": {"email": "admin@example.com", "admin": "true"}}}
+//	}
+type Fixtures struct {
+	Lists     map[string][]string                     `json:"lists,omitempty"`
+	Sets      map[string][]string                     `json:"sets,omitempty"`
+	KeyValues map[string]map[string]string            `json:"keyvalues,omitempty"`
+	HashMaps  map[string]map[string]map[string]string `json:"hashmaps,omitempty"`
+}
+
+// ReadFixtures reads fixtures in the JSON format from the given reader
+func ReadFixtures(r io.Reader) (*Fixtures, error) {
+	var f Fixtures
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Write the fixtures to the given writer, as indented JSON
+func (f *Fixtures) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(f)
+}
+
+// Load creates the data structures with the given creator, and fills them
+// with the contents of the fixtures. Lists are cleared before the elements
+// are added, while elements of sets, key/values and hash maps are added to
+// any existing contents, so that the same fixtures can be loaded at every startup.
+func (f *Fixtures) Load(creator pinterface.ICreator) error {
+	for name, values := range f.Lists {
+		list, err := creator.NewList(name)
+		if err != nil {
+			return err
+		}
+		if err := list.Clear(); err != nil {
+			return err
+		}
+		for _, value := range values {
+			if err := list.Add(value); err != nil {
+				return err
+			}
+		}
+	}
+	for name, values := range f.Sets {
+		set, err := creator.NewSet(name)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			if err := set.Add(value); err != nil {
+				return err
+			}
+		}
+	}
+	for name, m := range f.KeyValues {
+		kv, err := creator.NewKeyValue(name)
+		if err != nil {
+			return err
+		}
+		for key, value := range m {
+			if err := kv.Set(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	for name, owners := range f.HashMaps {
+		hashMap, err := creator.NewHashMap(name)
+		if err != nil {
+			return err
+		}
+		for owner, m := range owners {
+			for key, value := range m {
+				if err := hashMap.Set(owner, key, value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ImportFixtures reads fixtures from the given JSON file, and loads them
+// into data structures that are created with the given options
+func (host *Host) ImportFixtures(filename string, opts ...Option) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	f, err := ReadFixtures(file)
+	if err != nil {
+		return err
+	}
+	return f.Load(NewCreator(host, opts...))
+}
+
+// ExportFixtures returns the contents of all lists, sets, key/values and
+// hash maps that are found by Discover, opened with the given options.
+// HashMap2 and Queue data structures are not included, since they are
+// not available through pinterface.ICreator.
+func (host *Host) ExportFixtures(opts ...Option) (*Fixtures, error) {
+	structures, err := host.Discover()
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixtures{
+		Lists:     make(map[string][]string),
+		Sets:      make(map[string][]string),
+		KeyValues: make(map[string]map[string]string),
+		HashMaps:  make(map[string]map[string]map[string]string),
+	}
+	for _, s := range structures {
+		switch s.Kind {
+		case "list":
+			list, err := NewList(host, s.Name, opts...)
+			if err != nil {
+				return nil, err
+			}
+			if f.Lists[s.Name], err = list.All(); err != nil {
+				return nil, err
+			}
+		case "set":
+			set, err := NewSet(host, s.Name, opts...)
+			if err != nil {
+				return nil, err
+			}
+			if f.Sets[s.Name], err = set.All(); err != nil {
+				return nil, err
+			}
+		case "keyvalue":
+			kv, err := NewKeyValue(host, s.Name, opts...)
+			if err != nil {
+				return nil, err
+			}
+			if f.KeyValues[s.Name], err = kv.pairs(); err != nil {
+				return nil, err
+			}
+		case "hashmap":
+			hashMap, err := NewHashMap(host, s.Name, opts...)
+			if err != nil {
+				return nil, err
+			}
+			owners, err := hashMap.All()
+			if err != nil {
+				return nil, err
+			}
+			m := make(map[string]map[string]string, len(owners))
+			for _, owner := range owners {
+				keys, err := hashMap.Keys(owner)
+				if err != nil {
+					return nil, err
+				}
+				m[owner] = make(map[string]string, len(keys))
+				for _, key := range keys {
+					if m[owner][key], err = hashMap.Get(owner, key); err != nil {
+						return nil, err
+					}
+				}
+			}
+			f.HashMaps[s.Name] = m
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,63 @@
+package simplehstore
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadFixtures(t *testing.T) {
+	const fixture = `{
+  "sets": {"usernames": ["admin"]},
+  "hashmaps": {"users": {"admin": {"email": "admin@example.com", "admin": "true"}}}
+}`
+	f, err := ReadFixtures(strings.NewReader(fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Sets["usernames"]) != 1 || f.HashMaps["users"]["admin"]["email"] != "admin@example.com" {
+		t.Errorf("Error, unexpected fixtures: %+v", f)
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ReadFixtures(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.HashMaps["users"]["admin"]["admin"] != "true" || again.Lists != nil {
+		t.Errorf("Error, the fixtures did not survive a round trip: %+v", again)
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	f := &Fixtures{
+		Lists:    map[string][]string{"fixtures_test_list": {"a", "b"}},
+		Sets:     map[string][]string{"fixtures_test_usernames": {"admin"}},
+		HashMaps: map[string]map[string]map[string]string{"fixtures_test_users": {"admin": {"admin": "true"}}},
+	}
+	// Loading twice should give the same result
+	for i := 0; i < 2; i++ {
+		if err := f.Load(NewCreator(host)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported, err := host.ExportFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported.Lists["fixtures_test_list"]) != 2 || len(exported.Sets["fixtures_test_usernames"]) != 1 || exported.HashMaps["fixtures_test_users"]["admin"]["admin"] != "true" {
+		t.Errorf("Error, unexpected exported fixtures: %+v", exported)
+	}
+
+	list, _ := NewList(host, "fixtures_test_list")
+	list.Remove()
+	set, _ := NewSet(host, "fixtures_test_usernames")
+	set.Remove()
+	users, _ := NewHashMap(host, "fixtures_test_users")
+	users.Remove()
+}
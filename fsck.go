@@ -0,0 +1,125 @@
+package simplehstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FsckReport contains the problems that Fsck found in a HashMap2
+type FsckReport struct {
+	Keys             int      // the number of stored keys that were examined
+	MissingSeparator []string // stored keys that do not contain the field separator
+	MissingProps     []string // property keys that are in use, but missing from the set of encountered properties
+	OrphanedProps    []string // encountered properties that are no longer used by any owner
+	Misencoded       []string // owner and property keys (joined by the field separator) for values that are not stored with the codec of the hash map, for instance double encoded values
+	Repaired         int      // the number of problems that were repaired
+}
+
+// OK returns true if no problems were found
+func (r *FsckReport) OK() bool {
+	return len(r.MissingSeparator) == 0 && len(r.MissingProps) == 0 && len(r.OrphanedProps) == 0 && len(r.Misencoded) == 0
+}
+
+// Fsck checks the hash map for keys without the field separator, property keys
+// that are missing from or orphaned in the set of encountered properties, and
+// values that are not stored with the codec of the hash map. If repair is true,
+// keys without the separator are removed (they can not be reached through the
+// hash map), the set of encountered properties is brought up to date and the
+// values are rewritten, all within one transaction.
+func (hm2 *HashMap2) Fsck(repair bool) (FsckReport, error) {
+	var report FsckReport
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return report, err
+	}
+	kv := hm2.keyValue()
+	pairs, err := kv.scanPairs(fmt.Sprintf("SELECT key, value FROM (SELECT (each(attr)).* FROM %s) AS temp WHERE value IS NOT NULL ORDER BY key", kv.tableName()))
+	if err != nil {
+		return report, err
+	}
+	props, err := hm2.propSet().All()
+	if err != nil {
+		return report, err
+	}
+	known := make(map[string]bool, len(props))
+	for _, prop := range props {
+		known[prop] = true
+	}
+	used := make(map[string]bool)
+	rewrites := make(map[string]string)
+	for _, pair := range pairs {
+		report.Keys++
+		pos := strings.Index(pair[0], fieldSep)
+		if pos == -1 {
+			report.MissingSeparator = append(report.MissingSeparator, pair[0])
+			continue
+		}
+		prop := pair[0][pos+len(fieldSep):]
+		if !used[prop] {
+			used[prop] = true
+			if !known[prop] {
+				report.MissingProps = append(report.MissingProps, prop)
+			}
+		}
+		plain, _ := peelEncoding(pair[1])
+		wanted, err := kv.getCodec().Encode(plain)
+		if err != nil {
+			return report, err
+		}
+		if wanted != pair[1] {
+			report.Misencoded = append(report.Misencoded, pair[0])
+			rewrites[pair[0]] = wanted
+		}
+	}
+	for _, prop := range props {
+		if !used[prop] {
+			report.OrphanedProps = append(report.OrphanedProps, prop)
+		}
+	}
+	sort.Strings(report.MissingProps)
+	sort.Strings(report.OrphanedProps)
+	if !repair || report.OK() {
+		return report, nil
+	}
+
+	ctx := hm2.host.context()
+	transaction, err := hm2.host.begin(ctx)
+	if err != nil {
+		return report, err
+	}
+	for _, key := range report.MissingSeparator {
+		if _, err := transaction.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET attr = delete(attr, $1::text)", kv.tableName()), key); err != nil {
+			transaction.Rollback()
+			return report, err
+		}
+	}
+	for _, key := range report.Misencoded {
+		if _, err := transaction.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET attr = attr || hstore($1::text, $2::text)", kv.tableName()), key, rewrites[key]); err != nil {
+			transaction.Rollback()
+			return report, err
+		}
+	}
+	propSet := hm2.propSet()
+	for _, prop := range report.MissingProps {
+		if err := propSet.addWithTransactionNoCheck(ctx, transaction, prop); err != nil {
+			transaction.Rollback()
+			return report, err
+		}
+	}
+	for _, prop := range report.OrphanedProps {
+		encoded := prop
+		if err := propSet.encode(&encoded); err != nil {
+			transaction.Rollback()
+			return report, err
+		}
+		if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = $1", propSet.tableName(), setCol), encoded); err != nil {
+			transaction.Rollback()
+			return report, err
+		}
+	}
+	if err := transaction.Commit(); err != nil {
+		return report, err
+	}
+	report.Repaired = len(report.MissingSeparator) + len(report.MissingProps) + len(report.OrphanedProps) + len(report.Misencoded)
+	return report, nil
+}
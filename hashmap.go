@@ -12,29 +12,54 @@ import (
 
 // HashMap is a hash map with a name, key and value, stored in PostgreSQL
 // Useful when storing several keys and values for a specific username, for instance.
-type HashMap dbDatastructure
+type HashMap struct {
+	dbDatastructure
+}
 
 // NewHashMap creates a new HashMap struct
-func NewHashMap(host *Host, name string) (*HashMap, error) {
-	h := &HashMap{host, pq.QuoteIdentifier(name)}
+func NewHashMap(provider HostProvider, name string, opts ...Option) (*HashMap, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	h := &HashMap{newDatastructure(host, pq.QuoteIdentifier(name), opts)}
+	if err := h.createSchema(); err != nil {
+		return nil, err
+	}
 
 	// Create extension hstore
-	query := "CREATE EXTENSION hstore"
+	query := "CREATE EXTENSION IF NOT EXISTS hstore"
 	// Ignore errors if hstore is already enabled
-	h.host.db.Exec(query)
+	h.host.exec(query)
 
 	// Create a new table that maps from the owner string (like user ID) to a blob of hstore ("attr hstore")
 
+	existed, err := h.exists(h.tableName())
+	if err != nil {
+		return nil, err
+	}
+
 	// Using three columns: element id, key and value
-	query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s %s, attr hstore)", h.table, ownerCol, defaultStringType)
-	if Verbose {
+	query = fmt.Sprintf("%s %s (%s %s, attr hstore)", h.createTable(), h.tableName(), ownerCol, defaultStringType)
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	if _, err := h.host.db.Exec(query); err != nil {
+	if _, err := h.host.exec(query); err != nil {
 		return nil, err
 	}
-	if Verbose {
-		log.Println("Created HSTORE table " + h.table + " in database " + host.dbname)
+	if err := h.setStorageParameters(h.tableName()); err != nil {
+		return nil, err
+	}
+	if err := h.register("hashmap", name, h.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if h.index {
+		if err := h.CreateIndexTable(); err != nil {
+			return nil, err
+		}
+	}
+	if h.verbose() {
+		h.host.Logger().Println("Created HSTORE table " + h.tableName() + " in database " + host.dbname)
 	}
 	return h, nil
 }
@@ -43,11 +68,11 @@ func NewHashMap(host *Host, name string) (*HashMap, error) {
 func (h *HashMap) CreateIndexTable() error {
 	// strip double quotes from h.table and add _idx at the end
 	indexTableName := strings.TrimSuffix(strings.TrimPrefix(h.table, "\""), "\"") + "_idx"
-	query := fmt.Sprintf("CREATE INDEX %q ON %s USING GIN (attr)", indexTableName, h.table)
-	if Verbose {
+	query := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %q ON %s USING GIN (attr)", indexTableName, h.tableName())
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	_, err := h.host.db.Exec(query)
+	_, err := h.host.exec(query)
 	return err
 
 }
@@ -56,30 +81,41 @@ func (h *HashMap) CreateIndexTable() error {
 func (h *HashMap) RemoveIndexTable(owner string) error {
 	// strip double quotes from h.table and add _idx at the end
 	indexTableName := strings.TrimSuffix(strings.TrimPrefix(h.table, "\""), "\"") + "_idx"
-	query := fmt.Sprintf("DROP INDEX %q", indexTableName)
-	if Verbose {
+	query := fmt.Sprintf("DROP INDEX %s", h.qualify(fmt.Sprintf("%q", indexTableName)))
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	_, err := h.host.db.Exec(query)
+	_, err := h.host.exec(query)
 	return err
 }
 
-// Set a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
+// Set a value in a hashmap given the element id (for instance a user id) and the key (for instance "password").
+// See WithConflictPolicy for what happens if the key already exists.
 func (h *HashMap) Set(owner, key, value string) error {
-	if !h.host.rawUTF8 {
-		Encode(&value)
+	if err := h.encode(&value); err != nil {
+		return err
 	}
 	encodedValue := value
+	if h.conflict != ConflictOverwrite {
+		n, err := h.insertIfMissing(owner, key, encodedValue)
+		if err != nil {
+			return fmt.Errorf("hashMap Set, insert: %w", err)
+		}
+		if n == 0 {
+			return h.conflictError()
+		}
+		return nil
+	}
 	// First try updating the key/values
 	n, err := h.update(owner, key, encodedValue)
 	if err != nil {
-		return fmt.Errorf("hashMap Set, update: %s", err)
+		return fmt.Errorf("hashMap Set, update: %w", err)
 	}
 	// If no rows are affected (SELECTED) by the update, try inserting a row instead
 	if n == 0 {
 		n, err = h.insert(owner, key, encodedValue)
 		if err != nil {
-			return fmt.Errorf("hashMap Set, insert: %s", err)
+			return fmt.Errorf("hashMap Set, insert: %w", err)
 		}
 		if n == 0 {
 			return errors.New("hashMap Set: could not update or insert any rows")
@@ -92,28 +128,41 @@ func (h *HashMap) Set(owner, key, value string) error {
 // insert a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
 func (h *HashMap) insert(owner, key, encodedValue string) (int64, error) {
 	// Try inserting
-	query := fmt.Sprintf("INSERT INTO %s (%s, attr) VALUES ('%s', '\"%s\"=>\"%s\"') ON CONFLICT DO NOTHING", h.table, ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
-	if Verbose {
+	query := fmt.Sprintf("INSERT INTO %s (%s, attr) VALUES ('%s', '\"%s\"=>\"%s\"') ON CONFLICT DO NOTHING", h.tableName(), ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	result, err := h.host.db.Exec(query)
-	if Verbose {
-		log.Println("Inserted row into: "+h.table+" err? ", err)
+	result, err := h.host.exec(query)
+	if h.verbose() {
+		h.host.Logger().Println("Inserted row into: "+h.tableName()+" err? ", err)
 	}
 	n, _ := result.RowsAffected()
 	return n, err
 }
 
+// insertIfMissing inserts a value in a hashmap, unless the owner already has the given key
+func (h *HashMap) insertIfMissing(owner, key, encodedValue string) (int64, error) {
+	query := fmt.Sprintf("INSERT INTO %s (%s, attr) SELECT '%s', '\"%s\"=>\"%s\"' WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s = '%s' AND attr ? '%s')", h.tableName(), ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue), h.tableName(), ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key))
+	if h.verbose() {
+		fmt.Println(query)
+	}
+	result, err := h.host.exec(query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // update a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
 func (h *HashMap) update(owner, key, encodedValue string) (int64, error) {
 	// Try updating
-	query := fmt.Sprintf("UPDATE %s SET attr = attr || '%q=>%q' :: hstore WHERE %s = '%s' AND attr ? '%s'", h.table, escapeSingleQuotes(key), escapeSingleQuotes(encodedValue), ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key))
-	if Verbose {
+	query := fmt.Sprintf("UPDATE %s SET attr = attr || '%q=>%q' :: hstore WHERE %s = '%s' AND attr ? '%s'", h.tableName(), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue), ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key))
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	result, err := h.host.db.Exec(query)
-	if Verbose {
-		log.Println("Updated row in: "+h.table+" err? ", err)
+	result, err := h.host.exec(query)
+	if h.verbose() {
+		h.host.Logger().Println("Updated row in: "+h.tableName()+" err? ", err)
 	}
 	if result == nil {
 		return 0, fmt.Errorf("no result when trying to update %s -> %s with a value", owner, key)
@@ -125,8 +174,8 @@ func (h *HashMap) update(owner, key, encodedValue string) (int64, error) {
 // SetCheck will set a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
 // Returns true if the key already existed.
 func (h *HashMap) SetCheck(owner, key, value string) (bool, error) {
-	if !h.host.rawUTF8 {
-		Encode(&value)
+	if err := h.encode(&value); err != nil {
+		return false, err
 	}
 	encodedValue := value
 	// First try updating the key/values
@@ -151,11 +200,11 @@ func (h *HashMap) SetCheck(owner, key, value string) (bool, error) {
 
 // Get a value from a hashmap given the element id (for instance a user id) and the key (for instance "password").
 func (h *HashMap) Get(owner, key string) (string, error) {
-	query := fmt.Sprintf("SELECT attr -> '%s' FROM %s WHERE %s = '%s' AND attr ? '%s'", escapeSingleQuotes(key), h.table, ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key))
-	if Verbose {
+	query := fmt.Sprintf("SELECT attr -> '%s' FROM %s WHERE %s = '%s' AND attr ? '%s'", escapeSingleQuotes(key), h.tableName(), ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key))
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	rows, err := h.host.db.Query(query)
+	rows, err := h.host.query(query)
 	if err != nil {
 		return "", err
 	}
@@ -178,22 +227,22 @@ func (h *HashMap) Get(owner, key string) (string, error) {
 		return "", err
 	}
 	if counter == 0 {
-		return "", errors.New("No such owner/key: " + owner + "/" + key)
+		return "", mark(ErrNotFound, "No such owner/key: %s/%s", owner, key)
 	}
 	s := value.String
-	if !h.host.rawUTF8 {
-		Decode(&s)
+	if err := h.decode(&s); err != nil {
+		return "", err
 	}
 	return s, nil
 }
 
 // Has checks if a given owner + key exists in the hash map
 func (h *HashMap) Has(owner, key string) (bool, error) {
-	query := fmt.Sprintf("SELECT attr -> '%s' FROM %s WHERE %s = '%s' AND attr ? '%s'", escapeSingleQuotes(key), h.table, ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key))
-	if Verbose {
+	query := fmt.Sprintf("SELECT attr -> '%s' FROM %s WHERE %s = '%s' AND attr ? '%s'", escapeSingleQuotes(key), h.tableName(), ownerCol, escapeSingleQuotes(owner), escapeSingleQuotes(key))
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	rows, err := h.host.db.Query(query)
+	rows, err := h.host.query(query)
 	if err != nil {
 		return false, err
 	}
@@ -224,8 +273,8 @@ func (h *HashMap) Has(owner, key string) (bool, error) {
 
 // Exists checks if a given owner exists as a hash map at all
 func (h *HashMap) Exists(owner string) (bool, error) {
-	query := fmt.Sprintf("SELECT attr FROM %s WHERE %s = '%s'", h.table, ownerCol, escapeSingleQuotes(owner))
-	rows, err := h.host.db.Query(query)
+	query := fmt.Sprintf("SELECT attr FROM %s WHERE %s = '%s'", h.tableName(), ownerCol, escapeSingleQuotes(owner))
+	rows, err := h.host.query(query)
 	if err != nil {
 		return false, err
 	}
@@ -250,11 +299,11 @@ func (h *HashMap) Exists(owner string) (bool, error) {
 
 // json returns the first found hstore value for the given key as a JSON string
 func (h *HashMap) json(owner string) (string, error) {
-	query := fmt.Sprintf("SELECT hstore_to_json(hstore(array_agg(altering_pairs))) FROM %s, LATERAL unnest(hstore_to_array(attr)) altering_pairs WHERE %s = '%s'", h.table, ownerCol, escapeSingleQuotes(owner))
-	if Verbose {
+	query := fmt.Sprintf("SELECT hstore_to_json(hstore(array_agg(altering_pairs))) FROM %s, LATERAL unnest(hstore_to_array(attr)) altering_pairs WHERE %s = '%s'", h.tableName(), ownerCol, escapeSingleQuotes(owner))
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	rows, err := h.host.db.Query(query)
+	rows, err := h.host.query(query)
 	if err != nil {
 		return "", err
 	}
@@ -268,8 +317,10 @@ func (h *HashMap) json(owner string) (string, error) {
 			return "", err
 		}
 		s := value.String
-		if !h.host.rawUTF8 {
-			Decode(&s)
+		// Decoding may need the connection
+		rows.Close()
+		if err := h.decode(&s); err != nil {
+			return "", err
 		}
 		// Got a value, return it
 		return s, nil
@@ -283,7 +334,7 @@ func (h *HashMap) All() ([]string, error) {
 		values []string
 		value  string
 	)
-	rows, err := h.host.db.Query(fmt.Sprintf("SELECT DISTINCT %s FROM %s", ownerCol, h.table))
+	rows, err := h.host.query(fmt.Sprintf("SELECT DISTINCT %s FROM %s", ownerCol, h.tableName()))
 	if err != nil {
 		return values, err
 	}
@@ -294,27 +345,28 @@ func (h *HashMap) All() ([]string, error) {
 	defer rows.Close()
 	for rows.Next() {
 		err = rows.Scan(&value)
-		if !h.host.rawUTF8 {
-			Decode(&value)
-		}
 		values = append(values, value)
 		if err != nil {
 			return values, err
 		}
 	}
-	err = rows.Err()
+	if err = rows.Err(); err != nil {
+		return values, err
+	}
+	rows.Close()
+	err = h.decodeAll(values)
 	return values, err
 }
 
 // AllWhere returns all owner ID's that has a property where key == value
 func (h *HashMap) AllWhere(key, value string) ([]string, error) {
 	var values []string
-	if !h.host.rawUTF8 {
-		Encode(&value)
+	if err := h.encode(&value); err != nil {
+		return values, err
 	}
 	// Return all owner ID's for all entries that has the given key->value attribute
-	//fmt.Printf("SELECT DISTINCT %s FROM %s WHERE attr @> '\"%s\"=>\"%s\"' :: hstore", ownerCol, h.table, key, value)
-	rows, err := h.host.db.Query(fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE attr @> '\"%s\"=>\"%s\"' :: hstore", ownerCol, h.table, key, value))
+	//fmt.Printf("SELECT DISTINCT %s FROM %s WHERE attr @> '\"%s\"=>\"%s\"' :: hstore", ownerCol, h.tableName(), key, value)
+	rows, err := h.host.query(fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE attr @> '\"%s\"=>\"%s\"' :: hstore", ownerCol, h.tableName(), key, value))
 	if err != nil {
 		return values, err
 	}
@@ -325,22 +377,35 @@ func (h *HashMap) AllWhere(key, value string) ([]string, error) {
 	var v string
 	for rows.Next() {
 		err = rows.Scan(&v)
-		if !h.host.rawUTF8 {
-			Decode(&v)
-		}
 		values = append(values, v)
 		if err != nil {
 			return values, err
 		}
 	}
-	err = rows.Err()
+	if err = rows.Err(); err != nil {
+		return values, err
+	}
+	rows.Close()
+	err = h.decodeAll(values)
 	return values, err
 }
 
+// Checksum returns an md5 sum of all owners, keys and values, sorted by owner and key.
+// The sum is calculated by the database server, and can be used for
+// checking that two hash maps have the same contents.
+func (h *HashMap) Checksum() (string, error) {
+	return h.host.checksum(fmt.Sprintf("SELECT md5(COALESCE(string_agg(%s || E'\\t' || key || E'\\t' || COALESCE(value, ''), E'\\n' ORDER BY %s, key), '')) FROM (SELECT %s, (each(attr)).* FROM %s) AS temp", ownerCol, ownerCol, ownerCol, h.tableName()))
+}
+
+// IsEmpty checks if the hash map has no owners, without counting them
+func (h *HashMap) IsEmpty() (bool, error) {
+	return h.host.noRows(fmt.Sprintf("SELECT 1 FROM %s", h.tableName()))
+}
+
 // Count counts the number of owners for hash map elements
 func (h *HashMap) Count() (int, error) {
 	var value sql.NullInt32
-	rows, err := h.host.db.Query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", ownerCol, h.table))
+	rows, err := h.host.query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", ownerCol, h.tableName()))
 	if err != nil {
 		return 0, err
 	}
@@ -359,7 +424,7 @@ func (h *HashMap) Count() (int, error) {
 // CountInt64 counts the number of owners for hash map elements
 func (h *HashMap) CountInt64() (int64, error) {
 	var value sql.NullInt64
-	rows, err := h.host.db.Query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", ownerCol, h.table))
+	rows, err := h.host.query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", ownerCol, h.tableName()))
 	if err != nil {
 		return 0, err
 	}
@@ -375,14 +440,16 @@ func (h *HashMap) CountInt64() (int64, error) {
 	return value.Int64, nil
 }
 
-// GetAll is deprecated in favor of All
+// GetAll returns the same as All.
+//
+// Deprecated: use All instead.
 func (h *HashMap) GetAll() ([]string, error) {
 	return h.All()
 }
 
 // Keys returns all keys for a given owner
 func (h *HashMap) Keys(owner string) ([]string, error) {
-	rows, err := h.host.db.Query(fmt.Sprintf("SELECT skeys(attr) FROM %s WHERE %s = '%s'", h.table, ownerCol, escapeSingleQuotes(owner)))
+	rows, err := h.host.query(fmt.Sprintf("SELECT skeys(attr) FROM %s WHERE %s = '%s'", h.tableName(), ownerCol, escapeSingleQuotes(owner)))
 	if err != nil {
 		return []string{}, err
 	}
@@ -409,18 +476,18 @@ func (h *HashMap) Keys(owner string) ([]string, error) {
 // DelKey removes a key of an owner in a hashmap (for instance the email field for a user)
 func (h *HashMap) DelKey(owner, key string) error {
 	// Remove a key from the hashmap
-	query := fmt.Sprintf("UPDATE %s SET attr = delete(attr, '%s') WHERE attr ? '%s' AND %s = '%s'", h.table, escapeSingleQuotes(key), escapeSingleQuotes(key), ownerCol, escapeSingleQuotes(owner))
-	if Verbose {
+	query := fmt.Sprintf("UPDATE %s SET attr = delete(attr, '%s') WHERE attr ? '%s' AND %s = '%s'", h.tableName(), escapeSingleQuotes(key), escapeSingleQuotes(key), ownerCol, escapeSingleQuotes(owner))
+	if h.verbose() {
 		fmt.Println(query)
 	}
-	_, err := h.host.db.Exec(query)
+	_, err := h.host.exec(query)
 	return err
 }
 
 // Del removes an element (for instance a user)
 func (h *HashMap) Del(owner string) error {
 	// Remove an element id from the table
-	results, err := h.host.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = '%s'", h.table, ownerCol, escapeSingleQuotes(owner)))
+	results, err := h.host.exec(fmt.Sprintf("DELETE FROM %s WHERE %s = '%s'", h.tableName(), ownerCol, escapeSingleQuotes(owner)))
 	if err != nil {
 		return err
 	}
@@ -428,8 +495,8 @@ func (h *HashMap) Del(owner string) error {
 	if err != nil {
 		return err
 	}
-	if Verbose {
-		log.Println(n, "rows were deleted with Del("+owner+")!")
+	if h.verbose() {
+		h.host.Logger().Println(n, "rows were deleted with Del("+owner+")!")
 	}
 	return nil
 }
@@ -437,19 +504,21 @@ func (h *HashMap) Del(owner string) error {
 // Remove this hashmap
 func (h *HashMap) Remove() error {
 	// Remove the table
-	q := fmt.Sprintf("DROP TABLE %s", h.table)
+	q := fmt.Sprintf("DROP TABLE %s", h.tableName())
 	log.Println(q)
-	_, err := h.host.db.Exec(q)
-	return err
+	if _, err := h.host.exec(q); err != nil {
+		return err
+	}
+	return h.unregister("hashmap", h.tableName())
 }
 
 // Clear the contents
 func (h *HashMap) Clear() error {
-	query := fmt.Sprintf("TRUNCATE TABLE %s", h.table)
-	if Verbose {
+	query := fmt.Sprintf("TRUNCATE TABLE %s", h.tableName())
+	if h.verbose() {
 		fmt.Println(query)
 	}
 	// Clear the table
-	_, err := h.host.db.Exec(query)
+	_, err := h.host.exec(query)
 	return err
 }
@@ -3,9 +3,7 @@ package simplehstore
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/lib/pq"
@@ -22,32 +20,48 @@ type HashMap2 struct {
 const fieldSep = "¤"
 
 // NewHashMap2 creates a new HashMap2 struct
-func NewHashMap2(host *Host, name string) (*HashMap2, error) {
+func NewHashMap2(provider HostProvider, name string, opts ...Option) (*HashMap2, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
 	var hm2 HashMap2
 	// kv is a KeyValue (HSTORE) table of all properties (key = owner_ID + "¤" + property_key)
-	kv, err := NewKeyValue(host, name+"_properties_HSTORE_map")
+	kv, err := NewKeyValue(host, name+"_properties_HSTORE_map", opts...)
 	if err != nil {
 		return nil, err
 	}
 	// seenPropSet is a set of all encountered property keys
-	seenPropSet, err := NewSet(host, name+"_encountered_property_keys")
+	seenPropSet, err := NewSet(host, name+"_encountered_property_keys", opts...)
 	if err != nil {
 		return nil, err
 	}
-	hm2.host = host
-	hm2.table = kv.table
+	// Hash maps created by earlier versions do not have a column for owner expiry
+	if err := kv.addColumn(kv.tableName(), "owner_expires", "hstore DEFAULT hstore('')"); err != nil {
+		return nil, err
+	}
+	hm2.dbDatastructure = kv.dbDatastructure
 	hm2.seenPropTable = seenPropSet.table
+	if hm2.columnLayout {
+		if err := hm2.createColumns(); err != nil {
+			return nil, err
+		}
+	}
+	// The codec is already pinned by the KeyValue, this is for keeping track of the HashMap2 itself
+	if err := hm2.register("hashmap2", name, kv.tableName(), true); err != nil {
+		return nil, err
+	}
 	return &hm2, nil
 }
 
 // keyValue returns the *KeyValue of properties for this HashMap2
 func (hm2 *HashMap2) keyValue() *KeyValue {
-	return &KeyValue{hm2.host, hm2.table}
+	return &KeyValue{hm2.dbDatastructure}
 }
 
 // propSet returns the property *Set for this HashMap2
 func (hm2 *HashMap2) propSet() *Set {
-	return &Set{hm2.host, hm2.seenPropTable}
+	return &Set{dbDatastructure{host: hm2.host, table: hm2.seenPropTable, options: hm2.options}}
 }
 
 // Set a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
@@ -57,7 +71,7 @@ func (hm2 *HashMap2) Set(owner, key, value string) error {
 
 // updatePropWithTransaction will set a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
 // Note that the database can not be empty when calling this! The HSTORE must be initialized first, possibly with an INSERT!
-func (hm2 *HashMap2) updatePropWithTransaction(ctx context.Context, transaction *sql.Tx, owner, key, value string, checkForFieldSep bool) error {
+func (hm2 *HashMap2) updatePropWithTransaction(ctx context.Context, transaction queryer, owner, key, value string, checkForFieldSep bool) error {
 	if checkForFieldSep {
 		if strings.Contains(owner, fieldSep) {
 			return fmt.Errorf("owner can not contain %s", fieldSep)
@@ -72,17 +86,20 @@ func (hm2 *HashMap2) updatePropWithTransaction(ctx context.Context, transaction
 	}
 	// Set a key + value for this "owner¤key"
 	kv := hm2.keyValue()
-	if !kv.host.rawUTF8 {
-		Encode(&value)
+	if err := kv.encode(&value); err != nil {
+		return err
 	}
 	encodedValue := value
-	_, err := kv.updateWithTransaction(ctx, transaction, owner+fieldSep+key, encodedValue)
+	n, err := kv.updateWithTransaction(ctx, transaction, owner+fieldSep+key, encodedValue)
+	if err == nil && n == 0 && kv.conflict != ConflictOverwrite {
+		return kv.conflictError()
+	}
 	return err
 }
 
 // insertPropWithTransaction will set a value in a hashmap given the element id (for instance a user id) and the key (for instance "password")
 // Note that the database can not be empty when calling this! The HSTORE must be initialized first, possibly with an INSERT!
-func (hm2 *HashMap2) insertPropWithTransaction(ctx context.Context, transaction *sql.Tx, owner, key, value string, checkForFieldSep bool) error {
+func (hm2 *HashMap2) insertPropWithTransaction(ctx context.Context, transaction queryer, owner, key, value string, checkForFieldSep bool) error {
 	if checkForFieldSep {
 		if strings.Contains(owner, fieldSep) {
 			return fmt.Errorf("owner can not contain %s", fieldSep)
@@ -97,16 +114,21 @@ func (hm2 *HashMap2) insertPropWithTransaction(ctx context.Context, transaction
 	}
 	// Set a key + value for this "owner¤key"
 	kv := hm2.keyValue()
-	if !kv.host.rawUTF8 {
-		Encode(&value)
+	if err := kv.encode(&value); err != nil {
+		return err
 	}
 	encodedValue := value
 	_, err := kv.insertWithTransaction(ctx, transaction, owner+fieldSep+key, encodedValue)
 	return err
 }
 
-// SetMap will set many keys/values, in a single transaction
+// SetMap will set many keys/values, in a single transaction.
+// If the ConflictError policy is used and one of the keys already exists,
+// none of the keys are set. See WithConflictPolicy.
 func (hm2 *HashMap2) SetMap(owner string, m map[string]string) error {
+	if hm2.columnLayout {
+		return hm2.setMapColumns(owner, m)
+	}
 	checkForFieldSep := true
 
 	// Get all properties
@@ -122,11 +144,15 @@ func (hm2 *HashMap2) SetMap(owner string, m map[string]string) error {
 	}
 
 	// Use a context and a transaction to bundle queries
-	ctx := context.Background()
-	transaction, err := hm2.host.db.BeginTx(ctx, nil)
+	ctx := hm2.host.context()
+	transaction, err := hm2.host.begin(ctx)
 	if err != nil {
 		return err
 	}
+	if err := hm2.lockOwner(ctx, transaction, owner); err != nil {
+		transaction.Rollback()
+		return err
+	}
 
 	insertedKey := ""
 	if isEmpty { // Insert just one key, to initialize the HSTORE value
@@ -171,6 +197,9 @@ func (hm2 *HashMap2) SetMap(owner string, m map[string]string) error {
 // These must all be brand new "usernames" (the first key), and not be in the existing hm2.OwnerSet().
 // This function has good performance, but must be used carefully.
 func (hm2 *HashMap2) SetLargeMap(allProperties map[string]map[string]string) error {
+	if hm2.columnLayout {
+		return hm2.setLargeMapColumns(allProperties)
+	}
 
 	// First get the KeyValue and Set structures that will be used
 	kv := hm2.keyValue()
@@ -199,108 +228,117 @@ func (hm2 *HashMap2) SetLargeMap(allProperties map[string]map[string]string) err
 		}
 	}
 
-	ctx := context.Background()
+	ctx := hm2.host.context()
 
-	if Verbose {
+	if hm2.verbose() {
 		fmt.Println("Starting transaction")
 	}
 
 	// Create a new transaction
-	transaction, err := hm2.host.db.BeginTx(ctx, nil)
+	transaction, err := hm2.host.begin(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Store the new properties
 	for _, prop := range newProps {
-		if Verbose {
+		if hm2.verbose() {
 			fmt.Printf("ADDING %s\n", prop)
 		}
 		if err := propSet.addWithTransactionNoCheck(ctx, transaction, prop); err != nil {
+			transaction.Rollback()
 			return err
 		}
 	}
 
-	var (
-		sb                     strings.Builder
-		beyondFirst            bool
-		firstO, firstK, firstV string
-	)
-
-	// Build a long key+value string
+	// Load all keys and values into a staging table with COPY, which is much
+	// faster than sending them as part of a query
+	staging := pq.QuoteIdentifier("simplehstore_staging")
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (key TEXT NOT NULL, value TEXT) ON COMMIT DROP", staging)); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	pairs := make([][]interface{}, 0, len(allProperties))
 	for owner, propMap := range allProperties {
 		for k, v := range propMap {
-			if firstO == "" {
-				firstO = owner
-				firstK = k
-				firstV = v
-			}
-			if beyondFirst {
-				sb.WriteString(",")
-			} else {
-				beyondFirst = true
-			}
-			if !kv.host.rawUTF8 {
-				Encode(&v)
+			if err := kv.encode(&v); err != nil {
+				transaction.Rollback()
+				return err
 			}
-			sb.WriteString("\"" + owner + fieldSep + k + "\"=>\"" + v + "\"")
+			pairs = append(pairs, []interface{}{owner + fieldSep + k, v})
 		}
 	}
-
-	// Initialize the HSTORE, if needed
-	if isEmpty && firstO != "" && firstK != "" && firstV != "" {
-		encodedValue := firstV
-		if !kv.host.rawUTF8 {
-			Encode(&encodedValue)
-		}
-		kv.insert(firstO+fieldSep+firstK, encodedValue)
+	if err := transaction.copyIn(ctx, "simplehstore_staging", []string{"key", "value"}, pairs); err != nil {
+		transaction.Rollback()
+		return err
 	}
 
-	// Try setting+updating all values, in a transaction
-	query := fmt.Sprintf("UPDATE %s SET attr = attr || '%s' :: hstore", pq.QuoteIdentifier(kvPrefix+kv.table), escapeSingleQuotes(sb.String()))
-	if Verbose {
-		fmt.Println(query)
-	}
-	result, err := transaction.ExecContext(ctx, query)
-	if Verbose {
-		log.Println("Updated row in: "+kv.table+" err? ", err)
+	// Merge the staging table into the HSTORE, with a single query
+	merged := fmt.Sprintf("SELECT hstore(array_agg(key), array_agg(value)) FROM %s", staging)
+	query := fmt.Sprintf("UPDATE %s SET attr = attr || (%s)", kv.tableName(), merged)
+	if isEmpty {
+		// Initialize the HSTORE
+		query = fmt.Sprintf("INSERT INTO %s (attr) %s", kv.tableName(), merged)
 	}
-	if result == nil {
-		transaction.Rollback()
-		return fmt.Errorf("keyValue updateWithTransaction: no result when updating with %s", sb.String())
+	if len(pairs) > 0 {
+		if hm2.verbose() {
+			fmt.Println(query)
+		}
+		_, err := transaction.ExecContext(ctx, query)
+		if hm2.verbose() {
+			hm2.host.Logger().Println("Updated row in: "+kv.table+" err? ", err)
+		}
+		if err != nil {
+			transaction.Rollback()
+			return err
+		}
 	}
-	_, err = result.RowsAffected()
-	if err != nil {
+	// Drop the staging table now, in case the transaction is not owned and is used for more
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", staging)); err != nil {
 		transaction.Rollback()
 		return err
 	}
 
-	if Verbose {
+	if hm2.verbose() {
 		fmt.Println("Committing transaction")
 	}
-	if err := transaction.Commit(); err != nil {
-		return err
-	}
-
-	fmt.Println("Transaction complete")
-
-	return nil // success
+	return transaction.Commit()
 }
 
 // Get a value.
 // Returns: value, error
 // If a value was not found, an empty string is returned.
 func (hm2 *HashMap2) Get(owner, key string) (string, error) {
+	if hm2.columnLayout {
+		query := fmt.Sprintf("SELECT value FROM %s WHERE owner = $1 AND prop = $2", hm2.columnsTable())
+		return hm2.getColumns(hm2.host.queryRow(query, owner, key), owner, key)
+	}
 	return hm2.keyValue().Get(owner + fieldSep + key)
 }
 
+// GetForUpdate gets a value as part of the given transaction, and locks it
+// until the transaction is committed or rolled back, so that the value can be
+// safely read, modified and written back. Since all owners are stored in a
+// single row, the entire hash map is locked.
+func (hm2 *HashMap2) GetForUpdate(tx *sql.Tx, owner, key string) (string, error) {
+	if hm2.columnLayout {
+		// Only the row of the property is locked
+		query := fmt.Sprintf("SELECT value FROM %s WHERE owner = $1 AND prop = $2 FOR UPDATE", hm2.columnsTable())
+		return hm2.getColumns(hm2.host.withTx(tx).queryRow(query, owner, key), owner, key)
+	}
+	return hm2.keyValue().GetForUpdate(tx, owner+fieldSep+key)
+}
+
 // GetMap can retrieve multiple values in one transaction
 func (hm2 *HashMap2) GetMap(owner string, keys []string) (map[string]string, error) {
+	if hm2.columnLayout {
+		return hm2.getMapColumns(owner, keys)
+	}
 	results := make(map[string]string)
 
 	// Use a context and a transaction to bundle queries
-	ctx := context.Background()
-	transaction, err := hm2.host.db.BeginTx(ctx, nil)
+	ctx := hm2.host.context()
+	transaction, err := hm2.host.begin(ctx)
 	if err != nil {
 		return results, err
 	}
@@ -320,7 +358,7 @@ func (hm2 *HashMap2) GetMap(owner string, keys []string) (map[string]string, err
 
 // Has checks if a given owner + key exists in the hash map
 func (hm2 *HashMap2) Has(owner, key string) (bool, error) {
-	s, err := hm2.keyValue().Get(owner + fieldSep + key)
+	s, err := hm2.Get(owner, key)
 	if err != nil {
 		if noResult(err) {
 			// Not an actual error, just got no results
@@ -337,71 +375,117 @@ func (hm2 *HashMap2) Has(owner, key string) (bool, error) {
 }
 
 // Exists checks if a given owner exists as a hash map at all.
+// This is done with a single query, that stops at the first matching key.
 func (hm2 *HashMap2) Exists(owner string) (bool, error) {
+	if hm2.columnLayout {
+		var found bool
+		err := hm2.host.queryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE owner = $1)", hm2.columnsTable()), owner).Scan(&found)
+		return found, err
+	}
+	kv := hm2.keyValue()
+	// Match the prefix with left() instead of LIKE, so that owners with % or _ are matched exactly
+	query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM (SELECT skeys(attr) FROM %s) AS temp WHERE left(skeys, length($1)) = $1)", kv.tableName())
+	var found bool
+	if err := kv.host.queryRow(query, owner+fieldSep).Scan(&found); err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// AllWhere returns all owner ID's that has a property where key == value.
+// The filtering is done by the database server, in a single query.
+func (hm2 *HashMap2) AllWhere(key, value string) ([]string, error) {
 	kv := hm2.keyValue()
-	query := fmt.Sprintf("SELECT SUBSTRING(skeys,'(.*)%s') FROM (SELECT skeys(attr) FROM %s) AS temp WHERE skeys LIKE '%s%s%%' LIMIT 1",
+	if err := kv.encode(&value); err != nil {
+		return []string{}, err
+	}
+	if hm2.columnLayout {
+		return hm2.ownersColumns(fmt.Sprintf("SELECT DISTINCT owner FROM %s WHERE prop = $1 AND value = $2", hm2.columnsTable()), key, value)
+	}
+	query := fmt.Sprintf("SELECT DISTINCT split_part(e.key, '%s', 1) FROM %s AS t, each(t.attr) AS e WHERE split_part(e.key, '%s', 2) = $1 AND e.value = $2",
 		fieldSep,
-		pq.QuoteIdentifier(kvPrefix+kv.table),
-		owner,
+		kv.tableName(),
 		fieldSep,
 	)
-	rows, err := kv.host.db.Query(query)
+	rows, err := kv.host.query(query, key, value)
 	if err != nil {
-		return false, err
-	}
-	if rows == nil {
-		return false, errors.New("hashMap2 Exists returned no rows for owner " + owner)
+		return []string{}, err
 	}
 	defer rows.Close()
-	var scanValue sql.NullString
-	// Get the value. Should not loop more than once.
-	counter := 0
+	var (
+		owner  string
+		owners []string
+	)
 	for rows.Next() {
-		err = rows.Scan(&scanValue)
-		if err != nil {
-			// No rows
-			return false, err
+		if err := rows.Scan(&owner); err != nil {
+			return owners, err
 		}
-		counter++
-	}
-	if err := rows.Err(); err != nil {
-		return false, err
+		owners = append(owners, owner)
 	}
-	return counter > 0, nil
+	return owners, rows.Err()
 }
 
-// AllWhere returns all owner ID's that has a property where key == value
-func (hm2 *HashMap2) AllWhere(key, value string) ([]string, error) {
+// DelWhere removes all owners, with all their properties, that have the given
+// key set to the given value, in a single statement. The number of removed
+// owners is returned. This can be used for purging all users that are banned.
+func (hm2 *HashMap2) DelWhere(key, value string) (int64, error) {
 	kv := hm2.keyValue()
-	if !kv.host.rawUTF8 {
-		Encode(&value)
+	if err := kv.encode(&value); err != nil {
+		return 0, err
 	}
-	query := fmt.Sprintf("SELECT SUBSTRING(skeys,'(.*)%s') FROM (SELECT skeys(attr), svals(attr) FROM %s) AS temp WHERE skeys LIKE '%%%s%s' AND svals = '%s'",
+	if hm2.columnLayout {
+		var n int64
+		query := fmt.Sprintf("WITH owners AS (SELECT DISTINCT owner FROM %s WHERE prop = $1 AND value = $2), removed AS (DELETE FROM %s WHERE owner IN (SELECT owner FROM owners)) SELECT COUNT(*) FROM owners", hm2.columnsTable(), hm2.columnsTable())
+		err := hm2.host.queryRow(query, key, value).Scan(&n)
+		return n, err
+	}
+	query := fmt.Sprintf("WITH owners AS (SELECT DISTINCT split_part(e.key, '%s', 1) AS owner FROM %s AS t, each(t.attr) AS e WHERE split_part(e.key, '%s', 2) = $1 AND e.value = $2), removed AS (UPDATE %s SET attr = delete(attr, ARRAY(SELECT k FROM skeys(attr) AS k WHERE split_part(k, '%s', 1) IN (SELECT owner FROM owners)))) SELECT COUNT(*) FROM owners",
+		fieldSep,
+		kv.tableName(),
 		fieldSep,
-		pq.QuoteIdentifier(kvPrefix+kv.table),
+		kv.tableName(),
 		fieldSep,
-		key,
-		value,
 	)
-	rows, err := kv.host.db.Query(query)
+	var n int64
+	err := kv.host.queryRow(query, key, value).Scan(&n)
+	return n, err
+}
+
+// DuplicateValues returns all values of the given property that are shared by more than one owner.
+// This can be used for finding duplicate e-mail addresses before requiring them to be unique.
+func (hm2 *HashMap2) DuplicateValues(key string) ([]string, error) {
+	kv := hm2.keyValue()
+	query := fmt.Sprintf("SELECT value FROM (SELECT (each(attr)).* FROM %s) AS temp WHERE split_part(key, '%s', 2) = $1 GROUP BY value HAVING COUNT(*) > 1",
+		kv.tableName(),
+		fieldSep,
+	)
+	if hm2.columnLayout {
+		query = fmt.Sprintf("SELECT value FROM %s WHERE %s = $1 GROUP BY value HAVING COUNT(*) > 1", hm2.columnsTable(), pq.QuoteIdentifier("prop"))
+	}
+	if hm2.verbose() {
+		fmt.Println(query)
+	}
+	rows, err := kv.host.query(query, key)
 	if err != nil {
 		return []string{}, err
 	}
 	if rows == nil {
 		return []string{}, ErrNoAvailableValues
 	}
-	defer rows.Close()
 	var v sql.NullString
 	var values []string
 	for rows.Next() {
-		err = rows.Scan(&v)
-		vs := v.String
-		values = append(values, vs)
-		if err != nil {
+		if err = rows.Scan(&v); err != nil {
+			rows.Close()
 			return values, err
 		}
+		values = append(values, v.String)
 	}
-	err = rows.Err()
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return values, err
+	}
+	err = kv.decodeAll(values)
 	return values, err
 }
 
@@ -410,90 +494,189 @@ func (hm2 *HashMap2) AllPossibleKeys() ([]string, error) {
 	return hm2.propSet().All()
 }
 
-// Keys loops through absolutely all owners and all properties in the database
-// and returns all found keys.
+// Keys returns all keys for the given owner, with a single query
 func (hm2 *HashMap2) Keys(owner string) ([]string, error) {
-	allProps, err := hm2.propSet().All()
+	kv := hm2.keyValue()
+	prefix := owner + fieldSep
+	query := fmt.Sprintf("SELECT substr(skeys, length($1) + 1) FROM (SELECT skeys(attr) FROM %s) AS temp WHERE left(skeys, length($1)) = $1 ORDER BY 1", kv.tableName())
+	if hm2.columnLayout {
+		prefix = owner
+		query = fmt.Sprintf("SELECT prop FROM %s WHERE owner = $1 ORDER BY 1", hm2.columnsTable())
+	}
+	rows, err := kv.host.query(query, prefix)
 	if err != nil {
 		return []string{}, err
 	}
-	// TODO: Improve the performance of this by using SQL instead of looping
+	defer rows.Close()
 	allKeys := []string{}
-	for _, key := range allProps {
-		if found, err := hm2.Has(owner, key); err == nil && found {
-			allKeys = append(allKeys, key)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return allKeys, err
 		}
+		allKeys = append(allKeys, key)
 	}
-	return allKeys, nil
+	return allKeys, rows.Err()
 }
 
-// All returns all owner ID's
+// All returns all owner ID's. The owners are found by the database server,
+// so that only the distinct owners are returned, not all the keys.
 func (hm2 *HashMap2) All() ([]string, error) {
-	foundOwners := make(map[string]bool)
-	allOwnersAndKeys, err := hm2.keyValue().All()
+	if hm2.columnLayout {
+		return hm2.ownersColumns(fmt.Sprintf("SELECT DISTINCT owner FROM %s", hm2.columnsTable()))
+	}
+	kv := hm2.keyValue()
+	query := fmt.Sprintf("SELECT DISTINCT split_part(skeys, '%s', 1) FROM (SELECT skeys(attr) FROM %s) AS temp WHERE position('%s' in skeys) > 0", fieldSep, kv.tableName(), fieldSep)
+	rows, err := kv.host.query(query)
 	if err != nil {
 		return []string{}, err
 	}
-	for _, ownerAndKey := range allOwnersAndKeys {
-		if pos := strings.Index(ownerAndKey, fieldSep); pos != -1 {
-			owner := ownerAndKey[:pos]
-			if _, has := foundOwners[owner]; !has {
-				foundOwners[owner] = true
-			}
+	defer rows.Close()
+	owners := []string{}
+	for rows.Next() {
+		var owner string
+		if err := rows.Scan(&owner); err != nil {
+			return owners, err
+		}
+		owners = append(owners, owner)
+	}
+	return owners, rows.Err()
+}
+
+// GetAll returns all keys and values for the given owner, with a single
+// query. This is both faster than calling Get for each of the Keys, and
+// gives a consistent view of the properties of the owner.
+// Unlike the deprecated GetAll of List, Set and HashMap, this is not an alias
+// for All, which returns the owners.
+func (hm2 *HashMap2) GetAll(owner string) (map[string]string, error) {
+	kv := hm2.keyValue()
+	prefix := owner + fieldSep
+	results := make(map[string]string)
+	query := fmt.Sprintf("SELECT substr(e.key, length($1) + 1), e.value FROM %s AS t, each(t.attr) AS e WHERE left(e.key, length($1)) = $1", kv.tableName())
+	if hm2.columnLayout {
+		prefix = owner
+		query = fmt.Sprintf("SELECT prop, value FROM %s WHERE owner = $1", hm2.columnsTable())
+	}
+	pairs, err := kv.scanPairs(query, prefix)
+	if err != nil {
+		return results, err
+	}
+	if err := kv.decodePairs(pairs); err != nil {
+		return results, err
+	}
+	for _, pair := range pairs {
+		results[pair[0]] = pair[1]
+	}
+	return results, nil
+}
+
+// AnyOwner returns an arbitrary owner, and true, or false if the hash map
+// has no owners. Only one key is fetched from the database.
+func (hm2 *HashMap2) AnyOwner() (string, bool, error) {
+	var owner string
+	query := fmt.Sprintf("SELECT split_part(skeys, '%s', 1) FROM (SELECT skeys(attr) FROM %s) AS temp WHERE position('%s' in skeys) > 0 LIMIT 1", fieldSep, hm2.keyValue().tableName(), fieldSep)
+	if hm2.columnLayout {
+		query = fmt.Sprintf("SELECT owner FROM %s LIMIT 1", hm2.columnsTable())
+	}
+	if err := hm2.host.queryRow(query).Scan(&owner); err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
 		}
+		return "", false, err
 	}
-	keys := make([]string, len(foundOwners))
-	i := 0
-	for k := range foundOwners {
-		keys[i] = k
-		i++
+	return owner, true, nil
+}
+
+// IsEmpty checks if the hash map has no owners, without counting them.
+// Unlike Empty, this is also true if all owners have been deleted.
+func (hm2 *HashMap2) IsEmpty() (bool, error) {
+	if hm2.columnLayout {
+		return hm2.host.noRows(fmt.Sprintf("SELECT 1 FROM %s", hm2.columnsTable()))
 	}
-	return keys, nil
+	return hm2.keyValue().IsEmpty()
 }
 
 // Count counts the number of owners for hash map elements
 func (hm2 *HashMap2) Count() (int64, error) {
-	a, err := hm2.All()
-	if err != nil {
-		return 0, err
+	var count int64
+	// Counting the keys of the KeyValue is not correct, since it counts all owners + fieldSep + keys
+	query := fmt.Sprintf("SELECT COUNT(DISTINCT split_part(skeys, '%s', 1)) FROM (SELECT skeys(attr) FROM %s) AS temp WHERE position('%s' in skeys) > 0", fieldSep, hm2.keyValue().tableName(), fieldSep)
+	if hm2.columnLayout {
+		query = fmt.Sprintf("SELECT COUNT(DISTINCT owner) FROM %s", hm2.columnsTable())
+	}
+	err := hm2.host.queryRow(query).Scan(&count)
+	return count, err
+}
+
+// Checksum returns an md5 sum of all owners, keys and values, sorted by owner and key.
+// The sum is calculated by the database server, and can be used for
+// checking that two hash maps have the same contents.
+func (hm2 *HashMap2) Checksum() (string, error) {
+	if hm2.columnLayout {
+		// The same sum as for the HSTORE layout, for the same contents
+		key := fmt.Sprintf("owner || '%s' || prop", fieldSep)
+		return hm2.host.checksum(fmt.Sprintf("SELECT md5(COALESCE(string_agg(%s || E'\\t' || COALESCE(value, ''), E'\\n' ORDER BY %s), '')) FROM %s", key, key, hm2.columnsTable()))
 	}
-	return int64(len(a)), nil
-	// return hm2.KeyValue().Count() is not correct, since it counts all owners + fieldSep + keys
+	return hm2.keyValue().Checksum()
 }
 
 // DelKey removes a key of an owner in a hashmap (for instance the email field for a user)
 func (hm2 *HashMap2) DelKey(owner, key string) error {
-	// The key is not removed from the set of all encountered properties
-	// even if it's the last key with that name, for a performance vs storage tradeoff.
-	return hm2.keyValue().Del(owner + fieldSep + key)
+	return hm2.withOwnerLocks([]string{owner}, func(hm2 *HashMap2) error {
+		// The key is not removed from the set of all encountered properties
+		// even if it's the last key with that name, for a performance vs storage tradeoff.
+		if hm2.columnLayout {
+			_, err := hm2.host.exec(fmt.Sprintf("DELETE FROM %s WHERE owner = $1 AND prop = $2", hm2.columnsTable()), owner, key)
+			return err
+		}
+		return hm2.keyValue().Del(owner + fieldSep + key)
+	})
 }
 
 // Del removes an element (for instance a user)
 func (hm2 *HashMap2) Del(owner string) error {
-	allProps, err := hm2.propSet().All()
-	if err != nil {
-		return err
+	return hm2.DelAll([]string{owner})
+}
+
+// DelAll removes all keys for all the given owners, with a single query
+func (hm2 *HashMap2) DelAll(owners []string) error {
+	if len(owners) == 0 {
+		return nil
 	}
-	for _, key := range allProps {
-		if err := hm2.keyValue().Del(owner + fieldSep + key); err != nil {
+	return hm2.withOwnerLocks(owners, func(hm2 *HashMap2) error {
+		if hm2.columnLayout {
+			_, err := hm2.host.exec(fmt.Sprintf("DELETE FROM %s WHERE owner = ANY($1)", hm2.columnsTable()), pq.Array(owners))
 			return err
 		}
-	}
-	return nil
+		kv := hm2.keyValue()
+		query := fmt.Sprintf("UPDATE %s SET attr = delete(attr, ARRAY(SELECT k FROM skeys(attr) AS k WHERE split_part(k, '%s', 1) = ANY($1))), owner_expires = delete(owner_expires, $1::text[])", kv.tableName(), fieldSep)
+		_, err := kv.host.exec(query, pq.Array(owners))
+		return err
+	})
 }
 
 // Remove this hashmap
 func (hm2 *HashMap2) Remove() error {
 	hm2.propSet().Remove()
+	if hm2.columnLayout {
+		if _, err := hm2.host.exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", hm2.columnsTable())); err != nil {
+			return err
+		}
+	}
 	if err := hm2.keyValue().Remove(); err != nil {
-		return fmt.Errorf("could not remove kv: %s", err)
+		return fmt.Errorf("could not remove kv: %w", err)
 	}
-	return nil
+	return hm2.unregister("hashmap2", hm2.keyValue().tableName())
 }
 
 // Clear the contents
 func (hm2 *HashMap2) Clear() error {
 	hm2.propSet().Clear()
+	if hm2.columnLayout {
+		if _, err := hm2.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", hm2.columnsTable())); err != nil {
+			return err
+		}
+	}
 	if err := hm2.keyValue().Clear(); err != nil {
 		return err
 	}
@@ -502,5 +685,8 @@ func (hm2 *HashMap2) Clear() error {
 
 // Empty checks if there are no owners+keys+values
 func (hm2 *HashMap2) Empty() (bool, error) {
+	if hm2.columnLayout {
+		return hm2.IsEmpty()
+	}
 	return hm2.keyValue().Empty()
 }
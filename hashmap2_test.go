@@ -1,8 +1,13 @@
 package simplehstore
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	// For testing the storage of bcrypt password hashes
 	"golang.org/x/crypto/bcrypt"
@@ -613,3 +618,607 @@ func TestDashesAndQuotes2(t *testing.T) {
 		t.Errorf("Error, could not remove hashmap! %s", err)
 	}
 }
+
+func TestDuplicateValues2(t *testing.T) {
+	Verbose = true
+
+	//host := New() // locally
+	host := NewHost(defaultConnectionString)
+
+	defer host.Close()
+	hashmap, err := NewHashMap2(host, hashmapname)
+	if err != nil {
+		t.Error(err)
+	}
+	hashmap.Clear()
+
+	hashmap.Set("bob", "email", "bob@example.com")
+	hashmap.Set("alice", "email", "shared@example.com")
+	hashmap.Set("eve", "email", "shared@example.com")
+
+	dups, err := hashmap.DuplicateValues("email")
+	if err != nil {
+		t.Errorf("Error, could not find duplicate values! %s", err)
+	}
+	if len(dups) != 1 || dups[0] != "shared@example.com" {
+		t.Errorf("Error, expected one duplicate e-mail address, got %v", dups)
+	}
+
+	hashmap.Remove()
+}
+
+func TestJoin(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "join_test_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invites, err := NewHashMap2(host, "join_test_invites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	invites.Clear()
+
+	users.Set("alice", "email", "alice@example.com")
+	users.Set("bob", "email", "bob@example.com")
+	invites.Set("invite1", "address", "bob@example.com")
+	invites.Set("invite2", "address", "carol@example.com")
+	pairs, err := users.Join(invites, "email", "address")
+	if err != nil || len(pairs) != 1 || pairs[0] != [2]string{"bob", "invite1"} {
+		t.Errorf("Error, expected bob to match invite1, got %v (%v)", pairs, err)
+	}
+
+	users.Remove()
+	invites.Remove()
+}
+
+func TestSnapshot(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "snapshot_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.Set("bob", "email", "bob@example.com")
+
+	snapshot, err := users.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := snapshot.Get("bob", "email"); err != nil || v != "bob@example.com" {
+		t.Errorf("Error, expected bob@example.com, got %s (%v)", v, err)
+	}
+	// Writes made after the snapshot was taken should not be visible
+	users.Set("bob", "email", "bob@example.org")
+	users.Set("alice", "email", "alice@example.com")
+	if v, err := snapshot.Get("bob", "email"); err != nil || v != "bob@example.com" {
+		t.Errorf("Error, expected bob@example.com from the snapshot, got %s (%v)", v, err)
+	}
+	if n, err := snapshot.Count(); err != nil || n != 1 {
+		t.Errorf("Error, expected one owner in the snapshot, got %d (%v)", n, err)
+	}
+	if err := snapshot.Close(); err != nil {
+		t.Error(err)
+	}
+	if n, err := users.Count(); err != nil || n != 2 {
+		t.Errorf("Error, expected two owners after the snapshot, got %d (%v)", n, err)
+	}
+
+	users.Remove()
+}
+
+func TestDelWhere(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "delwhere_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.SetMap("bob", map[string]string{"banned": "true", "email": "bob@example.com"})
+	users.SetMap("eve", map[string]string{"banned": "true", "email": "eve@example.com"})
+	users.SetMap("alice", map[string]string{"banned": "false", "email": "alice@example.com"})
+
+	if n, err := users.DelWhere("banned", "true"); err != nil || n != 2 {
+		t.Errorf("Error, expected two owners to be removed, got %d (%v)", n, err)
+	}
+	if all, err := users.All(); err != nil || len(all) != 1 || all[0] != "alice" {
+		t.Errorf("Error, expected only alice to be left, got %v (%v)", all, err)
+	}
+
+	kv := users.keyValue()
+	if n, err := kv.DelWhereValue("alice@example.com"); err != nil || n != 1 {
+		t.Errorf("Error, expected one key to be removed, got %d (%v)", n, err)
+	}
+	if has, err := users.Has("alice", "email"); err != nil || has {
+		t.Errorf("Error, expected the e-mail address of alice to be gone (%v)", err)
+	}
+
+	users.Remove()
+}
+
+func TestExistsWildcards(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "exists_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.Set("bob's_1", "email", "bob@example.com")
+	for owner, expected := range map[string]bool{"bob's_1": true, "bob's%": false, "bob's_": false, "bob": false} {
+		if found, err := users.Exists(owner); err != nil || found != expected {
+			t.Errorf("Error, expected Exists(%q) to be %v, got %v (%v)", owner, expected, found, err)
+		}
+	}
+
+	users.Remove()
+}
+
+func TestAllWhereExactKey(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "allwhere_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.Set("bob", "email", "it's true")
+	users.Set("alice", "mail", "it's true")
+	users.Set("eve", "email", "false")
+	owners, err := users.AllWhere("mail", "it's true")
+	if err != nil || len(owners) != 1 || owners[0] != "alice" {
+		t.Errorf("Error, expected only alice, got %v (%v)", owners, err)
+	}
+
+	users.Remove()
+}
+
+func TestGetAll(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "getall_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.SetMap("bob", map[string]string{"email": "bob@example.com", "password": "hunter1"})
+	users.Set("bobby", "email", "bobby@example.com")
+
+	props, err := users.GetAll("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(props) != 2 || props["email"] != "bob@example.com" || props["password"] != "hunter1" {
+		t.Errorf("Error, unexpected properties for bob: %v", props)
+	}
+	if props, err := users.GetAll("carol"); err != nil || len(props) != 0 {
+		t.Errorf("Error, expected no properties for carol, got %v (%v)", props, err)
+	}
+
+	users.Remove()
+}
+
+func TestInterning(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "interning_test", WithCodec(RawCodec), WithInterning(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	settings := strings.Repeat("dark mode, large font, ", 10)
+	users.Set("bob", "settings", settings)
+	users.Set("alice", "settings", settings)
+	users.Set("alice", "email", "alice@example.com")
+
+	// Only a reference is stored for the long value, and both owners share it
+	kv := users.keyValue()
+	var bobRef, aliceRef, email string
+	query := "SELECT attr -> $1 FROM " + kv.tableName()
+	host.queryRow(query, "bob"+fieldSep+"settings").Scan(&bobRef)
+	host.queryRow(query, "alice"+fieldSep+"settings").Scan(&aliceRef)
+	host.queryRow(query, "alice"+fieldSep+"email").Scan(&email)
+	if !strings.HasPrefix(bobRef, internMarker) || bobRef != aliceRef {
+		t.Errorf("Error, expected the same reference for both owners, got %q and %q", bobRef, aliceRef)
+	}
+	if strings.HasPrefix(email, internMarker) {
+		t.Error("Error, short values should not be interned")
+	}
+
+	if s, err := users.Get("alice", "settings"); err != nil || s != settings {
+		t.Errorf("Error, expected the interned value back, got %q (%v)", s, err)
+	}
+	if owners, err := users.AllWhere("settings", settings); err != nil || len(owners) != 2 {
+		t.Errorf("Error, expected two owners with the same settings, got %v (%v)", owners, err)
+	}
+
+	// Interned values are read after the rows, also within a transaction
+	err = host.InTransaction(func(tx *Transaction) error {
+		all, err := users.WithTx(tx).GetAll("alice")
+		if err != nil {
+			return err
+		}
+		if all["settings"] != settings || all["email"] != "alice@example.com" {
+			t.Errorf("Error, expected the interned value in the transaction, got %v", all)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	users.Remove()
+}
+
+func TestSetLargeMapQuotes(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "setlargemap_test", WithCodec(RawCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	all := map[string]map[string]string{
+		"bob":   {"motto": `it's "quoted"`, "path": `C:\Users\bob`},
+		"alice": {"motto": "=> no escape"},
+	}
+	if err := users.SetLargeMap(all); err != nil {
+		t.Fatal(err)
+	}
+	for owner, props := range all {
+		got, err := users.GetAll(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range props {
+			if got[k] != v {
+				t.Errorf("Error, expected %s.%s to be %q, got %q", owner, k, v, got[k])
+			}
+		}
+	}
+
+	users.Remove()
+}
+
+func TestDictionary(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "dictionary_test", WithDictionary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	plans := map[string]string{"bob": "premium", "alice": "free", "eve": "free", "mallory": "premium"}
+	for owner, plan := range plans {
+		if err := users.Set(owner, "plan", plan); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the codes are stored, and each plan is in the dictionary once
+	kv := users.keyValue()
+	var stored string
+	host.queryRow("SELECT attr -> $1 FROM "+kv.tableName(), "bob"+fieldSep+"plan").Scan(&stored)
+	if !strings.HasPrefix(stored, dictMarker) {
+		t.Errorf("Error, expected a dictionary code to be stored, got %q", stored)
+	}
+	var n int
+	host.queryRow("SELECT COUNT(*) FROM " + kv.dictTable()).Scan(&n)
+	if n != 2 {
+		t.Errorf("Error, expected 2 values in the dictionary, got %d", n)
+	}
+
+	for owner, plan := range plans {
+		if s, err := users.Get(owner, "plan"); err != nil || s != plan {
+			t.Errorf("Error, expected the plan of %s to be %s, got %q (%v)", owner, plan, s, err)
+		}
+	}
+	if owners, err := users.AllWhere("plan", "free"); err != nil || len(owners) != 2 {
+		t.Errorf("Error, expected two owners on the free plan, got %v (%v)", owners, err)
+	}
+
+	users.Remove()
+}
+
+func TestDelAll(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "delall_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	for _, owner := range []string{"bob", "eve", "alice", "bobby"} {
+		users.SetMap(owner, map[string]string{"email": owner + "@example.com", "plan": "free"})
+	}
+	if err := users.DelAll([]string{"bob", "eve", "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	all, err := users.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(all)
+	if len(all) != 2 || all[0] != "alice" || all[1] != "bobby" {
+		t.Errorf("Error, expected alice and bobby to be left, got %v", all)
+	}
+
+	users.Remove()
+}
+
+func TestExpireOwner(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "expireowner_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.SetMap("bob", map[string]string{"email": "bob@example.com"})
+	users.SetMap("invited", map[string]string{"email": "invited@example.com", "invitation_code": "abc123"})
+	if err := users.ExpireOwner("invited", 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok, err := users.OwnerTTL("invited"); err != nil || !ok || ttl <= 0 {
+		t.Errorf("Error, expected a TTL for the invited owner, got %v %v (%v)", ttl, ok, err)
+	}
+	if _, ok, err := users.OwnerTTL("bob"); err != nil || ok {
+		t.Errorf("Error, expected bob to not expire (%v)", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	owners, err := users.ExpireOwners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 1 || owners[0] != "invited" {
+		t.Errorf("Error, expected the invited owner to expire, got %v", owners)
+	}
+	if found, err := users.Exists("invited"); err != nil || found {
+		t.Errorf("Error, expected the invited owner to be removed (%v)", err)
+	}
+	props, err := users.AllPossibleKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(props) != 1 || props[0] != "email" {
+		t.Errorf("Error, expected only the email property to be left, got %v", props)
+	}
+
+	users.Remove()
+}
+
+func TestWatch(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "watch_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := users.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Set("bob", "email", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.DelKey("bob", "email"); err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []string{"insert", "delete"} {
+		select {
+		case e := <-events:
+			if e.Op != op || e.Name != "watch_test" || e.Owner != "bob" || e.Key != "email" {
+				t.Errorf("Error, expected a %s event for the email of bob, got %+v", op, e)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Error, expected a %s event", op)
+		}
+	}
+	cancel()
+	for range events {
+	}
+
+	users.Remove()
+}
+
+func TestStream(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "stream_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	stream, err := users.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Set("bob", "email", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Set("bob", "email", "bob@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	events, err := stream.Read("cache", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Op != "update" || events[1].Owner != "bob" || events[1].Old != "bob@example.com" || events[1].New != "bob@example.org" {
+		t.Fatalf("Error, expected an insert and an update event, got %+v", events)
+	}
+	if err := stream.Ack("cache", events[0].Offset); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the unacknowledged event is delivered, and the offset is committed with it
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var consumed []StreamEvent
+	err = stream.Consume(ctx, "cache", func(tx *Transaction, e StreamEvent) error {
+		consumed = append(consumed, e)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(consumed) != 1 || consumed[0].Offset != events[1].Offset {
+		t.Errorf("Error, expected only the update event to be consumed, got %+v", consumed)
+	}
+	if offset, err := stream.Offset("cache"); err != nil || offset != events[1].Offset {
+		t.Errorf("Error, expected the offset to be %d, got %d (%v)", events[1].Offset, offset, err)
+	}
+	if n, err := stream.Trim(); err != nil || n != 2 {
+		t.Errorf("Error, expected 2 events to be trimmed, got %d (%v)", n, err)
+	}
+
+	users.Remove()
+}
+
+func TestParallelForEachOwner(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "parallel_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	for i := 0; i < 20; i++ {
+		if err := users.Set(fmt.Sprintf("user%d", i), "visits", "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = users.ParallelForEachOwner(context.Background(), 4, func(ctx context.Context, worker *HashMap2, owner string) error {
+		return worker.Set(owner, "visits", "2")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if visits, err := users.Get(fmt.Sprintf("user%d", i), "visits"); err != nil || visits != "2" {
+			t.Errorf("Error, expected user%d to be visited, got %q (%v)", i, visits, err)
+		}
+	}
+
+	users.Remove()
+}
+
+func TestOwnerLocking(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "ownerlock_test", WithOwnerLocking())
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	errs := make(chan error)
+	for _, writer := range []string{"x", "y"} {
+		go func(writer string) {
+			for i := 0; i < 20; i++ {
+				if err := users.SetMap("bob", map[string]string{"first": writer, "second": writer}); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(writer)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := users.GetAll("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["first"] != m["second"] {
+		t.Errorf("Error, expected the properties from a single SetMap call, got %v", m)
+	}
+
+	users.Remove()
+}
+
+func TestColumnLayout(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	old, err := NewHashMap2(host, "layout_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Clear()
+	old.SetMap("bob", map[string]string{"email": "bob@example.com", "role": "admin"})
+	old.Set("alice", "email", "alice@example.com")
+	sum, err := old.Checksum()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hm2, err := NewHashMap2(host, "layout_test", WithColumnLayout())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := hm2.MigrateToColumnLayout(); err != nil || n != 3 {
+		t.Errorf("Error, expected 3 properties to be migrated, got %d (%v)", n, err)
+	}
+	if empty, err := old.IsEmpty(); err != nil || !empty {
+		t.Errorf("Error, expected the HSTORE layout to be empty after migrating, got %v (%v)", empty, err)
+	}
+	if migratedSum, err := hm2.Checksum(); err != nil || migratedSum != sum {
+		t.Errorf("Error, expected the checksum to be the same after migrating, got %s and %s (%v)", sum, migratedSum, err)
+	}
+	if v, err := hm2.Get("bob", "role"); err != nil || v != "admin" {
+		t.Errorf("Error, expected admin, got %s (%v)", v, err)
+	}
+	if _, err := hm2.Get("bob", "phone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound, got %v", err)
+	}
+	if owners, err := hm2.AllWhere("email", "alice@example.com"); err != nil || len(owners) != 1 || owners[0] != "alice" {
+		t.Errorf("Error, expected alice, got %v (%v)", owners, err)
+	}
+
+	// Owners and keys can contain the field separator
+	if err := hm2.Set("carol"+fieldSep+"x", "a"+fieldSep+"b", "c"); err != nil {
+		t.Error(err)
+	}
+	if keys, err := hm2.Keys("carol" + fieldSep + "x"); err != nil || len(keys) != 1 || keys[0] != "a"+fieldSep+"b" {
+		t.Errorf("Error, expected the key with the field separator, got %v (%v)", keys, err)
+	}
+	if n, err := hm2.Count(); err != nil || n != 3 {
+		t.Errorf("Error, expected 3 owners, got %d (%v)", n, err)
+	}
+	if err := hm2.Del("bob"); err != nil {
+		t.Error(err)
+	}
+	if found, err := hm2.Exists("bob"); err != nil || found {
+		t.Errorf("Error, expected bob to be removed, got %v (%v)", found, err)
+	}
+	if _, err := hm2.Query().Where("email", "alice@example.com").Owners(); err != ErrUnsupportedLayout {
+		t.Errorf("Error, expected ErrUnsupportedLayout, got %v", err)
+	}
+
+	hm2.Remove()
+}
@@ -0,0 +1,232 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// columnsPrefix is the prefix of the tables of hash maps with the column layout
+const columnsPrefix = "a_hm2_"
+
+// ErrUnsupportedLayout is returned by the HashMap2 methods that are only
+// available for the HSTORE layout, when the column layout is used
+var ErrUnsupportedLayout = errors.New("not supported for hash maps with the column layout")
+
+// WithColumnLayout stores each property of a HashMap2 as a row of its own,
+// with the owner, the key and the value in separate columns and (owner, key)
+// as the primary key, instead of as an owner¤key key in a single HSTORE value.
+// Owners and keys can then contain any character, writes to different owners
+// no longer lock the same row, and properties are found by owner or by key
+// with an index. Hash maps that use the HSTORE layout can be moved to the
+// column layout with MigrateToColumnLayout.
+//
+// Query, Join, Watch, Stream, Fsck, Repair and owner expiry are only available
+// for the HSTORE layout, and return ErrUnsupportedLayout.
+func WithColumnLayout() Option {
+	return func(o *options) {
+		o.columnLayout = true
+	}
+}
+
+// name returns the name that was given to NewHashMap2
+func (hm2 *HashMap2) name() string {
+	return strings.TrimSuffix(hm2.table, "_properties_HSTORE_map")
+}
+
+// columnsTable returns the quoted and possibly schema qualified name of the table for the column layout
+func (hm2 *HashMap2) columnsTable() string {
+	return hm2.qualify(pq.QuoteIdentifier(columnsPrefix + hm2.name()))
+}
+
+// requireHstoreLayout returns ErrUnsupportedLayout if the hash map uses the column layout
+func (hm2 *HashMap2) requireHstoreLayout() error {
+	if hm2.columnLayout {
+		return ErrUnsupportedLayout
+	}
+	return nil
+}
+
+// createColumns creates the table for the column layout, if it does not exist
+func (hm2 *HashMap2) createColumns() error {
+	query := fmt.Sprintf("%s %s (owner TEXT NOT NULL, prop TEXT NOT NULL, value TEXT, PRIMARY KEY (owner, prop))", hm2.createTable(), hm2.columnsTable())
+	if _, err := hm2.host.exec(query); err != nil {
+		return err
+	}
+	if err := hm2.setStorageParameters(hm2.columnsTable()); err != nil {
+		return err
+	}
+	// The primary key already finds the properties of an owner
+	_, err := hm2.host.exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (prop)", pq.QuoteIdentifier(columnsPrefix+hm2.name()+"_prop_idx"), hm2.columnsTable()))
+	return err
+}
+
+// MigrateToColumnLayout moves all properties from the HSTORE layout to the
+// column layout, in a single transaction, and returns the number of moved
+// properties. The hash map must be created WithColumnLayout, with the same
+// name as the hash map that used the HSTORE layout. Properties that already
+// exist in the column layout are kept as they are, and owner expiry is not
+// moved. The encountered property keys are shared by both layouts.
+func (hm2 *HashMap2) MigrateToColumnLayout() (int64, error) {
+	if !hm2.columnLayout {
+		return 0, fmt.Errorf("%s was not created with WithColumnLayout", hm2.name())
+	}
+	kv := hm2.keyValue()
+	ctx := hm2.host.context()
+	transaction, err := hm2.host.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (owner, prop, value) SELECT split_part(e.key, '%s', 1), substr(e.key, position('%s' in e.key) + length('%s')), e.value FROM %s AS t, each(t.attr) AS e WHERE position('%s' in e.key) > 0 AND COALESCE((t.expires -> e.key)::timestamptz > now(), true) ON CONFLICT (owner, prop) DO NOTHING",
+		hm2.columnsTable(), fieldSep, fieldSep, fieldSep, kv.tableName(), fieldSep)
+	result, err := transaction.ExecContext(ctx, query)
+	if err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	// Not TRUNCATE, so that the sets that are linked to the owners WithOwnersOf keep their members
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", kv.tableName())); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	return n, transaction.Commit()
+}
+
+// setMapColumns sets the given properties of the owner, in a single statement
+func (hm2 *HashMap2) setMapColumns(owner string, m map[string]string) error {
+	propset := hm2.propSet()
+	allProperties, err := propset.All()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(m))
+	values := make([]string, 0, len(m))
+	for k, v := range m {
+		if err := hm2.encode(&v); err != nil {
+			return err
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	ctx := hm2.host.context()
+	transaction, err := hm2.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := hm2.lockOwner(ctx, transaction, owner); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	conflict := "DO UPDATE SET value = EXCLUDED.value"
+	if hm2.conflict != ConflictOverwrite {
+		conflict = "DO NOTHING"
+	}
+	query := fmt.Sprintf("INSERT INTO %s (owner, prop, value) SELECT $1, k, v FROM unnest($2::text[], $3::text[]) AS p (k, v) ON CONFLICT (owner, prop) %s", hm2.columnsTable(), conflict)
+	result, err := transaction.ExecContext(ctx, query, owner, pq.Array(keys), pq.Array(values))
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		transaction.Rollback()
+		return err
+	} else if int(n) < len(keys) && hm2.conflict == ConflictError {
+		transaction.Rollback()
+		return ErrExists
+	}
+	for _, k := range keys {
+		if !hasS(allProperties, k) {
+			if err := propset.Add(k); err != nil {
+				transaction.Rollback()
+				return err
+			}
+		}
+	}
+	return transaction.Commit()
+}
+
+// setLargeMapColumns adds the properties of many owners, with a single statement
+func (hm2 *HashMap2) setLargeMapColumns(allProperties map[string]map[string]string) error {
+	var owners, keys, values []string
+	for owner, propMap := range allProperties {
+		for k, v := range propMap {
+			if err := hm2.encode(&v); err != nil {
+				return err
+			}
+			owners = append(owners, owner)
+			keys = append(keys, k)
+			values = append(values, v)
+		}
+	}
+	if err := hm2.propSet().AddAll(keys); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (owner, prop, value) SELECT * FROM unnest($1::text[], $2::text[], $3::text[]) ON CONFLICT (owner, prop) DO UPDATE SET value = EXCLUDED.value", hm2.columnsTable())
+	_, err := hm2.host.exec(query, pq.Array(owners), pq.Array(keys), pq.Array(values))
+	return err
+}
+
+// getColumns returns the value of a property, or ErrNotFound
+func (hm2 *HashMap2) getColumns(row *row, owner, key string) (string, error) {
+	var value sql.NullString
+	if err := row.Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", mark(ErrNotFound, "key does not exist: %s", owner+fieldSep+key)
+		}
+		return "", err
+	}
+	s := value.String
+	if err := hm2.decode(&s); err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", mark(ErrNotFound, "key does not exist: %s", owner+fieldSep+key)
+	}
+	return s, nil
+}
+
+// getMapColumns returns the values of the given properties of the owner, with a
+// single query. Properties that do not exist have empty values.
+func (hm2 *HashMap2) getMapColumns(owner string, keys []string) (map[string]string, error) {
+	results := make(map[string]string, len(keys))
+	for _, key := range keys {
+		results[key] = ""
+	}
+	pairs, err := hm2.scanPairs(fmt.Sprintf("SELECT prop, value FROM %s WHERE owner = $1 AND prop = ANY($2)", hm2.columnsTable()), owner, pq.Array(keys))
+	if err != nil {
+		return results, err
+	}
+	if err := hm2.decodePairs(pairs); err != nil {
+		return results, err
+	}
+	for _, pair := range pairs {
+		results[pair[0]] = pair[1]
+	}
+	return results, nil
+}
+
+// ownersColumns returns the owners that are selected by the given query
+func (hm2 *HashMap2) ownersColumns(query string, args ...interface{}) ([]string, error) {
+	rows, err := hm2.host.query(query, args...)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+	owners := []string{}
+	for rows.Next() {
+		var owner string
+		if err := rows.Scan(&owner); err != nil {
+			return owners, err
+		}
+		owners = append(owners, owner)
+	}
+	return owners, rows.Err()
+}
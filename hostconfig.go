@@ -0,0 +1,104 @@
+package simplehstore
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// HostConfig is the effective configuration of a Host, for reporting how the
+// storage layer of a running service is set up. The timeouts are the server
+// settings for the session, as reported by SHOW.
+type HostConfig struct {
+	Version          string // the version of this package
+	ServerVersion    string // the version of the PostgreSQL server
+	Dialect          string
+	Database         string
+	ConnectionString string // with the password redacted, or "" if the Host was not created from one
+	SearchPath       string // where tables are created, unless WithSchema is given
+
+	MaxOpenConnections int // the maximum size of the connection pool, or 0 for no limit
+	OpenConnections    int // the current size of the connection pool
+
+	StatementTimeout         string
+	LockTimeout              string
+	IdleInTransactionTimeout string
+
+	Codec         string // the codec for data structures that are created without WithCodec
+	Verbose       bool
+	QueryTags     bool
+	InTransaction bool // if all queries are run as part of a transaction
+}
+
+// passwordSetting matches the password in a connection string on the key=value form
+var passwordSetting = regexp.MustCompile(`password=('(\\'|[^'])*'|\S+)`)
+
+// redactConnectionString replaces the password in a connection string
+func redactConnectionString(connectionString string) string {
+	if u, err := url.Parse(connectionString); err == nil && u.Scheme != "" && u.User != nil {
+		return u.Redacted()
+	}
+	return passwordSetting.ReplaceAllString(connectionString, "password=xxxxx")
+}
+
+// Config returns the effective configuration of the host
+func (host *Host) Config() (HostConfig, error) {
+	c := HostConfig{
+		Version:          VersionString,
+		Dialect:          host.Dialect(),
+		Database:         strings.Trim(host.dbname, `"`),
+		ConnectionString: redactConnectionString(host.dsn),
+		Codec:            HexCodec.Name(),
+		Verbose:          Verbose,
+		QueryTags:        host.queryTags,
+		InTransaction:    host.tx != nil,
+	}
+	if host.rawUTF8 {
+		c.Codec = RawCodec.Name()
+	}
+	if host.db != nil {
+		stats := host.db.Stats()
+		c.MaxOpenConnections, c.OpenConnections = stats.MaxOpenConnections, stats.OpenConnections
+	}
+	query := "SELECT current_setting('server_version'), current_setting('search_path'), current_setting('statement_timeout'), current_setting('lock_timeout'), current_setting('idle_in_transaction_session_timeout')"
+	err := host.queryRow(query).Scan(&c.ServerVersion, &c.SearchPath, &c.StatementTimeout, &c.LockTimeout, &c.IdleInTransactionTimeout)
+	return c, err
+}
+
+// fields returns the names and values of the fields, in order
+func (c HostConfig) fields() ([]string, []string) {
+	v := reflect.ValueOf(c)
+	names := make([]string, v.NumField())
+	values := make([]string, v.NumField())
+	for i := range names {
+		names[i] = v.Type().Field(i).Name
+		values[i] = fmt.Sprint(v.Field(i).Interface())
+	}
+	return names, values
+}
+
+// String returns the configuration with one "Name: value" line per field
+func (c HostConfig) String() string {
+	var sb strings.Builder
+	names, values := c.fields()
+	for i, name := range names {
+		sb.WriteString(name + ": " + values[i] + "\n")
+	}
+	return sb.String()
+}
+
+// Diff returns a "Name: value -> other value" line for each field that
+// differs from the other configuration, for instance between two services
+func (c HostConfig) Diff(other HostConfig) []string {
+	names, values := c.fields()
+	_, otherValues := other.fields()
+	diff := []string{}
+	for i, name := range names {
+		if values[i] != otherValues[i] {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", name, values[i], otherValues[i]))
+		}
+	}
+	return diff
+}
@@ -0,0 +1,87 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// ErrUnsupportedDialect is returned when a HostProvider does not provide a PostgreSQL database
+var ErrUnsupportedDialect = errors.New("only the postgres dialect is supported")
+
+// HostProvider provides a database connection pool that is managed elsewhere,
+// for instance by the application or by another package, so that it can be
+// shared with the data structures in this package. A *Host is a HostProvider,
+// and all data structure constructors accept a HostProvider.
+type HostProvider interface {
+	// DB returns the connection pool
+	DB() *sql.DB
+	// Dialect returns the SQL dialect of the database, which must be "postgres"
+	Dialect() string
+	// Logger returns the logger that is used for verbose logging
+	Logger() *log.Logger
+}
+
+// DB returns the underlying *sql.DB database struct
+func (host *Host) DB() *sql.DB {
+	return host.db
+}
+
+// Dialect returns "postgres"
+func (host *Host) Dialect() string {
+	return "postgres"
+}
+
+// Logger returns the logger that is used for verbose logging
+func (host *Host) Logger() *log.Logger {
+	if host.logger != nil {
+		return host.logger
+	}
+	return log.Default()
+}
+
+// hostOf returns the given provider if it is a *Host, or a new *Host that uses
+// the connection pool and logger of the provider
+func hostOf(provider HostProvider) (*Host, error) {
+	if host, ok := provider.(*Host); ok {
+		return host, nil
+	}
+	if provider.Dialect() != "postgres" {
+		return nil, ErrUnsupportedDialect
+	}
+	host, err := NewHostFromDB(provider.DB())
+	if err != nil {
+		return nil, err
+	}
+	host.logger = provider.Logger()
+	return host, nil
+}
+
+// SQLProvider is a HostProvider for a *sql.DB that is managed elsewhere. It can
+// be used with any library that exposes its pool as a *sql.DB, for instance
+// sqlx (SQLProvider{Pool: sqlxDB.DB}) or pgxpool, by using the stdlib package
+// from pgx (SQLProvider{Pool: stdlib.OpenDBFromPool(pool)}). The pool must be
+// connected to a PostgreSQL database. Queries then go through the pooling,
+// tracing and metrics of the application.
+type SQLProvider struct {
+	Pool *sql.DB
+	Log  *log.Logger // optional, log.Default() is used if nil
+}
+
+// DB returns the connection pool
+func (p SQLProvider) DB() *sql.DB {
+	return p.Pool
+}
+
+// Dialect returns "postgres"
+func (p SQLProvider) Dialect() string {
+	return "postgres"
+}
+
+// Logger returns the logger, or log.Default()
+func (p SQLProvider) Logger() *log.Logger {
+	if p.Log != nil {
+		return p.Log
+	}
+	return log.Default()
+}
@@ -0,0 +1,29 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"log"
+	"testing"
+)
+
+type mysqlProvider struct{}
+
+func (mysqlProvider) DB() *sql.DB         { return nil }
+func (mysqlProvider) Dialect() string     { return "mysql" }
+func (mysqlProvider) Logger() *log.Logger { return log.Default() }
+
+func TestHostOf(t *testing.T) {
+	host := &Host{}
+	if h, err := hostOf(host); err != nil || h != host {
+		t.Error("Error, expected a *Host to be used as it is")
+	}
+	if _, err := hostOf(mysqlProvider{}); err != ErrUnsupportedDialect {
+		t.Errorf("Error, expected ErrUnsupportedDialect, got %v", err)
+	}
+	if (SQLProvider{}).Logger() != log.Default() {
+		t.Error("Error, expected the default logger")
+	}
+	if _, err := NewList(mysqlProvider{}, "list"); err != ErrUnsupportedDialect {
+		t.Errorf("Error, expected ErrUnsupportedDialect, got %v", err)
+	}
+}
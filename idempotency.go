@@ -0,0 +1,167 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrInProgress is returned by IdempotencyStore.Begin when a request with the same key is still being processed
+var ErrInProgress = errors.New("a request with the same idempotency key is in progress")
+
+// ErrClaimLost is returned by IdempotencyHandle.Complete and Abort when the key
+// has been claimed by another request, after the lock timeout had passed
+var ErrClaimLost = errors.New("the idempotency key has been claimed by another request")
+
+// DefaultIdempotencyLockTimeout is how long a key is held by a request that
+// is being processed, before it may be processed again, by default
+const DefaultIdempotencyLockTimeout = time.Minute
+
+// beginAttempts is how many times Begin tries to claim a key that is removed
+// by someone else while Begin is looking at it
+const beginAttempts = 3
+
+// IdempotencyStore makes it possible to process a request only once, even if
+// the client retries it. Each request is identified by an idempotency key,
+// typically given by the client in an Idempotency-Key header. The response to
+// the first request is stored, and returned for any retries, until the key expires.
+type IdempotencyStore struct {
+	dbDatastructure
+	ttl         time.Duration
+	lockTimeout time.Duration
+}
+
+// IdempotencyHandle is returned by IdempotencyStore.Begin for new requests,
+// and must be either completed or aborted when the request has been processed
+type IdempotencyHandle struct {
+	store   *IdempotencyStore
+	key     string
+	claimed time.Time // the expiry the key was claimed with, which identifies the claim
+}
+
+// NewIdempotencyStore creates a new IdempotencyStore, where keys expire after the given duration
+func NewIdempotencyStore(provider HostProvider, name string, ttl time.Duration, opts ...Option) (*IdempotencyStore, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	s := &IdempotencyStore{newDatastructure(host, pq.QuoteIdentifier(name), opts), ttl, DefaultIdempotencyLockTimeout}
+	if err := s.createSchema(); err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, done BOOLEAN NOT NULL DEFAULT false, response TEXT, expires TIMESTAMPTZ NOT NULL)", s.tableName())
+	if _, err := s.host.exec(query); err != nil {
+		return nil, err
+	}
+	if s.verbose() {
+		s.host.Logger().Println("Created idempotency key table " + s.tableName() + " in database " + host.dbname)
+	}
+	return s, nil
+}
+
+// SetLockTimeout sets how long a key is held by a request that is being
+// processed. If the request is neither completed nor aborted within this time,
+// for instance because the process crashed, the key may be processed again.
+// Complete holds the key for the TTL of the store. This should be called
+// before the store is used.
+func (s *IdempotencyStore) SetLockTimeout(timeout time.Duration) {
+	s.lockTimeout = timeout
+}
+
+// Begin starts processing the request with the given key. If the key is new,
+// or has expired, a handle is returned, and the request should be processed.
+// If the request has already been processed, the stored response is returned
+// together with a nil handle. If the request is being processed by someone
+// else, ErrInProgress is returned.
+func (s *IdempotencyStore) Begin(key string) (*IdempotencyHandle, string, error) {
+	lockTimeout := s.lockTimeout
+	if lockTimeout > s.ttl {
+		lockTimeout = s.ttl
+	}
+	for attempt := 0; attempt < beginAttempts; attempt++ {
+		var claimed time.Time
+		query := fmt.Sprintf("INSERT INTO %s AS t (key, expires) VALUES ($1, now() + $2 * interval '1 microsecond') ON CONFLICT (key) DO UPDATE SET done = false, response = NULL, expires = EXCLUDED.expires WHERE t.expires <= now() RETURNING expires", s.tableName())
+		err := s.host.queryRow(query, key, lockTimeout.Microseconds()).Scan(&claimed)
+		if err == nil {
+			return &IdempotencyHandle{s, key, claimed}, "", nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, "", err
+		}
+		// The key exists and has not expired
+		var (
+			done     bool
+			response sql.NullString
+		)
+		err = s.host.queryRow(fmt.Sprintf("SELECT done, response FROM %s WHERE key = $1", s.tableName()), key).Scan(&done, &response)
+		if err == sql.ErrNoRows {
+			// Removed in the meantime, try again
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if !done {
+			return nil, "", ErrInProgress
+		}
+		return nil, response.String, nil
+	}
+	// The key keeps being removed by others, for instance by requests that abort
+	return nil, "", ErrInProgress
+}
+
+// Complete stores the response for the request, which will be returned by Begin
+// for any retries, and holds the key for the TTL of the store.
+// ErrClaimLost is returned if the key has been claimed by another request.
+func (h *IdempotencyHandle) Complete(response string) error {
+	s := h.store
+	result, err := s.host.exec(fmt.Sprintf("UPDATE %s SET done = true, response = $3, expires = now() + $4 * interval '1 microsecond' WHERE key = $1 AND expires = $2 AND NOT done", s.tableName()), h.key, h.claimed, response, s.ttl.Microseconds())
+	return h.checkClaim(result, err)
+}
+
+// Abort releases the key without storing a response, so that the request can be retried.
+// ErrClaimLost is returned if the key has been claimed by another request.
+func (h *IdempotencyHandle) Abort() error {
+	s := h.store
+	result, err := s.host.exec(fmt.Sprintf("DELETE FROM %s WHERE key = $1 AND expires = $2 AND NOT done", s.tableName()), h.key, h.claimed)
+	return h.checkClaim(result, err)
+}
+
+// checkClaim returns ErrClaimLost if the claim of the handle did not match any row
+func (h *IdempotencyHandle) checkClaim(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrClaimLost
+	}
+	return nil
+}
+
+// ExpireOldKeys removes all expired keys, and returns how many were removed
+func (s *IdempotencyStore) ExpireOldKeys() (int64, error) {
+	result, err := s.host.exec(fmt.Sprintf("DELETE FROM %s WHERE expires <= now()", s.tableName()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Remove the table with idempotency keys
+func (s *IdempotencyStore) Remove() error {
+	_, err := s.host.exec(fmt.Sprintf("DROP TABLE %s", s.tableName()))
+	return err
+}
+
+// Clear all idempotency keys
+func (s *IdempotencyStore) Clear() error {
+	_, err := s.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", s.tableName()))
+	return err
+}
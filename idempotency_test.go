@@ -0,0 +1,63 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	store, err := NewIdempotencyStore(host, "idempotency_test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Clear()
+
+	handle, _, err := store.Begin("payment-1")
+	if err != nil || handle == nil {
+		t.Fatalf("Error, expected a new request: %v", err)
+	}
+	if _, _, err := store.Begin("payment-1"); err != ErrInProgress {
+		t.Errorf("Error, expected ErrInProgress, got %v", err)
+	}
+	if err := handle.Complete("201 Created"); err != nil {
+		t.Fatal(err)
+	}
+	handle, response, err := store.Begin("payment-1")
+	if err != nil || handle != nil || response != "201 Created" {
+		t.Errorf("Error, expected the stored response, got %v %s (%v)", handle, response, err)
+	}
+
+	handle, _, _ = store.Begin("payment-2")
+	handle.Abort()
+	if handle, _, err := store.Begin("payment-2"); err != nil || handle == nil {
+		t.Errorf("Error, an aborted request should be retryable: %v", err)
+	}
+
+	// A request that is neither completed nor aborted releases the key after the lock timeout
+	store.SetLockTimeout(100 * time.Millisecond)
+	stale, _, err := store.Begin("payment-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	handle, _, err = store.Begin("payment-3")
+	if err != nil || handle == nil {
+		t.Fatalf("Error, an abandoned request should be retryable after the lock timeout: %v", err)
+	}
+
+	// The abandoned handle can no longer complete or abort the new claim
+	if err := stale.Complete("stale"); err != ErrClaimLost {
+		t.Errorf("Error, expected ErrClaimLost when completing a lost claim, got %v", err)
+	}
+	if err := stale.Abort(); err != ErrClaimLost {
+		t.Errorf("Error, expected ErrClaimLost when aborting a lost claim, got %v", err)
+	}
+	if err := handle.Complete("ok"); err != nil {
+		t.Errorf("Error, the new claim should still complete: %v", err)
+	}
+
+	store.Remove()
+}
@@ -0,0 +1,146 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// insertionOrderSequence numbers the keys of all key/values that are created
+// WithInsertionOrder. Only the order of the numbers matters, so one sequence
+// is shared by all of them.
+const insertionOrderSequence = "simplehstore_insertion_order"
+
+// insertionOrderFunction keeps the number of each key in the inserted column
+// of a key/value, by numbering the keys that are new, and by forgetting the
+// keys that are removed. A key that is set again keeps its position.
+const insertionOrderFunction = `
+CREATE SEQUENCE IF NOT EXISTS simplehstore_insertion_order;
+
+CREATE OR REPLACE FUNCTION simplehstore_insertion_order() RETURNS trigger AS $$
+DECLARE
+	k text;
+	positions hstore := hstore('');
+BEGIN
+	IF TG_OP = 'UPDATE' THEN
+		positions := slice(COALESCE(OLD.inserted, hstore('')), akeys(COALESCE(NEW.attr, hstore(''))));
+	END IF;
+	FOR k IN SELECT skeys(COALESCE(NEW.attr, hstore(''))) EXCEPT SELECT skeys(positions) LOOP
+		positions := positions || hstore(k, nextval('simplehstore_insertion_order')::text);
+	END LOOP;
+	NEW.inserted := positions;
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+`
+
+// WithInsertionOrder keeps track of the order in which the elements of a Set,
+// or the keys of a KeyValue, are added, so that they can be retrieved in that
+// order with AllInInsertionOrder and FirstAdded. Sets get a serial id column.
+// Key/values get a column with the position of each key, which is kept by a
+// trigger, so that each write to the key/value also updates the positions.
+// Elements and keys that exist when the option is first used are ordered
+// arbitrarily, but before the ones that are added later.
+func WithInsertionOrder() Option {
+	return func(o *options) {
+		o.insertionOrder = true
+	}
+}
+
+// errNoInsertionOrder is returned when retrieving the elements of a data
+// structure in insertion order, when it was not created WithInsertionOrder
+func errNoInsertionOrder(quotedTable string) error {
+	return fmt.Errorf("%s was not created with WithInsertionOrder", quotedTable)
+}
+
+// addInsertionOrder adds the serial id column to the set, if it is created WithInsertionOrder
+func (s *Set) addInsertionOrder() error {
+	if !s.insertionOrder {
+		return nil
+	}
+	return s.addColumn(s.tableName(), "id", "BIGSERIAL")
+}
+
+// AllInInsertionOrder returns all elements in the set, in the order they were
+// first added. The set must be created WithInsertionOrder.
+func (s *Set) AllInInsertionOrder() ([]string, error) {
+	return s.inInsertionOrder("")
+}
+
+// FirstAdded returns the n elements that were added to the set first, or
+// fewer if the set is smaller. The set must be created WithInsertionOrder.
+func (s *Set) FirstAdded(n int) ([]string, error) {
+	return s.inInsertionOrder(fmt.Sprintf(" LIMIT %d", n))
+}
+
+// inInsertionOrder returns the elements of the set in insertion order, with the given LIMIT clause
+func (s *Set) inInsertionOrder(limit string) ([]string, error) {
+	if !s.insertionOrder {
+		return []string{}, errNoInsertionOrder(s.tableName())
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL AND %s GROUP BY %s ORDER BY MIN(id)%s", setCol, s.tableName(), setCol, setLive, setCol, limit)
+	return s.host.decodedStrings(query, s.decodeAll)
+}
+
+// addInsertionOrder adds the column with the positions of the keys, and the
+// trigger that keeps it, if the key/value is created WithInsertionOrder
+func (kv *KeyValue) addInsertionOrder() error {
+	if !kv.insertionOrder {
+		return nil
+	}
+	if err := kv.addColumn(kv.tableName(), "inserted", "hstore DEFAULT hstore('')"); err != nil {
+		return err
+	}
+	if err := kv.host.createTriggers(kv.tableName(), insertionOrderFunction, map[string]string{
+		"simplehstore_insertion_order": fmt.Sprintf("BEFORE INSERT OR UPDATE ON %s FOR EACH ROW EXECUTE PROCEDURE simplehstore_insertion_order()", kv.tableName()),
+	}); err != nil {
+		return err
+	}
+	// Number the keys that were added before the trigger existed
+	_, err := kv.host.exec(fmt.Sprintf("UPDATE %s SET attr = attr WHERE NOT COALESCE(inserted, hstore('')) ?& akeys(attr)", kv.tableName()))
+	return err
+}
+
+// AllInInsertionOrder returns all keys that have not expired, in the order
+// they were first set. The key/value must be created WithInsertionOrder.
+func (kv *KeyValue) AllInInsertionOrder() ([]string, error) {
+	return kv.inInsertionOrder("")
+}
+
+// FirstAdded returns the n keys that were set first, and that have not
+// expired, or fewer if there are not that many keys. The key/value must be
+// created WithInsertionOrder.
+func (kv *KeyValue) FirstAdded(n int) ([]string, error) {
+	return kv.inInsertionOrder(fmt.Sprintf(" LIMIT %d", n))
+}
+
+// inInsertionOrder returns the keys in insertion order, with the given LIMIT clause
+func (kv *KeyValue) inInsertionOrder(limit string) ([]string, error) {
+	if !kv.insertionOrder {
+		return []string{}, errNoInsertionOrder(kv.tableName())
+	}
+	query := fmt.Sprintf("SELECT k FROM %s AS t CROSS JOIN skeys(t.attr) AS k WHERE %s ORDER BY (t.inserted -> k)::bigint%s", kv.tableName(), liveKey("k"), limit)
+	return kv.host.decodedStrings(query, func([]string) error { return nil })
+}
+
+// decodedStrings returns the strings that are selected by the given query,
+// decoded with the given function after the rows have been closed
+func (host *Host) decodedStrings(query string, decodeAll func([]string) error) ([]string, error) {
+	values := []string{}
+	rows, err := host.query(query)
+	if err != nil {
+		return values, err
+	}
+	var value sql.NullString
+	for rows.Next() {
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return values, err
+		}
+		values = append(values, value.String)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return values, err
+	}
+	return values, decodeAll(values)
+}
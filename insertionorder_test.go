@@ -0,0 +1,55 @@
+package simplehstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInsertionOrder(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	s, err := NewSet(host, "insertion_order_set_test", WithInsertionOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Clear()
+	for _, v := range []string{"carol", "alice", "bob", "alice"} {
+		s.Add(v)
+	}
+	if all, err := s.AllInInsertionOrder(); err != nil || strings.Join(all, ",") != "carol,alice,bob" {
+		t.Errorf("Error, expected carol,alice,bob, got %v (%v)", all, err)
+	}
+	if first, err := s.FirstAdded(2); err != nil || strings.Join(first, ",") != "carol,alice" {
+		t.Errorf("Error, expected carol,alice, got %v (%v)", first, err)
+	}
+	s.Remove()
+
+	kv, err := NewKeyValue(host, "insertion_order_kv_test", WithInsertionOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	kv.Set("zed", "1")
+	kv.Set("amy", "2")
+	kv.Set("max", "3")
+	kv.Set("zed", "4")
+	kv.Del("amy")
+	kv.Set("amy", "5")
+	if all, err := kv.AllInInsertionOrder(); err != nil || strings.Join(all, ",") != "zed,max,amy" {
+		t.Errorf("Error, expected zed,max,amy, got %v (%v)", all, err)
+	}
+	if first, err := kv.FirstAdded(1); err != nil || strings.Join(first, ",") != "zed" {
+		t.Errorf("Error, expected zed, got %v (%v)", first, err)
+	}
+	kv.Remove()
+
+	plain, err := NewSet(host, "insertion_order_plain_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.AllInInsertionOrder(); err == nil {
+		t.Error("Error, expected an error for a set that was not created WithInsertionOrder")
+	}
+	plain.Remove()
+}
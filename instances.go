@@ -0,0 +1,168 @@
+package simplehstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrNotRegistered is returned when sending a heartbeat for an instance that is not registered
+var ErrNotRegistered = fmt.Errorf("the instance is not registered")
+
+// Instance is a registered application instance
+type Instance struct {
+	ID       string
+	Metadata map[string]string
+	Started  time.Time
+	LastSeen time.Time
+}
+
+// Instances is a registry of running application instances. Each instance
+// registers itself with some metadata and then sends heartbeats. Instances
+// that have not sent a heartbeat within the TTL are no longer considered alive.
+type Instances struct {
+	dbDatastructure
+	ttl time.Duration
+}
+
+// NewInstances creates a new registry of instances, where instances must send a
+// heartbeat within the given TTL to be considered alive. The TTL must be positive.
+func NewInstances(provider HostProvider, name string, ttl time.Duration, opts ...Option) (*Instances, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("the TTL of instances must be positive, not %v", ttl)
+	}
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	in := &Instances{newDatastructure(host, pq.QuoteIdentifier(name), opts), ttl}
+	if err := in.createSchema(); err != nil {
+		return nil, err
+	}
+	// Create extension hstore
+	// Ignore errors if this is already created
+	in.host.exec("CREATE EXTENSION IF NOT EXISTS hstore")
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, meta hstore NOT NULL DEFAULT hstore(''), started TIMESTAMPTZ NOT NULL DEFAULT now(), last_seen TIMESTAMPTZ NOT NULL DEFAULT now())", in.tableName())
+	if _, err := in.host.exec(query); err != nil {
+		return nil, err
+	}
+	if in.verbose() {
+		in.host.Logger().Println("Created instance table " + in.tableName() + " in database " + host.dbname)
+	}
+	return in, nil
+}
+
+// Register an instance with the given ID and metadata. If the instance is
+// already registered, the metadata is replaced and the start time is reset.
+func (in *Instances) Register(id string, metadata map[string]string) error {
+	keys := make([]string, 0, len(metadata))
+	values := make([]string, 0, len(metadata))
+	for k, v := range metadata {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (id, meta) VALUES ($1, hstore($2::text[], $3::text[])) ON CONFLICT (id) DO UPDATE SET meta = EXCLUDED.meta, started = now(), last_seen = now()", in.tableName())
+	_, err := in.host.exec(query, id, pq.Array(keys), pq.Array(values))
+	return err
+}
+
+// Heartbeat marks the instance as alive
+func (in *Instances) Heartbeat(id string) error {
+	result, err := in.host.exec(fmt.Sprintf("UPDATE %s SET last_seen = now() WHERE id = $1", in.tableName()), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotRegistered
+	}
+	return nil
+}
+
+// Deregister an instance
+func (in *Instances) Deregister(id string) error {
+	_, err := in.host.exec(fmt.Sprintf("DELETE FROM %s WHERE id = $1", in.tableName()), id)
+	return err
+}
+
+// Run registers the instance, sends heartbeats until ctx is cancelled, and
+// then deregisters the instance. If the instance has expired in the meantime,
+// for instance because the database was unavailable, it is registered again.
+func (in *Instances) Run(ctx context.Context, id string, metadata map[string]string) error {
+	if err := in.Register(id, metadata); err != nil {
+		return err
+	}
+	interval := in.ttl / 3
+	if interval <= 0 {
+		interval = in.ttl
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return in.Deregister(id)
+		case <-ticker.C:
+			err := in.Heartbeat(id)
+			if err == ErrNotRegistered {
+				err = in.Register(id, metadata)
+			}
+			if err != nil && in.verbose() {
+				in.host.Logger().Println("Instance heartbeat: " + err.Error())
+			}
+		}
+	}
+}
+
+// AliveInstances returns all instances that have sent a heartbeat within the TTL, ordered by ID
+func (in *Instances) AliveInstances() ([]Instance, error) {
+	query := fmt.Sprintf("SELECT id, akeys(meta), avals(meta), started, last_seen FROM %s WHERE last_seen > now() - $1 * interval '1 microsecond' ORDER BY id", in.tableName())
+	rows, err := in.host.query(query, in.ttl.Microseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var instances []Instance
+	for rows.Next() {
+		var (
+			instance     Instance
+			keys, values []string
+		)
+		if err := rows.Scan(&instance.ID, pq.Array(&keys), pq.Array(&values), &instance.Started, &instance.LastSeen); err != nil {
+			return instances, err
+		}
+		instance.Metadata = make(map[string]string, len(keys))
+		for i, k := range keys {
+			instance.Metadata[k] = values[i]
+		}
+		instances = append(instances, instance)
+	}
+	return instances, rows.Err()
+}
+
+// ExpireDead removes all instances that have not sent a heartbeat within the TTL,
+// and returns how many were removed
+func (in *Instances) ExpireDead() (int64, error) {
+	result, err := in.host.exec(fmt.Sprintf("DELETE FROM %s WHERE last_seen <= now() - $1 * interval '1 microsecond'", in.tableName()), in.ttl.Microseconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Remove the instance table
+func (in *Instances) Remove() error {
+	_, err := in.host.exec(fmt.Sprintf("DROP TABLE %s", in.tableName()))
+	return err
+}
+
+// Clear all instances
+func (in *Instances) Clear() error {
+	_, err := in.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", in.tableName()))
+	return err
+}
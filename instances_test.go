@@ -0,0 +1,44 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInstances(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	if _, err := NewInstances(host, "instances_test", 0); err == nil {
+		t.Error("Error, expected a TTL of 0 to be rejected")
+	}
+	instances, err := NewInstances(host, "instances_test", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instances.Clear()
+
+	if err := instances.Register("web-1", map[string]string{"version": "1.2.3"}); err != nil {
+		t.Fatal(err)
+	}
+	instances.Register("web-2", nil)
+	if err := instances.Heartbeat("web-1"); err != nil {
+		t.Error(err)
+	}
+	if err := instances.Heartbeat("web-3"); err != ErrNotRegistered {
+		t.Errorf("Error, expected ErrNotRegistered, got %v", err)
+	}
+	alive, err := instances.AliveInstances()
+	if err != nil || len(alive) != 2 {
+		t.Fatalf("Error, expected two alive instances, got %v (%v)", alive, err)
+	}
+	if alive[0].ID != "web-1" || alive[0].Metadata["version"] != "1.2.3" {
+		t.Errorf("Error, unexpected instance: %v", alive[0])
+	}
+	instances.Deregister("web-2")
+	if alive, _ := instances.AliveInstances(); len(alive) != 1 {
+		t.Errorf("Error, expected one alive instance, got %v", alive)
+	}
+
+	instances.Remove()
+}
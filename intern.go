@@ -0,0 +1,128 @@
+package simplehstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// internTable is where interned values are stored, shared by all data structures
+	internTable = "simplehstore_interned"
+
+	// internMarker starts a stored value that refers to an interned value by ID
+	internMarker = "\x01interned:"
+)
+
+// WithInterning stores values that are at least minLength bytes long, after
+// encoding, only once, in a table that is shared by all data structures that
+// use this option. The data structure then only stores a reference to the
+// value. This can shrink tables considerably when many keys have identical
+// large values, like a HashMap2 where many users share the same settings.
+//
+// Reading interned values needs an extra query, which is shared by the values
+// that are read together, like the values returned by All. Interned values are
+// kept even when nothing refers to them any more. The option must be given every
+// time the data structure is opened, or the references are returned as they are.
+func WithInterning(minLength int) Option {
+	return func(o *options) {
+		o.internMin = minLength
+	}
+}
+
+// createInternTable creates the table for interned values, if interning is enabled
+func (d *dbDatastructure) createInternTable() error {
+	if d.internMin <= 0 {
+		return nil
+	}
+	_, err := d.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id BIGSERIAL PRIMARY KEY, hash TEXT NOT NULL UNIQUE, value TEXT NOT NULL)", internTable))
+	return err
+}
+
+// intern replaces an encoded value with a reference to the interned value,
+// if interning is enabled and the value is long enough. Values that look like
+// references are always interned, so that they are not mistaken for one.
+func (d *dbDatastructure) intern(value *string) error {
+	if d.internMin <= 0 || (len(*value) < d.internMin && !strings.HasPrefix(*value, internMarker)) {
+		return nil
+	}
+	// The values may be too large for a unique index, so the hash is indexed instead
+	sum := sha256.Sum256([]byte(*value))
+	var id int64
+	query := fmt.Sprintf("INSERT INTO %s (hash, value) VALUES ($1, $2) ON CONFLICT (hash) DO UPDATE SET hash = EXCLUDED.hash RETURNING id", internTable)
+	if err := d.host.queryRow(query, hex.EncodeToString(sum[:]), *value).Scan(&id); err != nil {
+		return err
+	}
+	*value = internMarker + strconv.FormatInt(id, 10)
+	return nil
+}
+
+// unintern replaces a reference with the interned value it refers to
+func (d *dbDatastructure) unintern(value *string) error {
+	if d.internMin <= 0 || !strings.HasPrefix(*value, internMarker) {
+		return nil
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(*value, internMarker), 10, 64)
+	if err != nil {
+		return mark(ErrEncoding, "invalid reference to an interned value: %v", err)
+	}
+	return d.host.queryRow(fmt.Sprintf("SELECT value FROM %s WHERE id = $1", internTable), id).Scan(value)
+}
+
+// uninternAll replaces the references among the given values with the interned
+// values they refer to, with a single query
+func (d *dbDatastructure) uninternAll(values []string) error {
+	if d.internMin <= 0 {
+		return nil
+	}
+	var ids []int64
+	for _, value := range values {
+		if !strings.HasPrefix(value, internMarker) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(value, internMarker), 10, 64)
+		if err != nil {
+			return mark(ErrEncoding, "invalid reference to an interned value: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := d.host.query(fmt.Sprintf("SELECT id, value FROM %s WHERE id = ANY($1)", internTable), pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	interned := make(map[int64]string, len(ids))
+	for rows.Next() {
+		var (
+			id    int64
+			value string
+		)
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return err
+		}
+		interned[id] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, value := range values {
+		if !strings.HasPrefix(value, internMarker) {
+			continue
+		}
+		id, _ := strconv.ParseInt(strings.TrimPrefix(value, internMarker), 10, 64)
+		found, ok := interned[id]
+		if !ok {
+			return mark(ErrEncoding, "the interned value %d does not exist", id)
+		}
+		values[i] = found
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package simplehstore
+
+import (
+	"fmt"
+)
+
+// propertyValues returns an SQL subquery with the columns owner and value, for the property with the
+// key given by the placeholder, in the given HSTORE table
+func propertyValues(quotedTable, keyPlaceholder string) string {
+	return fmt.Sprintf("SELECT split_part(key, '%s', 1) AS owner, value FROM (SELECT (each(attr)).* FROM %s) AS temp WHERE substr(key, strpos(key, '%s') + %d) = %s", fieldSep, quotedTable, fieldSep, len([]rune(fieldSep)), keyPlaceholder)
+}
+
+// Join returns all pairs of owners in this hash map and the other hash map
+// where the value of the given property in this hash map equals the value of
+// otherKey in the other hash map, for instance users.Join(invites, "email", "email").
+// The pairs are matched by one SQL join, and are sorted by owner.
+// Both hash maps must use the same codec and the same Host.
+func (hm2 *HashMap2) Join(other *HashMap2, key, otherKey string) ([][2]string, error) {
+	if hm2.columnLayout || other.columnLayout {
+		return nil, ErrUnsupportedLayout
+	}
+	if hm2.getCodec().Name() != other.getCodec().Name() {
+		return nil, fmt.Errorf("can not join hash maps with the %q and %q codecs", hm2.getCodec().Name(), other.getCodec().Name())
+	}
+	kv, otherKV := hm2.keyValue(), other.keyValue()
+	query := fmt.Sprintf("SELECT DISTINCT a.owner, b.owner FROM (%s) AS a JOIN (%s) AS b ON a.value = b.value ORDER BY a.owner, b.owner", propertyValues(kv.tableName(), "$1"), propertyValues(otherKV.tableName(), "$2"))
+	if hm2.verbose() {
+		fmt.Println(query)
+	}
+	return hm2.scanPairs(query, key, otherKey)
+}
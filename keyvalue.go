@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
 
@@ -13,39 +12,84 @@ import (
 )
 
 // KeyValue is a hash map with a key and a value, stored in PostgreSQL
-type KeyValue dbDatastructure
+type KeyValue struct {
+	dbDatastructure
+}
 
 // NewKeyValue creates a new KeyValue struct, for storing key/value pairs.
-func NewKeyValue(host *Host, name string) (*KeyValue, error) {
-	kv := &KeyValue{host, name}
+func NewKeyValue(provider HostProvider, name string, opts ...Option) (*KeyValue, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	kv := &KeyValue{newDatastructure(host, name, opts)}
+	if err := kv.createSchema(); err != nil {
+		return nil, err
+	}
 
 	// Create extension hstore
-	query := "CREATE EXTENSION hstore"
+	query := "CREATE EXTENSION IF NOT EXISTS hstore"
 	// Ignore erors if this is already created
-	kv.host.db.Exec(query)
+	kv.host.exec(query)
 
-	query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (attr hstore default hstore(''))", pq.QuoteIdentifier(kvPrefix+kv.table))
-	if _, err := kv.host.db.Exec(query); err != nil {
+	existed, err := kv.exists(kv.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query = fmt.Sprintf("%s %s (attr hstore default hstore(''))", kv.createTable(), kv.tableName())
+	if _, err := kv.host.exec(query); err != nil {
+		return nil, err
+	}
+	// Key/values created by earlier versions do not have an expires column
+	if err := kv.addColumn(kv.tableName(), "expires", "hstore DEFAULT hstore('')"); err != nil {
+		return nil, err
+	}
+	if err := kv.addInsertionOrder(); err != nil {
+		return nil, err
+	}
+	if err := kv.setStorageParameters(kv.tableName()); err != nil {
 		return nil, err
 	}
-	if Verbose {
-		log.Println("Created HSTORE table " + pq.QuoteIdentifier(kvPrefix+kv.table) + " in database " + host.dbname)
+	if err := kv.register("keyvalue", name, kv.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if kv.verbose() {
+		kv.host.Logger().Println("Created HSTORE table " + kv.tableName() + " in database " + host.dbname)
 	}
 
 	kv.CreateIndexTable()
 
+	// Needed by Upsert. This fails if the table already has more than one row.
+	if err := kv.createSingleRowIndex(); err != nil && kv.verbose() {
+		kv.host.Logger().Println("Could not create a single row index for " + kv.tableName() + ": " + err.Error())
+	}
+
 	return kv, nil
 }
 
+// createSingleRowIndex creates a unique index that makes sure that the HSTORE
+// table has at most one row, which makes it possible to use ON CONFLICT
+func (kv *KeyValue) createSingleRowIndex() error {
+	// strip double quotes from kv.table and add _single_row at the end
+	indexName := strings.TrimSuffix(strings.TrimPrefix(kv.table, "\""), "\"") + "_single_row"
+	_, err := kv.host.exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s ((true))", pq.QuoteIdentifier(indexName), kv.tableName()))
+	return err
+}
+
+// tableName returns the quoted and possibly schema qualified name of the HSTORE table
+func (kv *KeyValue) tableName() string {
+	return kv.qualify(pq.QuoteIdentifier(kvPrefix + kv.table))
+}
+
 // CreateIndexTable creates an INDEX table for this key/value, that may speed up lookups
 func (kv *KeyValue) CreateIndexTable() error {
 	// strip double quotes from kv.table and add _idx at the end
 	indexTableName := strings.TrimSuffix(strings.TrimPrefix(kv.table, "\""), "\"") + "_idx"
-	query := fmt.Sprintf("CREATE INDEX %q ON %s USING GIN (attr)", indexTableName, pq.QuoteIdentifier(kvPrefix+kv.table))
-	if Verbose {
+	query := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %q ON %s USING GIN (attr)", indexTableName, kv.tableName())
+	if kv.verbose() {
 		fmt.Println(query)
 	}
-	_, err := kv.host.db.Exec(query)
+	_, err := kv.host.exec(query)
 	return err
 }
 
@@ -53,22 +97,27 @@ func (kv *KeyValue) CreateIndexTable() error {
 func (kv *KeyValue) RemoveIndexTable() error {
 	// strip double quotes from kv.table and add _idx at the end
 	indexTableName := strings.TrimSuffix(strings.TrimPrefix(kv.table, "\""), "\"") + "_idx"
-	query := fmt.Sprintf("DROP INDEX %q", indexTableName)
-	if Verbose {
+	query := fmt.Sprintf("DROP INDEX %s", kv.qualify(fmt.Sprintf("%q", indexTableName)))
+	if kv.verbose() {
 		fmt.Println(query)
 	}
-	_, err := kv.host.db.Exec(query)
+	_, err := kv.host.exec(query)
 	return err
 }
 
-// All returns all elements in the set
+// liveKey returns a condition that is true if the given key of the row t has not expired
+func liveKey(key string) string {
+	return fmt.Sprintf("COALESCE((t.expires -> %s)::timestamptz > now(), true)", key)
+}
+
+// All returns all keys that have not expired
 func (kv *KeyValue) All() ([]string, error) {
 	var (
 		values []string
 		value  sql.NullString
 	)
-	query := fmt.Sprintf("SELECT DISTINCT skeys(attr) FROM %s", pq.QuoteIdentifier(kvPrefix+kv.table))
-	rows, err := kv.host.db.Query(query)
+	query := fmt.Sprintf("SELECT DISTINCT k FROM %s AS t CROSS JOIN skeys(t.attr) AS k WHERE %s", kv.tableName(), liveKey("k"))
+	rows, err := kv.host.query(query)
 	if err != nil {
 		return values, err
 	}
@@ -78,59 +127,70 @@ func (kv *KeyValue) All() ([]string, error) {
 	defer rows.Close()
 	for rows.Next() {
 		err = rows.Scan(&value)
-		vs := value.String
-		if !kv.host.rawUTF8 {
-			Decode(&vs)
-		}
-		values = append(values, vs)
+		values = append(values, value.String)
 		if err != nil {
 			return values, err
 		}
 	}
-	err = rows.Err()
+	if err = rows.Err(); err != nil {
+		return values, err
+	}
+	rows.Close()
+	err = kv.decodeAll(values)
 	return values, err
 }
 
 // insert a new key+value in the current KeyValue table
 func (kv *KeyValue) insert(key, encodedValue string) (int64, error) {
 	// Try inserting
-	query := fmt.Sprintf("INSERT INTO %s (attr) VALUES ('\"%s\"=>\"%s\"')", pq.QuoteIdentifier(kvPrefix+kv.table), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
-	if Verbose {
+	query := fmt.Sprintf("INSERT INTO %s (attr) VALUES ('\"%s\"=>\"%s\"')", kv.tableName(), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
+	if kv.verbose() {
 		fmt.Println(query)
 	}
-	result, err := kv.host.db.Exec(query)
-	if Verbose {
-		log.Println("keyValue insert: inserted row into: "+kv.table+" err? ", err)
+	result, err := kv.host.exec(query)
+	if kv.verbose() {
+		kv.host.Logger().Println("keyValue insert: inserted row into: "+kv.table+" err? ", err)
 	}
 	n, _ := result.RowsAffected()
 	return n, err
 }
 
 // insert a new key+value in the current KeyValue table, as part of a transaction
-func (kv *KeyValue) insertWithTransaction(ctx context.Context, transaction *sql.Tx, key, encodedValue string) (int64, error) {
+func (kv *KeyValue) insertWithTransaction(ctx context.Context, transaction queryer, key, encodedValue string) (int64, error) {
 	// Try inserting
-	query := fmt.Sprintf("INSERT INTO %s (attr) VALUES ('\"%s\"=>\"%s\"')", pq.QuoteIdentifier(kvPrefix+kv.table), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
-	if Verbose {
+	query := fmt.Sprintf("INSERT INTO %s (attr) VALUES ('\"%s\"=>\"%s\"')", kv.tableName(), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
+	if kv.verbose() {
 		fmt.Println(query)
 	}
 	result, err := transaction.ExecContext(ctx, query)
-	if Verbose {
-		log.Println("keyValue insertWithTransaction: inserted row into: "+kv.table+" err? ", err)
+	if kv.verbose() {
+		kv.host.Logger().Println("keyValue insertWithTransaction: inserted row into: "+kv.table+" err? ", err)
 	}
 	n, _ := result.RowsAffected()
 	return n, err
 }
 
+// updateQuery returns a query that sets the given key, or that only sets
+// the key if it does not exist, depending on the conflict policy.
+// Any expiry of the key is removed.
+func (kv *KeyValue) updateQuery(key, encodedValue string) string {
+	query := fmt.Sprintf("UPDATE %s SET attr = attr || '\"%s\"=>\"%s\"' :: hstore, expires = delete(expires, '%s')", kv.tableName(), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue), escapeSingleQuotes(key))
+	if kv.conflict != ConflictOverwrite {
+		query += fmt.Sprintf(" WHERE NOT attr ? '%s' OR (expires -> '%s')::timestamptz <= now()", escapeSingleQuotes(key), escapeSingleQuotes(key))
+	}
+	return query
+}
+
 // update a value in the current KeyValue table
 func (kv *KeyValue) update(key, encodedValue string) (int64, error) {
 	// Try updating
-	query := fmt.Sprintf("UPDATE %s SET attr = attr || '\"%s\"=>\"%s\"' :: hstore", pq.QuoteIdentifier(kvPrefix+kv.table), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
-	if Verbose {
+	query := kv.updateQuery(key, encodedValue)
+	if kv.verbose() {
 		fmt.Println(query)
 	}
-	result, err := kv.host.db.Exec(query)
-	if Verbose {
-		log.Println("Updated row in: "+kv.table+" err? ", err)
+	result, err := kv.host.exec(query)
+	if kv.verbose() {
+		kv.host.Logger().Println("Updated row in: "+kv.table+" err? ", err)
 	}
 	if result == nil {
 		return 0, fmt.Errorf("keyValue update: no result when trying to update %s with a value", key)
@@ -141,15 +201,15 @@ func (kv *KeyValue) update(key, encodedValue string) (int64, error) {
 
 // update a value in the current KeyValue table, as part of a transaction
 // NOTE that the database must have an initialized hstore, possibly by using insert, before calling this!
-func (kv *KeyValue) updateWithTransaction(ctx context.Context, transaction *sql.Tx, key, encodedValue string) (int64, error) {
+func (kv *KeyValue) updateWithTransaction(ctx context.Context, transaction queryer, key, encodedValue string) (int64, error) {
 	// Try updating
-	query := fmt.Sprintf("UPDATE %s SET attr = attr || '\"%s\"=>\"%s\"' :: hstore", pq.QuoteIdentifier(kvPrefix+kv.table), escapeSingleQuotes(key), escapeSingleQuotes(encodedValue))
-	if Verbose {
+	query := kv.updateQuery(key, encodedValue)
+	if kv.verbose() {
 		fmt.Println(query)
 	}
 	result, err := transaction.ExecContext(ctx, query)
-	if Verbose {
-		log.Println("Updated row in: "+kv.table+" err? ", err)
+	if kv.verbose() {
+		kv.host.Logger().Println("Updated row in: "+kv.table+" err? ", err)
 	}
 	if result == nil {
 		return 0, fmt.Errorf("keyValue updateWithTransaction: no result when trying to update %s with a value", key)
@@ -158,42 +218,86 @@ func (kv *KeyValue) updateWithTransaction(ctx context.Context, transaction *sql.
 	return n, err
 }
 
-// Set a key and value
+// Set a key and value. See WithConflictPolicy for what happens if the key already exists.
+// If the key/value is created WithTTL, the key expires, as with SetExpiring.
 func (kv *KeyValue) Set(key, value string) error {
-	if !kv.host.rawUTF8 {
-		Encode(&value)
+	if kv.ttl > 0 {
+		return kv.SetExpiring(key, value, kv.ttl)
+	}
+	_, err := kv.set(key, value)
+	return err
+}
+
+// set sets a key and value, and returns false if the value was not written
+// because of the conflict policy
+func (kv *KeyValue) set(key, value string) (bool, error) {
+	if err := kv.encode(&value); err != nil {
+		return false, err
 	}
 	encodedValue := value
 
 	isEmpty, err := kv.Empty()
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if isEmpty { // insert the first one if the KeyValue is currently empty
 		n, err := kv.insert(key, encodedValue)
 		if err != nil {
-			return err
+			return false, err
 		}
 		if n == 0 {
-			return errors.New("keyValue Set: could not insert any rows")
+			return false, errors.New("keyValue Set: could not insert any rows")
 		}
 	} else {
 		// Try updating the key/values
-		_, err := kv.update(key, encodedValue)
+		n, err := kv.update(key, encodedValue)
 		if err != nil {
-			return err
+			return false, err
+		}
+		if n == 0 && kv.conflict != ConflictOverwrite {
+			return false, kv.conflictError()
 		}
 	}
 	// success
-	return nil
+	return true, nil
+}
+
+// Upsert sets a key and value, in a single statement that either inserts the
+// first row of the table or updates it. Unlike Set, this is safe when several
+// processes write to an empty KeyValue at the same time.
+func (kv *KeyValue) Upsert(key, value string) error {
+	return kv.UpsertMany(map[string]string{key: value})
+}
+
+// UpsertMany sets several keys and values, in a single statement
+func (kv *KeyValue) UpsertMany(m map[string]string) error {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	values := make([]string, 0, len(m))
+	for k, v := range m {
+		if err := kv.encode(&v); err != nil {
+			return err
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	query := fmt.Sprintf("INSERT INTO %s AS t (attr) VALUES (hstore($1::text[], $2::text[])) ON CONFLICT ((true)) DO UPDATE SET attr = t.attr || EXCLUDED.attr, expires = delete(t.expires, $1::text[])", kv.tableName())
+	if kv.verbose() {
+		fmt.Println(query)
+	}
+	_, err := kv.host.exec(query, pq.Array(keys), pq.Array(values))
+	return err
 }
 
 // Get a value given a key
 func (kv *KeyValue) Get(key string) (string, error) {
-	rows, err := kv.host.db.Query(fmt.Sprintf("SELECT attr -> '%s' FROM %s", escapeSingleQuotes(key), pq.QuoteIdentifier(kvPrefix+kv.table)))
+	// Keys that have expired, but have not been removed by ExpireKeys yet, are not returned
+	rows, err := kv.host.query(fmt.Sprintf("SELECT CASE WHEN (expires -> '%s')::timestamptz <= now() THEN NULL ELSE attr -> '%s' END FROM %s", escapeSingleQuotes(key), escapeSingleQuotes(key), kv.tableName()))
 	if err != nil {
-		return "", fmt.Errorf("KeyValue.Get: query error: %s", err)
+		return "", fmt.Errorf("KeyValue.Get: query error: %w", err)
 	}
 	if rows == nil {
 		return "", fmt.Errorf("KeyValue.Get: no rows for key %s", key)
@@ -210,30 +314,55 @@ func (kv *KeyValue) Get(key string) (string, error) {
 		counter++
 	}
 	if err := rows.Err(); err != nil {
-		return "", fmt.Errorf("keyValue Get: rows.Err(): %s", err)
+		return "", fmt.Errorf("keyValue Get: rows.Err(): %w", err)
 	}
 	if counter == 0 {
-		return "", errors.New("keyValue Get: no rows")
+		return "", mark(ErrNotFound, "keyValue Get: no rows")
 	}
 	if counter != 1 {
 		return "", fmt.Errorf("keyValue Get: wrong number of keys in KeyValue table: %s", kvPrefix+kv.table)
 	}
 
 	s := value.String
-	if !kv.host.rawUTF8 {
-		Decode(&s)
+	if err := kv.decode(&s); err != nil {
+		return "", err
 	}
 	if s == "" {
-		return "", fmt.Errorf("key does not exist: %s", key)
+		return "", mark(ErrNotFound, "key does not exist: %s", key)
+	}
+	return s, nil
+}
+
+// GetForUpdate gets a value as part of the given transaction, and locks it
+// until the transaction is committed or rolled back, so that the value can be
+// safely read, modified and written back, for instance with a KeyValue that is
+// created with a Host from NewHostFromTx. Since all keys are stored in a single
+// row, all keys of the key/value are locked.
+func (kv *KeyValue) GetForUpdate(tx *sql.Tx, key string) (string, error) {
+	ctx := kv.host.context()
+	query := fmt.Sprintf("SELECT CASE WHEN (expires -> $1)::timestamptz <= now() THEN NULL ELSE attr -> $1 END FROM %s FOR UPDATE", kv.tableName())
+	var value sql.NullString
+	if err := tx.QueryRowContext(ctx, kv.host.annotate(ctx, query), key).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", mark(ErrNotFound, "keyValue GetForUpdate: no rows")
+		}
+		return "", err
+	}
+	s := value.String
+	if err := kv.decode(&s); err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", mark(ErrNotFound, "key does not exist: %s", key)
 	}
 	return s, nil
 }
 
 // Get a value given a key
-func (kv *KeyValue) getWithTransaction(ctx context.Context, transaction *sql.Tx, key string) (string, error) {
-	rows, err := transaction.QueryContext(ctx, fmt.Sprintf("SELECT attr -> '%s' FROM %s", escapeSingleQuotes(key), pq.QuoteIdentifier(kvPrefix+kv.table)))
+func (kv *KeyValue) getWithTransaction(ctx context.Context, transaction queryer, key string) (string, error) {
+	rows, err := transaction.QueryContext(ctx, fmt.Sprintf("SELECT CASE WHEN (expires -> '%s')::timestamptz <= now() THEN NULL ELSE attr -> '%s' END FROM %s", escapeSingleQuotes(key), escapeSingleQuotes(key), kv.tableName()))
 	if err != nil {
-		return "", fmt.Errorf("KeyValue getWithTransaction: query error: %s", err)
+		return "", fmt.Errorf("KeyValue getWithTransaction: query error: %w", err)
 	}
 	if rows == nil {
 		return "", fmt.Errorf("KeyValue getWithTransaction: no rows for key %s", key)
@@ -250,108 +379,208 @@ func (kv *KeyValue) getWithTransaction(ctx context.Context, transaction *sql.Tx,
 		counter++
 	}
 	if err := rows.Err(); err != nil {
-		return "", fmt.Errorf("keyValue getWithTransaction: rows.Err(): %s", err)
+		return "", fmt.Errorf("keyValue getWithTransaction: rows.Err(): %w", err)
 	}
 
 	if counter == 0 {
-		return "", errors.New("keyValue getWithTransaction: no rows")
+		return "", mark(ErrNotFound, "keyValue getWithTransaction: no rows")
 	}
 
 	if counter != 1 {
 		return "", fmt.Errorf("keyValue getWithTransaction: wrong number of keys in KeyValue table: %s", kvPrefix+kv.table)
 	}
 	s := value.String
-	if !kv.host.rawUTF8 {
-		Decode(&s)
+	if err := kv.decode(&s); err != nil {
+		return "", err
 	}
 	if s == "" {
-		return "", fmt.Errorf("key does not exist: %s", key)
+		return "", mark(ErrNotFound, "key does not exist: %s", key)
 	}
 	return s, nil
 }
 
+// Has checks if the given key exists, and has not expired
+func (kv *KeyValue) Has(key string) (bool, error) {
+	var found bool
+	err := kv.host.queryRow(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s AS t WHERE t.attr ? $1 AND %s)", kv.tableName(), liveKey("$1")), key).Scan(&found)
+	return found, err
+}
+
 // Inc increases the value of a key and returns the new value.
-// Returns "1" if no previous value is found.
+// Returns "1" if no previous value is found. See IncBy.
 func (kv *KeyValue) Inc(key string) (string, error) {
-	// Retrieve the current value, if any
-	num := 0
-	// See if we can fetch an existing value.
-	if val, err := kv.Get(key); err == nil { // success
-		// See if we can convert the value to a number.
-		if converted, errConv := strconv.Atoi(val); errConv == nil { // success
-			num = converted
-		}
-	} else {
-		// The key does not exist, create a new one.
-		// This is to reflect the behavior of INCR in Redis.
-		NewKeyValue(kv.host, kv.table)
-	}
-	// Num is now either 0 or the previous numeric value
-	num++
-	// Convert the new value to a string
-	val := strconv.Itoa(num)
-	// Store the new number
-	if err := kv.Set(key, val); err != nil {
-		// Saving the value failed
+	num, err := kv.IncBy(key, 1)
+	if err != nil {
 		return "0", err
 	}
-	// Success
-	return val, nil
+	return strconv.FormatInt(num, 10), nil
 }
 
-// Dec increases the value of a key and returns the new value.
-// Returns "1" if no previous value is found.
+// Dec decreases the value of a key and returns the new value.
+// Returns "-1" if no previous value is found. See IncBy.
 func (kv *KeyValue) Dec(key string) (string, error) {
-	// Retrieve the current value, if any
-	num := 0
-	// See if we can fetch an existing value. NOTE: "== nil"
-	if val, err := kv.Get(key); err == nil {
-		// See if we can convert the value to a number. NOTE: "== nil"
-		if converted, errConv := strconv.Atoi(val); errConv == nil {
-			num = converted
+	num, err := kv.IncBy(key, -1)
+	if err != nil {
+		return "0", err
+	}
+	return strconv.FormatInt(num, 10), nil
+}
+
+// IncBy adds n, which may be negative, to the numeric value of a key, and
+// returns the new value. A key that does not exist, or that does not have a
+// numeric value, counts as 0, like INCRBY in Redis. The value is read and
+// written in a single transaction, while holding a lock that makes
+// concurrent calls, also from other processes, wait, so that no increments
+// are lost. The value is always written, regardless of WithConflictPolicy.
+func (kv *KeyValue) IncBy(key string, n int64) (int64, error) {
+	ctx := kv.host.context()
+	transaction, err := kv.host.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// The advisory lock is also held while the table is still empty, and the
+	// row lock makes Set wait until the new value has been written
+	if _, err := transaction.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", kv.tableName()); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	var isEmpty bool
+	if err := transaction.QueryRowContext(ctx, fmt.Sprintf("SELECT NOT EXISTS (SELECT 1 FROM %s FOR UPDATE)", kv.tableName())).Scan(&isEmpty); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	var num int64
+	if !isEmpty {
+		value, err := kv.getWithTransaction(ctx, transaction, key)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			transaction.Rollback()
+			return 0, err
 		}
+		// Values that are not numbers count as 0
+		num, _ = strconv.ParseInt(value, 10, 64)
+	}
+	num += n
+	encodedValue := strconv.FormatInt(num, 10)
+	if err := kv.encode(&encodedValue); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	if isEmpty {
+		_, err = kv.insertWithTransaction(ctx, transaction, key, encodedValue)
 	} else {
-		// The key does not exist, create a new one.
-		NewKeyValue(kv.host, kv.table)
-	}
-	// Num is now either 0 or the previous numeric value
-	num--
-	// Convert the new value to a string
-	val := strconv.Itoa(num)
-	// Store the new number
-	if err := kv.Set(key, val); err != nil {
-		// Saving the value failed
-		return "0", err
+		counter := *kv
+		counter.conflict = ConflictOverwrite
+		_, err = counter.updateWithTransaction(ctx, transaction, key, encodedValue)
 	}
-	// Success
-	return val, nil
+	if err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	return num, transaction.Commit()
+}
+
+// DecBy subtracts n from the numeric value of a key, and returns the new value. See IncBy.
+func (kv *KeyValue) DecBy(key string, n int64) (int64, error) {
+	return kv.IncBy(key, -n)
 }
 
 // Del removes the given key
 func (kv *KeyValue) Del(key string) error {
-	_, err := kv.host.db.Exec(fmt.Sprintf("UPDATE %s SET attr = delete(attr, '%s')", pq.QuoteIdentifier(kvPrefix+kv.table), escapeSingleQuotes(key)))
+	_, err := kv.host.exec(fmt.Sprintf("UPDATE %s SET attr = delete(attr, '%s'), expires = delete(expires, '%s')", kv.tableName(), escapeSingleQuotes(key), escapeSingleQuotes(key)))
 	return err
 }
 
+// DelWhereValue removes all keys that have the given value, in a single
+// statement, and returns the number of removed keys
+func (kv *KeyValue) DelWhereValue(value string) (int64, error) {
+	if err := kv.encode(&value); err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("WITH matching AS (SELECT e.key FROM %s AS t, each(t.attr) AS e WHERE e.value = $1), removed AS (UPDATE %s SET attr = delete(attr, ARRAY(SELECT key FROM matching)), expires = delete(expires, ARRAY(SELECT key FROM matching))) SELECT COUNT(*) FROM matching", kv.tableName(), kv.tableName())
+	var n int64
+	err := kv.host.queryRow(query, value).Scan(&n)
+	return n, err
+}
+
 // Remove this key/value
 func (kv *KeyValue) Remove() error {
 	// Remove the table
-	_, err := kv.host.db.Exec(fmt.Sprintf("DROP TABLE %s", pq.QuoteIdentifier(kvPrefix+kv.table)))
-	return err
+	if _, err := kv.host.exec(fmt.Sprintf("DROP TABLE %s", kv.tableName())); err != nil {
+		return err
+	}
+	// Remove the table for large values, if it has been created
+	if _, err := kv.host.exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", kv.largeValues().table)); err != nil {
+		return err
+	}
+	// Remove the table for last access times, if it has been created
+	if _, err := kv.host.exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", kv.accessTable())); err != nil {
+		return err
+	}
+	accessTables.Delete(kv.accessTableKey())
+	// Remove the stream of changes, if it has been created
+	if err := kv.removeStream(); err != nil {
+		return err
+	}
+	return kv.unregister("keyvalue", kv.tableName())
 }
 
 // Clear this key/value
 func (kv *KeyValue) Clear() error {
 	// Truncate the table
-	_, err := kv.host.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s", pq.QuoteIdentifier(kvPrefix+kv.table)))
-	return err
+	if _, err := kv.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", kv.tableName())); err != nil {
+		return err
+	}
+	// Truncate the tables for large values and last access times, if they have been created
+	for _, table := range []string{kv.largeValues().table, kv.accessTable()} {
+		found, err := kv.exists(table)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if _, err := kv.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checksum returns an md5 sum of all keys and values, sorted by key.
+// Keys that have expired are not included.
+// The sum is calculated by the database server, and can be used for
+// checking that two key/values have the same contents.
+func (kv *KeyValue) Checksum() (string, error) {
+	return kv.host.checksum(fmt.Sprintf("SELECT md5(COALESCE(string_agg(key || E'\\t' || COALESCE(value, ''), E'\\n' ORDER BY key), '')) FROM (SELECT DISTINCT e.key, e.value FROM %s AS t, each(t.attr) AS e WHERE %s) AS temp", kv.tableName(), liveKey("e.key")))
+}
+
+// pairs returns all keys that have not expired, and their decoded values
+func (kv *KeyValue) pairs() (map[string]string, error) {
+	pairs, err := kv.scanPairs(fmt.Sprintf("SELECT key, value FROM (SELECT DISTINCT e.key, e.value FROM %s AS t, each(t.attr) AS e WHERE %s) AS temp WHERE value IS NOT NULL", kv.tableName(), liveKey("e.key")))
+	if err != nil {
+		return nil, err
+	}
+	if err := kv.decodePairs(pairs); err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		m[pair[0]] = pair[1]
+	}
+	return m, nil
+}
+
+// IsEmpty checks if the key/value has no keys, without counting them.
+// Unlike Empty, this is also true if all keys have been deleted or have expired.
+func (kv *KeyValue) IsEmpty() (bool, error) {
+	return kv.host.noRows(fmt.Sprintf("SELECT 1 FROM %s AS t CROSS JOIN skeys(t.attr) AS k WHERE %s", kv.tableName(), liveKey("k")))
 }
 
-// Count counts the number of keys
+// Count counts the number of keys that have not expired
 func (kv *KeyValue) Count() (int, error) {
 	var value sql.NullInt32
-	query := fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT skeys(attr) FROM %s) as temp", pq.QuoteIdentifier(kvPrefix+kv.table))
-	rows, err := kv.host.db.Query(query)
+	query := fmt.Sprintf("SELECT COUNT(DISTINCT k) FROM %s AS t CROSS JOIN skeys(t.attr) AS k WHERE %s", kv.tableName(), liveKey("k"))
+	rows, err := kv.host.query(query)
 	if err != nil {
 		return 0, err
 	}
@@ -368,11 +597,11 @@ func (kv *KeyValue) Count() (int, error) {
 	return int(value.Int32), nil
 }
 
-// CountInt64 counts the number of keys
+// CountInt64 counts the number of keys that have not expired
 func (kv *KeyValue) CountInt64() (int64, error) {
 	var value sql.NullInt64
-	query := fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT skeys(attr) FROM %s) as temp", pq.QuoteIdentifier(kvPrefix+kv.table))
-	rows, err := kv.host.db.Query(query)
+	query := fmt.Sprintf("SELECT COUNT(DISTINCT k) FROM %s AS t CROSS JOIN skeys(t.attr) AS k WHERE %s", kv.tableName(), liveKey("k"))
+	rows, err := kv.host.query(query)
 	if err != nil {
 		return 0, err
 	}
@@ -392,8 +621,8 @@ func (kv *KeyValue) CountInt64() (int64, error) {
 // Empty checks if there are no keys, in an efficient way
 func (kv *KeyValue) Empty() (bool, error) {
 	var value sql.NullInt64
-	query := fmt.Sprintf("SELECT COUNT(*) FROM (SELECT attr FROM %s LIMIT 1) as temp", pq.QuoteIdentifier(kvPrefix+kv.table))
-	rows, err := kv.host.db.Query(query)
+	query := fmt.Sprintf("SELECT COUNT(*) FROM (SELECT attr FROM %s LIMIT 1) as temp", kv.tableName())
+	rows, err := kv.host.query(query)
 	if err != nil {
 		return true, err
 	}
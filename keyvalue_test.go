@@ -1,7 +1,10 @@
 package simplehstore
 
 import (
+	"bytes"
+	"context"
 	"testing"
+	"time"
 
 	"github.com/colinf/pinterface"
 )
@@ -162,3 +165,345 @@ func TestInc3(t *testing.T) {
 
 	kv.Remove()
 }
+
+func TestIncBy(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+	kv, err := NewKeyValue(host, "kv_incby_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+
+	// Concurrent increments are not lost, also while the table is empty
+	errs := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func() {
+			for j := 0; j < 10; j++ {
+				if _, err := kv.IncBy("views", 2); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := kv.DecBy("views", 50); err != nil || n != 150 {
+		t.Errorf("Error, expected 150, got %d (%v)", n, err)
+	}
+	kv.Set("name", "bob")
+	if n, err := kv.IncBy("name", 1); err != nil || n != 1 {
+		t.Errorf("Error, expected a value that is not a number to count as 0, got %d (%v)", n, err)
+	}
+
+	kv.Remove()
+}
+
+func TestConditional(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+	kv, err := NewKeyValue(host, "kv_conditional_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+
+	if ok, err := kv.SetNX("lock", "worker1"); err != nil || !ok {
+		t.Errorf("Error, expected SetNX to set a missing key (%v)", err)
+	}
+	if ok, err := kv.SetNX("lock", "worker2"); err != nil || ok {
+		t.Errorf("Error, expected SetNX to leave an existing key as it is (%v)", err)
+	}
+	if ok, err := kv.CAS("lock", "worker2", "worker3"); err != nil || ok {
+		t.Errorf("Error, expected CAS to fail for the wrong old value (%v)", err)
+	}
+	if ok, err := kv.CAS("lock", "worker1", "worker3"); err != nil || !ok {
+		t.Errorf("Error, expected CAS to succeed for the right old value (%v)", err)
+	}
+	if previous, err := kv.GetSet("lock", "worker4"); err != nil || previous != "worker3" {
+		t.Errorf("Error, expected worker3, got %q (%v)", previous, err)
+	}
+	if previous, err := kv.GetSet("token", "used"); err != nil || previous != "" {
+		t.Errorf("Error, expected no previous value, got %q (%v)", previous, err)
+	}
+	if value, err := kv.Get("lock"); err != nil || value != "worker4" {
+		t.Errorf("Error, expected worker4, got %q (%v)", value, err)
+	}
+
+	kv.Remove()
+}
+
+func TestLargeValue(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "large_value_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+
+	// Larger than two chunks
+	data := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/8+1)
+	if err := kv.SetReader("blob", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Error, could not store large value! %s", err)
+	}
+	var buf bytes.Buffer
+	if err := kv.GetWriter("blob", &buf); err != nil {
+		t.Fatalf("Error, could not retrieve large value! %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Error, expected %d bytes back, got %d", len(data), buf.Len())
+	}
+	if err := kv.DelLarge("blob"); err != nil {
+		t.Errorf("Error, could not remove large value! %s", err)
+	}
+	if err := kv.GetWriter("blob", &buf); err != ErrNoLargeValue {
+		t.Errorf("Error, expected ErrNoLargeValue, got %v", err)
+	}
+
+	kv.Remove()
+}
+
+func TestUpsert(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "upsert_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+
+	if err := kv.Upsert("a", "1"); err != nil {
+		t.Fatalf("Error, could not upsert into an empty KeyValue! %s", err)
+	}
+	if err := kv.UpsertMany(map[string]string{"a": "2", "b": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := kv.Get("a"); err != nil || v != "2" {
+		t.Errorf("Error, expected 2, got %s (%v)", v, err)
+	}
+	if v, err := kv.Get("b"); err != nil || v != "3" {
+		t.Errorf("Error, expected 3, got %s (%v)", v, err)
+	}
+	if n, _ := kv.Count(); n != 2 {
+		t.Errorf("Error, expected two keys, got %d", n)
+	}
+	if found, err := kv.Has("b"); err != nil || !found {
+		t.Errorf("Error, expected b to exist (%v)", err)
+	}
+	if found, _ := kv.Has("c"); found {
+		t.Error("Error, c should not exist")
+	}
+
+	kv.Remove()
+}
+
+func TestEvictLRU(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "lru_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		kv.Set(key, key)
+	}
+	// "a" is never touched, so it should be evicted first
+	kv.Touch("b")
+	kv.Touch("c")
+	kv.Touch("d")
+	kv.Touch("b")
+	if n, err := kv.EvictLRU(2); err != nil || n != 2 {
+		t.Errorf("Error, expected two keys to be evicted, got %d (%v)", n, err)
+	}
+	for key, expected := range map[string]bool{"a": false, "b": true, "c": false, "d": true} {
+		if has, err := kv.Has(key); err != nil || has != expected {
+			t.Errorf("Error, expected Has(%s) to be %v (%v)", key, expected, err)
+		}
+	}
+	if accessed, err := kv.LastAccessed("b"); err != nil || accessed.IsZero() {
+		t.Errorf("Error, expected b to have been accessed (%v)", err)
+	}
+	if n, err := kv.EvictOlderThan(time.Now().Add(time.Hour)); err != nil || n != 2 {
+		t.Errorf("Error, expected the remaining two keys to be evicted, got %d (%v)", n, err)
+	}
+
+	kv.Remove()
+}
+
+func TestGetForUpdate(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "getforupdate_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	kv.Set("balance", "100")
+
+	tx, err := host.Database().Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHost, err := NewHostFromTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Use the same key/value, but within the transaction
+	txKV := &KeyValue{kv.dbDatastructure}
+	txKV.host = txHost
+	balance, err := kv.GetForUpdate(tx, "balance")
+	if err != nil || balance != "100" {
+		t.Fatalf("Error, expected 100, got %s (%v)", balance, err)
+	}
+	if err := txKV.Set("balance", "90"); err != nil {
+		t.Error(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := kv.Get("balance"); err != nil || v != "90" {
+		t.Errorf("Error, expected 90, got %s (%v)", v, err)
+	}
+
+	kv.Remove()
+}
+
+func TestSetExpiring(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "expiring_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	if err := kv.SetExpiring("session", "abc123", 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	kv.Set("username", "bob")
+	if ttl, ok, err := kv.TTL("session"); err != nil || !ok || ttl <= 0 || ttl > 100*time.Millisecond {
+		t.Errorf("Error, expected a TTL of at most 100ms, got %v %v (%v)", ttl, ok, err)
+	}
+	if _, ok, err := kv.TTL("username"); err != nil || ok {
+		t.Errorf("Error, expected username to not expire (%v)", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if s, err := kv.Get("session"); err == nil || s != "" {
+		t.Errorf("Error, expected the session to have expired, got %q", s)
+	}
+	if found, err := kv.Has("session"); err != nil || found {
+		t.Errorf("Error, expected Has to skip the expired session (%v)", err)
+	}
+	if keys, err := kv.All(); err != nil || len(keys) != 1 || keys[0] != "username" {
+		t.Errorf("Error, expected only username to be listed, got %v (%v)", keys, err)
+	}
+	if n, err := kv.Count(); err != nil || n != 1 {
+		t.Errorf("Error, expected one key to be counted, got %d (%v)", n, err)
+	}
+	if n, err := kv.ExpireKeys(); err != nil || n != 1 {
+		t.Errorf("Error, expected one key to be removed, got %d (%v)", n, err)
+	}
+	if s, err := kv.Get("username"); err != nil || s != "bob" {
+		t.Errorf("Error, expected username to be kept, got %q (%v)", s, err)
+	}
+
+	// Persist removes the expiry
+	kv.SetExpiring("session", "def456", 100*time.Millisecond)
+	kv.Persist("session")
+	time.Sleep(200 * time.Millisecond)
+	if s, err := kv.Get("session"); err != nil || s != "def456" {
+		t.Errorf("Error, expected the persisted session to be kept, got %q (%v)", s, err)
+	}
+
+	// A key/value with only expired keys is empty
+	kv.Del("username")
+	kv.Del("session")
+	kv.SetExpiring("session", "ghi789", 100*time.Millisecond)
+	if empty, err := kv.IsEmpty(); err != nil || empty {
+		t.Errorf("Error, expected the key/value to not be empty yet (%v)", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if empty, err := kv.IsEmpty(); err != nil || !empty {
+		t.Errorf("Error, expected a key/value with only expired keys to be empty (%v)", err)
+	}
+	kv.Set("username", "bob")
+
+	// A value that is kept because of the conflict policy does not get an expiry
+	keepFirst, err := NewKeyValue(host, "expiring_test", WithConflictPolicy(ConflictKeepFirst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keepFirst.SetExpiring("username", "alice", 100*time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if _, ok, err := kv.TTL("username"); err != nil || ok {
+		t.Errorf("Error, expected username to still not expire (%v)", err)
+	}
+
+	kv.Remove()
+}
+
+func TestWithTTL(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "ttl_test", WithTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	if err := kv.Set("session", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok, err := kv.TTL("session"); err != nil || !ok || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Error, expected a TTL of at most a minute, got %v %v (%v)", ttl, ok, err)
+	}
+
+	kv.Remove()
+}
+
+func TestRunExpiry(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "run_expiry_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	kv.Set("username", "bob")
+	kv.SetExpiring("session", "abc123", 50*time.Millisecond)
+
+	expired := make(chan [2]string, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go kv.RunExpiry(ctx, 50*time.Millisecond, func(key, value string) {
+		expired <- [2]string{key, value}
+	})
+	select {
+	case e := <-expired:
+		if e != [2]string{"session", "abc123"} {
+			t.Errorf("Error, expected the session to expire, got %v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Error, the session did not expire")
+	}
+	if s, err := kv.Get("username"); err != nil || s != "bob" {
+		t.Errorf("Error, expected username to be kept, got %q (%v)", s, err)
+	}
+
+	cancel()
+	kv.Remove()
+}
@@ -0,0 +1,149 @@
+package simplehstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lib/pq"
+)
+
+// chunkSize is the size of each stored chunk of a large value
+var chunkSize = 256 * 1024
+
+// ErrNoLargeValue is returned when trying to read a large value that does not exist
+var ErrNoLargeValue = errors.New("no large value for the given key")
+
+// chunkTable stores large values as numbered chunks of bytes,
+// so that they can be streamed without being held in memory
+type chunkTable struct {
+	host  *Host
+	table string // quoted and possibly schema qualified table name
+}
+
+// create the chunk table, if it does not already exist
+func (c *chunkTable) create() error {
+	_, err := c.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT NOT NULL, seq INTEGER NOT NULL, data BYTEA NOT NULL, PRIMARY KEY (key, seq))", c.table))
+	return err
+}
+
+// write replaces the chunks for the given key with the contents of r,
+// as part of the given transaction. The number of bytes written is returned.
+func (c *chunkTable) write(ctx context.Context, transaction queryer, key string, r io.Reader) (int64, error) {
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE key = $1", c.table), key); err != nil {
+		return 0, err
+	}
+	var (
+		total int64
+		seq   int
+		buf   = make([]byte, chunkSize)
+	)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (key, seq, data) VALUES ($1, $2, $3)", c.table), key, seq, buf[:n]); err != nil {
+				return total, err
+			}
+			total += int64(n)
+			seq++
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+	if seq == 0 {
+		// Store an empty chunk, so that empty values can be told apart from missing ones
+		if _, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (key, seq, data) VALUES ($1, 0, '')", c.table), key); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// read streams the chunks for the given key to w, and returns the number of bytes written
+func (c *chunkTable) read(key string, w io.Writer) (int64, error) {
+	rows, err := c.host.query(fmt.Sprintf("SELECT data FROM %s WHERE key = $1 ORDER BY seq", c.table), key)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var (
+		total int64
+		found bool
+		chunk []byte
+	)
+	for rows.Next() {
+		found = true
+		if err := rows.Scan(&chunk); err != nil {
+			return total, err
+		}
+		n, err := w.Write(chunk)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return total, err
+	}
+	if !found {
+		return 0, ErrNoLargeValue
+	}
+	return total, nil
+}
+
+// del removes the chunks for the given key
+func (c *chunkTable) del(key string) error {
+	_, err := c.host.exec(fmt.Sprintf("DELETE FROM %s WHERE key = $1", c.table), key)
+	return err
+}
+
+// largeValues returns the chunk table for this key/value
+func (kv *KeyValue) largeValues() *chunkTable {
+	return &chunkTable{kv.host, kv.qualify(pq.QuoteIdentifier(kvPrefix + kv.table + "_chunks"))}
+}
+
+// SetReader stores a large value for the given key, by reading from r until EOF.
+// The value is stored in chunks, in a single transaction, so that the value
+// never needs to be held fully in memory. Large values are stored separately
+// from the values that are set with Set, and are retrieved with GetWriter.
+func (kv *KeyValue) SetReader(key string, r io.Reader) error {
+	chunks := kv.largeValues()
+	if err := chunks.create(); err != nil {
+		return err
+	}
+	ctx := kv.host.context()
+	transaction, err := kv.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := chunks.write(ctx, transaction, key, r); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+// GetWriter writes the large value for the given key to w, one chunk at a time.
+// ErrNoLargeValue is returned if no large value has been stored for the key.
+func (kv *KeyValue) GetWriter(key string, w io.Writer) error {
+	chunks := kv.largeValues()
+	if err := chunks.create(); err != nil {
+		return err
+	}
+	_, err := chunks.read(key, w)
+	return err
+}
+
+// DelLarge removes the large value for the given key
+func (kv *KeyValue) DelLarge(key string) error {
+	chunks := kv.largeValues()
+	if err := chunks.create(); err != nil {
+		return err
+	}
+	return chunks.del(key)
+}
@@ -0,0 +1,165 @@
+package simplehstore
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencySamples is how many latencies are kept per operation, by default
+const DefaultLatencySamples = 1024
+
+// LatencyStats are the latency percentiles of the queries of an operation
+type LatencyStats struct {
+	Count int64 // the number of queries, including the ones that are not in the sample
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+	Max   time.Duration // the longest latency in the sample
+}
+
+// String returns the percentiles on a single line, for logging
+func (s LatencyStats) String() string {
+	return fmt.Sprintf("n=%d p50=%s p95=%s p99=%s max=%s", s.Count, s.P50, s.P95, s.P99, s.Max)
+}
+
+// reservoir is a uniform random sample of a stream of latencies (Algorithm R)
+type reservoir struct {
+	count   int64
+	samples []time.Duration
+}
+
+// add adds a latency to the sample, replacing a random sample when the
+// reservoir is full, so that every latency has the same chance of being kept
+func (r *reservoir) add(latency time.Duration, size int, rng *rand.Rand) {
+	r.count++
+	if len(r.samples) < size {
+		r.samples = append(r.samples, latency)
+	} else if i := rng.Int63n(r.count); i < int64(size) {
+		r.samples[i] = latency
+	}
+}
+
+// stats returns the percentiles of the sample
+func (r *reservoir) stats() LatencyStats {
+	sorted := append([]time.Duration{}, r.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		if len(sorted) == 0 {
+			return 0
+		}
+		return sorted[int(p*float64(len(sorted)-1)+0.5)]
+	}
+	return LatencyStats{Count: r.count, P50: percentile(0.5), P95: percentile(0.95), P99: percentile(0.99), Max: percentile(1)}
+}
+
+// latencySampler keeps a reservoir of latencies per operation.
+// It is created together with the Host, and is shared by all copies of the
+// Host, but does not sample anything until sampling is enabled.
+type latencySampler struct {
+	mut        sync.Mutex
+	size       int // the number of latencies to keep per operation, or 0 if sampling is not enabled
+	rng        *rand.Rand
+	operations map[string]*reservoir
+}
+
+// newLatencySampler returns a sampler where sampling is not enabled
+func newLatencySampler() *latencySampler {
+	return &latencySampler{operations: make(map[string]*reservoir)}
+}
+
+// EnableLatencySampling samples the latency of each query that is run by
+// this host, and by the data structures that are created with it, grouped
+// by the operation that runs the query, like "hashmap2.set". Up to size
+// latencies are kept per operation, as a uniform random sample, so the memory
+// use is fixed, no matter how many queries are run. See Latencies.
+func (host *Host) EnableLatencySampling(size int) {
+	if size <= 0 {
+		size = DefaultLatencySamples
+	}
+	s := host.latency
+	if s == nil {
+		// The host was not created by one of the New functions
+		return
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.rng == nil {
+		s.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	s.size = size
+}
+
+// observe records the latency of a query that was started at the given time, if sampling is enabled
+func (host *Host) observe(start time.Time) {
+	if host.latency == nil {
+		return
+	}
+	latency := time.Since(start)
+	s := host.latency
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.size == 0 {
+		return
+	}
+	op := operation()
+	r, ok := s.operations[op]
+	if !ok {
+		r = &reservoir{}
+		s.operations[op] = r
+	}
+	r.add(latency, s.size, s.rng)
+}
+
+// Latencies returns the latency percentiles per operation, since sampling
+// was enabled or the latencies were last reset. An empty map is returned if
+// sampling is not enabled.
+func (host *Host) Latencies() map[string]LatencyStats {
+	latencies := make(map[string]LatencyStats)
+	if host.latency == nil {
+		return latencies
+	}
+	s := host.latency
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for op, r := range s.operations {
+		latencies[op] = r.stats()
+	}
+	return latencies
+}
+
+// ResetLatencies removes all sampled latencies
+func (host *Host) ResetLatencies() {
+	if host.latency == nil {
+		return
+	}
+	host.latency.mut.Lock()
+	host.latency.operations = make(map[string]*reservoir)
+	host.latency.mut.Unlock()
+}
+
+// LogLatencies logs the latency percentiles per operation with the logger of
+// the host every interval, and then resets them, until ctx is cancelled.
+// Sampling must be enabled with EnableLatencySampling.
+func (host *Host) LogLatencies(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		latencies := host.Latencies()
+		host.ResetLatencies()
+		ops := make([]string, 0, len(latencies))
+		for op := range latencies {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		for _, op := range ops {
+			host.Logger().Printf("latency %s: %s", op, latencies[op])
+		}
+	}
+}
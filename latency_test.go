@@ -0,0 +1,59 @@
+package simplehstore
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestLatencyPercentiles(t *testing.T) {
+	r := &reservoir{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 1; i <= 100; i++ {
+		r.add(time.Duration(i)*time.Millisecond, 1000, rng)
+	}
+	stats := r.stats()
+	if stats.Count != 100 {
+		t.Errorf("Expected a count of 100, got %d", stats.Count)
+	}
+	if stats.P50 < 49*time.Millisecond || stats.P50 > 51*time.Millisecond {
+		t.Errorf("Unexpected p50: %s", stats.P50)
+	}
+	if stats.P99 < 98*time.Millisecond || stats.Max != 100*time.Millisecond {
+		t.Errorf("Unexpected p99 or max: %s", stats)
+	}
+}
+
+func TestLatencyReservoirSize(t *testing.T) {
+	r := &reservoir{}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		r.add(time.Duration(i), 16, rng)
+	}
+	if len(r.samples) != 16 || r.stats().Count != 10000 {
+		t.Errorf("Expected 16 samples of 10000 latencies, got %d of %d", len(r.samples), r.stats().Count)
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	host := &Host{latency: newLatencySampler()}
+	host.observe(time.Now())
+	if len(host.Latencies()) != 0 {
+		t.Error("Expected no latencies when sampling is not enabled")
+	}
+	host.EnableLatencySampling(0)
+	host.observe(time.Now().Add(-time.Millisecond))
+	latencies := host.Latencies()
+	if len(latencies) != 1 {
+		t.Fatalf("Expected latencies for one operation, got %v", latencies)
+	}
+	for _, stats := range latencies {
+		if stats.Count != 1 || stats.P50 < time.Millisecond {
+			t.Errorf("Unexpected latency stats: %s", stats)
+		}
+	}
+	host.ResetLatencies()
+	if len(host.Latencies()) != 0 {
+		t.Error("Expected no latencies after a reset")
+	}
+}
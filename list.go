@@ -1,37 +1,73 @@
 package simplehstore
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
 
 // List is a list of strings, stored in PostgreSQL
-type List dbDatastructure
+type List struct {
+	dbDatastructure
+}
 
 // NewList creates a new List. Lists are ordered.
-func NewList(host *Host, name string) (*List, error) {
-	l := &List{host, pq.QuoteIdentifier(name)} // name is the name of the table
-	if _, err := l.host.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id SERIAL PRIMARY KEY, %s %s)", l.table, listCol, defaultStringType)); err != nil {
+func NewList(provider HostProvider, name string, opts ...Option) (*List, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	l := &List{newDatastructure(host, pq.QuoteIdentifier(name), opts)} // name is the name of the table
+	if err := l.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := l.exists(l.tableName())
+	if err != nil {
+		return nil, err
+	}
+	if l.partitionInterval > 0 || l.hashPartitions > 0 {
+		if !existed {
+			if err := l.createPartitioned(); err != nil {
+				return nil, err
+			}
+		}
+	} else if _, err := l.host.exec(fmt.Sprintf("%s %s (id SERIAL PRIMARY KEY, %s %s, created TIMESTAMPTZ NOT NULL DEFAULT now())", l.createTable(), l.tableName(), listCol, defaultStringType)); err != nil {
 		if !strings.HasSuffix(err.Error(), "already exists") {
 			return nil, err
 		}
 	}
-	if Verbose {
-		log.Println("Created table " + l.table + " in database " + host.dbname)
+	// Storage parameters can only be set for the partitions of a partitioned table
+	if l.partitionInterval == 0 && l.hashPartitions == 0 {
+		if err := l.setStorageParameters(l.tableName()); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.register("list", name, l.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if l.index {
+		if _, err := l.host.exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", pq.QuoteIdentifier(name+"_idx"), l.tableName(), listCol)); err != nil {
+			return nil, err
+		}
+	}
+	if l.verbose() {
+		l.host.Logger().Println("Created table " + l.tableName() + " in database " + host.dbname)
 	}
 	return l, nil
 }
 
 // Add an element to the list
 func (l *List) Add(value string) error {
-	if !l.host.rawUTF8 {
-		Encode(&value)
+	if err := l.encode(&value); err != nil {
+		return err
 	}
-	_, err := l.host.db.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1)", l.table, listCol), value)
+	_, err := l.host.exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1)", l.tableName(), listCol), value)
 	return err
 }
 
@@ -41,7 +77,7 @@ func (l *List) All() ([]string, error) {
 		values []string
 		value  sql.NullString
 	)
-	rows, err := l.host.db.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY id", listCol, l.table))
+	rows, err := l.host.query(fmt.Sprintf("SELECT %s FROM %s ORDER BY id", listCol, l.tableName()))
 	if err != nil {
 		return values, err
 	}
@@ -51,22 +87,22 @@ func (l *List) All() ([]string, error) {
 	defer rows.Close()
 	for rows.Next() {
 		err = rows.Scan(&value)
-		s := value.String
-		if !l.host.rawUTF8 {
-			Decode(&s)
-		}
-		values = append(values, s)
+		values = append(values, value.String)
 		if err != nil {
 			return values, err
 		}
 	}
-	err = rows.Err()
+	if err = rows.Err(); err != nil {
+		return values, err
+	}
+	rows.Close()
+	err = l.decodeAll(values)
 	return values, err
 }
 
 // Has checks if an element exists in the list
 func (l *List) Has(owner string) (bool, error) {
-	rows, err := l.host.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE id = '%s'", listCol, l.table, owner))
+	rows, err := l.host.query(fmt.Sprintf("SELECT %s FROM %s WHERE id = '%s'", listCol, l.tableName(), owner))
 	if err != nil {
 		return false, err
 	}
@@ -76,7 +112,9 @@ func (l *List) Has(owner string) (bool, error) {
 	return true, nil
 }
 
-// GetAll is deprecated in favor of All
+// GetAll returns the same as All.
+//
+// Deprecated: use All instead.
 func (l *List) GetAll() ([]string, error) {
 	return l.All()
 }
@@ -86,7 +124,7 @@ func (l *List) Last() (string, error) {
 	var value sql.NullString
 	// Fetches the item with the largest id.
 	// Faster than "ORDER BY id DESC limit 1" for large tables.
-	rows, err := l.host.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE id = (SELECT MAX(id) FROM %s)", listCol, l.table, l.table))
+	rows, err := l.host.query(fmt.Sprintf("SELECT %s FROM %s WHERE id = (SELECT MAX(id) FROM %s)", listCol, l.tableName(), l.tableName()))
 	if err != nil {
 		return "", err
 	}
@@ -105,13 +143,15 @@ func (l *List) Last() (string, error) {
 		return "", err
 	}
 	s := value.String
-	if !l.host.rawUTF8 {
-		Decode(&s)
+	if err := l.decode(&s); err != nil {
+		return "", err
 	}
 	return s, nil
 }
 
-// GetLast is deprecated in favor of Last
+// GetLast returns the same as Last.
+//
+// Deprecated: use Last instead.
 func (l *List) GetLast() (string, error) {
 	return l.Last()
 }
@@ -124,7 +164,7 @@ func (l *List) LastN(n int) ([]string, error) {
 		values []string
 		value  string
 	)
-	rows, err := l.host.db.Query(fmt.Sprintf("SELECT %s FROM (SELECT * FROM %s ORDER BY id DESC limit %d)sub ORDER BY id ASC", listCol, l.table, n))
+	rows, err := l.host.query(fmt.Sprintf("SELECT %s FROM (SELECT * FROM %s ORDER BY id DESC limit %d)sub ORDER BY id ASC", listCol, l.tableName(), n))
 	if err != nil {
 		return values, err
 	}
@@ -134,9 +174,6 @@ func (l *List) LastN(n int) ([]string, error) {
 	defer rows.Close()
 	for rows.Next() {
 		err = rows.Scan(&value)
-		if !l.host.rawUTF8 {
-			Decode(&value)
-		}
 		values = append(values, value)
 		if err != nil {
 			return values, err
@@ -145,41 +182,459 @@ func (l *List) LastN(n int) ([]string, error) {
 	if err := rows.Err(); err != nil {
 		return values, err
 	}
+	rows.Close()
+	if err := l.decodeAll(values); err != nil {
+		return values, err
+	}
 	if len(values) < n {
 		return values, ErrTooFewResults
 	}
 	return values, nil
 }
 
-// GetLastN is deprecated in favor of LastN
+// GetLastN returns the same as LastN.
+//
+// Deprecated: use LastN instead.
 func (l *List) GetLastN(n int) ([]string, error) {
 	return l.LastN(n)
 }
 
+// FirstN retrieves the N first elements of a list. If there are too few
+// available elements, the values that were found are returned, together
+// with ErrTooFewResults.
+func (l *List) FirstN(n int) ([]string, error) {
+	values, err := l.values(fmt.Sprintf("SELECT %s FROM %s ORDER BY id LIMIT %d", listCol, l.tableName(), n))
+	if err != nil {
+		return values, err
+	}
+	if len(values) < n {
+		return values, ErrTooFewResults
+	}
+	return values, nil
+}
+
+// Range retrieves up to limit elements, starting at the given offset, in the
+// same order as returned by All. Fewer elements are returned if the end of
+// the list is reached. For paging through long lists that change while they
+// are being read, Page is both faster and more reliable.
+func (l *List) Range(offset, limit int) ([]string, error) {
+	return l.values(fmt.Sprintf("SELECT %s FROM %s ORDER BY id LIMIT %d OFFSET %d", listCol, l.tableName(), limit, offset))
+}
+
+// Page retrieves up to limit elements, after the position of the given
+// cursor, together with a cursor for the next page. An empty cursor starts
+// at the beginning of the list, and the returned cursor is empty if there
+// are no more elements. Elements are found by their ID, so pages are not
+// skipped or repeated when elements are added or removed in the meantime.
+func (l *List) Page(cursor string, limit int) ([]string, string, error) {
+	if limit < 1 {
+		return []string{}, cursor, nil
+	}
+	var after int64
+	if cursor != "" {
+		var err error
+		if after, err = strconv.ParseInt(cursor, 10, 64); err != nil {
+			return []string{}, "", ErrInvalidCursor
+		}
+	}
+	// One more element is fetched, to know if there is a next page
+	rows, err := l.host.query(fmt.Sprintf("SELECT id, %s FROM %s WHERE id > $1 ORDER BY id LIMIT %d", listCol, l.tableName(), limit+1), after)
+	if err != nil {
+		return []string{}, "", err
+	}
+	defer rows.Close()
+	var (
+		values []string
+		ids    []int64
+		id     int64
+		value  sql.NullString
+	)
+	for rows.Next() {
+		if err := rows.Scan(&id, &value); err != nil {
+			return values, "", err
+		}
+		values = append(values, value.String)
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return values, "", err
+	}
+	rows.Close()
+	if err := l.decodeAll(values); err != nil {
+		return values, "", err
+	}
+	if len(values) <= limit {
+		return values, "", nil
+	}
+	return values[:limit], strconv.FormatInt(ids[limit-1], 10), nil
+}
+
+// values returns the decoded elements that are selected by the given query
+func (l *List) values(query string, args ...interface{}) ([]string, error) {
+	values := []string{}
+	rows, err := l.host.query(query, args...)
+	if err != nil {
+		return values, err
+	}
+	var value sql.NullString
+	for rows.Next() {
+		if err := rows.Scan(&value); err != nil {
+			rows.Close()
+			return values, err
+		}
+		values = append(values, value.String)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return values, err
+	}
+	return values, l.decodeAll(values)
+}
+
 // RemoveByIndex can remove the Nth item, in the same order as returned by All()
 func (l *List) RemoveByIndex(index int) error {
-	_, err := l.host.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (SELECT id FROM %s ORDER BY id LIMIT 1 OFFSET %d)", l.table, l.table, index))
+	_, err := l.host.exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (SELECT id FROM %s ORDER BY id LIMIT 1 OFFSET %d)", l.tableName(), l.tableName(), index))
+	return err
+}
+
+// RemoveWithPrefix removes all elements that start with the given prefix,
+// and returns the number of removed elements
+func (l *List) RemoveWithPrefix(prefix string) (int64, error) {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)
+	return l.removeWhere(listCol+" LIKE $1 || '%'", escaped, func(value string) bool {
+		return strings.HasPrefix(value, prefix)
+	})
+}
+
+// RemoveContaining removes all elements that contain the given string,
+// and returns the number of removed elements
+func (l *List) RemoveContaining(substring string) (int64, error) {
+	return l.removeWhere("strpos("+listCol+", $1) > 0", substring, func(value string) bool {
+		return strings.Contains(value, substring)
+	})
+}
+
+// RemoveMatching removes all elements that match the given regular expression,
+// and returns the number of removed elements. The elements are matched with
+// the regexp package, so the elements are read, but only the matching
+// elements are removed, in a single statement.
+func (l *List) RemoveMatching(re *regexp.Regexp) (int64, error) {
+	return l.removeWhere("", "", re.MatchString)
+}
+
+// removeWhere removes the elements that match. If the values are stored as
+// they are and a condition is given, this is done with a single DELETE.
+// If not, the elements are read and decoded, and the matching elements are
+// removed by ID, in a single transaction.
+func (l *List) removeWhere(condition, arg string, match func(string) bool) (int64, error) {
+	if condition != "" && l.getCodec().Name() == RawCodec.Name() {
+		result, err := l.host.exec(fmt.Sprintf("DELETE FROM %s WHERE %s", l.tableName(), condition), arg)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+	ctx := l.host.context()
+	transaction, err := l.host.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := transaction.QueryContext(ctx, fmt.Sprintf("SELECT id, %s FROM %s WHERE %s IS NOT NULL", listCol, l.tableName(), listCol))
+	if err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	var (
+		scanned []int64
+		values  []string
+		id      int64
+		value   string
+	)
+	for rows.Next() {
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			transaction.Rollback()
+			return 0, err
+		}
+		scanned = append(scanned, id)
+		values = append(values, value)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	if err := l.decodeAll(values); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	var ids []int64
+	for i, value := range values {
+		if match(value) {
+			ids = append(ids, scanned[i])
+		}
+	}
+	if len(ids) == 0 {
+		return 0, transaction.Commit()
+	}
+	result, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)", l.tableName()), pq.Array(ids))
+	if err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	return n, transaction.Commit()
+}
+
+// RemoveOlderThan removes all elements that were added before the given time,
+// and returns the number of removed elements. See ArchiveOlderThan for how
+// elements in lists that were created by earlier versions are handled.
+func (l *List) RemoveOlderThan(t time.Time) (int64, error) {
+	if err := l.addCreatedColumn(); err != nil {
+		return 0, err
+	}
+	result, err := l.host.exec(fmt.Sprintf("DELETE FROM %s WHERE created < $1", l.tableName()), t)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MoveLastTo removes the last element of this list and adds it to the end of
+// the other list, in a single statement, like RPOPLPUSH in Redis. The moved
+// element is returned. Concurrent calls never move the same element twice,
+// which makes this useful for reliable handoff of work between lists.
+// ErrNotFound is returned if this list is empty.
+func (l *List) MoveLastTo(other *List) (string, error) {
+	return l.moveTo(other, "DESC")
+}
+
+// MoveFirstTo removes the first element of this list and adds it to the end
+// of the other list, in a single statement. The moved element is returned.
+// ErrNotFound is returned if this list is empty.
+func (l *List) MoveFirstTo(other *List) (string, error) {
+	return l.moveTo(other, "ASC")
+}
+
+// moveTo moves the first or last element of this list to the other list
+func (l *List) moveTo(other *List, order string) (string, error) {
+	if l.getCodec().Name() != other.getCodec().Name() {
+		return "", fmt.Errorf("can not move elements between lists with the %q and %q codecs", l.getCodec().Name(), other.getCodec().Name())
+	}
+	query := fmt.Sprintf("WITH moved AS (DELETE FROM %s WHERE id = (SELECT id FROM %s ORDER BY id %s LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING %s) INSERT INTO %s (%s) SELECT %s FROM moved RETURNING %s",
+		l.tableName(), l.tableName(), order, listCol,
+		other.tableName(), listCol, listCol, listCol)
+	var value sql.NullString
+	if err := l.host.queryRow(query).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", mark(ErrNotFound, "the list is empty")
+		}
+		return "", err
+	}
+	s := value.String
+	if err := l.decode(&s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// Pop removes the first element of the list and returns it, in a single
+// statement, like LPOP in Redis. Concurrent calls never return the same
+// element twice, which makes the list usable as a simple work queue that is
+// shared between processes. ErrNotFound is returned if the list is empty.
+func (l *List) Pop() (string, error) {
+	return l.pop("ASC")
+}
+
+// PopLast removes the last element of the list and returns it, in a single
+// statement, like RPOP in Redis. ErrNotFound is returned if the list is empty.
+func (l *List) PopLast() (string, error) {
+	return l.pop("DESC")
+}
+
+// pop removes the first or last element of the list and returns it
+func (l *List) pop(order string) (string, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = (SELECT id FROM %s ORDER BY id %s LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING %s", l.tableName(), l.tableName(), order, listCol)
+	var value sql.NullString
+	if err := l.host.queryRow(query).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", mark(ErrNotFound, "the list is empty")
+		}
+		return "", err
+	}
+	s := value.String
+	if err := l.decode(&s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// Size returns the number of elements in the list, including duplicates,
+// unlike Count, which counts the distinct elements
+func (l *List) Size() (int, error) {
+	var n int
+	err := l.host.queryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", l.tableName())).Scan(&n)
+	return n, err
+}
+
+// at returns a subquery for the ID of the element at the given index, in the
+// same order as returned by All
+func (l *List) at(index int) string {
+	return fmt.Sprintf("(SELECT id FROM %s ORDER BY id LIMIT 1 OFFSET %d)", l.tableName(), index)
+}
+
+// Set replaces the element at the given index, in the same order as returned
+// by All. ErrNotFound is returned if there is no element at the index.
+func (l *List) Set(index int, value string) error {
+	if index < 0 {
+		return mark(ErrNotFound, "no element at index %d", index)
+	}
+	if err := l.encode(&value); err != nil {
+		return err
+	}
+	result, err := l.host.exec(fmt.Sprintf("UPDATE %s SET %s = $1 WHERE id = %s", l.tableName(), listCol, l.at(index)), value)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return mark(ErrNotFound, "no element at index %d", index)
+	}
+	return nil
+}
+
+// RemoveAt removes the element at the given index, in the same order as
+// returned by All. Unlike RemoveByIndex, ErrNotFound is returned if there is
+// no element at the index.
+func (l *List) RemoveAt(index int) error {
+	if index < 0 {
+		return mark(ErrNotFound, "no element at index %d", index)
+	}
+	result, err := l.host.exec(fmt.Sprintf("DELETE FROM %s WHERE id = %s", l.tableName(), l.at(index)))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return mark(ErrNotFound, "no element at index %d", index)
+	}
+	return nil
+}
+
+// InsertAt inserts an element at the given index, in the same order as
+// returned by All, so that the element that was at the index, and the
+// elements after it, come after the new element. An index that equals the
+// size of the list adds the element to the end. ErrNotFound is returned if
+// the index is larger than the size of the list.
+//
+// If there is no gap in the IDs before the element at the index, the IDs of
+// the following elements are shifted by one. The list is locked for writing
+// while this is done, so InsertAt is slower than Add for long lists.
+func (l *List) InsertAt(index int, value string) error {
+	if index < 0 {
+		return mark(ErrNotFound, "no element at index %d", index)
+	}
+	if err := l.encode(&value); err != nil {
+		return err
+	}
+	ctx := l.host.context()
+	transaction, err := l.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err := l.insertAt(ctx, transaction, index, value); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+// insertAt inserts an already encoded value at the given index, as part of the given transaction
+func (l *List) insertAt(ctx context.Context, transaction *txn, index int, value string) error {
+	// Concurrent writes could otherwise interleave with shifting the IDs
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("LOCK TABLE %s IN EXCLUSIVE MODE", l.tableName())); err != nil {
+		return err
+	}
+	var at sql.NullInt64
+	if err := transaction.QueryRowContext(ctx, "SELECT "+l.at(index)).Scan(&at); err != nil {
+		return err
+	}
+	if !at.Valid {
+		// There is no element at the index, so the value can only be added to the end
+		var size int
+		if err := transaction.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", l.tableName())).Scan(&size); err != nil {
+			return err
+		}
+		if index > size {
+			return mark(ErrNotFound, "no element at index %d", index)
+		}
+		_, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1)", l.tableName(), listCol), value)
+		return err
+	}
+	id := at.Int64
+	var previous int64
+	if err := transaction.QueryRowContext(ctx, fmt.Sprintf("SELECT COALESCE(MAX(id), 0) FROM %s WHERE id < $1", l.tableName()), id).Scan(&previous); err != nil {
+		return err
+	}
+	if id-1 > previous {
+		// There is a gap before the element, so nothing needs to be shifted
+		_, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (id, %s) VALUES ($1, $2)", l.tableName(), listCol), id-1, value)
+		return err
+	}
+	// The IDs are shifted in two steps, via negative IDs, since shifting them
+	// directly would break the uniqueness of the primary key along the way
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET id = -(id + 1) WHERE id >= $1", l.tableName()), id); err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET id = -id WHERE id < 0", l.tableName())); err != nil {
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (id, %s) VALUES ($1, $2)", l.tableName(), listCol), id, value); err != nil {
+		return err
+	}
+	// Elements that are added later must get IDs after the shifted ones
+	_, err := transaction.ExecContext(ctx, fmt.Sprintf("SELECT setval(pg_get_serial_sequence($1, 'id'), MAX(id)) FROM %s", l.tableName()), l.tableName())
 	return err
 }
 
 // Remove this list
 func (l *List) Remove() error {
 	// Remove the table
-	_, err := l.host.db.Exec(fmt.Sprintf("DROP TABLE %s", l.table))
-	return err
+	if _, err := l.host.exec(fmt.Sprintf("DROP TABLE %s", l.tableName())); err != nil {
+		return err
+	}
+	return l.unregister("list", l.tableName())
 }
 
 // Clear the list contents
 func (l *List) Clear() error {
 	// Clear the table
-	_, err := l.host.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s", l.table))
+	_, err := l.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", l.tableName()))
 	return err
 }
 
+// Checksum returns an md5 sum of all elements in the list, in order.
+// The sum is calculated by the database server, and can be used for
+// checking that two lists have the same contents.
+func (l *List) Checksum() (string, error) {
+	return l.host.checksum(fmt.Sprintf("SELECT md5(COALESCE(string_agg(COALESCE(%s, ''), E'\\n' ORDER BY id), '')) FROM %s", listCol, l.tableName()))
+}
+
+// IsEmpty checks if the list has no elements, without counting them
+func (l *List) IsEmpty() (bool, error) {
+	return l.host.noRows(fmt.Sprintf("SELECT 1 FROM %s", l.tableName()))
+}
+
 // Count counts the number of elements in this list
 func (l *List) Count() (int, error) {
 	var value sql.NullInt32
-	rows, err := l.host.db.Query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", listCol, l.table))
+	rows, err := l.host.query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", listCol, l.tableName()))
 	if err != nil {
 		return 0, err
 	}
@@ -199,7 +654,7 @@ func (l *List) Count() (int, error) {
 // CountInt64 counts the number of elements in this list (int64)
 func (l *List) CountInt64() (int64, error) {
 	var value sql.NullInt64
-	rows, err := l.host.db.Query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", listCol, l.table))
+	rows, err := l.host.query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", listCol, l.tableName()))
 	if err != nil {
 		return 0, err
 	}
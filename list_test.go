@@ -1,7 +1,11 @@
 package simplehstore
 
 import (
+	"errors"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/colinf/pinterface"
 )
@@ -140,3 +144,239 @@ func TestRemoveItemFromList(t *testing.T) {
 		t.Errorf("Error, could not remove list! %s", err)
 	}
 }
+
+func TestListChecksum(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	list, err := NewList(host, listname)
+	if err != nil {
+		t.Error(err)
+	}
+	list.Clear()
+	other, err := NewList(host, listname+"_other")
+	if err != nil {
+		t.Error(err)
+	}
+	other.Clear()
+
+	for _, l := range []*List{list, other} {
+		l.Add(testdata1)
+		l.Add(testdata2)
+	}
+
+	a, err := list.Checksum()
+	if err != nil {
+		t.Errorf("Error, could not calculate checksum! %s", err)
+	}
+	b, err := other.Checksum()
+	if err != nil {
+		t.Errorf("Error, could not calculate checksum! %s", err)
+	}
+	if a == "" || a != b {
+		t.Errorf("Error, expected equal checksums, got %s and %s", a, b)
+	}
+
+	other.Add(testdata3)
+	if c, _ := other.Checksum(); c == a {
+		t.Error("Error, expected the checksum to change when an item is added")
+	}
+
+	list.Remove()
+	other.Remove()
+}
+
+func TestRemoveWhere(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	for _, codec := range []Codec{RawCodec, HexCodec} {
+		l, err := NewList(host, "removewhere_test_"+codec.Name(), WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Clear()
+		for _, value := range []string{"debug: a", "debug: b", "info: 50%", "error: 42", "info: c"} {
+			l.Add(value)
+		}
+		if n, err := l.RemoveWithPrefix("debug:"); err != nil || n != 2 {
+			t.Errorf("Error, expected two elements to be removed with the %s codec, got %d (%v)", codec.Name(), n, err)
+		}
+		if n, err := l.RemoveContaining("50%"); err != nil || n != 1 {
+			t.Errorf("Error, expected one element to be removed with the %s codec, got %d (%v)", codec.Name(), n, err)
+		}
+		if n, err := l.RemoveMatching(regexp.MustCompile(`^error: \d+$`)); err != nil || n != 1 {
+			t.Errorf("Error, expected one element to be removed with the %s codec, got %d (%v)", codec.Name(), n, err)
+		}
+		if all, err := l.All(); err != nil || len(all) != 1 || all[0] != "info: c" {
+			t.Errorf("Error, expected only one element to be left, got %v (%v)", all, err)
+		}
+		if n, err := l.RemoveOlderThan(time.Now().Add(time.Minute)); err != nil || n != 1 {
+			t.Errorf("Error, expected the last element to be removed, got %d (%v)", n, err)
+		}
+		l.Remove()
+	}
+}
+
+func TestMoveLastTo(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	pending, err := NewList(host, "move_test_pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	processing, err := NewList(host, "move_test_processing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending.Clear()
+	processing.Clear()
+	pending.Add("a")
+	pending.Add("b")
+	pending.Add("c")
+
+	if v, err := pending.MoveLastTo(processing); err != nil || v != "c" {
+		t.Errorf("Error, expected c to be moved, got %s (%v)", v, err)
+	}
+	if v, err := pending.MoveFirstTo(processing); err != nil || v != "a" {
+		t.Errorf("Error, expected a to be moved, got %s (%v)", v, err)
+	}
+	if all, err := processing.All(); err != nil || len(all) != 2 || all[0] != "c" || all[1] != "a" {
+		t.Errorf("Error, expected c and a in the processing list, got %v (%v)", all, err)
+	}
+	pending.MoveLastTo(processing)
+	if _, err := pending.MoveLastTo(processing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound for an empty list, got %v", err)
+	}
+
+	pending.Remove()
+	processing.Remove()
+}
+
+func TestPop(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, err := NewList(host, "pop_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clear()
+	l.Add("a")
+	l.Add("b")
+	l.Add("c")
+
+	if v, err := l.Pop(); err != nil || v != "a" {
+		t.Errorf("Error, expected a to be popped, got %s (%v)", v, err)
+	}
+	if v, err := l.PopLast(); err != nil || v != "c" {
+		t.Errorf("Error, expected c to be popped, got %s (%v)", v, err)
+	}
+	if all, err := l.All(); err != nil || len(all) != 1 || all[0] != "b" {
+		t.Errorf("Error, expected only b to be left, got %v (%v)", all, err)
+	}
+	l.Pop()
+	if _, err := l.Pop(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound for an empty list, got %v", err)
+	}
+
+	l.Remove()
+}
+
+func TestListPositions(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, err := NewList(host, "positions_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clear()
+	l.Add("a")
+	l.Add("c")
+	l.Add("c")
+
+	if n, err := l.Size(); err != nil || n != 3 {
+		t.Errorf("Error, expected a size of 3, got %d (%v)", n, err)
+	}
+	if err := l.InsertAt(1, "b"); err != nil {
+		t.Error(err)
+	}
+	if err := l.InsertAt(0, "start"); err != nil {
+		t.Error(err)
+	}
+	if err := l.InsertAt(5, "end"); err != nil {
+		t.Error(err)
+	}
+	if err := l.Set(3, "d"); err != nil {
+		t.Error(err)
+	}
+	if err := l.RemoveAt(4); err != nil {
+		t.Error(err)
+	}
+	if all, err := l.All(); err != nil || strings.Join(all, ",") != "start,a,b,d,end" {
+		t.Errorf("Error, expected start,a,b,d,end, got %v (%v)", all, err)
+	}
+	if err := l.InsertAt(7, "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound for an index past the end, got %v", err)
+	}
+	if err := l.Set(5, "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound for an index past the end, got %v", err)
+	}
+	if err := l.RemoveAt(5); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound for an index past the end, got %v", err)
+	}
+	l.Add("last")
+	if last, err := l.Last(); err != nil || last != "last" {
+		t.Errorf("Error, expected last to be added after the shifted elements, got %s (%v)", last, err)
+	}
+
+	l.Remove()
+}
+
+func TestListRange(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, err := NewList(host, "range_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clear()
+	for _, v := range []string{"a", "b", "c", "d", "e"} {
+		l.Add(v)
+	}
+
+	if first, err := l.FirstN(2); err != nil || strings.Join(first, ",") != "a,b" {
+		t.Errorf("Error, expected a,b, got %v (%v)", first, err)
+	}
+	if _, err := l.FirstN(6); err != ErrTooFewResults {
+		t.Errorf("Error, expected ErrTooFewResults, got %v", err)
+	}
+	if r, err := l.Range(3, 5); err != nil || strings.Join(r, ",") != "d,e" {
+		t.Errorf("Error, expected d,e, got %v (%v)", r, err)
+	}
+
+	var all []string
+	cursor := ""
+	for {
+		page, next, err := l.Page(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if strings.Join(all, ",") != "a,b,c,d,e" {
+		t.Errorf("Error, expected all elements to be paged through, got %v", all)
+	}
+	if _, _, err := l.Page("nope", 2); err != ErrInvalidCursor {
+		t.Errorf("Error, expected ErrInvalidCursor, got %v", err)
+	}
+
+	l.Remove()
+}
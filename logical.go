@@ -0,0 +1,362 @@
+package simplehstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSubscriberBatchSize is the maximum number of changes a Subscriber fetches at once
+const DefaultSubscriberBatchSize = 1000
+
+// ErrInvalidHstore is returned when an HSTORE value from the change stream can not be parsed
+var ErrInvalidHstore = errors.New("invalid hstore value")
+
+// ChangeEvent is a change to an element of a data structure, as found by a Subscriber or Watch
+type ChangeEvent struct {
+	Op    string // "insert", "update" or "delete", or "clear" or "reset" for Watch
+	Kind  string // the kind of data structure, like "hashmap2"
+	Name  string // the name of the data structure
+	Owner string // the owner, for hash maps
+	Key   string // the key, for hash maps and key/values
+	Old   string // the old value, or "" for inserts
+	New   string // the new value, or "" for deletes
+}
+
+// subscribedTable is a data structure that changes are converted for
+type subscribedTable struct {
+	kind, name string
+	codec      Codec
+}
+
+// Subscriber consumes the changes to all data structures from a logical
+// replication slot, and converts them to change events. This is more robust
+// than notifications for tables with many changes, since no changes are lost
+// while the subscriber is not running, and changes are only removed from the
+// slot after they have been handled.
+//
+// The PostgreSQL server must be configured with wal_level = logical, and the
+// wal2json output plugin must be installed. A slot keeps the server from
+// removing write-ahead log that has not been consumed, so a slot that is no
+// longer in use must be removed with Drop.
+type Subscriber struct {
+	host      *Host
+	slot      string
+	batchSize int
+	tables    map[string]subscribedTable // by schema.table
+}
+
+// NewSubscriber creates a Subscriber that uses the given logical replication
+// slot, which is created if it does not exist. The tables of all data
+// structures that are found by Discover are set to REPLICA IDENTITY FULL,
+// so that the old values are a part of the changes.
+func NewSubscriber(provider HostProvider, slot string) (*Subscriber, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	sub := &Subscriber{host: host, slot: slot, batchSize: DefaultSubscriberBatchSize}
+	var exists bool
+	if err := host.queryRow("SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)", slot).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		if _, err := host.exec("SELECT pg_create_logical_replication_slot($1, 'wal2json')", slot); err != nil {
+			return nil, err
+		}
+	}
+	if err := sub.refresh(); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// SetBatchSize sets the maximum number of changes that are fetched at once
+func (sub *Subscriber) SetBatchSize(n int) {
+	sub.batchSize = n
+}
+
+// refresh finds the tables of all data structures, and makes sure that the
+// old values are a part of the changes
+func (sub *Subscriber) refresh() error {
+	structures, err := sub.host.Discover()
+	if err != nil {
+		return err
+	}
+	tables := make(map[string]subscribedTable, len(structures))
+	for _, s := range structures {
+		var schema, table string
+		if err := sub.host.queryRow("SELECT n.nspname, c.relname FROM pg_class AS c JOIN pg_namespace AS n ON n.oid = c.relnamespace WHERE c.oid = to_regclass($1)", s.Table).Scan(&schema, &table); err != nil {
+			return err
+		}
+		codec, ok := lookupCodec(s.Codec)
+		if !ok {
+			return fmt.Errorf("%s was written with the unknown codec %q, use RegisterCodec", s.Table, s.Codec)
+		}
+		if _, err := sub.host.exec(fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY FULL", s.Table)); err != nil {
+			return err
+		}
+		tables[schema+"."+table] = subscribedTable{s.Kind, s.Name, codec}
+	}
+	sub.tables = tables
+	return nil
+}
+
+// wal2jsonColumn is a column of a change, in the wal2json format
+type wal2jsonColumn struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value"`
+}
+
+// wal2jsonChange is a single change, in the wal2json format version 2
+type wal2jsonChange struct {
+	Action   string           `json:"action"`
+	Schema   string           `json:"schema"`
+	Table    string           `json:"table"`
+	Columns  []wal2jsonColumn `json:"columns"`
+	Identity []wal2jsonColumn `json:"identity"`
+}
+
+// column returns the value of the named column, or "" if it is missing or NULL
+func column(columns []wal2jsonColumn, name string) string {
+	for _, c := range columns {
+		if c.Name == name && c.Value != nil {
+			return *c.Value
+		}
+	}
+	return ""
+}
+
+// Poll returns the changes that have not been handled yet, without removing
+// them from the slot, together with the position to give to Ack once the
+// changes have been handled. Changes to other tables are skipped.
+func (sub *Subscriber) Poll() ([]ChangeEvent, string, error) {
+	rows, err := sub.host.query("SELECT lsn::text, data FROM pg_logical_slot_peek_changes($1, NULL, $2, 'format-version', '2', 'include-transaction', 'false')", sub.slot, sub.batchSize)
+	if err != nil {
+		return []ChangeEvent{}, "", err
+	}
+	defer rows.Close()
+	var (
+		events    = []ChangeEvent{}
+		lsn       string
+		data      string
+		refreshed bool
+	)
+	for rows.Next() {
+		if err := rows.Scan(&lsn, &data); err != nil {
+			return events, "", err
+		}
+		var change wal2jsonChange
+		if err := json.Unmarshal([]byte(data), &change); err != nil {
+			return events, "", err
+		}
+		table, ok := sub.tables[change.Schema+"."+change.Table]
+		if !ok && !refreshed {
+			// The data structure may have been created after the subscriber
+			if err := sub.refresh(); err != nil {
+				return events, "", err
+			}
+			refreshed = true
+			table, ok = sub.tables[change.Schema+"."+change.Table]
+		}
+		if !ok {
+			continue
+		}
+		found, err := table.events(change)
+		if err != nil {
+			return events, "", err
+		}
+		events = append(events, found...)
+	}
+	return events, lsn, rows.Err()
+}
+
+// Ack removes all changes up to and including the given position from the slot
+func (sub *Subscriber) Ack(lsn string) error {
+	if lsn == "" {
+		return nil
+	}
+	_, err := sub.host.exec("SELECT pg_replication_slot_advance($1, $2::pg_lsn)", sub.slot, lsn)
+	return err
+}
+
+// Run calls fn for each change, polling every interval until ctx is cancelled.
+// Changes are only removed from the slot once fn has handled all the changes
+// of a batch, so if fn returns an error, the batch is delivered again by the
+// next call to Run.
+func (sub *Subscriber) Run(ctx context.Context, interval time.Duration, fn func(ChangeEvent) error) error {
+	for {
+		events, lsn, err := sub.Poll()
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if err := sub.Ack(lsn); err != nil {
+			return err
+		}
+		if lsn != "" && ctx.Err() == nil {
+			// There may be more changes
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Drop removes the replication slot
+func (sub *Subscriber) Drop() error {
+	_, err := sub.host.exec("SELECT pg_drop_replication_slot($1)", sub.slot)
+	return err
+}
+
+// events converts a change to a table of a data structure to change events
+func (t subscribedTable) events(change wal2jsonChange) ([]ChangeEvent, error) {
+	var op string
+	switch change.Action {
+	case "I":
+		op = "insert"
+	case "U":
+		op = "update"
+	case "D":
+		op = "delete"
+	default:
+		// Truncate and transaction boundaries are not converted
+		return nil, nil
+	}
+	decode := func(value string) string {
+		if decoded, err := t.codec.Decode(value); err == nil {
+			return decoded
+		}
+		return value
+	}
+	switch t.kind {
+	case "list", "set":
+		col := listCol
+		if t.kind == "set" {
+			col = setCol
+		}
+		oldValue, newValue := column(change.Identity, col), column(change.Columns, col)
+		if oldValue == newValue {
+			return nil, nil
+		}
+		return []ChangeEvent{{Op: op, Kind: t.kind, Name: t.name, Old: decode(oldValue), New: decode(newValue)}}, nil
+	case "keyvalue", "hashmap", "hashmap2":
+		oldAttr, err := parseHstore(column(change.Identity, "attr"))
+		if err != nil {
+			return nil, err
+		}
+		newAttr, err := parseHstore(column(change.Columns, "attr"))
+		if err != nil {
+			return nil, err
+		}
+		owner := column(change.Columns, ownerCol)
+		if owner == "" {
+			owner = column(change.Identity, ownerCol)
+		}
+		var events []ChangeEvent
+		for _, key := range changedKeys(oldAttr, newAttr) {
+			event := ChangeEvent{Op: "update", Kind: t.kind, Name: t.name, Key: key, Old: decode(oldAttr[key]), New: decode(newAttr[key])}
+			if _, had := oldAttr[key]; !had {
+				event.Op = "insert"
+			} else if _, has := newAttr[key]; !has {
+				event.Op = "delete"
+			}
+			switch t.kind {
+			case "hashmap":
+				event.Owner = owner
+			case "hashmap2":
+				if pos := strings.Index(key, fieldSep); pos != -1 {
+					event.Owner, event.Key = key[:pos], key[pos+len(fieldSep):]
+				}
+			}
+			events = append(events, event)
+		}
+		return events, nil
+	}
+	return nil, nil
+}
+
+// changedKeys returns the sorted keys that are added, changed or removed
+func changedKeys(oldAttr, newAttr map[string]string) []string {
+	var keys []string
+	for k, v := range oldAttr {
+		if nv, ok := newAttr[k]; !ok || nv != v {
+			keys = append(keys, k)
+		}
+	}
+	for k := range newAttr {
+		if _, ok := oldAttr[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseHstore parses the text representation of an HSTORE value,
+// like "a"=>"1", "b"=>NULL. NULL values are returned as empty strings.
+func parseHstore(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	i := 0
+	skipSpace := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+	}
+	quoted := func() (string, error) {
+		if i >= len(s) || s[i] != '"' {
+			return "", ErrInvalidHstore
+		}
+		i++
+		var sb strings.Builder
+		for i < len(s) {
+			switch s[i] {
+			case '\\':
+				if i+1 >= len(s) {
+					return "", ErrInvalidHstore
+				}
+				sb.WriteByte(s[i+1])
+				i += 2
+			case '"':
+				i++
+				return sb.String(), nil
+			default:
+				sb.WriteByte(s[i])
+				i++
+			}
+		}
+		return "", ErrInvalidHstore
+	}
+	for skipSpace(); i < len(s); skipSpace() {
+		key, err := quoted()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(s[i:], "=>") {
+			return nil, ErrInvalidHstore
+		}
+		i += 2
+		if strings.HasPrefix(s[i:], "NULL") {
+			i += 4
+			m[key] = ""
+			continue
+		}
+		value, err := quoted()
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
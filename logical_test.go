@@ -0,0 +1,49 @@
+package simplehstore
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseHstore(t *testing.T) {
+	m, err := parseHstore(`"a"=>"1", "b \"quoted\""=>"back\\slash", "c"=>NULL`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 3 || m["a"] != "1" || m[`b "quoted"`] != `back\slash` || m["c"] != "" {
+		t.Errorf("Error, unexpected result: %v", m)
+	}
+	if m, err := parseHstore(""); err != nil || len(m) != 0 {
+		t.Errorf("Error, expected an empty map, got %v (%v)", m, err)
+	}
+	if _, err := parseHstore(`"a"=`); err != ErrInvalidHstore {
+		t.Errorf("Error, expected ErrInvalidHstore, got %v", err)
+	}
+}
+
+func TestChangeEvents(t *testing.T) {
+	data := `{"action":"U","schema":"public","table":"a_kv_users_properties_HSTORE_map",
+		"columns":[{"name":"attr","type":"hstore","value":"\"bob¤email\"=>\"bob@example.org\", \"alice¤email\"=>\"alice@example.com\""}],
+		"identity":[{"name":"attr","type":"hstore","value":"\"bob¤email\"=>\"bob@example.com\", \"eve¤email\"=>\"eve@example.com\""}]}`
+	var change wal2jsonChange
+	if err := json.Unmarshal([]byte(data), &change); err != nil {
+		t.Fatal(err)
+	}
+	events, err := subscribedTable{"hashmap2", "users", RawCodec}.events(change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ChangeEvent{
+		{Op: "insert", Kind: "hashmap2", Name: "users", Owner: "alice", Key: "email", New: "alice@example.com"},
+		{Op: "update", Kind: "hashmap2", Name: "users", Owner: "bob", Key: "email", Old: "bob@example.com", New: "bob@example.org"},
+		{Op: "delete", Kind: "hashmap2", Name: "users", Owner: "eve", Key: "email", Old: "eve@example.com"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Error, expected %d events, got %v", len(expected), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Error, expected %v, got %v", expected[i], events[i])
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package simplehstore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// accessTable returns the quoted name of the table that keeps track of when
+// each key in this key/value was last accessed
+func (kv *KeyValue) accessTable() string {
+	return kv.qualify(pq.QuoteIdentifier(kvPrefix + kv.table + "_access"))
+}
+
+// accessTables are the access tables that are known to exist, by database and table
+var accessTables sync.Map
+
+// accessTableKey returns the key of the access table in accessTables
+func (kv *KeyValue) accessTableKey() string {
+	return kv.host.dbname + "." + kv.accessTable()
+}
+
+// createAccessTable creates the table for last access times, if it does not
+// already exist. The table is only created once per process, since Touch is
+// meant to be called after every Get.
+func (kv *KeyValue) createAccessTable() error {
+	if _, ok := accessTables.Load(kv.accessTableKey()); ok {
+		return nil
+	}
+	if _, err := kv.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, last_accessed TIMESTAMPTZ NOT NULL DEFAULT now())", kv.accessTable())); err != nil {
+		return err
+	}
+	accessTables.Store(kv.accessTableKey(), true)
+	return nil
+}
+
+// withAccessTable runs fn after creating the table for last access times, if
+// needed. If the table has been removed by another process in the meantime,
+// it is created again, and fn is run once more.
+func (kv *KeyValue) withAccessTable(fn func() error) error {
+	if err := kv.createAccessTable(); err != nil {
+		return err
+	}
+	err := fn()
+	if !errors.Is(err, ErrTableMissing) {
+		return err
+	}
+	accessTables.Delete(kv.accessTableKey())
+	if err := kv.createAccessTable(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// Touch marks the given key as accessed now, for use with EvictLRU and
+// EvictOlderThan. Together, these make it possible to use a KeyValue as a
+// disk-backed LRU cache, by calling Touch after each Get or Set.
+func (kv *KeyValue) Touch(key string) error {
+	return kv.withAccessTable(func() error {
+		_, err := kv.host.exec(fmt.Sprintf("INSERT INTO %s (key) VALUES ($1) ON CONFLICT (key) DO UPDATE SET last_accessed = now()", kv.accessTable()), key)
+		return err
+	})
+}
+
+// LastAccessed returns the time the given key was last touched, or the zero
+// time if the key has never been touched
+func (kv *KeyValue) LastAccessed(key string) (time.Time, error) {
+	var t time.Time
+	err := kv.withAccessTable(func() error {
+		err := kv.host.queryRow(fmt.Sprintf("SELECT last_accessed FROM %s WHERE key = $1", kv.accessTable()), key).Scan(&t)
+		if noResult(err) {
+			t = time.Time{}
+			return nil
+		}
+		return err
+	})
+	return t, err
+}
+
+// EvictLRU removes the n least recently accessed keys, and returns the
+// number of keys that were removed. Keys that have never been touched are
+// removed first.
+func (kv *KeyValue) EvictLRU(n int) (int, error) {
+	return kv.evict(fmt.Sprintf("SELECT k.key FROM (SELECT skeys(attr) AS key FROM %s) AS k LEFT JOIN %s AS a ON a.key = k.key ORDER BY a.last_accessed NULLS FIRST, k.key LIMIT %d", kv.tableName(), kv.accessTable(), n))
+}
+
+// EvictOlderThan removes all keys that were last accessed before the given
+// time, including keys that have never been touched, and returns the number
+// of keys that were removed.
+func (kv *KeyValue) EvictOlderThan(t time.Time) (int, error) {
+	return kv.evict(fmt.Sprintf("SELECT k.key FROM (SELECT skeys(attr) AS key FROM %s) AS k LEFT JOIN %s AS a ON a.key = k.key WHERE a.last_accessed IS NULL OR a.last_accessed < $1", kv.tableName(), kv.accessTable()), t)
+}
+
+// evict removes the keys that are returned by the given query, in one transaction
+func (kv *KeyValue) evict(query string, args ...interface{}) (int, error) {
+	var n int
+	err := kv.withAccessTable(func() error {
+		var err error
+		n, err = kv.evictKeys(query, args...)
+		return err
+	})
+	return n, err
+}
+
+// evictKeys removes the keys that are returned by the given query, in one transaction
+func (kv *KeyValue) evictKeys(query string, args ...interface{}) (int, error) {
+	ctx := kv.host.context()
+	transaction, err := kv.host.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := transaction.QueryContext(ctx, query, args...)
+	if err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	var (
+		keys []string
+		key  string
+	)
+	for rows.Next() {
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			transaction.Rollback()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, transaction.Commit()
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET attr = delete(attr, $1::text[]), expires = delete(expires, $1::text[])", kv.tableName()), pq.Array(keys)); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE key = ANY($1::text[])", kv.accessTable()), pq.Array(keys)); err != nil {
+		transaction.Rollback()
+		return 0, err
+	}
+	return len(keys), transaction.Commit()
+}
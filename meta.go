@@ -0,0 +1,145 @@
+package simplehstore
+
+import (
+	"fmt"
+	"sync"
+)
+
+// metaTable is where the codec that is used by each data structure is stored,
+// so that data written with one setting can be read back correctly, even if
+// the Host or the options have changed in the mean time.
+const metaTable = "simplehstore_meta"
+
+var (
+	codecMut sync.RWMutex
+	codecs   = map[string]Codec{
+		HexCodec.Name(): HexCodec,
+		RawCodec.Name(): RawCodec,
+	}
+)
+
+// RegisterCodec makes a custom codec available when opening data structures
+// that were written with it. HexCodec and RawCodec are always available.
+func RegisterCodec(codec Codec) {
+	codecMut.Lock()
+	codecs[codec.Name()] = codec
+	codecMut.Unlock()
+}
+
+// lookupCodec finds a registered codec, by name
+func lookupCodec(name string) (Codec, bool) {
+	codecMut.RLock()
+	codec, ok := codecs[name]
+	codecMut.RUnlock()
+	return codec, ok
+}
+
+// exists checks if the table for this data structure exists
+func (d *dbDatastructure) exists(quotedTable string) (bool, error) {
+	var found bool
+	err := d.host.queryRow("SELECT to_regclass($1) IS NOT NULL", quotedTable).Scan(&found)
+	return found, err
+}
+
+// addColumn adds a column to a table that was created by an earlier version,
+// if the column is missing. The column is looked up first, since ALTER TABLE
+// locks the table exclusively, even if the column already exists.
+func (d *dbDatastructure) addColumn(quotedTable, column, definition string) error {
+	var found bool
+	query := "SELECT EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = to_regclass($1) AND attname = $2 AND NOT attisdropped)"
+	if err := d.host.queryRow(query, quotedTable, column).Scan(&found); err != nil || found {
+		return err
+	}
+	_, err := d.host.exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", quotedTable, column, definition))
+	return err
+}
+
+// register stores a marker row with the codec for this data structure.
+// If the table was just created, any old marker is replaced. If not, the
+// stored codec is used from now on, even if a different codec is configured.
+func (d *dbDatastructure) register(kind, name, quotedTable string, created bool) error {
+	if err := d.createInternTable(); err != nil {
+		return err
+	}
+	if err := d.createDictTable(); err != nil {
+		return err
+	}
+	if d.temporary {
+		// Temporary tables are gone when the session ends, so there is nothing to mark
+		d.codec = d.getCodec()
+		return nil
+	}
+	if _, err := d.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (kind TEXT NOT NULL, name TEXT NOT NULL, tbl TEXT NOT NULL, codec TEXT NOT NULL, PRIMARY KEY (kind, tbl))", metaTable)); err != nil {
+		return err
+	}
+	codec := d.getCodec()
+	conflict := "DO NOTHING"
+	if created {
+		conflict = "DO UPDATE SET name = EXCLUDED.name, codec = EXCLUDED.codec"
+	}
+	if _, err := d.host.exec(fmt.Sprintf("INSERT INTO %s (kind, name, tbl, codec) VALUES ($1, $2, $3, $4) ON CONFLICT (kind, tbl) %s", metaTable, conflict), kind, name, quotedTable, codec.Name()); err != nil {
+		return err
+	}
+	var stored string
+	if err := d.host.queryRow(fmt.Sprintf("SELECT codec FROM %s WHERE kind = $1 AND tbl = $2", metaTable), kind, quotedTable).Scan(&stored); err != nil {
+		return err
+	}
+	return d.pinCodec(stored, quotedTable)
+}
+
+// load pins the codec that is stored in the marker row for this data
+// structure, without creating any tables, for data structures that have
+// already been created with one of the regular constructors. The configured
+// codec is used if there is no marker row.
+func (d *dbDatastructure) load(kind, quotedTable string) error {
+	if d.temporary {
+		d.codec = d.getCodec()
+		return nil
+	}
+	var stored string
+	if err := d.host.queryRow(fmt.Sprintf("SELECT codec FROM %s WHERE kind = $1 AND tbl = $2", metaTable), kind, quotedTable).Scan(&stored); err != nil {
+		if !noResult(err) {
+			return err
+		}
+		stored = d.getCodec().Name()
+	}
+	return d.pinCodec(stored, quotedTable)
+}
+
+// pinCodec pins the codec with the given name, which is the codec the data
+// structure was written with, so that changing the Host setting later has no effect
+func (d *dbDatastructure) pinCodec(stored, quotedTable string) error {
+	codec := d.getCodec()
+	if stored != codec.Name() {
+		storedCodec, ok := lookupCodec(stored)
+		if !ok {
+			return fmt.Errorf("%s was written with the unknown codec %q, use RegisterCodec", quotedTable, stored)
+		}
+		if d.strict && d.codec != nil {
+			return fmt.Errorf("%s was written with the %q codec, not %q", quotedTable, stored, codec.Name())
+		}
+		if d.verbose() {
+			d.host.Logger().Printf("Using the %q codec for %s, since it was written with it\n", stored, quotedTable)
+		}
+		codec = storedCodec
+	}
+	// Pin the codec, so that changing the Host setting later has no effect
+	d.codec = codec
+	return nil
+}
+
+// unregister removes the marker row for this data structure
+func (d *dbDatastructure) unregister(kind, quotedTable string) error {
+	if d.dictionary {
+		if _, err := d.host.exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", d.dictTable())); err != nil {
+			return err
+		}
+		dictionaries.Delete(d.host.dbname + "." + d.dictTable())
+	}
+	_, err := d.host.exec(fmt.Sprintf("DELETE FROM %s WHERE kind = $1 AND tbl = $2", metaTable), kind, quotedTable)
+	if err != nil && noResult(err) {
+		// The meta table does not exist, so there is nothing to unregister
+		return nil
+	}
+	return err
+}
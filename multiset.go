@@ -0,0 +1,187 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// MultiSet is a set of strings where each member has a count, stored in
+// PostgreSQL. It is useful for tag clouds and for tracking frequencies.
+type MultiSet struct {
+	dbDatastructure
+}
+
+// MemberCount is a member of a MultiSet, together with its count
+type MemberCount struct {
+	Member string
+	Count  int64
+}
+
+// NewMultiSet creates a new multiset
+func NewMultiSet(provider HostProvider, name string, opts ...Option) (*MultiSet, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	ms := &MultiSet{newDatastructure(host, pq.QuoteIdentifier(name), opts)}
+	if err := ms.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := ms.exists(ms.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("%s %s (%s %s PRIMARY KEY, count BIGINT NOT NULL)", ms.createTable(), ms.tableName(), setCol, defaultStringType)
+	if _, err := ms.host.exec(query); err != nil {
+		return nil, err
+	}
+	if err := ms.setStorageParameters(ms.tableName()); err != nil {
+		return nil, err
+	}
+	if err := ms.register("multiset", name, ms.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if ms.index {
+		if _, err := ms.host.exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (count)", pq.QuoteIdentifier(name+"_count_idx"), ms.tableName())); err != nil {
+			return nil, err
+		}
+	}
+	if ms.verbose() {
+		ms.host.Logger().Println("Created multiset table " + ms.tableName() + " in database " + host.dbname)
+	}
+	return ms, nil
+}
+
+// Add increments the count of the given member by one
+func (ms *MultiSet) Add(member string) error {
+	_, err := ms.AddN(member, 1)
+	return err
+}
+
+// AddN increments the count of the given member by n, and returns the new count
+func (ms *MultiSet) AddN(member string, n int64) (int64, error) {
+	if err := ms.encode(&member); err != nil {
+		return 0, err
+	}
+	var count int64
+	query := fmt.Sprintf("INSERT INTO %s AS m (%s, count) VALUES ($1, $2) ON CONFLICT (%s) DO UPDATE SET count = m.count + EXCLUDED.count RETURNING count", ms.tableName(), setCol, setCol)
+	if err := ms.host.queryRow(query, member, n).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Remove decrements the count of the given member by one. The member is
+// removed when the count reaches zero. Removing a member that is not in the
+// multiset is not an error.
+func (ms *MultiSet) Remove(member string) error {
+	_, err := ms.RemoveN(member, 1)
+	return err
+}
+
+// RemoveN decrements the count of the given member by n, and returns the new
+// count. The member is removed when the count reaches zero.
+func (ms *MultiSet) RemoveN(member string, n int64) (int64, error) {
+	if err := ms.encode(&member); err != nil {
+		return 0, err
+	}
+	var count int64
+	err := ms.host.queryRow(fmt.Sprintf("UPDATE %s SET count = count - $2 WHERE %s = $1 RETURNING count", ms.tableName(), setCol), member, n).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return count, nil
+	}
+	// The count may have been incremented in the meantime, so check it again when deleting
+	if _, err := ms.host.exec(fmt.Sprintf("DELETE FROM %s WHERE %s = $1 AND count <= 0", ms.tableName(), setCol), member); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Count returns the count of the given member, or 0 if it is not in the multiset
+func (ms *MultiSet) Count(member string) (int64, error) {
+	if err := ms.encode(&member); err != nil {
+		return 0, err
+	}
+	var count int64
+	err := ms.host.queryRow(fmt.Sprintf("SELECT count FROM %s WHERE %s = $1", ms.tableName(), setCol), member).Scan(&count)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return count, err
+}
+
+// Has checks if the given member is in the multiset
+func (ms *MultiSet) Has(member string) (bool, error) {
+	count, err := ms.Count(member)
+	return count > 0, err
+}
+
+// Members returns the number of distinct members
+func (ms *MultiSet) Members() (int64, error) {
+	var count int64
+	err := ms.host.queryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", ms.tableName())).Scan(&count)
+	return count, err
+}
+
+// TopN returns the n members with the highest counts, highest count first.
+// Members with the same count are sorted by member.
+func (ms *MultiSet) TopN(n int) ([]MemberCount, error) {
+	query := fmt.Sprintf("SELECT %s, count FROM %s ORDER BY count DESC, %s LIMIT $1", setCol, ms.tableName(), setCol)
+	rows, err := ms.host.query(query, n)
+	if err != nil {
+		return []MemberCount{}, err
+	}
+	top := []MemberCount{}
+	var members []string
+	for rows.Next() {
+		var mc MemberCount
+		if err := rows.Scan(&mc.Member, &mc.Count); err != nil {
+			rows.Close()
+			return top, err
+		}
+		top = append(top, mc)
+		members = append(members, mc.Member)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return top, err
+	}
+	if err := ms.decodeAll(members); err != nil {
+		return top, err
+	}
+	for i := range top {
+		top[i].Member = members[i]
+	}
+	return top, nil
+}
+
+// Del removes the given member, regardless of its count
+func (ms *MultiSet) Del(member string) error {
+	if err := ms.encode(&member); err != nil {
+		return err
+	}
+	_, err := ms.host.exec(fmt.Sprintf("DELETE FROM %s WHERE %s = $1", ms.tableName(), setCol), member)
+	return err
+}
+
+// RemoveTable removes the multiset table. This is not called Remove, like
+// for the other data structures, since Remove decrements the count of a member.
+func (ms *MultiSet) RemoveTable() error {
+	if _, err := ms.host.exec(fmt.Sprintf("DROP TABLE %s", ms.tableName())); err != nil {
+		return err
+	}
+	return ms.unregister("multiset", ms.tableName())
+}
+
+// Clear all members
+func (ms *MultiSet) Clear() error {
+	_, err := ms.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", ms.tableName()))
+	return err
+}
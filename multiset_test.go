@@ -0,0 +1,47 @@
+package simplehstore
+
+import (
+	"testing"
+)
+
+func TestMultiSet(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	ms, err := NewMultiSet(host, "multiset_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.Clear()
+	for _, tag := range []string{"go", "sql", "go", "hstore", "go", "sql"} {
+		if err := ms.Add(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := ms.Count("go"); err != nil || n != 3 {
+		t.Errorf("Error, expected go to be counted 3 times, got %d (%v)", n, err)
+	}
+	top, err := ms.TopN(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0] != (MemberCount{"go", 3}) || top[1] != (MemberCount{"sql", 2}) {
+		t.Errorf("Error, unexpected top members: %v", top)
+	}
+
+	// The member is removed when the count reaches zero
+	if err := ms.Remove("hstore"); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := ms.Has("hstore"); err != nil || has {
+		t.Errorf("Error, expected hstore to be removed (%v)", err)
+	}
+	if err := ms.Remove("hstore"); err != nil {
+		t.Errorf("Error, removing a missing member should not fail: %v", err)
+	}
+	if n, err := ms.Members(); err != nil || n != 2 {
+		t.Errorf("Error, expected 2 distinct members, got %d (%v)", n, err)
+	}
+
+	ms.RemoveTable()
+}
@@ -0,0 +1,76 @@
+package simplehstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	minReconnectInterval = 10 * time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// ErrNoConnectionString is returned when listening for notifications on a
+// Host that was not created from a connection string
+var ErrNoConnectionString = errors.New("the host has no connection string to listen with")
+
+// notify sends a notification with the given payload on the given channel.
+// If the host is bound to a transaction, the notification is delivered when it commits.
+func (host *Host) notify(channel, payload string) error {
+	_, err := host.exec("SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// listen calls fn with the payload of each notification on the given channel,
+// until ctx is cancelled. Notifications may be lost while the dedicated
+// connection is being re-established, so fn is called with an empty payload
+// after each reconnect.
+func (host *Host) listen(ctx context.Context, channel string, fn func(payload string)) error {
+	listener, err := host.newListener(channel)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	serve(ctx, listener, fn)
+	return nil
+}
+
+// newListener returns a dedicated connection that listens on the given channel
+func (host *Host) newListener(channel string) (*pq.Listener, error) {
+	if host.dsn == "" {
+		return nil, ErrNoConnectionString
+	}
+	listener := pq.NewListener(host.dsn, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		if err != nil && Verbose {
+			host.Logger().Println("Listener: " + err.Error())
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serve calls fn with the payload of each notification from the listener,
+// until ctx is cancelled, see listen
+func serve(ctx context.Context, listener *pq.Listener, fn func(payload string)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			if n == nil {
+				// The connection was re-established
+				fn("")
+				continue
+			}
+			fn(n.Extra)
+		case <-time.After(maxReconnectInterval):
+			go listener.Ping()
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package simplehstore
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Option can be given to the data structure constructors, for configuring
+// a single data structure without changing the Host or any package globals.
+type Option func(*options)
+
+// options are the per data structure settings
+type options struct {
+	codec   Codec  // the codec for values, or nil for using the Host setting
+	schema  string // the schema for the table, or "" for the default search path
+	index   bool   // create indexes for faster lookups
+	strict  bool   // return encoding and decoding errors instead of ignoring them
+	verbose bool   // log queries, even if Verbose is false
+
+	unlogged  bool // create the table without writing to the WAL
+	temporary bool // create the table for the current session only
+
+	storage [][2]string // storage parameters, as names and values
+
+	queryTags bool // tag queries with the operation that runs them
+
+	conflict ConflictPolicy // what Set does when a key already exists
+
+	ttl time.Duration // the default expiry of keys and elements, or 0
+
+	internMin  int  // store values of at least this length once, in a shared table, or 0
+	dictionary bool // store codes from a dictionary table instead of the values
+
+	ownerLocking bool // serialize the writes to each owner with an advisory lock
+
+	insertionOrder bool // keep track of the order elements and keys are added in
+
+	columnLayout bool // store the properties of a HashMap2 in separate columns
+
+	ownersOf *HashMap2 // remove the members of a Set that are removed as owners of this hash map
+
+	renewInterval time.Duration // how often an Election renews leadership, or 0 for the default
+
+	partitionInterval time.Duration // partition lists by the time elements are added, or 0
+	hashPartitions    int           // partition lists by hash into this many partitions, or 0
+}
+
+// WithCodec selects the codec that is used for encoding and decoding values
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithTTL makes KeyValue.Set and Set.Add expire the keys and elements they
+// write after the given duration, like SetExpiring and AddExpiring do.
+// Expired keys and elements are not returned, and are removed by ExpireKeys,
+// RunExpiry or ExpireMembers.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithSchema places the table in the given PostgreSQL schema, which is created if needed
+func WithSchema(schema string) Option {
+	return func(o *options) {
+		o.schema = schema
+	}
+}
+
+// WithIndex creates indexes that speeds up lookups, at the cost of slower writes
+func WithIndex() Option {
+	return func(o *options) {
+		o.index = true
+	}
+}
+
+// WithStrict makes operations return an error if a value can not be encoded
+// or decoded, instead of ignoring the problem
+func WithStrict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithVerbose logs the queries for this data structure, even if Verbose is false
+func WithVerbose() Option {
+	return func(o *options) {
+		o.verbose = true
+	}
+}
+
+// WithUnlogged creates the table as an UNLOGGED table, which makes writes much
+// faster, since they are not written to the write-ahead log. The contents of an
+// unlogged table are lost if the server crashes, and are not replicated, so
+// this is for cache-like data only. The option has no effect for tables that
+// already exist.
+func WithUnlogged() Option {
+	return func(o *options) {
+		o.unlogged = true
+	}
+}
+
+// WithTemporary creates the table as a TEMPORARY table, which is only visible
+// to the current session, and which is dropped when the session ends.
+// Since the Host uses a pool of connections, this is only useful when the Host
+// is bound to a single connection, for instance within a transaction.
+// Temporary tables are never placed in the schema given by WithSchema, and no
+// marker row is written for them.
+func WithTemporary() Option {
+	return func(o *options) {
+		o.temporary = true
+	}
+}
+
+// WithStorageParameter sets a storage parameter for the table, such as
+// "autovacuum_vacuum_threshold". The parameter is also set for tables that
+// already exist. See the PostgreSQL documentation for CREATE TABLE for the
+// available parameters.
+func WithStorageParameter(name, value string) Option {
+	return func(o *options) {
+		o.storage = append(o.storage, [2]string{name, value})
+	}
+}
+
+// WithFillFactor sets the fillfactor storage parameter, in percent. A fillfactor
+// below 100 leaves room in each page for updated rows, which reduces bloat for
+// tables that are updated often, like counters and sessions.
+func WithFillFactor(percent int) Option {
+	return WithStorageParameter("fillfactor", strconv.Itoa(percent))
+}
+
+// WithAutovacuumScaleFactor sets the fraction of the table that must be updated
+// or deleted before the table is vacuumed. The PostgreSQL default is 0.2, which
+// is too high for large tables that are updated often.
+func WithAutovacuumScaleFactor(scaleFactor float64) Option {
+	return WithStorageParameter("autovacuum_vacuum_scale_factor", strconv.FormatFloat(scaleFactor, 'f', -1, 64))
+}
+
+// WithTimePartitions creates a new List as a table that is partitioned by the
+// time each element is added, with one partition per interval. See
+// List.EnsurePartitions and List.DropPartitionsOlderThan.
+// The option has no effect for lists that already exist.
+func WithTimePartitions(interval time.Duration) Option {
+	return func(o *options) {
+		o.partitionInterval = interval
+	}
+}
+
+// WithHashPartitions creates a new List as a table that is partitioned by hash
+// into the given number of partitions, which spreads very large lists over
+// several smaller tables. The option has no effect for lists that already exist.
+func WithHashPartitions(n int) Option {
+	return func(o *options) {
+		o.hashPartitions = n
+	}
+}
+
+// WithRenewInterval sets how often an Election renews the leadership, and how
+// often other candidates try to acquire it. The default is DefaultRenewInterval.
+func WithRenewInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.renewInterval = interval
+	}
+}
+
+// WithQueryTags prefixes each query with a comment that names the operation
+// that runs it, like /* simplehstore:hashmap2.set */, which makes it possible
+// to tell the load from each operation apart in pg_stat_statements, pg_stat_activity
+// and the PostgreSQL log.
+func WithQueryTags() Option {
+	return func(o *options) {
+		o.queryTags = true
+	}
+}
+
+// ConflictPolicy decides what Set does when the key already exists
+type ConflictPolicy int
+
+const (
+	// ConflictOverwrite replaces the existing value. This is the default.
+	ConflictOverwrite ConflictPolicy = iota
+	// ConflictError leaves the existing value as it is, and returns ErrExists
+	ConflictError
+	// ConflictKeepFirst leaves the existing value as it is, without returning an error
+	ConflictKeepFirst
+)
+
+// ErrExists is returned by Set when the key already exists and the
+// ConflictError policy is used
+var ErrExists = errors.New("the key already exists")
+
+// WithConflictPolicy decides what Set does for KeyValue, HashMap and HashMap2
+// when the key already exists. Keeping the first value is useful for values
+// that should only be written once, like registration timestamps and
+// confirmation codes.
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(o *options) {
+		o.conflict = policy
+	}
+}
+
+// conflictError returns what Set should return when the key already exists,
+// and the conflict policy is not ConflictOverwrite
+func (d *dbDatastructure) conflictError() error {
+	if d.conflict == ConflictError {
+		return ErrExists
+	}
+	return nil
+}
+
+// newDatastructure applies the given options and returns a dbDatastructure
+func newDatastructure(host *Host, table string, opts []Option) dbDatastructure {
+	d := dbDatastructure{host: host, table: table}
+	for _, opt := range opts {
+		opt(&d.options)
+	}
+	if d.queryTags && !host.queryTags {
+		// Use a copy of the host, so that only this data structure is affected
+		tagged := *host
+		tagged.queryTags = true
+		d.host = &tagged
+	}
+	return d
+}
+
+// createSchema creates the schema for this data structure, if a schema is configured
+func (d *dbDatastructure) createSchema() error {
+	if d.schema == "" || d.temporary {
+		return nil
+	}
+	_, err := d.host.exec(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s", pq.QuoteIdentifier(d.schema)))
+	return err
+}
+
+// qualify prefixes the given quoted table name with the schema, if a schema is configured
+func (d *dbDatastructure) qualify(quotedTable string) string {
+	if d.schema == "" || d.temporary {
+		return quotedTable
+	}
+	return pq.QuoteIdentifier(d.schema) + "." + quotedTable
+}
+
+// createTable returns the start of a CREATE TABLE statement, with the table kind from the options
+func (d *dbDatastructure) createTable() string {
+	switch {
+	case d.temporary:
+		return "CREATE TEMPORARY TABLE IF NOT EXISTS"
+	case d.unlogged:
+		return "CREATE UNLOGGED TABLE IF NOT EXISTS"
+	}
+	return "CREATE TABLE IF NOT EXISTS"
+}
+
+// setStorageParameters sets the storage parameters from the options, if any
+func (d *dbDatastructure) setStorageParameters(quotedTable string) error {
+	if len(d.storage) == 0 {
+		return nil
+	}
+	params := make([]string, len(d.storage))
+	for i, param := range d.storage {
+		for _, r := range param[0] {
+			if !(r >= 'a' && r <= 'z' || r == '_' || r == '.') {
+				return fmt.Errorf("invalid storage parameter: %q", param[0])
+			}
+		}
+		params[i] = param[0] + " = " + pq.QuoteLiteral(param[1])
+	}
+	_, err := d.host.exec(fmt.Sprintf("ALTER TABLE %s SET (%s)", quotedTable, strings.Join(params, ", ")))
+	return err
+}
+
+// tableName returns the quoted and possibly schema qualified table name
+func (d *dbDatastructure) tableName() string {
+	return d.qualify(d.table)
+}
+
+// verbose checks if queries should be logged for this data structure
+func (d *dbDatastructure) verbose() bool {
+	return Verbose || d.options.verbose
+}
+
+// getCodec returns the codec for this data structure
+func (d *dbDatastructure) getCodec() Codec {
+	if d.codec != nil {
+		return d.codec
+	}
+	if d.host.rawUTF8 {
+		return RawCodec
+	}
+	return HexCodec
+}
+
+// encode a value with the codec for this data structure.
+// Errors are only returned in strict mode.
+func (d *dbDatastructure) encode(value *string) error {
+	encoded, err := d.getCodec().Encode(*value)
+	if err != nil {
+		if d.verbose() {
+			d.host.Logger().Println("could not encode value:", err)
+		}
+		if d.strict {
+			return &markedError{ErrEncoding, err}
+		}
+		return nil
+	}
+	if err := d.dictEncode(&encoded); err != nil {
+		return err
+	}
+	if err := d.intern(&encoded); err != nil {
+		return err
+	}
+	*value = encoded
+	return nil
+}
+
+// decode a value with the codec for this data structure.
+// Errors are only returned in strict mode. Decoding may run queries, so this
+// must not be called while rows are being read, see decodeAll.
+func (d *dbDatastructure) decode(value *string) error {
+	if err := d.unintern(value); err != nil {
+		return err
+	}
+	return d.decodeUninterned(value)
+}
+
+// decodeAll decodes the given values in place, looking up the interned values
+// among them with a single query. This is meant for values that have been read
+// from rows that are closed, since a connection can not run a new query while
+// it is still sending the rows of another one.
+func (d *dbDatastructure) decodeAll(values []string) error {
+	if err := d.uninternAll(values); err != nil {
+		return err
+	}
+	for i := range values {
+		if err := d.decodeUninterned(&values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodePairs decodes the values of the given key and value pairs in place, see decodeAll
+func (d *dbDatastructure) decodePairs(pairs [][2]string) error {
+	values := make([]string, len(pairs))
+	for i, pair := range pairs {
+		values[i] = pair[1]
+	}
+	if err := d.decodeAll(values); err != nil {
+		return err
+	}
+	for i := range pairs {
+		pairs[i][1] = values[i]
+	}
+	return nil
+}
+
+// decodeUninterned decodes a value that is not a reference to an interned value
+func (d *dbDatastructure) decodeUninterned(value *string) error {
+	if err := d.dictDecode(value); err != nil {
+		return err
+	}
+	decoded, err := d.getCodec().Decode(*value)
+	if err != nil {
+		if d.verbose() {
+			d.host.Logger().Println("could not decode value:", err)
+		}
+		if d.strict {
+			return &markedError{ErrEncoding, err}
+		}
+		return nil
+	}
+	*value = decoded
+	return nil
+}
@@ -0,0 +1,117 @@
+package simplehstore
+
+import (
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	host := &Host{}
+	d := newDatastructure(host, `"people"`, []Option{WithSchema("app"), WithIndex(), WithStrict()})
+	if !d.index || !d.strict {
+		t.Error("Error, the options were not applied")
+	}
+	if d.tableName() != `"app"."people"` {
+		t.Errorf("Error, expected a schema qualified table name, got %s", d.tableName())
+	}
+	if d.getCodec() != HexCodec {
+		t.Error("Error, expected the hex codec to be the default")
+	}
+	host.rawUTF8 = true
+	if d.getCodec() != RawCodec {
+		t.Error("Error, expected the raw codec when the host uses raw UTF-8")
+	}
+	d = newDatastructure(host, `"people"`, []Option{WithCodec(HexCodec)})
+	if d.getCodec() != HexCodec {
+		t.Error("Error, expected the codec option to override the host setting")
+	}
+}
+
+func TestTableKinds(t *testing.T) {
+	host := &Host{}
+	if d := newDatastructure(host, `"cache"`, nil); d.createTable() != "CREATE TABLE IF NOT EXISTS" {
+		t.Errorf("Error, unexpected statement: %s", d.createTable())
+	}
+	if d := newDatastructure(host, `"cache"`, []Option{WithUnlogged()}); d.createTable() != "CREATE UNLOGGED TABLE IF NOT EXISTS" {
+		t.Errorf("Error, unexpected statement: %s", d.createTable())
+	}
+	d := newDatastructure(host, `"cache"`, []Option{WithTemporary(), WithSchema("app")})
+	if d.createTable() != "CREATE TEMPORARY TABLE IF NOT EXISTS" {
+		t.Errorf("Error, unexpected statement: %s", d.createTable())
+	}
+	if d.tableName() != `"cache"` {
+		t.Errorf("Error, temporary tables should not be schema qualified, got %s", d.tableName())
+	}
+}
+
+func TestStorageParameters(t *testing.T) {
+	d := newDatastructure(&Host{}, `"counters"`, []Option{WithFillFactor(70), WithAutovacuumScaleFactor(0.01)})
+	if len(d.storage) != 2 || d.storage[0] != [2]string{"fillfactor", "70"} || d.storage[1] != [2]string{"autovacuum_vacuum_scale_factor", "0.01"} {
+		t.Errorf("Error, unexpected storage parameters: %v", d.storage)
+	}
+	d = newDatastructure(&Host{}, `"counters"`, []Option{WithStorageParameter("fillfactor = 10); DROP TABLE x; --", "1")})
+	if err := d.setStorageParameters(d.tableName()); err == nil {
+		t.Error("Error, expected an invalid storage parameter to be rejected")
+	}
+}
+
+func TestStrictDecode(t *testing.T) {
+	host := &Host{}
+	value := "not hex"
+	lenient := newDatastructure(host, "t", nil)
+	if err := lenient.decode(&value); err != nil || value != "not hex" {
+		t.Errorf("Error, expected the value to be left as it is, got %s (%v)", value, err)
+	}
+	strict := newDatastructure(host, "t", []Option{WithStrict()})
+	if err := strict.decode(&value); err == nil {
+		t.Error("Error, expected an error when decoding in strict mode")
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "conflict_test_kv", WithConflictPolicy(ConflictError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	kv.Set("registered", "first")
+	kv.Set("other", "x")
+	if err := kv.Set("registered", "second"); err != ErrExists {
+		t.Errorf("Error, expected ErrExists, got %v", err)
+	}
+	if v, err := kv.Get("registered"); err != nil || v != "first" {
+		t.Errorf("Error, expected the first value to be kept, got %s (%v)", v, err)
+	}
+
+	h, err := NewHashMap(host, "conflict_test_hashmap", WithConflictPolicy(ConflictKeepFirst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Clear()
+	h.Set("bob", "code", "first")
+	if err := h.Set("bob", "code", "second"); err != nil {
+		t.Errorf("Error, expected no error when keeping the first value, got %v", err)
+	}
+	if v, err := h.Get("bob", "code"); err != nil || v != "first" {
+		t.Errorf("Error, expected the first value to be kept, got %s (%v)", v, err)
+	}
+
+	hm2, err := NewHashMap2(host, "conflict_test_hashmap2", WithConflictPolicy(ConflictError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm2.Clear()
+	hm2.Set("bob", "code", "first")
+	if err := hm2.SetMap("bob", map[string]string{"code": "second", "email": "bob@example.com"}); err != ErrExists {
+		t.Errorf("Error, expected ErrExists, got %v", err)
+	}
+	if has, err := hm2.Has("bob", "email"); err != nil || has {
+		t.Errorf("Error, expected no keys to be set when one of them exists (%v)", err)
+	}
+
+	kv.Remove()
+	h.Remove()
+	hm2.Remove()
+}
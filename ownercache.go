@@ -0,0 +1,247 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultOwnerCacheSize is the maximum number of owners an OwnerCache keeps, by default
+const DefaultOwnerCacheSize = 10000
+
+// OwnerCache is an in-memory cache of the properties of the owners of a
+// HashMap2, for lookups that are repeated often, like permission checks.
+// The properties of an owner are read with a single query, and are cached
+// for the given TTL. Owners that have no properties can also be cached, for
+// a separate and typically shorter TTL, so that repeated lookups of owners
+// that do not exist, which are common during scanning attacks, do not reach
+// the database every time.
+//
+// Changes that are made through the cache invalidate the cached owner.
+// Changes that are made in other ways are seen when the TTL has passed, or
+// when Invalidate is called.
+type OwnerCache struct {
+	hm2     *HashMap2
+	ttl     time.Duration
+	missTTL time.Duration
+	size    int
+
+	mut         sync.RWMutex
+	owners      map[string]cachedOwner
+	invalidated uint64 // counts the invalidations, so that loads that overlap one are not stored
+}
+
+// cachedOwner is the properties of an owner, and when they were read
+type cachedOwner struct {
+	props    map[string]string // nil if the owner has no properties
+	loadedAt time.Time
+}
+
+// NewOwnerCache creates a cache of the properties of the owners of hm2.
+// The properties are cached for ttl, and owners without properties are
+// cached for missTTL. A missTTL of 0 disables caching of owners without
+// properties.
+func NewOwnerCache(hm2 *HashMap2, ttl, missTTL time.Duration) *OwnerCache {
+	return &OwnerCache{hm2: hm2, ttl: ttl, missTTL: missTTL, size: DefaultOwnerCacheSize, owners: make(map[string]cachedOwner)}
+}
+
+// cached returns the cached properties of the owner, and true, or false if
+// the owner is not cached or the cached properties are too old
+func (c *OwnerCache) cached(owner string) (map[string]string, bool) {
+	c.mut.RLock()
+	entry, ok := c.owners[owner]
+	c.mut.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	ttl := c.ttl
+	if entry.props == nil {
+		ttl = c.missTTL
+	}
+	return entry.props, time.Since(entry.loadedAt) < ttl
+}
+
+// store caches the properties of the owner, which are nil if it has none,
+// unless the cache has been invalidated since they were read
+func (c *OwnerCache) store(owner string, props map[string]string, loadedAt time.Time, invalidated uint64) {
+	if props == nil && c.missTTL <= 0 {
+		return
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.invalidated != invalidated {
+		return
+	}
+	if _, ok := c.owners[owner]; !ok && len(c.owners) >= c.size {
+		c.prune()
+		if len(c.owners) >= c.size {
+			// Keep the owners that are already cached
+			return
+		}
+	}
+	c.owners[owner] = cachedOwner{props, loadedAt}
+}
+
+// prune removes the owners whose cached properties are too old.
+// The mutex must be held.
+func (c *OwnerCache) prune() {
+	for owner, entry := range c.owners {
+		ttl := c.ttl
+		if entry.props == nil {
+			ttl = c.missTTL
+		}
+		if time.Since(entry.loadedAt) >= ttl {
+			delete(c.owners, owner)
+		}
+	}
+}
+
+// props returns the properties of the owner, from the cache if they are fresh,
+// or nil if the owner has no properties
+func (c *OwnerCache) props(owner string) (map[string]string, error) {
+	if props, ok := c.cached(owner); ok {
+		return props, nil
+	}
+	c.mut.RLock()
+	invalidated := c.invalidated
+	c.mut.RUnlock()
+	loadedAt := time.Now()
+	all, err := c.hm2.getAllOf([]string{owner})
+	if err != nil {
+		return nil, err
+	}
+	props := all[owner]
+	c.store(owner, props, loadedAt, invalidated)
+	return props, nil
+}
+
+// Preload reads the properties of the given owners into the cache, with a
+// single query that uses ctx, for instance at startup, so that the first
+// lookups of the most used owners do not have to wait for the database.
+// Owners without properties are cached as misses, if missTTL is not 0.
+// If the deadline of ctx is exceeded, nothing is cached, and the error is returned.
+func (c *OwnerCache) Preload(ctx context.Context, owners []string) error {
+	if len(owners) == 0 {
+		return nil
+	}
+	c.mut.RLock()
+	invalidated := c.invalidated
+	c.mut.RUnlock()
+	loadedAt := time.Now()
+	all, err := c.hm2.WithContext(ctx).getAllOf(owners)
+	if err != nil {
+		return err
+	}
+	for _, owner := range owners {
+		c.store(owner, all[owner], loadedAt, invalidated)
+	}
+	return nil
+}
+
+// Get returns the value of the given property of the owner
+func (c *OwnerCache) Get(owner, key string) (string, error) {
+	props, err := c.props(owner)
+	if err != nil {
+		return "", err
+	}
+	value, ok := props[key]
+	if !ok {
+		return "", mark(ErrNotFound, "key does not exist: %s", key)
+	}
+	return value, nil
+}
+
+// GetAll returns all properties of the owner. The returned map may be modified.
+func (c *OwnerCache) GetAll(owner string) (map[string]string, error) {
+	props, err := c.props(owner)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(props))
+	for k, v := range props {
+		m[k] = v
+	}
+	return m, nil
+}
+
+// Exists checks if the owner has any properties
+func (c *OwnerCache) Exists(owner string) (bool, error) {
+	props, err := c.props(owner)
+	return props != nil, err
+}
+
+// Set sets a property of the owner in the hash map, and invalidates the cached owner
+func (c *OwnerCache) Set(owner, key, value string) error {
+	defer c.Invalidate(owner)
+	return c.hm2.Set(owner, key, value)
+}
+
+// Del removes the owner from the hash map, and invalidates the cached owner
+func (c *OwnerCache) Del(owner string) error {
+	defer c.Invalidate(owner)
+	return c.hm2.Del(owner)
+}
+
+// Invalidate removes the owner from the cache, so that its properties are
+// read from the database the next time
+func (c *OwnerCache) Invalidate(owner string) {
+	c.mut.Lock()
+	delete(c.owners, owner)
+	c.invalidated++
+	c.mut.Unlock()
+}
+
+// Clear removes all owners from the cache
+func (c *OwnerCache) Clear() {
+	c.mut.Lock()
+	c.owners = make(map[string]cachedOwner)
+	c.invalidated++
+	c.mut.Unlock()
+}
+
+// getAllOf returns the properties of the given owners, with a single query.
+// Owners without properties are not in the returned map.
+func (hm2 *HashMap2) getAllOf(owners []string) (map[string]map[string]string, error) {
+	kv := hm2.keyValue()
+	query := fmt.Sprintf("SELECT split_part(e.key, '%s', 1), split_part(e.key, '%s', 2), e.value FROM %s AS t, each(t.attr) AS e WHERE split_part(e.key, '%s', 1) = ANY($1)", fieldSep, fieldSep, kv.tableName(), fieldSep)
+	if hm2.columnLayout {
+		query = fmt.Sprintf("SELECT owner, prop, value FROM %s WHERE owner = ANY($1)", hm2.columnsTable())
+	}
+	rows, err := kv.host.query(query, pq.Array(owners))
+	if err != nil {
+		return nil, err
+	}
+	var owned, keys, values []string
+	for rows.Next() {
+		var (
+			owner, key string
+			value      sql.NullString
+		)
+		if err := rows.Scan(&owner, &key, &value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		owned = append(owned, owner)
+		keys = append(keys, key)
+		values = append(values, value.String)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := kv.decodeAll(values); err != nil {
+		return nil, err
+	}
+	all := make(map[string]map[string]string)
+	for i, owner := range owned {
+		if all[owner] == nil {
+			all[owner] = make(map[string]string)
+		}
+		all[owner][keys[i]] = values[i]
+	}
+	return all, nil
+}
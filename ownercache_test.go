@@ -0,0 +1,70 @@
+package simplehstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOwnerCache(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "ownercache_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.Set("bob", "role", "admin")
+
+	cache := NewOwnerCache(users, time.Minute, time.Minute)
+	if role, err := cache.Get("bob", "role"); err != nil || role != "admin" {
+		t.Errorf("Error, expected admin, got %s (%v)", role, err)
+	}
+	if _, err := cache.Get("mallory", "role"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Error, expected ErrNotFound, got %v", err)
+	}
+
+	// The miss is cached, until the owner is invalidated
+	users.Set("mallory", "role", "user")
+	if found, err := cache.Exists("mallory"); err != nil || found {
+		t.Errorf("Error, expected the miss to be cached (%v)", err)
+	}
+	cache.Invalidate("mallory")
+	if role, err := cache.Get("mallory", "role"); err != nil || role != "user" {
+		t.Errorf("Error, expected user, got %s (%v)", role, err)
+	}
+
+	// Writes through the cache are seen right away
+	if err := cache.Set("bob", "role", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if role, err := cache.Get("bob", "role"); err != nil || role != "user" {
+		t.Errorf("Error, expected user, got %s (%v)", role, err)
+	}
+
+	// Misses are not cached when missTTL is 0
+	uncached := NewOwnerCache(users, time.Minute, 0)
+	uncached.Exists("carol")
+	users.Set("carol", "role", "user")
+	if found, err := uncached.Exists("carol"); err != nil || !found {
+		t.Errorf("Error, expected carol to be found (%v)", err)
+	}
+
+	// Preloaded owners are served from the cache
+	preloaded := NewOwnerCache(users, time.Minute, time.Minute)
+	if err := preloaded.Preload(context.Background(), []string{"bob", "mallory", "dave"}); err != nil {
+		t.Fatal(err)
+	}
+	users.Set("dave", "role", "user")
+	users.Del("bob")
+	if role, err := preloaded.Get("bob", "role"); err != nil || role != "user" {
+		t.Errorf("Error, expected the preloaded role of bob, got %s (%v)", role, err)
+	}
+	if found, _ := preloaded.Exists("dave"); found {
+		t.Error("Error, expected dave to be preloaded as a miss")
+	}
+
+	users.Remove()
+}
@@ -0,0 +1,141 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ExpireOwner makes the given owner expire after the given duration, for
+// temporary accounts, invitations or pending signups. Expired owners are
+// removed by ExpireOwners, which should be called regularly. Removing the
+// owner with Del or DelAll also removes the expiry.
+func (hm2 *HashMap2) ExpireOwner(owner string, ttl time.Duration) error {
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s SET owner_expires = owner_expires || hstore($1, (now() + $2 * interval '1 microsecond')::text)", hm2.keyValue().tableName())
+	_, err := hm2.host.exec(query, owner, ttl.Microseconds())
+	return err
+}
+
+// OwnerTTL returns the time until the given owner expires, and true, or false
+// if the owner does not expire. Owners that have expired return 0 and true.
+func (hm2 *HashMap2) OwnerTTL(owner string) (time.Duration, bool, error) {
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return 0, false, err
+	}
+	var seconds sql.NullFloat64
+	query := fmt.Sprintf("SELECT GREATEST(extract(epoch FROM (owner_expires -> $1)::timestamptz - now()), 0) FROM %s", hm2.keyValue().tableName())
+	if err := hm2.host.queryRow(query, owner).Scan(&seconds); err != nil {
+		if noResult(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if !seconds.Valid {
+		return 0, false, nil
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second)), true, nil
+}
+
+// PersistOwner removes the expiry of the given owner
+func (hm2 *HashMap2) PersistOwner(owner string) error {
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return err
+	}
+	_, err := hm2.host.exec(fmt.Sprintf("UPDATE %s SET owner_expires = delete(owner_expires, $1)", hm2.keyValue().tableName()), owner)
+	return err
+}
+
+// ExpireOwners removes all keys of all owners that have expired, and the
+// encountered properties that are no longer used by any owner, in one
+// transaction. The expired owners are returned.
+func (hm2 *HashMap2) ExpireOwners() ([]string, error) {
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return []string{}, err
+	}
+	ctx := hm2.host.context()
+	transaction, err := hm2.host.begin(ctx)
+	if err != nil {
+		return []string{}, err
+	}
+	txHM2 := *hm2
+	txHM2.host = hm2.host.withTx(transaction)
+	kv := txHM2.keyValue()
+
+	rows, err := transaction.QueryContext(ctx, fmt.Sprintf("SELECT e.key FROM %s AS t, each(t.owner_expires) AS e WHERE e.value::timestamptz <= now() ORDER BY e.key FOR UPDATE OF t", kv.tableName()))
+	if err != nil {
+		transaction.Rollback()
+		return []string{}, err
+	}
+	owners := []string{}
+	for rows.Next() {
+		var owner string
+		if err := rows.Scan(&owner); err != nil {
+			rows.Close()
+			transaction.Rollback()
+			return []string{}, err
+		}
+		owners = append(owners, owner)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		transaction.Rollback()
+		return []string{}, err
+	}
+	if len(owners) == 0 {
+		return owners, transaction.Commit()
+	}
+	if err := txHM2.DelAll(owners); err != nil {
+		transaction.Rollback()
+		return []string{}, err
+	}
+
+	// Remove the properties that were only used by the expired owners
+	propSet := txHM2.propSet()
+	props, err := propSet.All()
+	if err != nil {
+		transaction.Rollback()
+		return []string{}, err
+	}
+	used := make(map[string]bool)
+	rows, err = transaction.QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT split_part(skeys, '%s', 2) FROM (SELECT skeys(attr) FROM %s) AS temp", fieldSep, kv.tableName()))
+	if err != nil {
+		transaction.Rollback()
+		return []string{}, err
+	}
+	for rows.Next() {
+		var prop string
+		if err := rows.Scan(&prop); err != nil {
+			rows.Close()
+			transaction.Rollback()
+			return []string{}, err
+		}
+		used[prop] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		transaction.Rollback()
+		return []string{}, err
+	}
+	var orphaned []string
+	for _, prop := range props {
+		if !used[prop] {
+			if err := propSet.encode(&prop); err != nil {
+				transaction.Rollback()
+				return []string{}, err
+			}
+			orphaned = append(orphaned, prop)
+		}
+	}
+	if len(orphaned) > 0 {
+		if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = ANY($1)", propSet.tableName(), setCol), pq.Array(orphaned)); err != nil {
+			transaction.Rollback()
+			return []string{}, err
+		}
+	}
+	return owners, transaction.Commit()
+}
@@ -0,0 +1,61 @@
+package simplehstore
+
+import (
+	"context"
+	"sort"
+)
+
+// WithOwnerLocking serializes the writes to each owner of a HashMap2, with a
+// transaction level advisory lock per owner. Concurrent calls to Set, SetMap,
+// DelKey, Del and DelAll for the same owner, also from other processes, then
+// run one after the other instead of interleaving, so that the owner never
+// ends up with a mix of the properties from both calls. Writes to different
+// owners still run concurrently. SetLargeMap does not take the locks, since
+// it may write to a very large number of owners at once, and neither do the
+// methods that write to owners that are not given by the caller, like
+// DelWhere, ExpireOwners and Clear.
+func WithOwnerLocking() Option {
+	return func(o *options) {
+		o.ownerLocking = true
+	}
+}
+
+// lockOwner takes the advisory lock for the given owner, if owner locking is
+// enabled. The lock is held until the transaction ends.
+func (d *dbDatastructure) lockOwner(ctx context.Context, transaction *txn, owner string) error {
+	if !d.ownerLocking {
+		return nil
+	}
+	_, err := transaction.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))", d.table, owner)
+	return err
+}
+
+// withOwnerLocks calls fn with a copy of the hash map that runs its queries in
+// a transaction that holds the locks for the given owners, if owner locking
+// is enabled, or else with the hash map itself
+func (hm2 *HashMap2) withOwnerLocks(owners []string, fn func(*HashMap2) error) error {
+	if !hm2.ownerLocking {
+		return fn(hm2)
+	}
+	ctx := hm2.host.context()
+	transaction, err := hm2.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	// Lock the owners in the same order everywhere, so that DelAll calls do not deadlock
+	sorted := append([]string{}, owners...)
+	sort.Strings(sorted)
+	for _, owner := range sorted {
+		if err := hm2.lockOwner(ctx, transaction, owner); err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	locked := *hm2
+	locked.host = hm2.host.withTx(transaction)
+	if err := fn(&locked); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
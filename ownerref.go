@@ -0,0 +1,117 @@
+package simplehstore
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// cascadeFunctions remove the members of a set that are owners that have been
+// removed from a HashMap2. The first argument is the quoted table of the set,
+// and the second argument is the column of the members. Nothing is done if
+// the set has been removed.
+var cascadeFunctions = fmt.Sprintf(`
+CREATE OR REPLACE FUNCTION simplehstore_cascade_hstore() RETURNS trigger AS $$
+DECLARE
+	removed text[];
+BEGIN
+	IF to_regclass(TG_ARGV[0]) IS NULL THEN
+		RETURN NULL;
+	END IF;
+	SELECT array_agg(owner) INTO removed FROM (
+		SELECT split_part(k, '%[1]s', 1) AS owner FROM skeys(COALESCE(OLD.attr, hstore(''))) AS k WHERE position('%[1]s' in k) > 0
+		EXCEPT
+		SELECT split_part(k, '%[1]s', 1) FROM skeys(COALESCE(NEW.attr, hstore(''))) AS k WHERE position('%[1]s' in k) > 0
+	) AS gone;
+	IF removed IS NOT NULL THEN
+		EXECUTE format('DELETE FROM %%s WHERE %%I = ANY($1)', TG_ARGV[0], TG_ARGV[1]) USING removed;
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION simplehstore_cascade_columns() RETURNS trigger AS $$
+BEGIN
+	IF to_regclass(TG_ARGV[0]) IS NULL THEN
+		RETURN NULL;
+	END IF;
+	EXECUTE format('DELETE FROM %%s WHERE %%I = $1 AND NOT EXISTS (SELECT 1 FROM %%I.%%I WHERE owner = $1)', TG_ARGV[0], TG_ARGV[1], TG_TABLE_SCHEMA, TG_TABLE_NAME) USING OLD.owner;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION simplehstore_cascade_truncate() RETURNS trigger AS $$
+BEGIN
+	IF to_regclass(TG_ARGV[0]) IS NOT NULL THEN
+		EXECUTE format('DELETE FROM %%s', TG_ARGV[0]);
+	END IF;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+`, fieldSep)
+
+// WithOwnersOf declares that the members of a Set are owners of the given
+// HashMap2, like a foreign key with ON DELETE CASCADE. When an owner is
+// removed from the hash map, with Del, DelAll, DelWhere, ExpireOwners or by
+// removing its last property, it is also removed from the set, in the same
+// transaction, so that sets like "admins" or "confirmed" do not need to be
+// cleaned up by the application. Clearing the hash map clears the set.
+//
+// Unlike a foreign key, adding a member that is not an owner is not refused.
+// The set must store its members as they are (RawCodec), so that they can be
+// compared with the owners. The removal is done by triggers on the tables of
+// the hash map, which are created by NewSet for the current layout of the
+// hash map, so NewSet should be called again after MigrateToColumnLayout.
+func WithOwnersOf(hm2 *HashMap2) Option {
+	return func(o *options) {
+		o.ownersOf = hm2
+	}
+}
+
+// cascadeTrigger returns the name of the trigger that removes owners from this set
+func (s *Set) cascadeTrigger() string {
+	sum := sha256.Sum256([]byte(s.tableName()))
+	return fmt.Sprintf("simplehstore_cascade_%x", sum[:8])
+}
+
+// cascadeTable returns the quoted table that the triggers of this set are on
+func (s *Set) cascadeTable() string {
+	if s.ownersOf.columnLayout {
+		return s.ownersOf.columnsTable()
+	}
+	return s.ownersOf.keyValue().tableName()
+}
+
+// linkOwners creates the triggers that remove owners from this set when they
+// are removed from the hash map, if the set is created WithOwnersOf
+func (s *Set) linkOwners() error {
+	if s.ownersOf == nil {
+		return nil
+	}
+	if s.getCodec().Name() != RawCodec.Name() {
+		return fmt.Errorf("%s must use the %q codec to be linked to the owners of a hash map", s.tableName(), RawCodec.Name())
+	}
+	quotedTable := s.cascadeTable()
+	row := fmt.Sprintf("AFTER UPDATE ON %s FOR EACH ROW EXECUTE PROCEDURE simplehstore_cascade_hstore('%s', '%s')", quotedTable, escapeSingleQuotes(s.tableName()), escapeSingleQuotes(setCol))
+	if s.ownersOf.columnLayout {
+		row = fmt.Sprintf("AFTER DELETE ON %s FOR EACH ROW EXECUTE PROCEDURE simplehstore_cascade_columns('%s', '%s')", quotedTable, escapeSingleQuotes(s.tableName()), escapeSingleQuotes(setCol))
+	}
+	return s.host.createTriggers(quotedTable, cascadeFunctions, map[string]string{
+		s.cascadeTrigger():               row,
+		s.cascadeTrigger() + "_truncate": fmt.Sprintf("AFTER TRUNCATE ON %s FOR EACH STATEMENT EXECUTE PROCEDURE simplehstore_cascade_truncate('%s')", quotedTable, escapeSingleQuotes(s.tableName())),
+	})
+}
+
+// unlinkOwners removes the triggers of this set from the hash map, if the set is created WithOwnersOf
+func (s *Set) unlinkOwners() error {
+	if s.ownersOf == nil {
+		return nil
+	}
+	for _, name := range []string{s.cascadeTrigger(), s.cascadeTrigger() + "_truncate"} {
+		// The hash map may have been removed already
+		if _, err := s.host.exec(fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", name, s.cascadeTable())); err != nil && !errors.Is(err, ErrTableMissing) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package simplehstore
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWithOwnersOf(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	for _, opts := range [][]Option{nil, {WithColumnLayout()}} {
+		users, err := NewHashMap2(host, "ownerref_users_test", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users.Clear()
+		admins, err := NewSet(host, "ownerref_admins_test", WithOwnersOf(users))
+		if err != nil {
+			t.Fatal(err)
+		}
+		admins.Clear()
+		users.Set("alice", "email", "alice@example.com")
+		users.SetMap("bob", map[string]string{"email": "bob@example.com", "role": "admin"})
+		admins.Add("alice")
+		admins.Add("bob")
+
+		// Removing one property keeps the owner
+		users.DelKey("bob", "role")
+		if err := users.Del("alice"); err != nil {
+			t.Fatal(err)
+		}
+		members, err := admins.All()
+		sort.Strings(members)
+		if err != nil || strings.Join(members, ",") != "bob" {
+			t.Errorf("Error, expected only bob to be left, got %v (%v)", members, err)
+		}
+		users.Clear()
+		if empty, err := admins.IsEmpty(); err != nil || !empty {
+			t.Errorf("Error, expected clearing the owners to clear the set, got %v (%v)", empty, err)
+		}
+
+		if err := admins.Remove(); err != nil {
+			t.Error(err)
+		}
+		// Writes to the hash map still work after the set is removed
+		if err := users.Set("carol", "email", "carol@example.com"); err != nil {
+			t.Error(err)
+		}
+		users.Remove()
+	}
+}
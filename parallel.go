@@ -0,0 +1,71 @@
+package simplehstore
+
+import (
+	"context"
+	"sync"
+)
+
+// ParallelForEachOwner calls fn for each owner, spread over the given number
+// of workers, for maintenance jobs that touch every owner. The workers share
+// the connection pool of the host, and fn is given a copy of the hash map that
+// uses the context that is given to fn, so that the methods it calls run in
+// transactions of their own, as usual. The first error from fn stops all
+// workers, by cancelling the context that is given to fn, and is returned.
+// Owners that are added while the workers are running may not be visited.
+func (hm2 *HashMap2) ParallelForEachOwner(ctx context.Context, workers int, fn func(ctx context.Context, worker *HashMap2, owner string) error) error {
+	if hm2.host.tx != nil || hm2.host.db == nil {
+		// The queries of a transaction can not run in parallel
+		return ErrNestedTransaction
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	owners, err := hm2.WithContext(ctx).All()
+	if err != nil {
+		return err
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	queue := make(chan string)
+	go func() {
+		defer close(queue)
+		for _, owner := range owners {
+			select {
+			case queue <- owner:
+			case <-wctx.Done():
+				return
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := *hm2
+			worker.host = hm2.host.WithContext(wctx)
+			for owner := range queue {
+				if err := fn(wctx, &worker, owner); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	// Owners may have been skipped if ctx was cancelled
+	return ctx.Err()
+}
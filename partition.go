@@ -0,0 +1,109 @@
+package simplehstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// partitionTimeFormat is used in the names of time partitions
+const partitionTimeFormat = "20060102T150405"
+
+// partitionName returns the quoted and possibly schema qualified name of the partition with the given suffix
+func (l *List) partitionName(suffix string) string {
+	// strip double quotes from l.table and add the suffix at the end
+	return l.qualify(pq.QuoteIdentifier(strings.TrimSuffix(strings.TrimPrefix(l.table, "\""), "\"") + "_" + suffix))
+}
+
+// createPartitioned creates the list table as a partitioned table, together with the initial partitions
+func (l *List) createPartitioned() error {
+	if l.hashPartitions > 0 {
+		if _, err := l.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id SERIAL PRIMARY KEY, %s %s, created TIMESTAMPTZ NOT NULL DEFAULT now()) PARTITION BY HASH (id)", l.tableName(), listCol, defaultStringType)); err != nil {
+			return err
+		}
+		for i := 0; i < l.hashPartitions; i++ {
+			query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES WITH (MODULUS %d, REMAINDER %d)", l.partitionName(fmt.Sprintf("p%d", i)), l.tableName(), l.hashPartitions, i)
+			if _, err := l.host.exec(query); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// The partition key must be part of the primary key
+	if _, err := l.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id SERIAL, %s %s, created TIMESTAMPTZ NOT NULL DEFAULT now(), PRIMARY KEY (id, created)) PARTITION BY RANGE (created)", l.tableName(), listCol, defaultStringType)); err != nil {
+		return err
+	}
+	// Elements that do not fit in any partition end up in the default partition
+	if _, err := l.host.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s DEFAULT", l.partitionName("default"), l.tableName())); err != nil {
+		return err
+	}
+	return l.EnsurePartitions(time.Now().Add(l.partitionInterval))
+}
+
+// EnsurePartitions creates the time partitions that are needed for storing
+// elements that are added from now and until the given time. This should be
+// called periodically, for instance once per partition interval, for lists
+// that were created with WithTimePartitions.
+func (l *List) EnsurePartitions(until time.Time) error {
+	if l.partitionInterval <= 0 {
+		return fmt.Errorf("%s was not created with WithTimePartitions", l.tableName())
+	}
+	for start := time.Now().Truncate(l.partitionInterval); start.Before(until); start = start.Add(l.partitionInterval) {
+		end := start.Add(l.partitionInterval)
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (%s) TO (%s)", l.partitionName(start.UTC().Format(partitionTimeFormat)), l.tableName(), pq.QuoteLiteral(start.UTC().Format(time.RFC3339)), pq.QuoteLiteral(end.UTC().Format(time.RFC3339)))
+		if _, err := l.host.exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partitions returns the names of the partitions of the list, without the quotes and the schema
+func (l *List) partitions() ([]string, error) {
+	rows, err := l.host.query("SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass ORDER BY c.relname", l.tableName())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// DropPartitionsOlderThan drops the time partitions that only contain elements
+// that were added before t, and returns how many partitions were dropped.
+// This is much faster than deleting the elements one by one.
+func (l *List) DropPartitionsOlderThan(t time.Time) (int, error) {
+	if l.partitionInterval <= 0 {
+		return 0, fmt.Errorf("%s was not created with WithTimePartitions", l.tableName())
+	}
+	names, err := l.partitions()
+	if err != nil {
+		return 0, err
+	}
+	prefix := strings.TrimSuffix(strings.TrimPrefix(l.table, "\""), "\"") + "_"
+	dropped := 0
+	for _, name := range names {
+		start, err := time.Parse(partitionTimeFormat, strings.TrimPrefix(name, prefix))
+		if err != nil {
+			// Not a time partition, for instance the default partition
+			continue
+		}
+		if start.Add(l.partitionInterval).After(t) {
+			continue
+		}
+		if _, err := l.host.exec(fmt.Sprintf("DROP TABLE %s", l.qualify(pq.QuoteIdentifier(name)))); err != nil {
+			return dropped, err
+		}
+		dropped++
+	}
+	return dropped, nil
+}
@@ -0,0 +1,48 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionedList(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, err := NewList(host, "partition_test", WithTimePartitions(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clear()
+	if err := l.EnsurePartitions(time.Now().Add(3 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	l.Add("a")
+	l.Add("b")
+	if all, err := l.All(); err != nil || len(all) != 2 {
+		t.Errorf("Error, expected two elements, got %v (%v)", all, err)
+	}
+	if n, err := l.DropPartitionsOlderThan(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("Error, expected no partitions to be dropped, got %d (%v)", n, err)
+	}
+	if n, err := l.DropPartitionsOlderThan(time.Now().Add(2 * time.Hour)); err != nil || n == 0 {
+		t.Errorf("Error, expected the current partition to be dropped, got %d (%v)", n, err)
+	}
+	if all, _ := l.All(); len(all) != 0 {
+		t.Errorf("Error, expected no elements, got %v", all)
+	}
+	l.Remove()
+
+	h, err := NewList(host, "partition_hash_test", WithHashPartitions(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Clear()
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		h.Add(value)
+	}
+	if all, err := h.All(); err != nil || len(all) != 5 || all[4] != "e" {
+		t.Errorf("Error, expected five elements in order, got %v (%v)", all, err)
+	}
+	h.Remove()
+}
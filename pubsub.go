@@ -0,0 +1,178 @@
+package simplehstore
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultPubSubBuffer is how many messages a subscription can hold before
+// new messages are dropped, by default
+const DefaultPubSubBuffer = 64
+
+// maxMessageLength is the longest payload PostgreSQL accepts for a notification
+const maxMessageLength = 7999
+
+var (
+	// ErrMessageTooLarge is returned when publishing a message that is too long for a notification
+	ErrMessageTooLarge = errors.New("the message is too large to be published")
+	// ErrPubSubClosed is returned when subscribing with a PubSub that has been closed
+	ErrPubSubClosed = errors.New("the pubsub has been closed")
+)
+
+// PubSub is lightweight publish/subscribe messaging with LISTEN/NOTIFY.
+// Messages are only delivered to the subscribers that are listening when the
+// message is published, and are not stored. Messages that are published as
+// part of a transaction are delivered when the transaction commits.
+//
+// All subscriptions share a single connection, which is only available for a
+// Host that is created from a connection string. Messages may be lost while
+// the connection is being re-established, and messages are dropped for a
+// subscription that has more than the buffer size of messages waiting.
+type PubSub struct {
+	host   *Host
+	buffer int
+
+	mut      sync.Mutex
+	listener *pq.Listener
+	subs     map[string][]chan string // by notification channel
+	done     chan struct{}
+	closed   bool
+}
+
+// NewPubSub creates a new PubSub
+func NewPubSub(provider HostProvider) (*PubSub, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	return &PubSub{host: host, buffer: DefaultPubSubBuffer, subs: make(map[string][]chan string)}, nil
+}
+
+// SetBuffer sets how many messages a new subscription can hold before new messages are dropped
+func (p *PubSub) SetBuffer(n int) {
+	p.mut.Lock()
+	p.buffer = n
+	p.mut.Unlock()
+}
+
+// pubsubChannel returns the notification channel for the given channel.
+// Long names are hashed, since notification channels are limited to 63 bytes.
+func pubsubChannel(channel string) string {
+	name := "simplehstore_pubsub_" + channel
+	if len(name) > 63 {
+		sum := sha256.Sum256([]byte(channel))
+		name = fmt.Sprintf("simplehstore_pubsub_%x", sum[:16])
+	}
+	return name
+}
+
+// Publish sends a message to all current subscribers of the channel
+func (p *PubSub) Publish(channel, msg string) error {
+	if len(msg) > maxMessageLength {
+		return ErrMessageTooLarge
+	}
+	return p.host.notify(pubsubChannel(channel), msg)
+}
+
+// Subscribe returns a channel with the messages that are published to the
+// given channel, until Unsubscribe or Close is called
+func (p *PubSub) Subscribe(channel string) (<-chan string, error) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.closed {
+		return nil, ErrPubSubClosed
+	}
+	name := pubsubChannel(channel)
+	if p.listener == nil {
+		listener, err := p.host.newListener(name)
+		if err != nil {
+			return nil, err
+		}
+		p.listener, p.done = listener, make(chan struct{})
+		go p.dispatch(listener, p.done)
+	} else if len(p.subs[name]) == 0 {
+		if err := p.listener.Listen(name); err != nil && err != pq.ErrChannelAlreadyOpen {
+			return nil, err
+		}
+	}
+	sub := make(chan string, p.buffer)
+	p.subs[name] = append(p.subs[name], sub)
+	return sub, nil
+}
+
+// Unsubscribe stops the delivery of messages to a channel that was returned
+// by Subscribe, and closes it
+func (p *PubSub) Unsubscribe(sub <-chan string) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for name, subs := range p.subs {
+		for i, c := range subs {
+			if c != sub {
+				continue
+			}
+			close(c)
+			p.subs[name] = append(subs[:i], subs[i+1:]...)
+			if len(p.subs[name]) > 0 {
+				return nil
+			}
+			delete(p.subs, name)
+			if err := p.listener.Unlisten(name); err != nil && err != pq.ErrChannelNotOpen {
+				return err
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// dispatch delivers the notifications from the listener to the subscribers, until done is closed
+func (p *PubSub) dispatch(listener *pq.Listener, done chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case n := <-listener.Notify:
+			if n == nil {
+				// The connection was re-established, and messages may have been lost
+				continue
+			}
+			p.mut.Lock()
+			for _, sub := range p.subs[n.Channel] {
+				select {
+				case sub <- n.Extra:
+				default:
+					// The subscriber is not keeping up
+				}
+			}
+			p.mut.Unlock()
+		case <-time.After(maxReconnectInterval):
+			go listener.Ping()
+		}
+	}
+}
+
+// Close ends all subscriptions, closes their channels and the shared connection
+func (p *PubSub) Close() error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	for _, subs := range p.subs {
+		for _, sub := range subs {
+			close(sub)
+		}
+	}
+	p.subs = nil
+	if p.listener == nil {
+		return nil
+	}
+	close(p.done)
+	return p.listener.Close()
+}
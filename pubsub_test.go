@@ -0,0 +1,52 @@
+package simplehstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPubSubChannel(t *testing.T) {
+	if name := pubsubChannel("chat"); name != "simplehstore_pubsub_chat" {
+		t.Errorf("Error, unexpected channel name %q", name)
+	}
+	long := strings.Repeat("x", 100)
+	if name := pubsubChannel(long); len(name) > 63 || name == pubsubChannel(long+"y") {
+		t.Errorf("Error, expected a short and unique channel name, got %q", name)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	ps, err := NewPubSub(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.Close()
+	messages, err := ps.Subscribe("chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.Publish("chat", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-messages:
+		if msg != "hello" {
+			t.Errorf("Error, expected hello, got %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Error, expected a message")
+	}
+	if err := ps.Publish("chat", strings.Repeat("x", 8000)); err != ErrMessageTooLarge {
+		t.Errorf("Error, expected ErrMessageTooLarge, got %v", err)
+	}
+	if err := ps.Unsubscribe(messages); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-messages; ok {
+		t.Error("Error, expected the channel to be closed")
+	}
+}
@@ -0,0 +1,268 @@
+package simplehstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SortOrder is the order of owners returned by a HashMap2Query
+type SortOrder int
+
+// Sort orders
+const (
+	Asc SortOrder = iota
+	Desc
+)
+
+// ErrInvalidCursor is returned when a pagination cursor can not be parsed
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// condition is a property filter in a HashMap2Query
+type condition struct {
+	key, value string
+	negate     bool
+}
+
+// HashMap2Query selects owners in a HashMap2 by the values of their properties.
+// All filters are compiled into one SQL statement.
+type HashMap2Query struct {
+	hm2        *HashMap2
+	conditions []condition
+	limit      int
+	orderKey   string
+	order      SortOrder
+	cursor     string
+}
+
+// Query returns a new query for selecting owners in the hash map
+func (hm2 *HashMap2) Query() *HashMap2Query {
+	return &HashMap2Query{hm2: hm2}
+}
+
+// Where selects owners where the given property has the given value
+func (q *HashMap2Query) Where(key, value string) *HashMap2Query {
+	q.conditions = append(q.conditions, condition{key, value, false})
+	return q
+}
+
+// WhereNot selects owners where the given property does not have the given
+// value, including owners that do not have the property
+func (q *HashMap2Query) WhereNot(key, value string) *HashMap2Query {
+	q.conditions = append(q.conditions, condition{key, value, true})
+	return q
+}
+
+// Limit sets the maximum number of owners to return. 0 means no limit.
+func (q *HashMap2Query) Limit(n int) *HashMap2Query {
+	q.limit = n
+	return q
+}
+
+// OrderByProp sorts the owners by the value of the given property, instead of
+// by owner. Values that are numbers are compared as numbers, and are sorted
+// before other values. Owners without the property are sorted last.
+// Since values may be compressed by the codec, they are sorted after they
+// have been retrieved and decoded, so the limit is applied after sorting.
+func (q *HashMap2Query) OrderByProp(key string, order SortOrder) *HashMap2Query {
+	q.orderKey = key
+	q.order = order
+	return q
+}
+
+// After continues the query after the position of the given cursor, as returned by Page
+func (q *HashMap2Query) After(cursor string) *HashMap2Query {
+	q.cursor = cursor
+	return q
+}
+
+// position is the position of an owner in the result, used as a pagination cursor
+type position struct {
+	Value string `json:"v,omitempty"`
+	Owner string `json:"o"`
+	Has   bool   `json:"h,omitempty"`
+}
+
+// encodeCursor returns the position as an opaque cursor
+func encodeCursor(p position) string {
+	data, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor that was returned by encodeCursor
+func decodeCursor(cursor string) (position, error) {
+	var p position
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return p, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, ErrInvalidCursor
+	}
+	return p, nil
+}
+
+// less compares two positions by property value, with numbers before other values
+// and missing values last, and then by owner
+func less(a, b position, order SortOrder) bool {
+	if a.Has != b.Has {
+		return a.Has
+	}
+	if a.Has && a.Value != b.Value {
+		af, aErr := strconv.ParseFloat(a.Value, 64)
+		bf, bErr := strconv.ParseFloat(b.Value, 64)
+		var result bool
+		switch {
+		case aErr == nil && bErr != nil:
+			return true
+		case aErr != nil && bErr == nil:
+			return false
+		case aErr == nil && bErr == nil && af != bf:
+			result = af < bf
+		default:
+			result = a.Value < b.Value
+		}
+		if order == Desc {
+			return !result
+		}
+		return result
+	}
+	return a.Owner < b.Owner
+}
+
+// build returns the SQL statement and arguments for the query. If the query
+// is ordered by a property, the value of the property is the second column.
+func (q *HashMap2Query) build() (string, []interface{}, error) {
+	if err := q.hm2.requireHstoreLayout(); err != nil {
+		return "", nil, err
+	}
+	kv := q.hm2.keyValue()
+	var (
+		where []string
+		args  []interface{}
+	)
+	for _, c := range q.conditions {
+		value := c.value
+		if err := kv.encode(&value); err != nil {
+			return "", nil, err
+		}
+		args = append(args, fieldSep+c.key, value)
+		op := "="
+		if c.negate {
+			op = "IS DISTINCT FROM"
+		}
+		where = append(where, fmt.Sprintf("(h.attr -> (o.owner || $%d)) %s $%d", len(args)-1, op, len(args)))
+	}
+	columns := "o.owner"
+	if q.orderKey != "" {
+		args = append(args, fieldSep+q.orderKey)
+		columns += fmt.Sprintf(", h.attr -> (o.owner || $%d)", len(args))
+	} else if q.cursor != "" {
+		p, err := decodeCursor(q.cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, p.Owner)
+		where = append(where, fmt.Sprintf("o.owner > $%d", len(args)))
+	}
+	// Owners can not contain fieldSep, so the owner is everything before the first fieldSep
+	query := fmt.Sprintf("SELECT DISTINCT %s FROM (SELECT DISTINCT split_part(skeys(attr), '%s', 1) AS owner FROM %s) AS o, %s AS h", columns, fieldSep, kv.tableName(), kv.tableName())
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if q.orderKey == "" {
+		query += " ORDER BY o.owner"
+		if q.limit > 0 {
+			// Fetch one more, to find out if there is a next page
+			query += fmt.Sprintf(" LIMIT %d", q.limit+1)
+		}
+	}
+	return query, args, nil
+}
+
+// Page runs the query and returns the owners, together with a cursor that can
+// be given to After for getting the next page. The cursor is empty if there
+// are no more owners.
+func (q *HashMap2Query) Page() ([]string, string, error) {
+	query, args, err := q.build()
+	if err != nil {
+		return []string{}, "", err
+	}
+	if q.hm2.verbose() {
+		fmt.Println(query)
+	}
+	rows, err := q.hm2.host.query(query, args...)
+	if err != nil {
+		return []string{}, "", err
+	}
+	defer rows.Close()
+	var positions []position
+	for rows.Next() {
+		var p position
+		if q.orderKey == "" {
+			err = rows.Scan(&p.Owner)
+		} else {
+			var value *string
+			err = rows.Scan(&p.Owner, &value)
+			if value != nil {
+				p.Value, p.Has = *value, true
+			}
+		}
+		if err != nil {
+			return []string{}, "", err
+		}
+		positions = append(positions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return []string{}, "", err
+	}
+	if q.orderKey != "" {
+		if positions, err = q.sortPositions(positions); err != nil {
+			return []string{}, "", err
+		}
+	}
+	next := ""
+	if q.limit > 0 && len(positions) > q.limit {
+		positions = positions[:q.limit]
+		next = encodeCursor(positions[q.limit-1])
+	}
+	owners := make([]string, len(positions))
+	for i, p := range positions {
+		owners[i] = p.Owner
+	}
+	return owners, next, nil
+}
+
+// sortPositions decodes the values, sorts the positions and skips the positions up to and including the cursor
+func (q *HashMap2Query) sortPositions(positions []position) ([]position, error) {
+	values := make([]string, len(positions))
+	for i, p := range positions {
+		values[i] = p.Value
+	}
+	if err := q.hm2.keyValue().decodeAll(values); err != nil {
+		return nil, err
+	}
+	for i := range positions {
+		positions[i].Value = values[i]
+	}
+	sort.Slice(positions, func(i, j int) bool { return less(positions[i], positions[j], q.order) })
+	if q.cursor == "" {
+		return positions, nil
+	}
+	after, err := decodeCursor(q.cursor)
+	if err != nil {
+		return nil, err
+	}
+	start := sort.Search(len(positions), func(i int) bool { return less(after, positions[i], q.order) })
+	return positions[start:], nil
+}
+
+// Owners runs the query and returns the owners
+func (q *HashMap2Query) Owners() ([]string, error) {
+	owners, _, err := q.Page()
+	return owners, err
+}
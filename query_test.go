@@ -0,0 +1,85 @@
+package simplehstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueryBuild(t *testing.T) {
+	hm2 := &HashMap2{dbDatastructure: newDatastructure(&Host{}, "users", []Option{WithCodec(RawCodec)})}
+	query, args, err := hm2.Query().Where("confirmed", "true").WhereNot("banned", "true").Limit(100).build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "(h.attr -> (o.owner || $1)) = $2 AND (h.attr -> (o.owner || $3)) IS DISTINCT FROM $4") {
+		t.Errorf("Error, unexpected conditions: %s", query)
+	}
+	if !strings.HasSuffix(query, " LIMIT 101") {
+		t.Errorf("Error, expected a limit: %s", query)
+	}
+	if len(args) != 4 || args[0] != fieldSep+"confirmed" || args[1] != "true" {
+		t.Errorf("Error, unexpected arguments: %v", args)
+	}
+}
+
+func TestLess(t *testing.T) {
+	positions := []position{
+		{Owner: "d"},
+		{Value: "abc", Owner: "c", Has: true},
+		{Value: "10", Owner: "b", Has: true},
+		{Value: "9", Owner: "a", Has: true},
+	}
+	if !less(positions[3], positions[2], Asc) || !less(positions[2], positions[3], Desc) {
+		t.Error("Error, numbers should be compared as numbers")
+	}
+	if !less(positions[2], positions[1], Asc) || !less(positions[2], positions[1], Desc) {
+		t.Error("Error, numbers should be sorted before other values")
+	}
+	if !less(positions[1], positions[0], Asc) || !less(positions[1], positions[0], Desc) {
+		t.Error("Error, missing values should be sorted last")
+	}
+	cursor := encodeCursor(positions[1])
+	if p, err := decodeCursor(cursor); err != nil || p != positions[1] {
+		t.Errorf("Error, the cursor should decode to the same position, got %v (%v)", p, err)
+	}
+	if _, err := decodeCursor("not a cursor"); err != ErrInvalidCursor {
+		t.Errorf("Error, expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestQueryOwners(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "query_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+
+	users.Set("alice", "confirmed", "true")
+	users.Set("bob", "confirmed", "true")
+	users.Set("bob", "banned", "true")
+	users.Set("carol", "confirmed", "false")
+	owners, err := users.Query().Where("confirmed", "true").WhereNot("banned", "true").Owners()
+	if err != nil || len(owners) != 1 || owners[0] != "alice" {
+		t.Errorf("Error, expected only alice, got %v (%v)", owners, err)
+	}
+	if owners, _ := users.Query().Limit(2).Owners(); len(owners) != 2 {
+		t.Errorf("Error, expected two owners, got %v", owners)
+	}
+
+	users.Set("alice", "registered", "2021-01-01")
+	users.Set("bob", "registered", "2023-01-01")
+	users.Set("carol", "registered", "2022-01-01")
+	owners, next, err := users.Query().OrderByProp("registered", Desc).Limit(2).Page()
+	if err != nil || len(owners) != 2 || owners[0] != "bob" || owners[1] != "carol" || next == "" {
+		t.Errorf("Error, expected bob and carol on the first page, got %v %q (%v)", owners, next, err)
+	}
+	owners, next, err = users.Query().OrderByProp("registered", Desc).Limit(2).After(next).Page()
+	if err != nil || len(owners) != 1 || owners[0] != "alice" || next != "" {
+		t.Errorf("Error, expected alice on the last page, got %v %q (%v)", owners, next, err)
+	}
+
+	users.Remove()
+}
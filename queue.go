@@ -0,0 +1,517 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultVisibilityTimeout is how long a claimed job is hidden from other workers, by default
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// Job statuses
+const (
+	JobReady = "ready"
+	JobDone  = "done"
+	JobDead  = "dead"
+)
+
+var (
+	// ErrEmptyQueue is returned when there are no jobs that are ready to be claimed
+	ErrEmptyQueue = errors.New("no jobs are ready")
+	// ErrNoSuchJob is returned when a job is not found
+	ErrNoSuchJob = errors.New("no such job")
+	// ErrLeaseLost is returned when a claimed job is finished after it has been
+	// claimed again by another worker, or finished by someone else
+	ErrLeaseLost = errors.New("the job is no longer claimed by this worker")
+)
+
+// Queue is a job queue stored in PostgreSQL. Several workers can claim jobs
+// concurrently, since claimed rows are skipped with FOR UPDATE SKIP LOCKED.
+// A claimed job is hidden from other workers until the visibility timeout has
+// passed, so that the job is retried if the worker crashes.
+// Jobs that fail too many times are dead, and are either kept in the queue
+// with the JobDead status, or moved to a dead-letter queue.
+type Queue struct {
+	dbDatastructure
+	maxAttempts int
+	visibility  time.Duration
+	deadLetter  *Queue
+}
+
+// Job is a job in a Queue
+type Job struct {
+	ID        int64
+	Payload   string
+	Priority  int
+	Status    string
+	Attempts  int
+	LastError string
+	Created   time.Time
+
+	queue *Queue
+	// lease is the attempt counter when the job was claimed, 0 if it was not
+	// claimed, or -1 if it has been released
+	lease int
+}
+
+// NewQueue creates a new job queue
+func NewQueue(provider HostProvider, name string, opts ...Option) (*Queue, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	q := &Queue{dbDatastructure: newDatastructure(host, pq.QuoteIdentifier(name), opts), visibility: DefaultVisibilityTimeout}
+	if err := q.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := q.exists(q.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("%s %s (id BIGSERIAL PRIMARY KEY, payload TEXT NOT NULL, status TEXT NOT NULL DEFAULT '%s', attempts INTEGER NOT NULL DEFAULT 0, last_error TEXT NOT NULL DEFAULT '', visible_at TIMESTAMPTZ NOT NULL DEFAULT now(), created TIMESTAMPTZ NOT NULL DEFAULT now())", q.createTable(), q.tableName(), JobReady)
+	if _, err := q.host.exec(query); err != nil {
+		return nil, err
+	}
+	// Queues created by earlier versions do not have a priority column
+	if err := q.addColumn(q.tableName(), "priority", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	query = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (visible_at, id) WHERE status = '%s'", pq.QuoteIdentifier(name+"_ready_idx"), q.tableName(), JobReady)
+	if _, err := q.host.exec(query); err != nil {
+		return nil, err
+	}
+	query = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (priority DESC, visible_at, id) WHERE status = '%s'", pq.QuoteIdentifier(name+"_priority_idx"), q.tableName(), JobReady)
+	if _, err := q.host.exec(query); err != nil {
+		return nil, err
+	}
+	if err := q.setStorageParameters(q.tableName()); err != nil {
+		return nil, err
+	}
+	if err := q.register("queue", name, q.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if q.verbose() {
+		q.host.Logger().Println("Created queue table " + q.tableName() + " in database " + host.dbname)
+	}
+	return q, nil
+}
+
+// SetMaxAttempts sets how many times a job can be claimed before it is dead.
+// 0 means that jobs are retried until they are acked or failed.
+func (q *Queue) SetMaxAttempts(maxAttempts int) {
+	q.maxAttempts = maxAttempts
+}
+
+// SetVisibilityTimeout sets how long a claimed job is hidden from other workers.
+// If the job is neither acked, retried or failed within this time, it is claimed again.
+func (q *Queue) SetVisibilityTimeout(timeout time.Duration) {
+	q.visibility = timeout
+}
+
+// SetDeadLetterQueue makes dead jobs move to the given queue, instead of being
+// kept with the JobDead status. The queues must be on the same Host.
+func (q *Queue) SetDeadLetterQueue(deadLetter *Queue) {
+	q.deadLetter = deadLetter
+}
+
+// Push adds a job to the queue, and returns the ID of the job
+func (q *Queue) Push(payload string) (int64, error) {
+	return q.push(payload, 0, 0, nil)
+}
+
+// PushDelayed adds a job to the queue that can not be claimed until after the given delay
+func (q *Queue) PushDelayed(payload string, delay time.Duration) (int64, error) {
+	return q.push(payload, 0, delay, nil)
+}
+
+// PushPriority adds a job with the given priority to the queue. Jobs with a
+// higher priority are claimed first by PopHighestPriority. The default priority is 0.
+func (q *Queue) PushPriority(payload string, priority int) (int64, error) {
+	return q.push(payload, priority, 0, nil)
+}
+
+// PushAt adds a job with the given priority to the queue, that can not be claimed before notBefore
+func (q *Queue) PushAt(payload string, priority int, notBefore time.Time) (int64, error) {
+	return q.push(payload, priority, 0, &notBefore)
+}
+
+// push adds a job to the queue, that can be claimed at notBefore if given, or else after the delay
+func (q *Queue) push(payload string, priority int, delay time.Duration, notBefore *time.Time) (int64, error) {
+	if err := q.encode(&payload); err != nil {
+		return 0, err
+	}
+	var id int64
+	query := fmt.Sprintf("INSERT INTO %s (payload, priority, visible_at) VALUES ($1, $2, COALESCE($4, now() + $3 * interval '1 microsecond')) RETURNING id", q.tableName())
+	err := q.host.queryRow(query, payload, priority, delay.Microseconds(), notBefore).Scan(&id)
+	return id, err
+}
+
+// scanJob scans a single row with the columns id, payload, priority, status,
+// attempts, last_error and created, and decodes the payload
+func (q *Queue) scanJob(row *row) (*Job, error) {
+	job := &Job{queue: q}
+	if err := row.Scan(&job.ID, &job.Payload, &job.Priority, &job.Status, &job.Attempts, &job.LastError, &job.Created); err != nil {
+		return nil, err
+	}
+	if err := q.decode(&job.Payload); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// scanJobs scans rows with the same columns as scanJob, and decodes the
+// payloads after the rows have been closed
+func (q *Queue) scanJobs(rows *sql.Rows) ([]*Job, error) {
+	var jobs []*Job
+	for rows.Next() {
+		job := &Job{queue: q}
+		if err := rows.Scan(&job.ID, &job.Payload, &job.Priority, &job.Status, &job.Attempts, &job.LastError, &job.Created); err != nil {
+			rows.Close()
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return jobs, err
+	}
+	payloads := make([]string, len(jobs))
+	for i, job := range jobs {
+		payloads[i] = job.Payload
+	}
+	if err := q.decodeAll(payloads); err != nil {
+		return jobs, err
+	}
+	for i, job := range jobs {
+		job.Payload = payloads[i]
+	}
+	return jobs, nil
+}
+
+const jobColumns = "id, payload, priority, status, attempts, last_error, created"
+
+// Claim claims the next job that is ready, and hides it from other workers
+// for the visibility timeout. Jobs are claimed in the order they became ready.
+// ErrEmptyQueue is returned if no job is ready.
+// The job must be finished with Ack, Retry or Fail.
+func (q *Queue) Claim() (*Job, error) {
+	return q.claim("visible_at, id")
+}
+
+// PopHighestPriority claims the ready job with the highest priority, like Claim.
+// Jobs with the same priority are claimed in the order they became ready.
+func (q *Queue) PopHighestPriority() (*Job, error) {
+	return q.claim("priority DESC, visible_at, id")
+}
+
+// claim claims the first job that is ready, in the given order
+func (q *Queue) claim(order string) (*Job, error) {
+	jobs, err := q.claimN(order, 1, q.visibility)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, ErrEmptyQueue
+	}
+	return jobs[0], nil
+}
+
+// claimN claims up to n jobs that are ready, in the given order, and hides them for the given duration
+func (q *Queue) claimN(order string, n int, visibility time.Duration) ([]*Job, error) {
+	if err := q.buryExhausted(); err != nil {
+		return nil, err
+	}
+	exhausted := ""
+	if q.maxAttempts > 0 {
+		exhausted = fmt.Sprintf(" AND attempts < %d", q.maxAttempts)
+	}
+	// The claimed rows are returned in the given order, which UPDATE ... RETURNING does not keep
+	query := fmt.Sprintf("WITH locked AS (SELECT id, priority, visible_at FROM %s WHERE status = '%s' AND visible_at <= now()%s ORDER BY %s LIMIT %d FOR UPDATE SKIP LOCKED), ranked AS (SELECT id AS claim_id, row_number() OVER (ORDER BY %s) AS claim_rank FROM locked), claimed AS (UPDATE %s SET attempts = attempts + 1, visible_at = now() + $1 * interval '1 microsecond' FROM ranked WHERE id = claim_id RETURNING %s, claim_rank) SELECT %s FROM claimed ORDER BY claim_rank", q.tableName(), JobReady, exhausted, order, n, order, q.tableName(), jobColumns, jobColumns)
+	rows, err := q.host.query(query, visibility.Microseconds())
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := q.scanJobs(rows)
+	for _, job := range jobs {
+		job.lease = job.Attempts
+	}
+	return jobs, err
+}
+
+// Lease claims up to n jobs that are ready, and hides them from other workers
+// for the given duration. Each job must be finished with Ack or Nack (or Retry
+// or Fail) within the duration, or else it is returned to the queue and may be
+// claimed again. An empty slice is returned if no jobs are ready.
+func (q *Queue) Lease(n int, duration time.Duration) ([]*Job, error) {
+	if n <= 0 {
+		return []*Job{}, nil
+	}
+	jobs, err := q.claimN("visible_at, id", n, duration)
+	if jobs == nil {
+		jobs = []*Job{}
+	}
+	return jobs, err
+}
+
+// buryExhausted makes jobs dead if they have been claimed the maximum number of
+// times, and the visibility timeout of the last attempt has passed
+func (q *Queue) buryExhausted() error {
+	if q.maxAttempts <= 0 {
+		return nil
+	}
+	const reason = "the visibility timeout of the last attempt passed"
+	if q.deadLetter == nil {
+		_, err := q.host.exec(fmt.Sprintf("UPDATE %s SET status = '%s', last_error = $1 WHERE status = '%s' AND attempts >= %d AND visible_at <= now()", q.tableName(), JobDead, JobReady, q.maxAttempts), reason)
+		return err
+	}
+	rows, err := q.host.query(fmt.Sprintf("SELECT id FROM %s WHERE status = '%s' AND attempts >= %d AND visible_at <= now() ORDER BY id LIMIT 100", q.tableName(), JobReady, q.maxAttempts))
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		// Another worker may have buried the job in the meantime
+		if err := q.fail(id, 0, reason); err != nil && err != ErrNoSuchJob {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the job with the given ID
+func (q *Queue) Get(id int64) (*Job, error) {
+	job, err := q.scanJob(q.host.queryRow(fmt.Sprintf("SELECT %s FROM %s WHERE id = $1", jobColumns, q.tableName()), id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSuchJob
+	}
+	return job, err
+}
+
+// Inspect returns up to limit jobs with the given status, oldest first
+func (q *Queue) Inspect(status string, limit int) ([]*Job, error) {
+	rows, err := q.host.query(fmt.Sprintf("SELECT %s FROM %s WHERE status = $1 ORDER BY id LIMIT %d", jobColumns, q.tableName(), limit), status)
+	if err != nil {
+		return nil, err
+	}
+	return q.scanJobs(rows)
+}
+
+// setStatus updates the status of a job, and when it may be claimed again.
+// If lease is not 0, the job is only updated if it is still claimed with that
+// attempt counter, and ErrLeaseLost is returned if it is not.
+func (q *Queue) setStatus(id int64, lease int, status, reason string, delay time.Duration) error {
+	query := fmt.Sprintf("UPDATE %s SET status = $2, last_error = $3, visible_at = now() + $4 * interval '1 microsecond' WHERE id = $1", q.tableName())
+	args := []interface{}{id, status, reason, delay.Microseconds()}
+	if lease != 0 {
+		query += fmt.Sprintf(" AND attempts = $5 AND status = '%s'", JobReady)
+		args = append(args, lease)
+	}
+	result, err := q.host.exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if lease != 0 {
+			return ErrLeaseLost
+		}
+		return ErrNoSuchJob
+	}
+	return nil
+}
+
+// Ack marks the job as done.
+// ErrLeaseLost is returned if the job has been claimed again since this worker claimed it.
+func (j *Job) Ack() error {
+	if err := j.queue.setStatus(j.ID, j.lease, JobDone, j.LastError, 0); err != nil {
+		return err
+	}
+	j.Status = JobDone
+	return nil
+}
+
+// Retry makes the job ready to be claimed again after the given delay.
+// If the job has been attempted the maximum number of times, it is failed instead.
+// ErrLeaseLost is returned if the job has been claimed again since this worker claimed it.
+func (j *Job) Retry(delay time.Duration, reason string) error {
+	if j.queue.maxAttempts > 0 && j.Attempts >= j.queue.maxAttempts {
+		return j.Fail(reason)
+	}
+	if err := j.queue.setStatus(j.ID, j.lease, JobReady, reason, delay); err != nil {
+		return err
+	}
+	j.LastError = reason
+	return nil
+}
+
+// Nack returns the job to the queue, so that it can be claimed again right away
+func (j *Job) Nack() error {
+	return j.Retry(0, j.LastError)
+}
+
+// Release returns the job to the queue, so that it can be claimed again right
+// away, without counting the attempt, for instance when the worker is shutting
+// down. ErrLeaseLost is returned if the job has been claimed again since this
+// worker claimed it.
+func (j *Job) Release() error {
+	query := fmt.Sprintf("UPDATE %s SET attempts = GREATEST(attempts - 1, 0), visible_at = now() WHERE id = $1", j.queue.tableName())
+	args := []interface{}{j.ID}
+	if j.lease != 0 {
+		query += fmt.Sprintf(" AND attempts = $2 AND status = '%s'", JobReady)
+		args = append(args, j.lease)
+	}
+	result, err := j.queue.host.exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if j.lease != 0 {
+			return ErrLeaseLost
+		}
+		return ErrNoSuchJob
+	}
+	if j.Attempts > 0 {
+		j.Attempts--
+	}
+	// The job is no longer claimed by this worker
+	j.lease = -1
+	return nil
+}
+
+// Fail marks the job as dead, so that it is not retried. If the queue has a
+// dead-letter queue, the job is moved there.
+// ErrLeaseLost is returned if the job has been claimed again since this worker claimed it.
+func (j *Job) Fail(reason string) error {
+	if err := j.queue.fail(j.ID, j.lease, reason); err != nil {
+		return err
+	}
+	j.Status = JobDead
+	j.LastError = reason
+	return nil
+}
+
+// fail marks the job with the given ID as dead, or moves it to the dead-letter queue.
+// If lease is not 0, the job is only failed if it is still claimed with that attempt counter.
+func (q *Queue) fail(id int64, lease int, reason string) error {
+	if q.deadLetter == nil {
+		return q.setStatus(id, lease, JobDead, reason, 0)
+	}
+	ctx := q.host.context()
+	transaction, err := q.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = $1 RETURNING payload", q.tableName())
+	args := []interface{}{id}
+	if lease != 0 {
+		query = fmt.Sprintf("DELETE FROM %s WHERE id = $1 AND attempts = $2 AND status = '%s' RETURNING payload", q.tableName(), JobReady)
+		args = append(args, lease)
+	}
+	var payload string
+	err = transaction.QueryRowContext(ctx, query, args...).Scan(&payload)
+	if err != nil {
+		transaction.Rollback()
+		if err == sql.ErrNoRows {
+			if lease != 0 {
+				return ErrLeaseLost
+			}
+			return ErrNoSuchJob
+		}
+		return err
+	}
+	// The queues may use different codecs
+	if err := q.decode(&payload); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if err := q.deadLetter.encode(&payload); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (payload, last_error) VALUES ($1, $2)", q.deadLetter.tableName()), payload, reason); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+// Requeue makes a job ready to be claimed right away, with the attempt counter
+// reset, for instance after the cause of a failure has been fixed
+func (q *Queue) Requeue(id int64) error {
+	result, err := q.host.exec(fmt.Sprintf("UPDATE %s SET status = '%s', attempts = 0, visible_at = now() WHERE id = $1", q.tableName(), JobReady), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoSuchJob
+	}
+	return nil
+}
+
+// RequeueDead requeues all dead jobs, and returns how many were requeued
+func (q *Queue) RequeueDead() (int64, error) {
+	result, err := q.host.exec(fmt.Sprintf("UPDATE %s SET status = '%s', attempts = 0, visible_at = now() WHERE status = '%s'", q.tableName(), JobReady, JobDead))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Len returns the number of jobs that are waiting to be done, including claimed jobs
+func (q *Queue) Len() (int, error) {
+	var count int
+	err := q.host.queryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE status = '%s'", q.tableName(), JobReady)).Scan(&count)
+	return count, err
+}
+
+// Purge removes all jobs that are done, and were created before the given duration ago.
+// The number of removed jobs is returned.
+func (q *Queue) Purge(olderThan time.Duration) (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE status = '%s' AND created < now() - $1 * interval '1 microsecond'", q.tableName(), JobDone)
+	result, err := q.host.exec(query, olderThan.Microseconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Remove the queue
+func (q *Queue) Remove() error {
+	if _, err := q.host.exec(fmt.Sprintf("DROP TABLE %s", q.tableName())); err != nil {
+		return err
+	}
+	return q.unregister("queue", q.tableName())
+}
+
+// Clear all jobs
+func (q *Queue) Clear() error {
+	_, err := q.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", q.tableName()))
+	return err
+}
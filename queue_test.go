@@ -0,0 +1,173 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	q, err := NewQueue(host, "queue_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Clear()
+
+	if _, err := q.Claim(); err != ErrEmptyQueue {
+		t.Errorf("Error, expected ErrEmptyQueue, got %v", err)
+	}
+	id, err := q.Push("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Push("second")
+	job, err := q.Claim()
+	if err != nil || job.ID != id || job.Payload != "first" || job.Attempts != 1 {
+		t.Fatalf("Error, unexpected job: %v (%v)", job, err)
+	}
+	if err := job.Ack(); err != nil {
+		t.Error(err)
+	}
+	job, _ = q.Claim()
+	job.Retry(0, "try again")
+	job, err = q.Claim()
+	if err != nil || job.Payload != "second" || job.Attempts != 2 || job.LastError != "try again" {
+		t.Errorf("Error, unexpected job: %v (%v)", job, err)
+	}
+	job.Fail("giving up")
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Error, expected an empty queue, got %d jobs", n)
+	}
+
+	q.Remove()
+}
+
+func TestQueueDeadLetters(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	q, err := NewQueue(host, "queue_retry_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dlq, err := NewQueue(host, "queue_retry_test_dead")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Clear()
+	dlq.Clear()
+	q.SetMaxAttempts(2)
+
+	id, _ := q.Push("flaky")
+	job, _ := q.Claim()
+	job.Retry(0, "first failure")
+	job, _ = q.Claim()
+	if err := job.Retry(0, "second failure"); err != nil {
+		t.Fatal(err)
+	}
+	if dead, err := q.Inspect(JobDead, 10); err != nil || len(dead) != 1 || dead[0].LastError != "second failure" {
+		t.Errorf("Error, expected one dead job: %v (%v)", dead, err)
+	}
+	if err := q.Requeue(id); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := q.Claim(); err != nil || job.Attempts != 1 {
+		t.Errorf("Error, expected the requeued job to be claimable: %v (%v)", job, err)
+	}
+
+	q.Clear()
+	q.SetDeadLetterQueue(dlq)
+	q.Push("poison")
+	job, _ = q.Claim()
+	if err := job.Fail("can not be processed"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.Len(); n != 0 {
+		t.Errorf("Error, expected the job to be moved, got %d jobs", n)
+	}
+	job, err = dlq.Claim()
+	if err != nil || job.Payload != "poison" || job.LastError != "can not be processed" {
+		t.Errorf("Error, expected the job in the dead-letter queue: %v (%v)", job, err)
+	}
+
+	q.Remove()
+	dlq.Remove()
+}
+
+func TestQueuePriority(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	q, err := NewQueue(host, "queue_priority_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Clear()
+
+	q.PushPriority("low", -1)
+	q.Push("normal")
+	q.PushPriority("high", 10)
+	q.PushAt("later", 100, time.Now().Add(time.Hour))
+	for _, expected := range []string{"high", "normal", "low"} {
+		job, err := q.PopHighestPriority()
+		if err != nil || job.Payload != expected {
+			t.Fatalf("Error, expected %s, got %v (%v)", expected, job, err)
+		}
+		job.Ack()
+	}
+	if _, err := q.PopHighestPriority(); err != ErrEmptyQueue {
+		t.Errorf("Error, the scheduled job should not be ready yet, got %v", err)
+	}
+
+	q.Remove()
+}
+
+func TestQueueLease(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	q, err := NewQueue(host, "queue_lease_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Clear()
+
+	for _, payload := range []string{"a", "b", "c"} {
+		q.Push(payload)
+	}
+	jobs, err := q.Lease(2, time.Minute)
+	if err != nil || len(jobs) != 2 || jobs[0].Payload != "a" || jobs[1].Payload != "b" {
+		t.Fatalf("Error, expected a and b to be leased: %v (%v)", jobs, err)
+	}
+	jobs[0].Ack()
+	jobs[1].Nack()
+	jobs, err = q.Lease(10, time.Minute)
+	if err != nil || len(jobs) != 2 {
+		t.Errorf("Error, expected b and c to be leased: %v (%v)", jobs, err)
+	}
+	if jobs, _ := q.Lease(10, time.Minute); len(jobs) != 0 {
+		t.Errorf("Error, expected no jobs to be ready, got %v", jobs)
+	}
+
+	// A job that is claimed again after its lease ran out can not be acked by the first worker
+	q.Clear()
+	q.Push("d")
+	stale, err := q.Lease(1, 0)
+	if err != nil || len(stale) != 1 {
+		t.Fatalf("Error, expected d to be leased: %v (%v)", stale, err)
+	}
+	fresh, err := q.Lease(1, time.Minute)
+	if err != nil || len(fresh) != 1 {
+		t.Fatalf("Error, expected d to be leased again: %v (%v)", fresh, err)
+	}
+	if err := stale[0].Ack(); err != ErrLeaseLost {
+		t.Errorf("Error, expected ErrLeaseLost, got %v", err)
+	}
+	if err := fresh[0].Ack(); err != nil {
+		t.Error(err)
+	}
+
+	q.Remove()
+}
@@ -0,0 +1,225 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// maxEncodingLayers is how many layers of hex encoding Repair will peel off a value
+const maxEncodingLayers = 4
+
+// RepairReport contains the results of scanning a data structure for values
+// that are stored with the wrong encoding
+type RepairReport struct {
+	Scanned       int // the number of values that were examined
+	Raw           int // values that were stored as they are
+	Encoded       int // values that were hex encoded once
+	DoubleEncoded int // values that were hex encoded more than once
+	Rewritten     int // values that were (or would be) rewritten with the configured codec
+}
+
+// peelEncoding decodes a value for as long as it looks hex encoded,
+// and returns the plain value together with the number of layers that were removed
+func peelEncoding(value string) (string, int) {
+	layers := 0
+	for layers < maxEncodingLayers && value != "" {
+		decoded := value
+		if err := Decode(&decoded); err != nil {
+			break
+		}
+		value = decoded
+		layers++
+	}
+	return value, layers
+}
+
+// repairValue figures out how a stored value was encoded, and returns the value
+// as it should be stored with the codec of this data structure. The returned
+// bool is true if the value needs to be rewritten.
+func (d *dbDatastructure) repairValue(stored string, report *RepairReport) (string, bool, error) {
+	report.Scanned++
+	plain, layers := peelEncoding(stored)
+	switch layers {
+	case 0:
+		report.Raw++
+	case 1:
+		report.Encoded++
+	default:
+		report.DoubleEncoded++
+	}
+	wanted, err := d.getCodec().Encode(plain)
+	if err != nil {
+		return stored, false, err
+	}
+	if wanted == stored {
+		return stored, false, nil
+	}
+	report.Rewritten++
+	return wanted, true, nil
+}
+
+// repairBatches calls fetch until it returns fewer rows than batchSize,
+// and applies the returned updates in one transaction per batch.
+// An error is returned if batchSize is not positive.
+// fetch returns the updates to apply, the number of rows that were fetched
+// and the cursor to continue from.
+func (d *dbDatastructure) repairBatches(batchSize int, dryRun bool, fetch func(cursor string) ([][]interface{}, int, string, error), update string) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("the batch size must be positive, not %d", batchSize)
+	}
+	cursor := ""
+	for {
+		updates, fetched, next, err := fetch(cursor)
+		if err != nil {
+			return err
+		}
+		if !dryRun && len(updates) > 0 {
+			transaction, err := d.host.begin(d.host.context())
+			if err != nil {
+				return err
+			}
+			for _, args := range updates {
+				if _, err := transaction.ExecContext(d.host.context(), update, args...); err != nil {
+					transaction.Rollback()
+					return err
+				}
+			}
+			if err := transaction.Commit(); err != nil {
+				return err
+			}
+		}
+		if fetched < batchSize {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// scanPairs runs a query that returns two text columns, and returns them as pairs
+func (d *dbDatastructure) scanPairs(query string, args ...interface{}) ([][2]string, error) {
+	rows, err := d.host.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		pairs [][2]string
+		a, b  sql.NullString
+	)
+	for rows.Next() {
+		if err := rows.Scan(&a, &b); err != nil {
+			return pairs, err
+		}
+		pairs = append(pairs, [2]string{a.String, b.String})
+	}
+	return pairs, rows.Err()
+}
+
+// Repair scans all values in the list, detects values that are raw, hex encoded
+// or hex encoded more than once, and rewrites those that are not stored with the
+// codec of this list. Rows are processed in batches of the given size, with one
+// transaction per batch. If dryRun is true, nothing is rewritten.
+// An error is returned if batchSize is not positive.
+func (l *List) Repair(batchSize int, dryRun bool) (RepairReport, error) {
+	var report RepairReport
+	fetch := func(cursor string) ([][]interface{}, int, string, error) {
+		if cursor == "" {
+			cursor = "0"
+		}
+		pairs, err := l.scanPairs(fmt.Sprintf("SELECT id::text, %s FROM %s WHERE id > $1 ORDER BY id LIMIT %d", listCol, l.tableName(), batchSize), cursor)
+		if err != nil {
+			return nil, 0, cursor, err
+		}
+		var updates [][]interface{}
+		for _, pair := range pairs {
+			value, rewrite, err := l.repairValue(pair[1], &report)
+			if err != nil {
+				return nil, 0, cursor, err
+			}
+			if rewrite {
+				updates = append(updates, []interface{}{value, pair[0]})
+			}
+			cursor = pair[0]
+		}
+		return updates, len(pairs), cursor, nil
+	}
+	err := l.repairBatches(batchSize, dryRun, fetch, fmt.Sprintf("UPDATE %s SET %s = $1 WHERE id = $2::integer", l.tableName(), listCol))
+	return report, err
+}
+
+// Repair scans all values in the set, detects values that are raw, hex encoded
+// or hex encoded more than once, and rewrites those that are not stored with the
+// codec of this set. Values are processed in batches of the given size, with one
+// transaction per batch. If dryRun is true, nothing is rewritten.
+// An error is returned if batchSize is not positive.
+func (s *Set) Repair(batchSize int, dryRun bool) (RepairReport, error) {
+	var report RepairReport
+	first := true
+	fetch := func(cursor string) ([][]interface{}, int, string, error) {
+		query := fmt.Sprintf("SELECT DISTINCT %s, '' FROM %s WHERE %s IS NOT NULL ORDER BY %s LIMIT %d", setCol, s.tableName(), setCol, setCol, batchSize)
+		var args []interface{}
+		if !first {
+			query = fmt.Sprintf("SELECT DISTINCT %s, '' FROM %s WHERE %s > $1 ORDER BY %s LIMIT %d", setCol, s.tableName(), setCol, setCol, batchSize)
+			args = append(args, cursor)
+		}
+		first = false
+		pairs, err := s.scanPairs(query, args...)
+		if err != nil {
+			return nil, 0, cursor, err
+		}
+		var updates [][]interface{}
+		for _, pair := range pairs {
+			value, rewrite, err := s.repairValue(pair[0], &report)
+			if err != nil {
+				return nil, 0, cursor, err
+			}
+			if rewrite {
+				updates = append(updates, []interface{}{value, pair[0]})
+			}
+			cursor = pair[0]
+		}
+		return updates, len(pairs), cursor, nil
+	}
+	err := s.repairBatches(batchSize, dryRun, fetch, fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", s.tableName(), setCol, setCol))
+	return report, err
+}
+
+// Repair scans all values in the key/value, detects values that are raw, hex encoded
+// or hex encoded more than once, and rewrites those that are not stored with the
+// codec of this key/value. Keys are processed in batches of the given size, with one
+// transaction per batch. If dryRun is true, nothing is rewritten.
+// An error is returned if batchSize is not positive.
+func (kv *KeyValue) Repair(batchSize int, dryRun bool) (RepairReport, error) {
+	var report RepairReport
+	fetch := func(cursor string) ([][]interface{}, int, string, error) {
+		pairs, err := kv.scanPairs(fmt.Sprintf("SELECT key, value FROM (SELECT (each(attr)).* FROM %s) AS temp WHERE key > $1 ORDER BY key LIMIT %d", kv.tableName(), batchSize), cursor)
+		if err != nil {
+			return nil, 0, cursor, err
+		}
+		var updates [][]interface{}
+		for _, pair := range pairs {
+			value, rewrite, err := kv.repairValue(pair[1], &report)
+			if err != nil {
+				return nil, 0, cursor, err
+			}
+			if rewrite {
+				updates = append(updates, []interface{}{pair[0], value})
+			}
+			cursor = pair[0]
+		}
+		return updates, len(pairs), cursor, nil
+	}
+	err := kv.repairBatches(batchSize, dryRun, fetch, fmt.Sprintf("UPDATE %s SET attr = attr || hstore($1::text, $2::text)", kv.tableName()))
+	return report, err
+}
+
+// Repair scans all values in the hash map, detects values that are raw, hex encoded
+// or hex encoded more than once, and rewrites those that are not stored with the
+// codec of this hash map. Properties are processed in batches of the given size,
+// with one transaction per batch. If dryRun is true, nothing is rewritten.
+func (hm2 *HashMap2) Repair(batchSize int, dryRun bool) (RepairReport, error) {
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return RepairReport{}, err
+	}
+	return hm2.keyValue().Repair(batchSize, dryRun)
+}
@@ -0,0 +1,102 @@
+package simplehstore
+
+import (
+	"testing"
+)
+
+func TestPeelEncoding(t *testing.T) {
+	plain := "hello there"
+	if v, layers := peelEncoding(plain); v != plain || layers != 0 {
+		t.Errorf("Error, expected a raw value, got %s with %d layers", v, layers)
+	}
+	once := plain
+	Encode(&once)
+	if v, layers := peelEncoding(once); v != plain || layers != 1 {
+		t.Errorf("Error, expected one layer, got %s with %d layers", v, layers)
+	}
+	twice := once
+	Encode(&twice)
+	if v, layers := peelEncoding(twice); v != plain || layers != 2 {
+		t.Errorf("Error, expected two layers, got %s with %d layers", v, layers)
+	}
+}
+
+func TestRepairKeyValue(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "repair_test", WithCodec(HexCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+
+	// Write values as if the codec had been changed along the way
+	rawKV := &KeyValue{kv.dbDatastructure}
+	rawKV.codec = RawCodec
+	doubleEncoded := "c"
+	Encode(&doubleEncoded)
+	Encode(&doubleEncoded)
+	kv.Set("a", "a")
+	rawKV.Set("b", "b")
+	rawKV.Set("c", doubleEncoded)
+
+	report, err := kv.Repair(2, false)
+	if err != nil {
+		t.Fatalf("Error, could not repair! %s", err)
+	}
+	if report.Scanned != 3 || report.Raw != 1 || report.Encoded != 1 || report.DoubleEncoded != 1 || report.Rewritten != 2 {
+		t.Errorf("Error, unexpected repair report: %+v", report)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if v, err := kv.Get(key); err != nil || v != key {
+			t.Errorf("Error, expected %s after repairing, got %s (%v)", key, v, err)
+		}
+	}
+	if _, err := kv.Repair(0, true); err == nil {
+		t.Error("Error, expected a batch size of 0 to be rejected")
+	}
+
+	kv.Remove()
+}
+
+func TestFsck(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	hm2, err := NewHashMap2(host, "fsck_test", WithCodec(HexCodec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hm2.Clear()
+	hm2.Set("bob", "email", "bob@example.com")
+	hm2.propSet().Add("unused")
+
+	// Write keys and values behind the back of the hash map
+	rawKV := &KeyValue{hm2.keyValue().dbDatastructure}
+	rawKV.codec = RawCodec
+	doubleEncoded := "2"
+	Encode(&doubleEncoded)
+	Encode(&doubleEncoded)
+	rawKV.Set("stray", "x")
+	rawKV.Set("bob"+fieldSep+"age", doubleEncoded)
+
+	report, err := hm2.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || len(report.MissingSeparator) != 1 || len(report.MissingProps) != 1 || len(report.OrphanedProps) != 1 || len(report.Misencoded) != 1 || report.Repaired != 0 {
+		t.Errorf("Error, unexpected fsck report: %+v", report)
+	}
+	if report, err = hm2.Fsck(true); err != nil || report.Repaired != 4 {
+		t.Errorf("Error, expected 4 repairs, got %+v (%v)", report, err)
+	}
+	if report, err = hm2.Fsck(false); err != nil || !report.OK() {
+		t.Errorf("Error, expected no problems after repairing, got %+v (%v)", report, err)
+	}
+	if v, err := hm2.Get("bob", "age"); err != nil || v != "2" {
+		t.Errorf("Error, expected 2 after repairing, got %s (%v)", v, err)
+	}
+
+	hm2.Remove()
+}
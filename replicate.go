@@ -0,0 +1,439 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultReplicateInterval is how often Replicate looks for new data
+// structures, and compares the checksums of the ones without a change stream
+const DefaultReplicateInterval = 10 * time.Second
+
+// replica copies a single data structure from one Host to another
+type replica struct {
+	name           string
+	srcSum, dstSum func() (string, error)
+	copyTo         func(*Transaction) error
+
+	// stream returns the change stream of the data structure in src, and
+	// apply applies a change to dst. Both are nil for data structures that
+	// do not have a change stream, which are compared by checksum instead.
+	stream func() (*ChangeStream, error)
+	apply  func(StreamEvent) error
+}
+
+// Replicate makes dst a read-only mirror of the lists, sets, key/values,
+// hash maps and HashMap2 data structures in src, for instance for migrating
+// data between PostgreSQL clusters with minimal downtime. All data structures
+// are copied first, and then kept up to date until ctx is cancelled.
+//
+// Key/values and HashMap2 data structures are kept up to date incrementally,
+// by consuming a ChangeStream in src, which is created if needed. The offset
+// of the consumer is stored in src, so that a Replicate that is restarted
+// continues where it stopped. Note that creating a stream serializes the
+// writes to the data structure in src. The expiry of keys and owners is
+// copied along with each change, but calling only Persist, ExpireOwner or
+// PersistOwner in src is not a change to the stream.
+//
+// Lists, sets, hash maps and HashMap2 data structures with the column layout
+// do not have change streams, and are copied again when their checksums
+// differ. The checksums are compared every interval, which is also how often
+// new data structures in src are picked up.
+//
+// The checksums are only equal if the data structures use the same codec on
+// both sides, so the options should match the ones the data structures in src
+// were created with. Data structures that are removed from src are not
+// removed from dst. Only one Replicate should run for each dst database.
+func Replicate(ctx context.Context, src, dst *Host, interval time.Duration, opts ...Option) error {
+	if interval <= 0 {
+		interval = DefaultReplicateInterval
+	}
+	consumer := "replicate to " + dst.dbname
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	var (
+		known   = make(map[string]bool) // kinds and tables
+		polled  []*replica
+		streams []*ChangeStream
+	)
+	for ctx.Err() == nil {
+		// Pick up data structures that have been added since the last pass
+		structures, err := src.Discover()
+		if err != nil {
+			fail(err)
+			break
+		}
+		for _, s := range structures {
+			if known[s.Kind+" "+s.Table] {
+				continue
+			}
+			r, err := newReplica(s, src, dst, opts)
+			if err != nil {
+				fail(err)
+				break
+			}
+			known[s.Kind+" "+s.Table] = true
+			if r == nil {
+				continue
+			}
+			stream, err := r.follow(consumer, dst)
+			if err != nil {
+				fail(err)
+				break
+			}
+			if stream == nil {
+				polled = append(polled, r)
+			} else {
+				streams = append(streams, stream)
+				wg.Add(1)
+				go func(r *replica) {
+					defer wg.Done()
+					err := stream.Consume(ctx, consumer, func(_ *Transaction, e StreamEvent) error {
+						return r.apply(e)
+					})
+					if err != nil {
+						fail(err)
+					}
+				}(r)
+			}
+		}
+		for _, r := range polled {
+			if ctx.Err() != nil {
+				break
+			}
+			if _, err := r.sync(dst); err != nil {
+				fail(err)
+			}
+		}
+		// Remove the events that have been replicated
+		for _, stream := range streams {
+			if ctx.Err() != nil {
+				break
+			}
+			if _, err := stream.Trim(); err != nil {
+				fail(err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+	cancel()
+	wg.Wait()
+	return firstErr
+}
+
+// follow makes the replica follow the change stream of the data structure in
+// src, if it has one, and copies the data structure if it differs. The offset
+// of a new consumer is placed at the end of the stream before copying, so that
+// the changes that are made while copying are applied afterwards.
+// nil is returned if the data structure does not have a change stream, and
+// nothing is copied.
+func (r *replica) follow(consumer string, dst *Host) (*ChangeStream, error) {
+	if r.stream == nil {
+		return nil, nil
+	}
+	stream, err := r.stream()
+	if err == ErrUnsupportedLayout {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	offset, err := stream.Offset(consumer)
+	if err != nil {
+		return nil, err
+	}
+	if offset == 0 {
+		head, err := stream.head()
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Ack(consumer, head); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := r.sync(dst); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// ReplicateOnce copies all lists, sets, key/values, hash maps and HashMap2
+// data structures from src to dst that have different contents, and returns
+// the names of the data structures that were copied. Each data structure is
+// replaced in a single transaction, so that readers of dst never see a data
+// structure that is only partially copied. This is a full copy, which does
+// not use or create change streams.
+func ReplicateOnce(src, dst *Host, opts ...Option) ([]string, error) {
+	structures, err := src.Discover()
+	if err != nil {
+		return []string{}, err
+	}
+	copied := []string{}
+	for _, s := range structures {
+		r, err := newReplica(s, src, dst, opts)
+		if err != nil {
+			return copied, err
+		}
+		if r == nil {
+			continue
+		}
+		changed, err := r.sync(dst)
+		if err != nil {
+			return copied, err
+		}
+		if changed {
+			copied = append(copied, s.Name)
+		}
+	}
+	return copied, nil
+}
+
+// newReplica opens the given data structure in src and dst, creating it in
+// dst if needed. nil is returned for kinds of data structures that can not be
+// replicated.
+func newReplica(s Structure, src, dst *Host, opts []Option) (*replica, error) {
+	r := &replica{name: s.Name}
+	switch s.Kind {
+	case "list":
+		from, err := NewList(src, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		to, err := NewList(dst, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		r.srcSum, r.dstSum = from.Checksum, to.Checksum
+		r.copyTo = func(tx *Transaction) error {
+			values, err := from.All()
+			if err != nil {
+				return err
+			}
+			to := to.WithTx(tx)
+			if err := to.Clear(); err != nil {
+				return err
+			}
+			for _, value := range values {
+				if err := to.Add(value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case "set":
+		from, err := NewSet(src, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		to, err := NewSet(dst, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		r.srcSum, r.dstSum = from.Checksum, to.Checksum
+		r.copyTo = func(tx *Transaction) error {
+			values, err := from.All()
+			if err != nil {
+				return err
+			}
+			to := to.WithTx(tx)
+			if err := to.Clear(); err != nil {
+				return err
+			}
+			for _, value := range values {
+				if err := to.Add(value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case "keyvalue":
+		from, err := NewKeyValue(src, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		to, err := NewKeyValue(dst, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		r.srcSum, r.dstSum = from.Checksum, to.Checksum
+		r.copyTo = func(tx *Transaction) error {
+			pairs, err := from.pairs()
+			if err != nil {
+				return err
+			}
+			to := to.WithTx(tx)
+			if err := to.Clear(); err != nil {
+				return err
+			}
+			for key, value := range pairs {
+				if err := to.Set(key, value); err != nil {
+					return err
+				}
+			}
+			return copyExpiries(from, to, "expires")
+		}
+		r.stream = from.Stream
+		r.apply = func(e StreamEvent) error {
+			switch e.Op {
+			case "insert", "update":
+				if err := to.Set(e.Key, e.New); err != nil {
+					return err
+				}
+				return copyExpiry(from, to, "expires", e.Key)
+			case "delete":
+				return to.Del(e.Key)
+			case "clear":
+				return to.Clear()
+			}
+			return nil
+		}
+	case "hashmap":
+		from, err := NewHashMap(src, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		to, err := NewHashMap(dst, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		r.srcSum, r.dstSum = from.Checksum, to.Checksum
+		r.copyTo = func(tx *Transaction) error {
+			owners, err := from.All()
+			if err != nil {
+				return err
+			}
+			to := to.WithTx(tx)
+			if err := to.Clear(); err != nil {
+				return err
+			}
+			for _, owner := range owners {
+				keys, err := from.Keys(owner)
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					value, err := from.Get(owner, key)
+					if err != nil {
+						return err
+					}
+					if err := to.Set(owner, key, value); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	case "hashmap2":
+		from, err := NewHashMap2(src, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		to, err := NewHashMap2(dst, s.Name, opts...)
+		if err != nil {
+			return nil, err
+		}
+		r.srcSum, r.dstSum = from.Checksum, to.Checksum
+		r.copyTo = func(tx *Transaction) error {
+			owners, err := from.All()
+			if err != nil {
+				return err
+			}
+			allProperties := make(map[string]map[string]string, len(owners))
+			for _, owner := range owners {
+				if allProperties[owner], err = from.GetAll(owner); err != nil {
+					return err
+				}
+			}
+			to := to.WithTx(tx)
+			if err := to.Clear(); err != nil {
+				return err
+			}
+			if err := to.SetLargeMap(allProperties); err != nil {
+				return err
+			}
+			return copyExpiries(from.keyValue(), to.keyValue(), "owner_expires")
+		}
+		r.stream = from.Stream
+		r.apply = func(e StreamEvent) error {
+			if e.Op != "clear" && e.Owner == "" {
+				// Not a property of an owner
+				return nil
+			}
+			switch e.Op {
+			case "insert", "update":
+				if err := to.Set(e.Owner, e.Key, e.New); err != nil {
+					return err
+				}
+				return copyExpiry(from.keyValue(), to.keyValue(), "owner_expires", e.Owner)
+			case "delete":
+				return to.DelKey(e.Owner, e.Key)
+			case "clear":
+				return to.Clear()
+			}
+			return nil
+		}
+	default:
+		return nil, nil
+	}
+	return r, nil
+}
+
+// sync copies the data structure to dst if the checksums differ, and returns
+// true if it was copied
+func (r *replica) sync(dst *Host) (bool, error) {
+	a, err := r.srcSum()
+	if err != nil {
+		return false, err
+	}
+	b, err := r.dstSum()
+	if err != nil {
+		return false, err
+	}
+	if a == b {
+		return false, nil
+	}
+	return true, dst.InTransaction(r.copyTo)
+}
+
+// copyExpiries replaces the expiry column of to with the expiries in from,
+// for the keys or owners that have not expired yet
+func copyExpiries(from, to *KeyValue, column string) error {
+	var expiries sql.NullString
+	query := fmt.Sprintf("SELECT hstore(array_agg(e.key), array_agg(e.value))::text FROM %s AS t, each(t.%s) AS e WHERE e.value::timestamptz > now()", from.tableName(), column)
+	if err := from.host.queryRow(query).Scan(&expiries); err != nil && !noResult(err) {
+		return err
+	}
+	_, err := to.host.exec(fmt.Sprintf("UPDATE %s SET %s = COALESCE($1::hstore, hstore(''))", to.tableName(), column), expiries)
+	return err
+}
+
+// copyExpiry copies the expiry of a single key or owner from one expiry column to another
+func copyExpiry(from, to *KeyValue, column, key string) error {
+	var expires sql.NullString
+	query := fmt.Sprintf("SELECT %s -> $1 FROM %s", column, from.tableName())
+	if err := from.host.queryRow(query, key).Scan(&expires); err != nil && !noResult(err) {
+		return err
+	}
+	if !expires.Valid {
+		_, err := to.host.exec(fmt.Sprintf("UPDATE %s SET %s = delete(%s, $1)", to.tableName(), column, column), key)
+		return err
+	}
+	_, err := to.host.exec(fmt.Sprintf("UPDATE %s SET %s = %s || hstore($1, $2)", to.tableName(), column, column), key, expires.String)
+	return err
+}
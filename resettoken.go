@@ -0,0 +1,123 @@
+package simplehstore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// resetTokenBytes is the number of random bytes in a password reset token
+const resetTokenBytes = 24
+
+// ErrInvalidToken is returned when a token does not exist, has expired or has already been used
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// ResetTokenStore keeps single-use password reset tokens that expire.
+// Only a SHA-256 hash of each token is stored.
+type ResetTokenStore struct {
+	dbDatastructure
+	ttl time.Duration
+}
+
+// NewResetTokenStore creates a new ResetTokenStore where issued tokens are valid for the given duration
+func NewResetTokenStore(provider HostProvider, name string, ttl time.Duration, opts ...Option) (*ResetTokenStore, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	r := &ResetTokenStore{newDatastructure(host, pq.QuoteIdentifier(name), opts), ttl}
+	if err := r.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := r.exists(r.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (token_hash TEXT PRIMARY KEY, username TEXT NOT NULL, expires TIMESTAMPTZ NOT NULL)", r.tableName())
+	if _, err := r.host.exec(query); err != nil {
+		return nil, err
+	}
+	if err := r.register("resettokenstore", name, r.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if r.verbose() {
+		r.host.Logger().Println("Created reset token table " + r.tableName() + " in database " + host.dbname)
+	}
+	return r, nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of the given token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a new reset token for the given user. Any earlier tokens
+// for the same user are invalidated.
+func (r *ResetTokenStore) Issue(username string) (string, error) {
+	b := make([]byte, resetTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	ctx := r.host.context()
+	transaction, err := r.host.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE username = $1", r.tableName()), username); err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+	if _, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (token_hash, username, expires) VALUES ($1, $2, now() + $3 * interval '1 microsecond')", r.tableName()), hashToken(token), username, r.ttl.Microseconds()); err != nil {
+		transaction.Rollback()
+		return "", err
+	}
+	return token, transaction.Commit()
+}
+
+// Redeem validates and consumes the given token, and returns the username it was issued for.
+// A token can only be redeemed once.
+func (r *ResetTokenStore) Redeem(token string) (string, error) {
+	var username string
+	err := r.host.queryRow(fmt.Sprintf("DELETE FROM %s WHERE token_hash = $1 AND expires > now() RETURNING username", r.tableName()), hashToken(token)).Scan(&username)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	return username, err
+}
+
+// Revoke invalidates all tokens issued for the given user
+func (r *ResetTokenStore) Revoke(username string) error {
+	_, err := r.host.exec(fmt.Sprintf("DELETE FROM %s WHERE username = $1", r.tableName()), username)
+	return err
+}
+
+// ExpireOldTokens removes all expired tokens, and returns how many were removed
+func (r *ResetTokenStore) ExpireOldTokens() (int64, error) {
+	result, err := r.host.exec(fmt.Sprintf("DELETE FROM %s WHERE expires <= now()", r.tableName()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Remove the table with reset tokens
+func (r *ResetTokenStore) Remove() error {
+	if _, err := r.host.exec(fmt.Sprintf("DROP TABLE %s", r.tableName())); err != nil {
+		return err
+	}
+	return r.unregister("resettokenstore", r.tableName())
+}
+
+// Clear all reset tokens
+func (r *ResetTokenStore) Clear() error {
+	_, err := r.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", r.tableName()))
+	return err
+}
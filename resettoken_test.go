@@ -0,0 +1,48 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResetTokenStore(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	tokens, err := NewResetTokenStore(host, "reset_token_test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens.Clear()
+
+	token, err := tokens.Issue("bob")
+	if err != nil {
+		t.Fatalf("Error, could not issue token! %s", err)
+	}
+	if username, err := tokens.Redeem(token); err != nil || username != "bob" {
+		t.Errorf("Error, expected bob, got %s (%v)", username, err)
+	}
+	if _, err := tokens.Redeem(token); err != ErrInvalidToken {
+		t.Errorf("Error, a token should only be redeemable once, got %v", err)
+	}
+
+	first, _ := tokens.Issue("alice")
+	second, _ := tokens.Issue("alice")
+	if _, err := tokens.Redeem(first); err != ErrInvalidToken {
+		t.Error("Error, issuing a new token should invalidate the old one")
+	}
+	if username, err := tokens.Redeem(second); err != nil || username != "alice" {
+		t.Errorf("Error, expected alice, got %s (%v)", username, err)
+	}
+
+	expired, err := NewResetTokenStore(host, "reset_token_test", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ = expired.Issue("carol")
+	if _, err := expired.Redeem(token); err != ErrInvalidToken {
+		t.Error("Error, an expired token should not be redeemable")
+	}
+
+	tokens.Remove()
+}
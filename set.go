@@ -5,59 +5,148 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"math/rand"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
 
 // Set is a set of strings, stored in PostgreSQL
-type Set dbDatastructure
+type Set struct {
+	dbDatastructure
+}
+
+// setLive is the condition for members that have not expired
+const setLive = "(expires IS NULL OR expires > now())"
 
 // NewSet creates a new set
-func NewSet(host *Host, name string) (*Set, error) {
-	s := &Set{host, pq.QuoteIdentifier(name)} // name is the name of the table
+func NewSet(provider HostProvider, name string, opts ...Option) (*Set, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{newDatastructure(host, pq.QuoteIdentifier(name), opts)} // name is the name of the table
+	if err := s.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := s.exists(s.tableName())
+	if err != nil {
+		return nil, err
+	}
 	// list is the name of the column
-	if _, err := s.host.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s %s)", s.table, setCol, defaultStringType)); err != nil {
+	if _, err := s.host.exec(fmt.Sprintf("%s %s (%s %s)", s.createTable(), s.tableName(), setCol, defaultStringType)); err != nil {
 		if !strings.HasSuffix(err.Error(), "already exists") {
 			return nil, err
 		}
 	}
-	if Verbose {
-		log.Println("Created table " + s.table + " in database " + host.dbname)
+	// Sets created by earlier versions do not have an expires column
+	if err := s.addColumn(s.tableName(), "expires", "TIMESTAMPTZ"); err != nil {
+		return nil, err
+	}
+	if err := s.addInsertionOrder(); err != nil {
+		return nil, err
+	}
+	if err := s.linkOwners(); err != nil {
+		return nil, err
+	}
+	if err := s.setStorageParameters(s.tableName()); err != nil {
+		return nil, err
+	}
+	if err := s.register("set", name, s.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if s.index {
+		if _, err := s.host.exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", pq.QuoteIdentifier(name+"_idx"), s.tableName(), setCol)); err != nil {
+			return nil, err
+		}
+	}
+	if s.verbose() {
+		s.host.Logger().Println("Created table " + s.tableName() + " in database " + host.dbname)
 	}
 	return s, nil
 }
 
-// Add an element to the set
+// Add an element to the set.
+// If the set is created WithTTL, the element expires, as with AddExpiring.
 func (s *Set) Add(value string) error {
+	if s.ttl > 0 {
+		return s.AddExpiring(value, s.ttl)
+	}
 	originalValue := value
-	if !s.host.rawUTF8 {
-		Encode(&value)
+	if err := s.encode(&value); err != nil {
+		return err
 	}
 	// Check that the value is not already there before adding
 	has, err := s.Has(originalValue)
 	if !has || noResult(err) {
-		_, err = s.host.db.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1)", s.table, setCol), value)
+		_, err = s.host.exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1)", s.tableName(), setCol), value)
+	}
+	return err
+}
+
+// AddAll adds the given elements to the set, with a single statement.
+// Elements that are already in the set, and duplicates among the given
+// elements, are only added once.
+func (s *Set) AddAll(values []string) error {
+	encoded := make([]string, len(values))
+	for i, value := range values {
+		encoded[i] = value
+		if err := s.encode(&encoded[i]); err != nil {
+			return err
+		}
 	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) SELECT DISTINCT v FROM unnest($1::text[]) AS v WHERE NOT EXISTS (SELECT 1 FROM %s WHERE %s = v AND %s)", s.tableName(), setCol, s.tableName(), setCol, setLive)
+	_, err := s.host.exec(query, pq.Array(encoded))
 	return err
 }
 
 // Add an element to the set, with a transaction, without checking if it exists already
-func (s *Set) addWithTransactionNoCheck(ctx context.Context, transaction *sql.Tx, value string) error {
-	if !s.host.rawUTF8 {
-		Encode(&value)
+func (s *Set) addWithTransactionNoCheck(ctx context.Context, transaction queryer, value string) error {
+	if err := s.encode(&value); err != nil {
+		return err
+	}
+	_, err := transaction.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1)", s.tableName(), setCol), value)
+	return err
+}
+
+// AddExpiring adds an element to the set, that is removed from the set after
+// the given duration. Expired elements are not returned by Has, All or Count,
+// and are deleted by ExpireMembers. If the element is already in the set,
+// the expiry time is updated.
+func (s *Set) AddExpiring(value string, ttl time.Duration) error {
+	if err := s.encode(&value); err != nil {
+		return err
+	}
+	result, err := s.host.exec(fmt.Sprintf("UPDATE %s SET expires = now() + $2 * interval '1 microsecond' WHERE %s = $1", s.tableName(), setCol), value, ttl.Microseconds())
+	if err != nil {
+		return err
 	}
-	_, err := transaction.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES ($1)", s.table, setCol), value)
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.host.exec(fmt.Sprintf("INSERT INTO %s (%s, expires) VALUES ($1, now() + $2 * interval '1 microsecond')", s.tableName(), setCol), value, ttl.Microseconds())
 	return err
 }
 
+// ExpireMembers deletes all expired elements, and returns the number of
+// deleted rows. This can be called periodically, for sets that are
+// added to with AddExpiring.
+func (s *Set) ExpireMembers() (int64, error) {
+	result, err := s.host.exec(fmt.Sprintf("DELETE FROM %s WHERE expires <= now()", s.tableName()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // Has checks if the given value is in the set
 func (s *Set) Has(value string) (bool, error) {
-	if !s.host.rawUTF8 {
-		Encode(&value)
+	if err := s.encode(&value); err != nil {
+		return false, err
 	}
-	rows, err := s.host.db.Query(fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1", setCol, s.table, setCol), value)
+	rows, err := s.host.query(fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1 AND %s", setCol, s.tableName(), setCol, setLive), value)
 	if err != nil {
 		return false, err
 	}
@@ -91,7 +180,7 @@ func (s *Set) All() ([]string, error) {
 		values []string
 		value  sql.NullString
 	)
-	rows, err := s.host.db.Query(fmt.Sprintf("SELECT DISTINCT %s FROM %s", setCol, s.table))
+	rows, err := s.host.query(fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s", setCol, s.tableName(), setLive))
 	if err != nil {
 		return values, err
 	}
@@ -101,84 +190,212 @@ func (s *Set) All() ([]string, error) {
 	defer rows.Close()
 	for rows.Next() {
 		err = rows.Scan(&value)
-		vs := value.String
-		if !s.host.rawUTF8 {
-			Decode(&vs)
-		}
-		values = append(values, vs)
+		values = append(values, value.String)
 		if err != nil {
 			return values, err
 		}
 	}
-	err = rows.Err()
+	if err = rows.Err(); err != nil {
+		return values, err
+	}
+	rows.Close()
+	err = s.decodeAll(values)
 	return values, err
 }
 
-// GetAll is deprecated in favor of All
+// Any returns an arbitrary element, and true, or false if the set is empty.
+// Only one element is fetched from the database.
+func (s *Set) Any() (string, bool, error) {
+	var value string
+	if err := s.host.queryRow(fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT NULL AND %s LIMIT 1", setCol, s.tableName(), setCol, setLive)).Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	if err := s.decode(&value); err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// GetAll returns the same as All.
+//
+// Deprecated: use All instead.
 func (s *Set) GetAll() ([]string, error) {
 	return s.All()
 }
 
 // Del removes an element from the set
 func (s *Set) Del(value string) error {
-	if !s.host.rawUTF8 {
-		Encode(&value)
+	if err := s.encode(&value); err != nil {
+		return err
 	}
 	// Remove a value from the table
-	_, err := s.host.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = '%s'", s.table, setCol, value))
+	_, err := s.host.exec(fmt.Sprintf("DELETE FROM %s WHERE %s = '%s'", s.tableName(), setCol, value))
 	return err
 }
 
-// Remove this set
-func (s *Set) Remove() error {
-	// Remove the table
-	_, err := s.host.db.Exec(fmt.Sprintf("DROP TABLE %s", s.table))
-	return err
+// MoveMember removes the given value from this set and adds it to the other
+// set, in a single statement, so that the value is never visible in both sets
+// or in neither. Returns true if the value was in this set.
+func (s *Set) MoveMember(value string, dest *Set) (bool, error) {
+	if err := s.checkTransfer(dest); err != nil {
+		return false, err
+	}
+	if err := s.encode(&value); err != nil {
+		return false, err
+	}
+	n, err := s.transfer(dest, fmt.Sprintf("%s = $1", setCol), value)
+	return n > 0, err
 }
 
-// Clear the list contents
-func (s *Set) Clear() error {
-	// Clear the table
-	_, err := s.host.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s", s.table))
-	return err
+// TransferAll moves all values from this set to the other set, in a single
+// statement, and returns the number of values that were moved
+func (s *Set) TransferAll(dest *Set) (int64, error) {
+	if err := s.checkTransfer(dest); err != nil {
+		return 0, err
+	}
+	return s.transfer(dest, fmt.Sprintf("%s IS NOT NULL", setCol))
 }
 
-// Count counts the number of elements in this list
-func (s *Set) Count() (int, error) {
-	var value sql.NullInt32
-	rows, err := s.host.db.Query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", setCol, s.table))
-	if err != nil {
-		return 0, err
+// checkTransfer checks that values can be moved from this set to the other set
+func (s *Set) checkTransfer(dest *Set) error {
+	if s.getCodec().Name() != dest.getCodec().Name() {
+		return fmt.Errorf("can not move values between sets with the %q and %q codecs", s.getCodec().Name(), dest.getCodec().Name())
 	}
-	if rows == nil {
-		return 0, ErrNoAvailableValues
+	if s.tableName() == dest.tableName() {
+		return errors.New("can not move values from a set to itself")
 	}
-	defer rows.Close()
-	if rows.Next() {
-		err = rows.Scan(&value)
-		if err != nil {
-			return 0, err
+	return nil
+}
+
+// transfer moves the values that match the given condition to the other set,
+// and returns the number of distinct values that were moved. Expired values
+// are removed, but not moved, while values that expire later keep expiring.
+func (s *Set) transfer(dest *Set, condition string, args ...interface{}) (int64, error) {
+	query := fmt.Sprintf("WITH moved AS (DELETE FROM %s WHERE %s RETURNING %s AS value, expires), live AS (SELECT value, CASE WHEN bool_or(expires IS NULL) THEN NULL ELSE max(expires) END AS expires FROM moved WHERE %s GROUP BY value), added AS (INSERT INTO %s (%s, expires) SELECT value, expires FROM live WHERE value NOT IN (SELECT %s FROM %s WHERE %s IS NOT NULL AND %s)) SELECT COUNT(*) FROM live",
+		s.tableName(), condition, setCol, setLive,
+		dest.tableName(), setCol, setCol, dest.tableName(), setCol, setLive)
+	var n int64
+	err := s.host.queryRow(query, args...).Scan(&n)
+	return n, err
+}
+
+// WeightedRandom returns a random member of the set, where the probability of
+// each member is proportional to its weight in the given key/value. Members
+// without a positive numeric weight, or whose weight has expired, are never
+// selected, and ErrNotFound is returned if no member has a weight. If both the
+// set and the key/value store values as they are (RawCodec), the selection is
+// done by the database server. If not, the members and weights are read and
+// the selection is done locally.
+func (s *Set) WeightedRandom(weights *KeyValue) (string, error) {
+	if s.getCodec().Name() != RawCodec.Name() || weights.getCodec().Name() != RawCodec.Name() {
+		return s.weightedRandomLocal(weights)
+	}
+	query := fmt.Sprintf("SELECT m FROM (SELECT s.m, CASE WHEN w.value ~ '^[0-9]+(\\.[0-9]+)?$' THEN w.value::float8 ELSE 0 END AS weight FROM (SELECT DISTINCT %s AS m FROM %s WHERE %s IS NOT NULL AND %s) AS s JOIN (SELECT e.key, e.value FROM %s AS t, each(t.attr) AS e WHERE %s) AS w ON w.key = s.m) AS temp WHERE weight > 0 ORDER BY -ln(1 - random()) / weight LIMIT 1",
+		setCol, s.tableName(), setCol, setLive, weights.tableName(), liveKey("e.key"))
+	var member string
+	if err := s.host.queryRow(query).Scan(&member); err != nil {
+		if err == sql.ErrNoRows {
+			return "", mark(ErrNotFound, "no member of the set has a weight")
 		}
+		return "", err
 	}
-	return int(value.Int32), nil
+	return member, nil
 }
 
-// CountInt64 counts the number of elements in this list (int64)
-func (s *Set) CountInt64() (int64, error) {
-	var value sql.NullInt64
-	rows, err := s.host.db.Query(fmt.Sprintf("SELECT COUNT(*) FROM (SELECT DISTINCT %s FROM %s) as temp", setCol, s.table))
+// weightedRandomLocal reads all members and weights, and selects a member locally
+func (s *Set) weightedRandomLocal(weights *KeyValue) (string, error) {
+	members, err := s.All()
 	if err != nil {
-		return 0, err
+		return "", err
 	}
-	if rows == nil {
-		return 0, ErrNoAvailableValues
+	pairs, err := weights.pairs()
+	if err != nil {
+		return "", err
 	}
-	defer rows.Close()
-	if rows.Next() {
-		err = rows.Scan(&value)
-		if err != nil {
-			return 0, err
+	member := weightedChoice(members, pairs, rand.Float64())
+	if member == "" {
+		return "", mark(ErrNotFound, "no member of the set has a weight")
+	}
+	return member, nil
+}
+
+// weightedChoice selects a member, given a random number in [0, 1).
+// An empty string is returned if no member has a positive weight.
+func weightedChoice(members []string, weights map[string]string, r float64) string {
+	total := 0.0
+	positive := make([]float64, len(members))
+	for i, member := range members {
+		if w, err := strconv.ParseFloat(weights[member], 64); err == nil && w > 0 {
+			positive[i] = w
+			total += w
 		}
 	}
-	return value.Int64, nil
+	if total == 0 {
+		return ""
+	}
+	target := r * total
+	for i, w := range positive {
+		if w == 0 {
+			continue
+		}
+		if target < w {
+			return members[i]
+		}
+		target -= w
+	}
+	// Rounding errors, select the last member with a weight
+	for i := len(members) - 1; i >= 0; i-- {
+		if positive[i] > 0 {
+			return members[i]
+		}
+	}
+	return ""
+}
+
+// Remove this set
+func (s *Set) Remove() error {
+	if err := s.unlinkOwners(); err != nil {
+		return err
+	}
+	// Remove the table
+	if _, err := s.host.exec(fmt.Sprintf("DROP TABLE %s", s.tableName())); err != nil {
+		return err
+	}
+	return s.unregister("set", s.tableName())
+}
+
+// Clear the list contents
+func (s *Set) Clear() error {
+	// Clear the table
+	_, err := s.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", s.tableName()))
+	return err
+}
+
+// Checksum returns an md5 sum of all elements in the set, in sorted order.
+// The sum is calculated by the database server, and can be used for
+// checking that two sets have the same contents.
+func (s *Set) Checksum() (string, error) {
+	return s.host.checksum(fmt.Sprintf("SELECT md5(COALESCE(string_agg(%s, E'\\n' ORDER BY %s), '')) FROM (SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL AND %s) AS temp", setCol, setCol, setCol, s.tableName(), setCol, setLive))
+}
+
+// IsEmpty checks if the set has no elements, without counting them
+func (s *Set) IsEmpty() (bool, error) {
+	return s.host.noRows(fmt.Sprintf("SELECT 1 FROM %s WHERE %s IS NOT NULL AND %s", s.tableName(), setCol, setLive))
+}
+
+// Count counts the distinct elements in this set, that have not expired.
+// The elements are counted by the database server, without retrieving them.
+func (s *Set) Count() (int, error) {
+	n, err := s.CountInt64()
+	return int(n), err
+}
+
+// CountInt64 counts the distinct elements in this set, that have not expired (int64)
+func (s *Set) CountInt64() (int64, error) {
+	var n int64
+	err := s.host.queryRow(fmt.Sprintf("SELECT COUNT(DISTINCT %s) FROM %s WHERE %s", setCol, s.tableName(), setLive)).Scan(&n)
+	return n, err
 }
@@ -1,7 +1,11 @@
 package simplehstore
 
 import (
+	"errors"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/colinf/pinterface"
 )
@@ -171,3 +175,274 @@ func TestCount(t *testing.T) {
 		t.Error("The set should have length 2 after adding two different items")
 	}
 }
+
+func TestSetCodecMarker(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	set, err := NewSet(host, setname, WithCodec(RawCodec))
+	if err != nil {
+		t.Error(err)
+	}
+	set.Clear()
+	if err := set.Add(testdata1); err != nil {
+		t.Errorf("Error, could not add item to set! %s", err)
+	}
+
+	// Open the same set with the default codec, the stored marker should win
+	set2, err := NewSet(host, setname)
+	if err != nil {
+		t.Error(err)
+	}
+	if set2.getCodec() != RawCodec {
+		t.Errorf("Error, expected the stored codec to be used, got %s", set2.getCodec().Name())
+	}
+	has, err := set2.Has(testdata1)
+	if err != nil || !has {
+		t.Errorf("Error, expected the set to have %s (%v)", testdata1, err)
+	}
+
+	// A strict set with an explicitly different codec should refuse to open
+	if _, err := NewSet(host, setname, WithCodec(HexCodec), WithStrict()); err == nil {
+		t.Error("Error, expected an error when opening a set with a different codec in strict mode")
+	}
+
+	set.Remove()
+}
+
+func TestSetAny(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	set, err := NewSet(host, "any_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Clear()
+	if _, found, err := set.Any(); err != nil || found {
+		t.Errorf("Error, expected no element in an empty set (%v)", err)
+	}
+	set.Add("a")
+	if value, found, err := set.Any(); err != nil || !found || value != "a" {
+		t.Errorf("Error, expected a, got %s, %v (%v)", value, found, err)
+	}
+
+	users, err := NewHashMap2(host, "any_test_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	if _, found, err := users.AnyOwner(); err != nil || found {
+		t.Errorf("Error, expected no owner in an empty hash map (%v)", err)
+	}
+	users.Set("bob", "email", "bob@example.com")
+	if owner, found, err := users.AnyOwner(); err != nil || !found || owner != "bob" {
+		t.Errorf("Error, expected bob, got %s, %v (%v)", owner, found, err)
+	}
+
+	set.Remove()
+	users.Remove()
+}
+
+func TestMoveMember(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	unconfirmed, err := NewSet(host, "move_test_unconfirmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed, err := NewSet(host, "move_test_confirmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unconfirmed.Clear()
+	confirmed.Clear()
+	unconfirmed.Add("bob")
+	unconfirmed.Add("alice")
+	unconfirmed.Add("eve")
+	confirmed.Add("eve")
+
+	if moved, err := unconfirmed.MoveMember("bob", confirmed); err != nil || !moved {
+		t.Errorf("Error, expected bob to be moved (%v)", err)
+	}
+	if moved, err := unconfirmed.MoveMember("bob", confirmed); err != nil || moved {
+		t.Errorf("Error, expected bob to already be moved (%v)", err)
+	}
+	if has, err := confirmed.Has("bob"); err != nil || !has {
+		t.Errorf("Error, expected bob to be confirmed (%v)", err)
+	}
+	if n, err := unconfirmed.TransferAll(confirmed); err != nil || n != 2 {
+		t.Errorf("Error, expected two values to be transferred, got %d (%v)", n, err)
+	}
+	// eve was in both sets, and should only be in the confirmed set once
+	if n, err := confirmed.Count(); err != nil || n != 3 {
+		t.Errorf("Error, expected three confirmed values, got %d (%v)", n, err)
+	}
+	if empty, err := unconfirmed.IsEmpty(); err != nil || !empty {
+		t.Errorf("Error, expected no unconfirmed values (%v)", err)
+	}
+	if _, err := confirmed.TransferAll(confirmed); err == nil {
+		t.Error("Error, expected an error when transferring to the same set")
+	}
+
+	unconfirmed.Remove()
+	confirmed.Remove()
+}
+
+func TestWeightedChoice(t *testing.T) {
+	members := []string{"a", "b", "c", "d"}
+	weights := map[string]string{"a": "1", "b": "0", "c": "3", "d": "x"}
+	for r, expected := range map[float64]string{0: "a", 0.2: "a", 0.25: "c", 0.99: "c"} {
+		if member := weightedChoice(members, weights, r); member != expected {
+			t.Errorf("Error, expected %s for %v, got %s", expected, r, member)
+		}
+	}
+	if member := weightedChoice(members, map[string]string{}, 0.5); member != "" {
+		t.Errorf("Error, expected no member without weights, got %s", member)
+	}
+}
+
+func TestWeightedRandom(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	for _, codec := range []Codec{RawCodec, HexCodec} {
+		variants, err := NewSet(host, "weighted_test_"+codec.Name(), WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		weights, err := NewKeyValue(host, "weighted_test_weights_"+codec.Name(), WithCodec(codec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		variants.Clear()
+		weights.Clear()
+		variants.Add("a")
+		variants.Add("b")
+		if _, err := variants.WeightedRandom(weights); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Error, expected ErrNotFound without weights, got %v", err)
+		}
+		weights.Set("a", "0")
+		weights.Set("b", "2.5")
+		for i := 0; i < 10; i++ {
+			if member, err := variants.WeightedRandom(weights); err != nil || member != "b" {
+				t.Errorf("Error, expected b with the %s codec, got %s (%v)", codec.Name(), member, err)
+			}
+		}
+		variants.Remove()
+		weights.Remove()
+	}
+}
+
+func TestAddExpiring(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	seen, err := NewSet(host, "expiring_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen.Clear()
+	seen.Add("10.0.0.1")
+	seen.AddExpiring("10.0.0.2", time.Hour)
+	seen.AddExpiring("10.0.0.3", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	if has, err := seen.Has("10.0.0.3"); err != nil || has {
+		t.Errorf("Error, expected the expired member to be gone (%v)", err)
+	}
+	if has, err := seen.Has("10.0.0.2"); err != nil || !has {
+		t.Errorf("Error, expected the member that has not expired to be there (%v)", err)
+	}
+	if all, err := seen.All(); err != nil || len(all) != 2 {
+		t.Errorf("Error, expected two members, got %v (%v)", all, err)
+	}
+	if n, err := seen.ExpireMembers(); err != nil || n != 1 {
+		t.Errorf("Error, expected one expired member to be deleted, got %d (%v)", n, err)
+	}
+
+	seen.Remove()
+}
+
+func TestSetAlgebra(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	a, err := NewSet(host, "algebra_a_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSet(host, "algebra_b_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest, err := NewSet(host, "algebra_dest_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Clear()
+	b.Clear()
+	dest.Clear()
+	for _, v := range []string{"x", "y", "z"} {
+		a.Add(v)
+	}
+	for _, v := range []string{"y", "z", "w"} {
+		b.Add(v)
+	}
+	sorted := func(values []string, err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		sort.Strings(values)
+		return strings.Join(values, ",")
+	}
+
+	if got := sorted(a.Union(b)); got != "w,x,y,z" {
+		t.Errorf("Error, expected the union to be w,x,y,z, got %s", got)
+	}
+	if got := sorted(a.Intersection(b)); got != "y,z" {
+		t.Errorf("Error, expected the intersection to be y,z, got %s", got)
+	}
+	if got := sorted(a.Difference(b)); got != "x" {
+		t.Errorf("Error, expected the difference to be x, got %s", got)
+	}
+	dest.Add("old")
+	if n, err := a.IntersectionStore(dest, b); err != nil || n != 2 {
+		t.Errorf("Error, expected 2 members to be stored, got %d (%v)", n, err)
+	}
+	if got := sorted(dest.All()); got != "y,z" {
+		t.Errorf("Error, expected dest to be y,z, got %s", got)
+	}
+	// The destination can be one of the sets
+	if n, err := a.DifferenceStore(a, b); err != nil || n != 1 {
+		t.Errorf("Error, expected 1 member to be stored, got %d (%v)", n, err)
+	}
+	if got := sorted(a.All()); got != "x" {
+		t.Errorf("Error, expected a to be x, got %s", got)
+	}
+
+	a.Remove()
+	b.Remove()
+	dest.Remove()
+}
+
+func TestAddAll(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	s, err := NewSet(host, "addall_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Clear()
+	s.Add("a")
+	if err := s.AddAll([]string{"a", "b", "c", "c"}); err != nil {
+		t.Error(err)
+	}
+	if n, err := s.Count(); err != nil || n != 3 {
+		t.Errorf("Error, expected 3 elements, got %d (%v)", n, err)
+	}
+
+	s.Remove()
+}
@@ -0,0 +1,84 @@
+package simplehstore
+
+import (
+	"fmt"
+)
+
+// Union returns the members that are in this set, the other set, or both.
+// The members are combined by the database server, so the sets must be in
+// the same database, and must use the same codec.
+func (s *Set) Union(other *Set) ([]string, error) {
+	return s.combine("UNION", other)
+}
+
+// Intersection returns the members that are in both this set and the other
+// set. See Union.
+func (s *Set) Intersection(other *Set) ([]string, error) {
+	return s.combine("INTERSECT", other)
+}
+
+// Difference returns the members that are in this set, but not in the other
+// set. See Union.
+func (s *Set) Difference(other *Set) ([]string, error) {
+	return s.combine("EXCEPT", other)
+}
+
+// UnionStore replaces the members of dest with the union of this set and the
+// other set, in a single statement, and returns the number of members that
+// dest ends up with. dest may be one of the two sets. The members of dest do
+// not expire, even if they expire in the sets they came from.
+func (s *Set) UnionStore(dest, other *Set) (int64, error) {
+	return s.combineStore("UNION", dest, other)
+}
+
+// IntersectionStore replaces the members of dest with the intersection of
+// this set and the other set. See UnionStore.
+func (s *Set) IntersectionStore(dest, other *Set) (int64, error) {
+	return s.combineStore("INTERSECT", dest, other)
+}
+
+// DifferenceStore replaces the members of dest with the members of this set
+// that are not in the other set. See UnionStore.
+func (s *Set) DifferenceStore(dest, other *Set) (int64, error) {
+	return s.combineStore("EXCEPT", dest, other)
+}
+
+// combineQuery returns a query for the live members of this set, combined
+// with the live members of the other set with the given set operation
+func (s *Set) combineQuery(operation string, other *Set) string {
+	return fmt.Sprintf("SELECT %s AS value FROM %s WHERE %s IS NOT NULL AND %s %s SELECT %s FROM %s WHERE %s IS NOT NULL AND %s",
+		setCol, s.tableName(), setCol, setLive, operation,
+		setCol, other.tableName(), setCol, setLive)
+}
+
+// checkCodecs checks that the members of the given sets can be compared by the database server
+func (s *Set) checkCodecs(others ...*Set) error {
+	for _, other := range others {
+		if s.getCodec().Name() != other.getCodec().Name() {
+			return fmt.Errorf("can not combine sets with the %q and %q codecs", s.getCodec().Name(), other.getCodec().Name())
+		}
+	}
+	return nil
+}
+
+// combine returns the members of this set, combined with the members of the other set
+func (s *Set) combine(operation string, other *Set) ([]string, error) {
+	if err := s.checkCodecs(other); err != nil {
+		return []string{}, err
+	}
+	return s.host.decodedStrings(s.combineQuery(operation, other), s.decodeAll)
+}
+
+// combineStore replaces the members of dest with the members of this set,
+// combined with the members of the other set. All parts of the statement see
+// the sets as they were before it started, so dest can be one of the sets.
+func (s *Set) combineStore(operation string, dest, other *Set) (int64, error) {
+	if err := s.checkCodecs(dest, other); err != nil {
+		return 0, err
+	}
+	query := fmt.Sprintf("WITH result AS (%s), removed AS (DELETE FROM %s), added AS (INSERT INTO %s (%s) SELECT value FROM result RETURNING 1) SELECT COUNT(*) FROM added",
+		s.combineQuery(operation, other), dest.tableName(), dest.tableName(), setCol)
+	var n int64
+	err := s.host.queryRow(query).Scan(&n)
+	return n, err
+}
@@ -4,11 +4,14 @@
 package simplehstore
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	// Using the PostgreSQL database engine
 	pq "github.com/lib/pq"
@@ -29,9 +32,28 @@ type Host struct {
 	db     *sql.DB
 	dbname string
 
+	// The connection string, used for opening dedicated connections for LISTEN
+	dsn string
+
+	// Used for verbose logging, or log.Default() if nil
+	logger *log.Logger
+
+	// If set to true, queries are tagged with the operation that runs them, see WithQueryTags
+	queryTags bool
+
+	// If set, all queries are run as part of this transaction
+	tx queryer
+
+	// If set, all queries are run with this context
+	ctx context.Context
+
+	// Samples the latencies of all queries, once enabled, see EnableLatencySampling
+	latency *latencySampler
+
 	// If set to true, any UTF-8 string will be let through as it is.
 	// Some UTF-8 strings may be unpalatable for PostgreSQL when performing
 	// SQL queries. The default is "false".
+	// This is only the default for new data structures, see WithCodec.
 	rawUTF8 bool
 }
 
@@ -39,6 +61,7 @@ type Host struct {
 type dbDatastructure struct {
 	host  *Host
 	table string
+	options
 }
 
 var defaultConnectionString = func() string {
@@ -143,7 +166,7 @@ func NewHost2(connectionString string) (*Host, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to %s", newConnectionString)
 	}
-	host := &Host{db, pq.QuoteIdentifier(dbname), false}
+	host := &Host{db: db, dbname: pq.QuoteIdentifier(dbname), dsn: newConnectionString, latency: newLatencySampler()}
 	if err := host.Ping(); err != nil {
 		return nil, fmt.Errorf("database host does not reply to ping: %s", err)
 	}
@@ -172,7 +195,7 @@ func NewHostWithDSN2(connectionString string, dbname string) (*Host, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to %s", connectionString)
 	}
-	host := &Host{db, pq.QuoteIdentifier(dbname), false}
+	host := &Host{db: db, dbname: pq.QuoteIdentifier(dbname), dsn: connectionString, latency: newLatencySampler()}
 	if err := host.Ping(); err != nil {
 		return nil, fmt.Errorf("database host does not reply to ping: %s", err)
 	}
@@ -185,6 +208,35 @@ func NewHostWithDSN2(connectionString string, dbname string) (*Host, error) {
 	return host, nil
 }
 
+// NewHostFromDB creates a Host that uses the given connection pool, which is
+// managed by the application. The current database of the pool is used.
+func NewHostFromDB(db *sql.DB) (*Host, error) {
+	host := &Host{db: db, latency: newLatencySampler()}
+	var dbname string
+	if err := host.queryRow("SELECT current_database()").Scan(&dbname); err != nil {
+		return nil, err
+	}
+	host.dbname = pq.QuoteIdentifier(dbname)
+	return host, nil
+}
+
+// NewHostFromTx creates a Host where all queries are run as part of the given
+// transaction, which is managed by the application. Data structures that are
+// created with the returned Host also run all their queries in the transaction,
+// and operations that need a transaction of their own become part of it.
+// Committing or rolling back is left to the application, and the Host must
+// not be used after that. Features that need their own connections, such as
+// Election and PrepareTransaction, are not available on such a Host.
+func NewHostFromTx(tx *sql.Tx) (*Host, error) {
+	host := &Host{tx: tx, latency: newLatencySampler()}
+	var dbname string
+	if err := host.queryRow("SELECT current_database()").Scan(&dbname); err != nil {
+		return nil, err
+	}
+	host.dbname = pq.QuoteIdentifier(dbname)
+	return host, nil
+}
+
 // New sets up a connection to the default (local) database host
 func New() *Host {
 	connectionString := defaultConnectionString + defaultDatabaseName
@@ -199,6 +251,11 @@ func New() *Host {
 // but malformed UTF-8 strings can potentially cause problems.
 // Encoding the strings before sending them to PostgreSQL is the default.
 // Choose the setting that best suits your situation.
+// The setting only affects data structures that are created afterwards, and
+// data structures that already have stored data keep using the encoding that
+// the data was written with.
+//
+// Deprecated: use the WithCodec option when creating each data structure.
 func (host *Host) SetRawUTF8(enabled bool) {
 	host.rawUTF8 = enabled
 }
@@ -230,17 +287,196 @@ func (host *Host) useDatabase() error {
 	return nil
 }
 
-// Database returns the underlying *sql.DB database struct
+// Database returns the underlying *sql.DB database struct,
+// or nil if the host was created with NewHostFromTx
 func (host *Host) Database() *sql.DB {
 	return host.db
 }
 
-// Close the connection
+// Close the connection. Hosts created with NewHostFromTx are left as they are,
+// since the transaction is managed by the application.
 func (host *Host) Close() {
+	if host.db == nil {
+		return
+	}
 	host.db.Close()
 }
 
 // Ping the host
 func (host *Host) Ping() error {
+	if host.db == nil {
+		_, err := host.exec("SELECT 1")
+		return err
+	}
 	return host.db.Ping()
 }
+
+// queryer is the subset of methods that both *sql.DB and *sql.Tx have,
+// and that are used for running queries
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// queryer returns the transaction the host is bound to, if any, or else the database
+func (host *Host) queryer() queryer {
+	if host.tx != nil {
+		return host.tx
+	}
+	return host.db
+}
+
+// exec runs a query that does not return any rows
+func (host *Host) exec(query string, args ...interface{}) (sql.Result, error) {
+	defer host.observe(time.Now())
+	ctx := host.context()
+	result, err := host.queryer().ExecContext(ctx, host.annotate(ctx, query), args...)
+	return result, wrapDriverError(err)
+}
+
+// query runs a query that returns rows
+func (host *Host) query(query string, args ...interface{}) (*sql.Rows, error) {
+	defer host.observe(time.Now())
+	ctx := host.context()
+	rows, err := host.queryer().QueryContext(ctx, host.annotate(ctx, query), args...)
+	return rows, wrapDriverError(err)
+}
+
+// queryRow runs a query that is expected to return at most one row
+func (host *Host) queryRow(query string, args ...interface{}) *row {
+	defer host.observe(time.Now())
+	ctx := host.context()
+	return &row{host.queryer().QueryRowContext(ctx, host.annotate(ctx, query), args...)}
+}
+
+// row is the result of queryRow, with driver errors wrapped like for exec and query
+type row struct {
+	*sql.Row
+}
+
+// Scan copies the columns of the row into dest, see sql.Row.Scan
+func (r *row) Scan(dest ...interface{}) error {
+	return wrapDriverError(r.Row.Scan(dest...))
+}
+
+// txn is a transaction that is either owned by the function that started it,
+// or is the transaction that the host is bound to. In the latter case,
+// committing and rolling back is left to whoever started the transaction.
+type txn struct {
+	queryer
+	tx   *sql.Tx // nil if the transaction is not owned
+	host *Host
+}
+
+// begin starts a new transaction, or returns the transaction the host is bound to
+func (host *Host) begin(ctx context.Context) (*txn, error) {
+	if host.tx != nil {
+		return &txn{host.tx, nil, host}, nil
+	}
+	tx, err := host.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{tx, tx, host}, nil
+}
+
+// ExecContext runs a query that does not return any rows, as part of the transaction
+func (t *txn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer t.host.observe(time.Now())
+	result, err := t.queryer.ExecContext(ctx, t.host.annotate(ctx, query), args...)
+	return result, wrapDriverError(err)
+}
+
+// QueryContext runs a query that returns rows, as part of the transaction
+func (t *txn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer t.host.observe(time.Now())
+	rows, err := t.queryer.QueryContext(ctx, t.host.annotate(ctx, query), args...)
+	return rows, wrapDriverError(err)
+}
+
+// copyIn loads the given rows into a table with the COPY protocol, as part of
+// the transaction. If the transaction can not prepare statements, the rows are
+// inserted one by one instead. The table name is quoted by copyIn.
+func (t *txn) copyIn(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	preparer, ok := t.queryer.(interface {
+		PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	})
+	if !ok {
+		quoted := make([]string, len(columns))
+		placeholders := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = pq.QuoteIdentifier(column)
+			placeholders[i] = "$" + strconv.Itoa(i+1)
+		}
+		query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", pq.QuoteIdentifier(table), strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+		for _, row := range rows {
+			if _, err := t.ExecContext(ctx, query, row...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	stmt, err := preparer.PrepareContext(ctx, pq.CopyIn(table, columns...))
+	if err != nil {
+		return wrapDriverError(err)
+	}
+	for _, row := range rows {
+		if _, err := stmt.ExecContext(ctx, row...); err != nil {
+			stmt.Close()
+			return wrapDriverError(err)
+		}
+	}
+	// Flush the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return wrapDriverError(err)
+	}
+	return stmt.Close()
+}
+
+// withTx returns a shallow copy of the host, where all queries are run as part of the given transaction
+func (host *Host) withTx(transaction queryer) *Host {
+	// The host annotates and observes the queries, so the txn should not do it as well
+	if t, ok := transaction.(*txn); ok {
+		transaction = t.queryer
+	}
+	txHost := *host
+	txHost.tx = transaction
+	return &txHost
+}
+
+// Commit the transaction, if it is owned
+func (t *txn) Commit() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Commit()
+}
+
+// Rollback the transaction, if it is owned
+func (t *txn) Rollback() error {
+	if t.tx == nil {
+		return nil
+	}
+	return t.tx.Rollback()
+}
+
+// checksum runs a query that returns a single md5 sum, and returns it as a string
+func (host *Host) checksum(query string) (string, error) {
+	if Verbose {
+		fmt.Println(query)
+	}
+	var sum sql.NullString
+	if err := host.queryRow(query).Scan(&sum); err != nil {
+		return "", err
+	}
+	return sum.String, nil
+}
+
+// noRows checks if the given query returns no rows, without fetching them
+func (host *Host) noRows(query string) (bool, error) {
+	var empty bool
+	err := host.queryRow(fmt.Sprintf("SELECT NOT EXISTS (%s)", query)).Scan(&empty)
+	return empty, err
+}
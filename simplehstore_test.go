@@ -1,6 +1,9 @@
 package simplehstore
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +29,178 @@ func TestTwoFields(t *testing.T) {
 		t.Error("Error in twoFields functions")
 	}
 }
+
+func TestConsistencyToken(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	token, err := host.ConsistencyToken()
+	if err != nil {
+		t.Fatalf("Error, could not get a consistency token! %s", err)
+	}
+	if token == "" {
+		t.Error("Error, got an empty consistency token")
+	}
+	// The primary has all of its own writes
+	if err := host.WaitForToken(context.Background(), token); err != nil {
+		t.Errorf("Error, could not wait for the consistency token! %s", err)
+	}
+}
+
+func TestHostFromTx(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	tx, err := host.Database().Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txHost, err := NewHostFromTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewList(txHost, "host_from_tx_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Add("a")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	// The table was created in the transaction, so it should be gone
+	if l, err := NewList(host, "host_from_tx_test"); err != nil {
+		t.Error(err)
+	} else {
+		if all, _ := l.All(); len(all) != 0 {
+			t.Errorf("Error, expected the rolled back element to be gone, got %v", all)
+		}
+		l.Remove()
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, err := NewList(host, "discover_test_list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Clear()
+	l.Add("a")
+	l.Add("b")
+	users, err := NewHashMap2(host, "discover_test_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	users.Set("bob", "email", "bob@example.com")
+
+	structures, err := host.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var foundList, foundUsers bool
+	for _, s := range structures {
+		switch s.Name {
+		case "discover_test_list":
+			foundList = s.Kind == "list" && s.Count == 2
+		case "discover_test_users":
+			foundUsers = s.Kind == "hashmap2" && s.Count == 1
+		case "discover_test_users_properties_HSTORE_map", "discover_test_users_encountered_property_keys":
+			t.Errorf("Error, %s is a part of a HashMap2 and should not be listed", s.Name)
+		}
+	}
+	if !foundList || !foundUsers {
+		t.Errorf("Error, expected to discover the list and the hash map, got %v", structures)
+	}
+
+	l.Remove()
+	users.Remove()
+}
+
+func TestAdvise(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "advise_test_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	props := make(map[string]string)
+	for i := 0; i < 150; i++ {
+		props[fmt.Sprintf("prop%d", i)] = "x"
+	}
+	users.SetMap("bob", props)
+	users.Set("alice", "email", "alice@example.com")
+	// The properties are still encountered properties after bob is gone
+	users.Del("bob")
+
+	advice, err := host.Advise()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, a := range advice {
+		if a.Name == "advise_test_users" && strings.Contains(a.Problem, "not used by any owner") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Error, expected advice about the unused properties, got %v", advice)
+	}
+
+	users.Remove()
+}
+
+func TestIsEmpty(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	l, _ := NewList(host, "isempty_test_list")
+	s, _ := NewSet(host, "isempty_test_set")
+	kv, _ := NewKeyValue(host, "isempty_test_kv")
+	users, _ := NewHashMap2(host, "isempty_test_users")
+	l.Clear()
+	s.Clear()
+	kv.Clear()
+	users.Clear()
+
+	check := func(what string, expected bool, empty bool, err error) {
+		if err != nil || empty != expected {
+			t.Errorf("Error, expected IsEmpty to be %v for the %s, got %v (%v)", expected, what, empty, err)
+		}
+	}
+	empty, err := l.IsEmpty()
+	check("list", true, empty, err)
+	empty, err = s.IsEmpty()
+	check("set", true, empty, err)
+	empty, err = kv.IsEmpty()
+	check("key/value", true, empty, err)
+	empty, err = users.IsEmpty()
+	check("hash map", true, empty, err)
+
+	l.Add("a")
+	s.Add("a")
+	kv.Set("a", "a")
+	users.Set("bob", "email", "bob@example.com")
+	empty, err = l.IsEmpty()
+	check("list", false, empty, err)
+	empty, err = s.IsEmpty()
+	check("set", false, empty, err)
+	empty, err = kv.IsEmpty()
+	check("key/value", false, empty, err)
+	empty, err = users.IsEmpty()
+	check("hash map", false, empty, err)
+
+	// The row of a key/value is kept when the last key is deleted
+	kv.Del("a")
+	empty, err = kv.IsEmpty()
+	check("key/value", true, empty, err)
+
+	l.Remove()
+	s.Remove()
+	kv.Remove()
+	users.Remove()
+}
@@ -0,0 +1,86 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+)
+
+// HashMap2Snapshot gives read access to a HashMap2, where all reads are
+// part of one read-only REPEATABLE READ transaction. All reads see the
+// hash map as it was when the first query was run, even while other
+// clients keep writing to it. Close must be called when done.
+type HashMap2Snapshot struct {
+	hm2 *HashMap2
+	tx  *sql.Tx
+}
+
+// Snapshot starts a read-only REPEATABLE READ transaction, and returns a
+// handle for reading from the hash map within that transaction.
+// This is useful for generating reports that needs a consistent view.
+func (hm2 *HashMap2) Snapshot(ctx context.Context) (*HashMap2Snapshot, error) {
+	if hm2.host.tx != nil || hm2.host.db == nil {
+		return nil, ErrNestedTransaction
+	}
+	tx, err := hm2.host.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	snapshotHM2 := *hm2
+	snapshotHM2.host = hm2.host.WithContext(ctx).withTx(tx)
+	return &HashMap2Snapshot{&snapshotHM2, tx}, nil
+}
+
+// Close ends the transaction of the snapshot
+func (s *HashMap2Snapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+// Get a value from the snapshot, given the owner and the key
+func (s *HashMap2Snapshot) Get(owner, key string) (string, error) {
+	return s.hm2.Get(owner, key)
+}
+
+// GetMap returns multiple values from the snapshot, for the given owner and keys
+func (s *HashMap2Snapshot) GetMap(owner string, keys []string) (map[string]string, error) {
+	return s.hm2.GetMap(owner, keys)
+}
+
+// Has checks if the given owner has the given key in the snapshot
+func (s *HashMap2Snapshot) Has(owner, key string) (bool, error) {
+	return s.hm2.Has(owner, key)
+}
+
+// Exists checks if the given owner exists in the snapshot
+func (s *HashMap2Snapshot) Exists(owner string) (bool, error) {
+	return s.hm2.Exists(owner)
+}
+
+// Keys returns all keys for the given owner in the snapshot
+func (s *HashMap2Snapshot) Keys(owner string) ([]string, error) {
+	return s.hm2.Keys(owner)
+}
+
+// All returns all owners in the snapshot
+func (s *HashMap2Snapshot) All() ([]string, error) {
+	return s.hm2.All()
+}
+
+// AllWhere returns all owners in the snapshot that have the given key set to the given value
+func (s *HashMap2Snapshot) AllWhere(key, value string) ([]string, error) {
+	return s.hm2.AllWhere(key, value)
+}
+
+// AllPossibleKeys returns all encountered property keys in the snapshot
+func (s *HashMap2Snapshot) AllPossibleKeys() ([]string, error) {
+	return s.hm2.AllPossibleKeys()
+}
+
+// Count returns the number of owners in the snapshot
+func (s *HashMap2Snapshot) Count() (int64, error) {
+	return s.hm2.Count()
+}
+
+// Query returns a query builder that runs its queries within the snapshot
+func (s *HashMap2Snapshot) Query() *HashMap2Query {
+	return s.hm2.Query()
+}
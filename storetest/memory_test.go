@@ -0,0 +1,181 @@
+package storetest
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/colinf/pinterface"
+)
+
+// The in-memory data structures in this file check that the suite passes for
+// an implementation that behaves as the interfaces describe
+
+var errNotFound = errors.New("not found")
+
+type memList struct{ values []string }
+
+func (l *memList) Add(value string) error { l.values = append(l.values, value); return nil }
+func (l *memList) All() ([]string, error) { return l.values, nil }
+func (l *memList) Clear() error           { l.values = nil; return nil }
+func (l *memList) Remove() error          { return l.Clear() }
+func (l *memList) LastN(n int) ([]string, error) {
+	if n > len(l.values) {
+		return l.values, errors.New("too few elements")
+	}
+	return l.values[len(l.values)-n:], nil
+}
+func (l *memList) Last() (string, error) {
+	if len(l.values) == 0 {
+		return "", nil
+	}
+	return l.values[len(l.values)-1], nil
+}
+
+type memSet map[string]bool
+
+func (s memSet) Add(value string) error         { s[value] = true; return nil }
+func (s memSet) Del(value string) error         { delete(s, value); return nil }
+func (s memSet) Has(value string) (bool, error) { return s[value], nil }
+func (s memSet) Remove() error                  { return s.Clear() }
+func (s memSet) Clear() error {
+	for k := range s {
+		delete(s, k)
+	}
+	return nil
+}
+func (s memSet) All() ([]string, error) {
+	var values []string
+	for k := range s {
+		values = append(values, k)
+	}
+	return values, nil
+}
+
+type memKeyValue map[string]string
+
+func (kv memKeyValue) Set(key, value string) error { kv[key] = value; return nil }
+func (kv memKeyValue) Del(key string) error        { delete(kv, key); return nil }
+func (kv memKeyValue) Remove() error               { return kv.Clear() }
+func (kv memKeyValue) Clear() error {
+	for k := range kv {
+		delete(kv, k)
+	}
+	return nil
+}
+func (kv memKeyValue) Get(key string) (string, error) {
+	value, ok := kv[key]
+	if !ok {
+		return "", errNotFound
+	}
+	return value, nil
+}
+func (kv memKeyValue) Inc(key string) (string, error) {
+	n, _ := strconv.Atoi(kv[key])
+	kv[key] = strconv.Itoa(n + 1)
+	return kv[key], nil
+}
+
+// memHashMap stores the properties as "owner/key" in a key/value
+type memHashMap struct{ memKeyValue }
+
+func (h memHashMap) Set(owner, key, value string) error {
+	return h.memKeyValue.Set(owner+"/"+key, value)
+}
+func (h memHashMap) Get(owner, key string) (string, error) {
+	return h.memKeyValue.Get(owner + "/" + key)
+}
+func (h memHashMap) DelKey(owner, key string) error { return h.memKeyValue.Del(owner + "/" + key) }
+func (h memHashMap) Has(owner, key string) (bool, error) {
+	_, ok := h.memKeyValue[owner+"/"+key]
+	return ok, nil
+}
+func (h memHashMap) Del(owner string) error {
+	for k := range h.memKeyValue {
+		if strings.HasPrefix(k, owner+"/") {
+			delete(h.memKeyValue, k)
+		}
+	}
+	return nil
+}
+func (h memHashMap) Exists(owner string) (bool, error) {
+	keys, err := h.Keys(owner)
+	return len(keys) > 0, err
+}
+func (h memHashMap) Keys(owner string) ([]string, error) {
+	var keys []string
+	for k := range h.memKeyValue {
+		if strings.HasPrefix(k, owner+"/") {
+			keys = append(keys, strings.TrimPrefix(k, owner+"/"))
+		}
+	}
+	return keys, nil
+}
+func (h memHashMap) All() ([]string, error) {
+	found := make(map[string]bool)
+	var owners []string
+	for k := range h.memKeyValue {
+		// Owners may contain "/", but the keys of the test suite do not
+		owner := k[:strings.LastIndex(k, "/")]
+		if !found[owner] {
+			found[owner] = true
+			owners = append(owners, owner)
+		}
+	}
+	return owners, nil
+}
+func (h memHashMap) Count() (int64, error) {
+	owners, err := h.All()
+	return int64(len(owners)), err
+}
+func (h memHashMap) Empty() (bool, error) { return len(h.memKeyValue) == 0, nil }
+func (h memHashMap) AllWhere(key, value string) ([]string, error) {
+	var owners []string
+	all, _ := h.All()
+	for _, owner := range all {
+		if v, err := h.Get(owner, key); err == nil && v == value {
+			owners = append(owners, owner)
+		}
+	}
+	return owners, nil
+}
+func (h memHashMap) GetMap(owner string, keys []string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, key := range keys {
+		if value, err := h.Get(owner, key); err == nil {
+			m[key] = value
+		}
+	}
+	return m, nil
+}
+func (h memHashMap) SetMap(owner string, m map[string]string) error {
+	for k, v := range m {
+		h.Set(owner, k, v)
+	}
+	return nil
+}
+func (h memHashMap) SetLargeMap(all map[string]map[string]string) error {
+	for owner, m := range all {
+		h.SetMap(owner, m)
+	}
+	return nil
+}
+
+type memCreator struct{}
+
+func (memCreator) NewList(id string) (pinterface.IList, error) { return &memList{}, nil }
+func (memCreator) NewSet(id string) (pinterface.ISet, error)   { return memSet{}, nil }
+func (memCreator) NewHashMap(id string) (pinterface.IHashMap, error) {
+	return memHashMap{memKeyValue{}}, nil
+}
+func (memCreator) NewKeyValue(id string) (pinterface.IKeyValue, error) {
+	return memKeyValue{}, nil
+}
+
+func TestMemory(t *testing.T) {
+	TestCreator(t, memCreator{})
+	TestIHashMap2(t, func(name string) (pinterface.IHashMap2, error) {
+		return memHashMap{memKeyValue{}}, nil
+	})
+}
@@ -0,0 +1,335 @@
+// Package storetest is a conformance test suite for implementations of the
+// data structure interfaces in github.com/colinf/pinterface, like the
+// PostgreSQL data structures in simplehstore, in-memory implementations and
+// wrappers that add caching or mirroring. Running the same suite against
+// every backend makes sure that they behave the same, also for edge cases
+// like empty values and Unicode keys.
+//
+// Each test creates the data structures it needs with the given factory,
+// with names that start with "storetest_", and removes them afterwards.
+package storetest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/colinf/pinterface"
+)
+
+// edgeCases are values, keys and owners that all implementations must store as they are
+var edgeCases = []string{
+	"plain",
+	"with spaces",
+	"it's quoted",
+	"dash-and_underscore",
+	"blåbærsyltetøy",
+	"日本語",
+	"emoji 🐘",
+}
+
+// sorted returns a sorted copy of xs
+func sorted(xs []string) []string {
+	ys := append([]string{}, xs...)
+	sort.Strings(ys)
+	return ys
+}
+
+// equal checks if two slices have the same elements, in the same order
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestCreator runs the test suites for lists, sets, hash maps and key/values
+// against the data structures from the given creator
+func TestCreator(t *testing.T, creator pinterface.ICreator) {
+	t.Run("IList", func(t *testing.T) { TestIList(t, creator.NewList) })
+	t.Run("ISet", func(t *testing.T) { TestISet(t, creator.NewSet) })
+	t.Run("IHashMap", func(t *testing.T) { TestIHashMap(t, creator.NewHashMap) })
+	t.Run("IKeyValue", func(t *testing.T) { TestIKeyValue(t, creator.NewKeyValue) })
+}
+
+// TestIList tests a list from the given factory
+func TestIList(t *testing.T, factory func(name string) (pinterface.IList, error)) {
+	l, err := factory("storetest_list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Remove()
+	if err := l.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := l.All(); err != nil || len(all) != 0 {
+		t.Errorf("All: expected an empty list, got %q (%v)", all, err)
+	}
+	values := append(append([]string{}, edgeCases...), "", "plain")
+	for _, value := range values {
+		if err := l.Add(value); err != nil {
+			t.Fatalf("Add(%q): %v", value, err)
+		}
+	}
+	if all, err := l.All(); err != nil || !equal(all, values) {
+		t.Errorf("All: expected %q, in the order they were added, got %q (%v)", values, all, err)
+	}
+	if last, err := l.Last(); err != nil || last != "plain" {
+		t.Errorf("Last: expected %q, got %q (%v)", "plain", last, err)
+	}
+	if lastN, err := l.LastN(2); err != nil || !equal(lastN, []string{"", "plain"}) {
+		t.Errorf("LastN(2): expected %q, got %q (%v)", []string{"", "plain"}, lastN, err)
+	}
+	if err := l.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := l.All(); err != nil || len(all) != 0 {
+		t.Errorf("All: expected an empty list after Clear, got %q (%v)", all, err)
+	}
+}
+
+// TestISet tests a set from the given factory
+func TestISet(t *testing.T, factory func(name string) (pinterface.ISet, error)) {
+	s, err := factory("storetest_set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Remove()
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range edgeCases {
+		// Adding a value twice has no effect
+		for i := 0; i < 2; i++ {
+			if err := s.Add(value); err != nil {
+				t.Fatalf("Add(%q): %v", value, err)
+			}
+		}
+	}
+	if all, err := s.All(); err != nil || !equal(sorted(all), sorted(edgeCases)) {
+		t.Errorf("All: expected %q, got %q (%v)", sorted(edgeCases), sorted(all), err)
+	}
+	for _, value := range edgeCases {
+		if has, err := s.Has(value); err != nil || !has {
+			t.Errorf("Has(%q): expected true (%v)", value, err)
+		}
+	}
+	if has, err := s.Has("missing"); err != nil || has {
+		t.Errorf("Has(%q): expected false (%v)", "missing", err)
+	}
+	if err := s.Del("日本語"); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := s.Has("日本語"); err != nil || has {
+		t.Errorf("Has(%q): expected false after Del (%v)", "日本語", err)
+	}
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := s.All(); err != nil || len(all) != 0 {
+		t.Errorf("All: expected an empty set after Clear, got %q (%v)", all, err)
+	}
+}
+
+// hashMap is what IHashMap and IHashMap2 have in common
+type hashMap interface {
+	All() ([]string, error)
+	Clear() error
+	DelKey(owner, key string) error
+	Del(key string) error
+	Exists(owner string) (bool, error)
+	Get(owner, key string) (string, error)
+	Has(owner, key string) (bool, error)
+	Keys(owner string) ([]string, error)
+	Set(owner, key, value string) error
+}
+
+// testHashMap tests what IHashMap and IHashMap2 have in common
+func testHashMap(t *testing.T, h hashMap) {
+	if err := h.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	for _, owner := range edgeCases {
+		for _, key := range edgeCases {
+			if err := h.Set(owner, key, owner+"/"+key); err != nil {
+				t.Fatalf("Set(%q, %q): %v", owner, key, err)
+			}
+		}
+	}
+	if all, err := h.All(); err != nil || !equal(sorted(all), sorted(edgeCases)) {
+		t.Errorf("All: expected %q, got %q (%v)", sorted(edgeCases), sorted(all), err)
+	}
+	for _, owner := range edgeCases {
+		if keys, err := h.Keys(owner); err != nil || !equal(sorted(keys), sorted(edgeCases)) {
+			t.Errorf("Keys(%q): expected %q, got %q (%v)", owner, sorted(edgeCases), sorted(keys), err)
+		}
+		for _, key := range edgeCases {
+			if value, err := h.Get(owner, key); err != nil || value != owner+"/"+key {
+				t.Errorf("Get(%q, %q): expected %q, got %q (%v)", owner, key, owner+"/"+key, value, err)
+			}
+		}
+	}
+	if err := h.Set("bob", "email", "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Set("bob", "email", "bob@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := h.Get("bob", "email"); err != nil || value != "bob@example.org" {
+		t.Errorf("Get: expected Set to overwrite the value, got %q (%v)", value, err)
+	}
+	if _, err := h.Get("bob", "missing"); err == nil {
+		t.Error("Get: expected an error for a missing key")
+	}
+	if _, err := h.Get("missing", "email"); err == nil {
+		t.Error("Get: expected an error for a missing owner")
+	}
+	if has, err := h.Has("bob", "email"); err != nil || !has {
+		t.Errorf("Has: expected true (%v)", err)
+	}
+	if has, err := h.Has("bob", "missing"); err != nil || has {
+		t.Errorf("Has: expected false for a missing key (%v)", err)
+	}
+	// An empty value may be reported as missing, but is never returned as anything else
+	if err := h.Set("bob", "nickname", ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := h.Get("bob", "nickname"); value != "" {
+		t.Errorf("Get: expected an empty value, got %q", value)
+	}
+	if err := h.DelKey("bob", "email"); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := h.Has("bob", "email"); err != nil || has {
+		t.Errorf("Has: expected false after DelKey (%v)", err)
+	}
+	if exists, err := h.Exists("日本語"); err != nil || !exists {
+		t.Errorf("Exists: expected true (%v)", err)
+	}
+	if err := h.Del("日本語"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := h.Exists("日本語"); err != nil || exists {
+		t.Errorf("Exists: expected false after Del (%v)", err)
+	}
+	if exists, err := h.Exists("plain"); err != nil || !exists {
+		t.Errorf("Exists: expected Del to only remove the given owner (%v)", err)
+	}
+	if err := h.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := h.All(); err != nil || len(all) != 0 {
+		t.Errorf("All: expected no owners after Clear, got %q (%v)", all, err)
+	}
+}
+
+// TestIHashMap tests a hash map from the given factory
+func TestIHashMap(t *testing.T, factory func(name string) (pinterface.IHashMap, error)) {
+	h, err := factory("storetest_hashmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Remove()
+	testHashMap(t, h)
+}
+
+// TestIHashMap2 tests a hash map from the given factory, including the
+// methods that IHashMap2 adds to IHashMap
+func TestIHashMap2(t *testing.T, factory func(name string) (pinterface.IHashMap2, error)) {
+	h, err := factory("storetest_hashmap2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Remove()
+	testHashMap(t, h)
+
+	if empty, err := h.Empty(); err != nil || !empty {
+		t.Errorf("Empty: expected true after Clear (%v)", err)
+	}
+	if err := h.SetMap("bob", map[string]string{"email": "bob@example.com", "language": "日本語"}); err != nil {
+		t.Fatal(err)
+	}
+	err = h.SetLargeMap(map[string]map[string]string{
+		"alice": {"email": "alice@example.com", "language": "blåbærsyltetøy"},
+		"carol": {"email": "carol@example.com", "language": "日本語"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, err := h.Count(); err != nil || count != 3 {
+		t.Errorf("Count: expected 3 owners, got %d (%v)", count, err)
+	}
+	m, err := h.GetMap("alice", []string{"email", "language"})
+	if err != nil || m["email"] != "alice@example.com" || m["language"] != "blåbærsyltetøy" {
+		t.Errorf("GetMap: unexpected result %q (%v)", m, err)
+	}
+	if owners, err := h.AllWhere("language", "日本語"); err != nil || !equal(sorted(owners), []string{"bob", "carol"}) {
+		t.Errorf("AllWhere: expected bob and carol, got %q (%v)", owners, err)
+	}
+	if empty, err := h.Empty(); err != nil || empty {
+		t.Errorf("Empty: expected false (%v)", err)
+	}
+}
+
+// TestIKeyValue tests a key/value from the given factory
+func TestIKeyValue(t *testing.T, factory func(name string) (pinterface.IKeyValue, error)) {
+	kv, err := factory("storetest_keyvalue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Remove()
+	if err := kv.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range edgeCases {
+		if err := kv.Set(key, "value of "+key); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+	}
+	for _, key := range edgeCases {
+		if value, err := kv.Get(key); err != nil || value != "value of "+key {
+			t.Errorf("Get(%q): expected %q, got %q (%v)", key, "value of "+key, value, err)
+		}
+	}
+	if err := kv.Set("plain", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := kv.Get("plain"); err != nil || value != "changed" {
+		t.Errorf("Get: expected Set to overwrite the value, got %q (%v)", value, err)
+	}
+	if _, err := kv.Get("missing"); err == nil {
+		t.Error("Get: expected an error for a missing key")
+	}
+	// An empty value may be reported as missing, but is never returned as anything else
+	if err := kv.Set("empty", ""); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := kv.Get("empty"); value != "" {
+		t.Errorf("Get: expected an empty value, got %q", value)
+	}
+	// Inc starts missing keys at 0, like INCR in Redis
+	for _, expected := range []string{"1", "2"} {
+		if value, err := kv.Inc("counter"); err != nil || value != expected {
+			t.Errorf("Inc: expected %q, got %q (%v)", expected, value, err)
+		}
+	}
+	if err := kv.Del("日本語"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get("日本語"); err == nil {
+		t.Error("Get: expected an error after Del")
+	}
+	if value, err := kv.Get("blåbærsyltetøy"); err != nil || value != "value of blåbærsyltetøy" {
+		t.Errorf("Get: expected Del to only remove the given key, got %q (%v)", value, err)
+	}
+	if err := kv.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get("plain"); err == nil {
+		t.Error("Get: expected an error after Clear")
+	}
+}
@@ -0,0 +1,339 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultStreamBatchSize is the maximum number of events that Consume handles in one transaction
+	DefaultStreamBatchSize = 100
+
+	// DefaultStreamPollInterval is how often Consume checks for new events, when
+	// it can not be notified about them
+	DefaultStreamPollInterval = time.Second
+
+	// streamOffsetsTable is where the offsets of the consumers of all streams are stored
+	streamOffsetsTable = "simplehstore_stream_offsets"
+)
+
+// streamFunctions append an event to a stream table for each key that is
+// inserted, updated or deleted in an HSTORE table, and for the table being
+// truncated. A transaction level advisory lock makes the offsets follow the
+// commit order, so that a consumer never moves past an offset that belongs
+// to a transaction that has not been committed yet.
+const streamFunctions = `
+CREATE OR REPLACE FUNCTION simplehstore_stream_row() RETURNS trigger AS $$
+DECLARE
+	k text;
+	old_attr hstore := hstore('');
+	new_attr hstore := hstore('');
+	query text := format('INSERT INTO %s (op, key, old_value, new_value) VALUES ($1, $2, $3, $4)', TG_ARGV[0]);
+BEGIN
+	IF TG_OP <> 'INSERT' THEN
+		old_attr := COALESCE(OLD.attr, hstore(''));
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		new_attr := COALESCE(NEW.attr, hstore(''));
+	END IF;
+	PERFORM pg_advisory_xact_lock(hashtext('simplehstore_stream'), hashtext(TG_ARGV[0]));
+	FOR k IN SELECT skeys(new_attr - old_attr) LOOP
+		EXECUTE query USING CASE WHEN old_attr ? k THEN 'update' ELSE 'insert' END, k, old_attr -> k, new_attr -> k;
+	END LOOP;
+	FOR k IN SELECT skeys(old_attr) EXCEPT SELECT skeys(new_attr) LOOP
+		EXECUTE query USING 'delete', k, old_attr -> k, NULL::text;
+	END LOOP;
+	PERFORM pg_notify(TG_ARGV[1], '');
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION simplehstore_stream_truncate() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_advisory_xact_lock(hashtext('simplehstore_stream'), hashtext(TG_ARGV[0]));
+	EXECUTE format('INSERT INTO %s (op) VALUES (''clear'')', TG_ARGV[0]);
+	PERFORM pg_notify(TG_ARGV[1], '');
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+`
+
+// StreamEvent is a change event together with its position in a ChangeStream
+type StreamEvent struct {
+	ChangeEvent
+	Offset int64
+}
+
+// ChangeStream is a durable log of the changes to a key/value or HashMap2.
+// The changes are appended by triggers, in the same transaction as the change
+// itself, so that no changes are lost, also while no consumer is running.
+// Each consumer has its own offset, which is stored in the database.
+//
+// Writes to a data structure with a stream are serialized, so that the
+// offsets follow the commit order. Events are kept until they are removed
+// with Trim.
+type ChangeStream struct {
+	host      *Host
+	table     string // the quoted table with the events
+	convert   func(StreamEvent) StreamEvent
+	decodeAll func([]string) error
+	batchSize int
+}
+
+// streamTable returns the quoted table with the change events of the key/value
+func (kv *KeyValue) streamTable() string {
+	return kv.qualify(pq.QuoteIdentifier(kvPrefix + kv.table + "_stream"))
+}
+
+// stream creates the stream table and the triggers that append to it, if they
+// do not already exist, and returns a ChangeStream that converts the events
+func (kv *KeyValue) stream(convert func(StreamEvent) StreamEvent) (*ChangeStream, error) {
+	table := kv.streamTable()
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id BIGSERIAL PRIMARY KEY, op TEXT NOT NULL, key TEXT NOT NULL DEFAULT '', old_value TEXT, new_value TEXT, created TIMESTAMPTZ NOT NULL DEFAULT now())", table)
+	if _, err := kv.host.exec(query); err != nil {
+		return nil, err
+	}
+	query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (stream TEXT NOT NULL, consumer TEXT NOT NULL, position BIGINT NOT NULL DEFAULT 0, PRIMARY KEY (stream, consumer))", streamOffsetsTable)
+	if _, err := kv.host.exec(query); err != nil {
+		return nil, err
+	}
+	// The table name is passed to the trigger functions as a string literal
+	literal := strings.ReplaceAll(table, "'", "''")
+	channel := watchChannel(table)
+	if err := kv.host.createTriggers(kv.tableName(), streamFunctions, map[string]string{
+		"simplehstore_stream":          fmt.Sprintf("AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE PROCEDURE simplehstore_stream_row('%s', '%s')", kv.tableName(), literal, channel),
+		"simplehstore_stream_truncate": fmt.Sprintf("AFTER TRUNCATE ON %s FOR EACH STATEMENT EXECUTE PROCEDURE simplehstore_stream_truncate('%s', '%s')", kv.tableName(), literal, channel),
+	}); err != nil {
+		return nil, err
+	}
+	return &ChangeStream{host: kv.host, table: table, convert: convert, decodeAll: kv.decodeAll, batchSize: DefaultStreamBatchSize}, nil
+}
+
+// removeStream removes the stream table and the offsets of its consumers, if they exist
+func (kv *KeyValue) removeStream() error {
+	if _, err := kv.host.exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", kv.streamTable())); err != nil {
+		return err
+	}
+	found, err := kv.exists(streamOffsetsTable)
+	if err != nil || !found {
+		return err
+	}
+	_, err = kv.host.exec(fmt.Sprintf("DELETE FROM %s WHERE stream = $1", streamOffsetsTable), kv.streamTable())
+	return err
+}
+
+// Stream returns a durable stream of the changes to the key/value, which is
+// created if it does not already exist. Only changes that are made after the
+// stream is created are a part of it.
+func (kv *KeyValue) Stream() (*ChangeStream, error) {
+	return kv.stream(func(e StreamEvent) StreamEvent {
+		e.Kind, e.Name = "keyvalue", kv.table
+		return e
+	})
+}
+
+// Stream returns a durable stream of the changes to the properties, which is
+// created if it does not already exist. Only changes that are made after the
+// stream is created are a part of it. See also Watch, which is lighter, but
+// loses the events that happen while no one is listening.
+func (hm2 *HashMap2) Stream() (*ChangeStream, error) {
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(hm2.table, "_properties_HSTORE_map")
+	return hm2.keyValue().stream(func(e StreamEvent) StreamEvent {
+		e.Kind, e.Name = "hashmap2", name
+		if pos := strings.Index(e.Key, fieldSep); pos != -1 {
+			e.Owner, e.Key = e.Key[:pos], e.Key[pos+len(fieldSep):]
+		}
+		return e
+	})
+}
+
+// SetBatchSize sets the maximum number of events that Consume handles in one transaction
+func (s *ChangeStream) SetBatchSize(n int) {
+	s.batchSize = n
+}
+
+// read returns up to n events after the given offset
+func (s *ChangeStream) read(ctx context.Context, q queryer, offset int64, n int) ([]StreamEvent, error) {
+	query := s.host.annotate(ctx, fmt.Sprintf("SELECT id, op, key, COALESCE(old_value, ''), COALESCE(new_value, '') FROM %s WHERE id > $1 ORDER BY id LIMIT %d", s.table, n))
+	rows, err := q.QueryContext(ctx, query, offset)
+	if err != nil {
+		return nil, wrapDriverError(err)
+	}
+	events := []StreamEvent{}
+	for rows.Next() {
+		var e StreamEvent
+		if err := rows.Scan(&e.Offset, &e.Op, &e.Key, &e.Old, &e.New); err != nil {
+			rows.Close()
+			return events, err
+		}
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return events, err
+	}
+	// The values are decoded after the rows are closed, since decoding may run queries
+	var (
+		values  []string
+		targets []*string
+	)
+	for i := range events {
+		for _, value := range []*string{&events[i].Old, &events[i].New} {
+			if *value != "" {
+				values = append(values, *value)
+				targets = append(targets, value)
+			}
+		}
+	}
+	if err := s.decodeAll(values); err != nil {
+		return events, err
+	}
+	for i, target := range targets {
+		*target = values[i]
+	}
+	for i := range events {
+		events[i] = s.convert(events[i])
+	}
+	return events, nil
+}
+
+// head returns the offset of the last event in the stream, or 0
+func (s *ChangeStream) head() (int64, error) {
+	var offset int64
+	err := s.host.queryRow(fmt.Sprintf("SELECT COALESCE(MAX(id), 0) FROM %s", s.table)).Scan(&offset)
+	return offset, err
+}
+
+// Offset returns the offset of the last event that the consumer has acknowledged, or 0
+func (s *ChangeStream) Offset(consumer string) (int64, error) {
+	var offset int64
+	err := s.host.queryRow(fmt.Sprintf("SELECT position FROM %s WHERE stream = $1 AND consumer = $2", streamOffsetsTable), s.table, consumer).Scan(&offset)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return offset, err
+}
+
+// Read returns up to n events that the consumer has not acknowledged yet, without acknowledging them
+func (s *ChangeStream) Read(consumer string, n int) ([]StreamEvent, error) {
+	offset, err := s.Offset(consumer)
+	if err != nil {
+		return []StreamEvent{}, err
+	}
+	return s.read(s.host.context(), s.host.queryer(), offset, n)
+}
+
+// Ack acknowledges all events up to and including the given offset for the
+// consumer, so that they are not returned by Read or Consume again.
+// The offset of a consumer never moves backwards.
+func (s *ChangeStream) Ack(consumer string, offset int64) error {
+	query := fmt.Sprintf("INSERT INTO %s (stream, consumer, position) VALUES ($1, $2, $3) ON CONFLICT (stream, consumer) DO UPDATE SET position = GREATEST(%s.position, EXCLUDED.position)", streamOffsetsTable, streamOffsetsTable)
+	_, err := s.host.exec(query, s.table, consumer, offset)
+	return err
+}
+
+// Consume calls fn for each event that the consumer has not acknowledged yet,
+// in order, until ctx is cancelled or fn returns an error. Each batch of
+// events is handled in a transaction, which also moves the offset of the
+// consumer, and which is given to fn. Changes that fn makes to data structures
+// bound to the transaction with WithTx are therefore applied exactly once,
+// together with the offset. If fn returns an error, the transaction is rolled
+// back, and the batch is delivered again by the next call to Consume. Other
+// side effects may be repeated, and can use the offset of the event to
+// recognize duplicates. Only one instance of a consumer handles events at a
+// time, while other instances of the same consumer wait.
+func (s *ChangeStream) Consume(ctx context.Context, consumer string, fn func(*Transaction, StreamEvent) error) error {
+	var wakeup <-chan *pq.Notification
+	if s.host.dsn != "" {
+		listener, err := s.host.newListener(watchChannel(s.table))
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		wakeup = listener.Notify
+	}
+	for {
+		n, err := s.consumeBatch(ctx, consumer, fn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if n == s.batchSize && ctx.Err() == nil {
+			// There may be more events
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wakeup:
+		case <-time.After(DefaultStreamPollInterval):
+		}
+	}
+}
+
+// consumeBatch handles the next batch of events for the consumer in a
+// transaction, and returns the number of events that were handled
+func (s *ChangeStream) consumeBatch(ctx context.Context, consumer string, fn func(*Transaction, StreamEvent) error) (int, error) {
+	t, err := s.host.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	tx := t.Tx()
+	// Lock the offset of the consumer, so that other instances of it wait
+	if _, err := tx.ExecContext(ctx, s.host.annotate(ctx, fmt.Sprintf("INSERT INTO %s (stream, consumer) VALUES ($1, $2) ON CONFLICT DO NOTHING", streamOffsetsTable)), s.table, consumer); err != nil {
+		t.Rollback()
+		return 0, wrapDriverError(err)
+	}
+	var offset int64
+	if err := tx.QueryRowContext(ctx, s.host.annotate(ctx, fmt.Sprintf("SELECT position FROM %s WHERE stream = $1 AND consumer = $2 FOR UPDATE", streamOffsetsTable)), s.table, consumer).Scan(&offset); err != nil {
+		t.Rollback()
+		return 0, wrapDriverError(err)
+	}
+	events, err := s.read(ctx, tx, offset, s.batchSize)
+	if err != nil {
+		t.Rollback()
+		return 0, err
+	}
+	for _, e := range events {
+		if err := fn(t, e); err != nil {
+			t.Rollback()
+			return 0, err
+		}
+	}
+	if len(events) > 0 {
+		last := events[len(events)-1].Offset
+		if _, err := tx.ExecContext(ctx, s.host.annotate(ctx, fmt.Sprintf("UPDATE %s SET position = $3 WHERE stream = $1 AND consumer = $2", streamOffsetsTable)), s.table, consumer, last); err != nil {
+			t.Rollback()
+			return 0, wrapDriverError(err)
+		}
+	}
+	return len(events), t.Commit()
+}
+
+// Trim removes the events that all consumers have acknowledged, and returns
+// how many were removed. Events are kept if there are no consumers.
+func (s *ChangeStream) Trim() (int64, error) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE id <= (SELECT MIN(position) FROM %s WHERE stream = $1)", s.table, streamOffsetsTable)
+	result, err := s.host.exec(query, s.table)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RemoveConsumer removes the offset of the consumer, so that it no longer
+// keeps Trim from removing events
+func (s *ChangeStream) RemoveConsumer(consumer string) error {
+	_, err := s.host.exec(fmt.Sprintf("DELETE FROM %s WHERE stream = $1 AND consumer = $2", streamOffsetsTable), s.table, consumer)
+	return err
+}
@@ -0,0 +1,102 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Transaction is a database transaction that spans several data structures.
+// Data structures are bound to the transaction with their WithTx method,
+// and all operations on the bound data structures are then committed or
+// rolled back together.
+type Transaction struct {
+	tx  *sql.Tx
+	ctx context.Context
+}
+
+// Begin starts a new transaction, see BeginTx
+func (host *Host) Begin() (*Transaction, error) {
+	return host.BeginTx(host.context(), nil)
+}
+
+// BeginTx starts a new transaction with the given context and options.
+// ErrNestedTransaction is returned if the host is already bound to a transaction.
+func (host *Host) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Transaction, error) {
+	if host.tx != nil || host.db == nil {
+		return nil, ErrNestedTransaction
+	}
+	tx, err := host.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{tx, ctx}, nil
+}
+
+// InTransaction runs fn within a new transaction, which is committed if fn
+// returns nil, and rolled back if not
+func (host *Host) InTransaction(fn func(*Transaction) error) error {
+	t, err := host.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(t); err != nil {
+		t.Rollback()
+		return err
+	}
+	return t.Commit()
+}
+
+// Commit the transaction
+func (t *Transaction) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback the transaction
+func (t *Transaction) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// Tx returns the underlying transaction, for running other queries as part of it
+func (t *Transaction) Tx() *sql.Tx {
+	return t.tx
+}
+
+// bind returns a shallow copy of the given host, where all queries are run as part of the transaction
+func (t *Transaction) bind(host *Host) *Host {
+	return host.WithContext(t.ctx).withTx(t.tx)
+}
+
+// WithTx returns a copy of the list, where all operations are run as part of the given transaction
+func (l *List) WithTx(t *Transaction) *List {
+	boundList := *l
+	boundList.host = t.bind(l.host)
+	return &boundList
+}
+
+// WithTx returns a copy of the set, where all operations are run as part of the given transaction
+func (s *Set) WithTx(t *Transaction) *Set {
+	boundSet := *s
+	boundSet.host = t.bind(s.host)
+	return &boundSet
+}
+
+// WithTx returns a copy of the key/value, where all operations are run as part of the given transaction
+func (kv *KeyValue) WithTx(t *Transaction) *KeyValue {
+	boundKV := *kv
+	boundKV.host = t.bind(kv.host)
+	return &boundKV
+}
+
+// WithTx returns a copy of the hash map, where all operations are run as part of the given transaction
+func (h *HashMap) WithTx(t *Transaction) *HashMap {
+	boundHashMap := *h
+	boundHashMap.host = t.bind(h.host)
+	return &boundHashMap
+}
+
+// WithTx returns a copy of the hash map, where all operations are run as part of the given transaction
+func (hm2 *HashMap2) WithTx(t *Transaction) *HashMap2 {
+	boundHM2 := *hm2
+	boundHM2.host = t.bind(hm2.host)
+	return &boundHM2
+}
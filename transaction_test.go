@@ -0,0 +1,63 @@
+package simplehstore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	users, err := NewHashMap2(host, "transaction_test_users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.Clear()
+	audit, err := NewList(host, "transaction_test_audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.Clear()
+
+	// Both changes are rolled back together
+	errAbort := errors.New("abort")
+	err = host.InTransaction(func(tx *Transaction) error {
+		if err := users.WithTx(tx).Set("bob", "email", "bob@example.com"); err != nil {
+			return err
+		}
+		if err := audit.WithTx(tx).Add("bob changed his e-mail address"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Errorf("Error, expected the error from fn, got %v", err)
+	}
+	if has, err := users.Has("bob", "email"); err != nil || has {
+		t.Errorf("Error, expected the hash map change to be rolled back (%v)", err)
+	}
+	if n, err := audit.Count(); err != nil || n != 0 {
+		t.Errorf("Error, expected the list change to be rolled back, got %d elements (%v)", n, err)
+	}
+
+	// And committed together
+	tx, err := host.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.WithTx(tx).Set("bob", "email", "bob@example.com")
+	audit.WithTx(tx).Add("bob changed his e-mail address")
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := users.Get("bob", "email"); err != nil || s != "bob@example.com" {
+		t.Errorf("Error, expected the hash map change to be committed, got %q (%v)", s, err)
+	}
+	if n, err := audit.Count(); err != nil || n != 1 {
+		t.Errorf("Error, expected the list change to be committed, got %d elements (%v)", n, err)
+	}
+
+	users.Remove()
+	audit.Remove()
+}
@@ -0,0 +1,84 @@
+package simplehstore
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// ErrNestedTransaction is returned when trying to start a transaction on a host
+// that is already bound to a transaction
+var ErrNestedTransaction = errors.New("the host is already part of a transaction")
+
+// PrepareTransaction runs fn with a Host that is bound to a new transaction.
+// Data structures created with the given Host will run all their queries as
+// part of this transaction. If fn returns nil, the transaction is prepared for
+// a two-phase commit, using the given global transaction ID.
+// The prepared transaction can then be finished with CommitPrepared or
+// RollbackPrepared, also from another process, once the other resources that
+// take part in the distributed transaction are ready.
+// The PostgreSQL server must be configured with max_prepared_transactions > 0.
+func (host *Host) PrepareTransaction(id string, fn func(*Host) error) error {
+	if host.tx != nil || host.db == nil {
+		return ErrNestedTransaction
+	}
+	ctx := host.context()
+	// Use a single connection, since the prepared transaction is not associated with the session afterwards
+	conn, err := host.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+		return err
+	}
+	if err := fn(host.withTx(conn)); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "PREPARE TRANSACTION "+pq.QuoteLiteral(id)); err != nil {
+		conn.ExecContext(ctx, "ROLLBACK")
+		return err
+	}
+	return nil
+}
+
+// CommitPrepared commits a transaction that was prepared with PrepareTransaction
+func (host *Host) CommitPrepared(id string) error {
+	if host.db == nil {
+		return ErrNestedTransaction
+	}
+	_, err := host.db.Exec("COMMIT PREPARED " + pq.QuoteLiteral(id))
+	return err
+}
+
+// RollbackPrepared rolls back a transaction that was prepared with PrepareTransaction
+func (host *Host) RollbackPrepared(id string) error {
+	if host.db == nil {
+		return ErrNestedTransaction
+	}
+	_, err := host.db.Exec("ROLLBACK PREPARED " + pq.QuoteLiteral(id))
+	return err
+}
+
+// PreparedTransactions returns the global transaction IDs of all prepared
+// transactions in the current database that are waiting to be committed or
+// rolled back. This is useful when recovering after a crash.
+func (host *Host) PreparedTransactions() ([]string, error) {
+	rows, err := host.query("SELECT gid FROM pg_prepared_xacts WHERE database = current_database() ORDER BY prepared")
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+	var (
+		ids []string
+		id  string
+	)
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,53 @@
+package simplehstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrepareTransaction(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	list, err := NewList(host, listname)
+	if err != nil {
+		t.Error(err)
+	}
+	list.Clear()
+
+	err = host.PrepareTransaction("simplehstore_test", func(txHost *Host) error {
+		txList, err := NewList(txHost, listname)
+		if err != nil {
+			return err
+		}
+		return txList.Add(testdata1)
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "prepared transactions are disabled") {
+			t.Skip("max_prepared_transactions is 0")
+		}
+		t.Fatalf("Error, could not prepare transaction! %s", err)
+	}
+
+	// The value should not be visible before the transaction is committed
+	if items, _ := list.All(); len(items) != 0 {
+		t.Errorf("Error, expected an empty list before committing, got %v", items)
+	}
+
+	ids, err := host.PreparedTransactions()
+	if err != nil {
+		t.Error(err)
+	}
+	if !hasS(ids, "simplehstore_test") {
+		t.Errorf("Error, expected the prepared transaction to be listed, got %v", ids)
+	}
+
+	if err := host.CommitPrepared("simplehstore_test"); err != nil {
+		t.Errorf("Error, could not commit prepared transaction! %s", err)
+	}
+	if items, _ := list.All(); len(items) != 1 {
+		t.Errorf("Error, expected one item after committing, got %v", items)
+	}
+
+	list.Remove()
+}
@@ -2,6 +2,8 @@ package simplehstore
 
 import (
 	"bytes"
+	"database/sql"
+	"errors"
 	"log"
 	"strconv"
 	"strings"
@@ -170,6 +172,10 @@ func noResult(err error) bool {
 	if err == nil {
 		return false
 	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrTableMissing) || errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+	// Errors that are not marked with a sentinel error, for instance from queryRow
 	msg := err.Error()
 	return strings.Contains(msg, "does not exist") || strings.Contains(msg, "no rows")
 }
@@ -0,0 +1,152 @@
+package simplehstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// watchEvent is the payload of a notification from the watch triggers
+type watchEvent struct {
+	Op  string `json:"op"`
+	Key string `json:"key"`
+}
+
+// watchFunctions notify about each key that is inserted, updated or deleted in
+// an HSTORE table, and about the table being truncated. Only the operation and
+// the key are sent, since notifications are limited to 8000 bytes.
+const watchFunctions = `
+CREATE OR REPLACE FUNCTION simplehstore_watch_row() RETURNS trigger AS $$
+DECLARE
+	k text;
+	old_attr hstore := hstore('');
+	new_attr hstore := hstore('');
+BEGIN
+	IF TG_OP <> 'INSERT' THEN
+		old_attr := COALESCE(OLD.attr, hstore(''));
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		new_attr := COALESCE(NEW.attr, hstore(''));
+	END IF;
+	FOR k IN SELECT skeys(new_attr - old_attr) LOOP
+		PERFORM pg_notify(TG_ARGV[0], json_build_object('op', CASE WHEN old_attr ? k THEN 'update' ELSE 'insert' END, 'key', k)::text);
+	END LOOP;
+	FOR k IN SELECT skeys(old_attr) EXCEPT SELECT skeys(new_attr) LOOP
+		PERFORM pg_notify(TG_ARGV[0], json_build_object('op', 'delete', 'key', k)::text);
+	END LOOP;
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION simplehstore_watch_truncate() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify(TG_ARGV[0], '{"op":"clear"}');
+	RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+`
+
+// watchChannel returns the notification channel for the given quoted table.
+// The table name is hashed, since channel names are limited to 63 bytes.
+func watchChannel(quotedTable string) string {
+	sum := sha256.Sum256([]byte(quotedTable))
+	return fmt.Sprintf("simplehstore_watch_%x", sum[:8])
+}
+
+// installWatchTriggers creates the triggers that send notifications when the
+// given HSTORE table is changed, if they do not already exist
+func (host *Host) installWatchTriggers(quotedTable string) error {
+	channel := watchChannel(quotedTable)
+	return host.createTriggers(quotedTable, watchFunctions, map[string]string{
+		"simplehstore_watch":          fmt.Sprintf("AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE PROCEDURE simplehstore_watch_row('%s')", quotedTable, channel),
+		"simplehstore_watch_truncate": fmt.Sprintf("AFTER TRUNCATE ON %s FOR EACH STATEMENT EXECUTE PROCEDURE simplehstore_watch_truncate('%s')", quotedTable, channel),
+	})
+}
+
+// createTriggers creates or replaces the given trigger functions, and creates
+// the given triggers, by name, on the given table if they do not already exist
+func (host *Host) createTriggers(quotedTable, functions string, triggers map[string]string) error {
+	if _, err := host.exec(functions); err != nil {
+		return err
+	}
+	for name, trigger := range triggers {
+		var exists bool
+		if err := host.queryRow("SELECT EXISTS (SELECT 1 FROM pg_trigger WHERE tgrelid = to_regclass($1) AND tgname = $2)", quotedTable, name).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := host.exec(fmt.Sprintf("CREATE TRIGGER %s %s", name, trigger)); err != nil && !strings.HasSuffix(err.Error(), "already exists") {
+			return err
+		}
+	}
+	return nil
+}
+
+// watch installs the triggers for the given HSTORE table, and returns a
+// channel with the change events, which is closed when ctx is cancelled
+func (host *Host) watch(ctx context.Context, quotedTable string, convert func(watchEvent) ChangeEvent) (<-chan ChangeEvent, error) {
+	if host.dsn == "" {
+		return nil, ErrNoConnectionString
+	}
+	if err := host.installWatchTriggers(quotedTable); err != nil {
+		return nil, err
+	}
+	// Listen before returning, so that no changes made after Watch returns are missed
+	listener, err := host.newListener(watchChannel(quotedTable))
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan ChangeEvent)
+	go func() {
+		defer close(events)
+		defer listener.Close()
+		serve(ctx, listener, func(payload string) {
+			var e watchEvent
+			if payload == "" {
+				// The connection was re-established, and events may have been lost
+				e.Op = "reset"
+			} else if err := json.Unmarshal([]byte(payload), &e); err != nil {
+				return
+			}
+			select {
+			case events <- convert(e):
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return events, nil
+}
+
+// Watch returns a channel with an event for each property that is set or
+// deleted, by this or any other process, until ctx is cancelled. The events
+// have the owner and the key, but not the values, which can be fetched with Get.
+// An event with the "clear" operation is sent when the hash map is cleared, and
+// an event with the "reset" operation is sent when events may have been lost,
+// after the connection to the server has been re-established. In both cases,
+// any cached data for the hash map should be considered stale.
+// Watch is only available for a Host that is created from a connection string.
+func (hm2 *HashMap2) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	if err := hm2.requireHstoreLayout(); err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(hm2.table, "_properties_HSTORE_map")
+	return hm2.host.watch(ctx, hm2.keyValue().tableName(), func(e watchEvent) ChangeEvent {
+		event := ChangeEvent{Op: e.Op, Kind: "hashmap2", Name: name, Key: e.Key}
+		if pos := strings.Index(e.Key, fieldSep); pos != -1 {
+			event.Owner, event.Key = e.Key[:pos], e.Key[pos+len(fieldSep):]
+		}
+		return event
+	})
+}
+
+// Watch returns a channel with an event for each key that is set or deleted,
+// by this or any other process, until ctx is cancelled. See HashMap2.Watch.
+func (kv *KeyValue) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	return kv.host.watch(ctx, kv.tableName(), func(e watchEvent) ChangeEvent {
+		return ChangeEvent{Op: e.Op, Kind: "keyvalue", Name: kv.table, Key: e.Key}
+	})
+}
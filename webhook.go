@@ -0,0 +1,246 @@
+package simplehstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Default settings for webhook delivery
+const (
+	DefaultWebhookMaxAttempts = 8
+	DefaultWebhookMinBackoff  = time.Second
+	DefaultWebhookMaxBackoff  = time.Hour
+	DefaultPollInterval       = time.Second
+	DefaultWebhookTimeout     = 30 * time.Second
+)
+
+// webhookVisibility returns how long a webhook that is being delivered is
+// hidden from other workers, which is twice the timeout of the HTTP client,
+// so that slow endpoints are not sent the same webhook twice
+func webhookVisibility(timeout time.Duration) time.Duration {
+	return 2 * timeout
+}
+
+// WebhookQueue delivers webhooks, by POSTing JSON payloads to URLs, with
+// retries and exponential backoff. Webhooks that can not be delivered after
+// the maximum number of attempts are kept as dead letters.
+type WebhookQueue struct {
+	queue        *Queue
+	client       *http.Client
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	pollInterval time.Duration
+}
+
+// Delivery is the delivery status of a webhook
+type Delivery struct {
+	ID        int64
+	URL       string
+	Status    string // JobReady, JobDone or JobDead
+	Attempts  int
+	LastError string
+	Created   time.Time
+}
+
+// webhook is what is stored in the queue for each webhook
+type webhook struct {
+	URL     string          `json:"url"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// NewWebhookQueue creates a new WebhookQueue
+func NewWebhookQueue(provider HostProvider, name string, opts ...Option) (*WebhookQueue, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	queue, err := NewQueue(host, name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	queue.SetMaxAttempts(DefaultWebhookMaxAttempts)
+	queue.SetVisibilityTimeout(webhookVisibility(DefaultWebhookTimeout))
+	return &WebhookQueue{
+		queue:        queue,
+		client:       &http.Client{Timeout: DefaultWebhookTimeout},
+		minBackoff:   DefaultWebhookMinBackoff,
+		maxBackoff:   DefaultWebhookMaxBackoff,
+		pollInterval: DefaultPollInterval,
+	}, nil
+}
+
+// SetClient sets the HTTP client that is used for delivering webhooks.
+// If the client has a timeout, webhooks that are being delivered are hidden
+// from other workers for twice that time. A client without a timeout keeps
+// the current visibility timeout, which should then be longer than any
+// delivery can take.
+func (wq *WebhookQueue) SetClient(client *http.Client) {
+	wq.client = client
+	if client.Timeout > 0 {
+		wq.queue.SetVisibilityTimeout(webhookVisibility(client.Timeout))
+	}
+}
+
+// SetMaxAttempts sets how many times delivery is attempted before a webhook is dead-lettered
+func (wq *WebhookQueue) SetMaxAttempts(maxAttempts int) {
+	wq.queue.SetMaxAttempts(maxAttempts)
+}
+
+// SetBackoff sets the delay after the first failed attempt, and the maximum delay.
+// The delay is doubled for every failed attempt.
+func (wq *WebhookQueue) SetBackoff(min, max time.Duration) {
+	wq.minBackoff = min
+	wq.maxBackoff = max
+}
+
+// SetPollInterval sets how long Run waits before checking again, when there are no webhooks to deliver
+func (wq *WebhookQueue) SetPollInterval(interval time.Duration) {
+	wq.pollInterval = interval
+}
+
+// backoff returns the delay before the next attempt, after the given number of attempts
+func backoff(attempts int, min, max time.Duration) time.Duration {
+	delay := min
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= max || delay <= 0 {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// Enqueue adds a webhook that POSTs the given JSON payload to the given URL
+func (wq *WebhookQueue) Enqueue(url string, payload []byte) (int64, error) {
+	if !json.Valid(payload) {
+		return 0, fmt.Errorf("the webhook payload for %s is not valid JSON", url)
+	}
+	data, err := json.Marshal(webhook{url, payload})
+	if err != nil {
+		return 0, err
+	}
+	return wq.queue.Push(string(data))
+}
+
+// delivery converts a job to a Delivery
+func delivery(job *Job) (*Delivery, error) {
+	var w webhook
+	if err := json.Unmarshal([]byte(job.Payload), &w); err != nil {
+		return nil, err
+	}
+	return &Delivery{job.ID, w.URL, job.Status, job.Attempts, job.LastError, job.Created}, nil
+}
+
+// Status returns the delivery status of the webhook with the given ID
+func (wq *WebhookQueue) Status(id int64) (*Delivery, error) {
+	job, err := wq.queue.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return delivery(job)
+}
+
+// DeadLetters returns up to limit webhooks that could not be delivered, oldest first
+func (wq *WebhookQueue) DeadLetters(limit int) ([]*Delivery, error) {
+	jobs, err := wq.queue.Inspect(JobDead, limit)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]*Delivery, 0, len(jobs))
+	for _, job := range jobs {
+		d, err := delivery(job)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// Redeliver makes a dead webhook ready to be delivered again
+func (wq *WebhookQueue) Redeliver(id int64) error {
+	return wq.queue.Requeue(id)
+}
+
+// deliver POSTs the webhook, and returns an error if it was not accepted
+func (wq *WebhookQueue) deliver(ctx context.Context, w *webhook) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(w.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wq.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s replied with %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// DeliverNext delivers the next webhook that is ready, if any.
+// ErrEmptyQueue is returned if there are no webhooks to deliver.
+func (wq *WebhookQueue) DeliverNext(ctx context.Context) error {
+	job, err := wq.queue.Claim()
+	if err != nil {
+		return err
+	}
+	var w webhook
+	if err := json.Unmarshal([]byte(job.Payload), &w); err != nil {
+		return job.Fail(err.Error())
+	}
+	if err := wq.deliver(ctx, &w); err != nil {
+		if ctx.Err() != nil {
+			// The delivery was interrupted, which does not count as an attempt
+			if err := job.Release(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+		if wq.queue.verbose() {
+			wq.queue.host.Logger().Printf("Webhook %d, attempt %d: %s\n", job.ID, job.Attempts, err)
+		}
+		return job.Retry(backoff(job.Attempts, wq.minBackoff, wq.maxBackoff), err.Error())
+	}
+	return job.Ack()
+}
+
+// Run delivers webhooks until ctx is cancelled. Several workers may call Run
+// at the same time, also from different processes.
+func (wq *WebhookQueue) Run(ctx context.Context) error {
+	for {
+		err := wq.DeliverNext(ctx)
+		if err == nil {
+			continue
+		}
+		if err != ErrEmptyQueue && ctx.Err() == nil && wq.queue.verbose() {
+			wq.queue.host.Logger().Println("Webhook delivery: " + err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wq.pollInterval):
+		}
+	}
+}
+
+// Remove the queue
+func (wq *WebhookQueue) Remove() error {
+	return wq.queue.Remove()
+}
+
+// Clear all webhooks
+func (wq *WebhookQueue) Clear() error {
+	return wq.queue.Clear()
+}
@@ -0,0 +1,66 @@
+package simplehstore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	if d := backoff(1, time.Second, time.Minute); d != time.Second {
+		t.Errorf("Error, expected 1s after the first attempt, got %s", d)
+	}
+	if d := backoff(4, time.Second, time.Minute); d != 8*time.Second {
+		t.Errorf("Error, expected 8s after the fourth attempt, got %s", d)
+	}
+	if d := backoff(100, time.Second, time.Minute); d != time.Minute {
+		t.Errorf("Error, expected the maximum delay, got %s", d)
+	}
+}
+
+func TestWebhookQueue(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received <- struct{}{}
+	}))
+	defer server.Close()
+
+	wq, err := NewWebhookQueue(host, "webhook_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wq.Clear()
+	wq.SetMaxAttempts(1)
+
+	if _, err := wq.Enqueue(server.URL, []byte("not json")); err == nil {
+		t.Error("Error, the payload should be rejected")
+	}
+	id, err := wq.Enqueue(server.URL+"/ok", []byte(`{"event":"created"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wq.DeliverNext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-received
+	if d, err := wq.Status(id); err != nil || d.Status != JobDone {
+		t.Errorf("Error, expected the webhook to be delivered: %v (%v)", d, err)
+	}
+
+	id, _ = wq.Enqueue(server.URL+"/fail", []byte(`{}`))
+	wq.DeliverNext(context.Background())
+	if dead, err := wq.DeadLetters(10); err != nil || len(dead) != 1 || dead[0].ID != id {
+		t.Errorf("Error, expected one dead letter: %v (%v)", dead, err)
+	}
+
+	wq.Remove()
+}
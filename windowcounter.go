@@ -0,0 +1,123 @@
+package simplehstore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultWindowResolution is the length of each bucket of a WindowCounter, by default
+	DefaultWindowResolution = 10 * time.Second
+	// DefaultWindowRetention is how long the buckets of a WindowCounter are kept, by default
+	DefaultWindowRetention = 24 * time.Hour
+)
+
+// WindowCounter counts events per key in timestamped buckets, so that the
+// number of events within a sliding window can be found, like the number of
+// requests per user in the last 5 minutes. Buckets that are older than the
+// retention are pruned when a key is incremented, or by calling Prune.
+type WindowCounter struct {
+	dbDatastructure
+	resolution time.Duration
+	retention  time.Duration
+}
+
+// NewWindowCounter creates a new sliding window counter
+func NewWindowCounter(provider HostProvider, name string, opts ...Option) (*WindowCounter, error) {
+	host, err := hostOf(provider)
+	if err != nil {
+		return nil, err
+	}
+	wc := &WindowCounter{newDatastructure(host, pq.QuoteIdentifier(name), opts), DefaultWindowResolution, DefaultWindowRetention}
+	if err := wc.createSchema(); err != nil {
+		return nil, err
+	}
+	existed, err := wc.exists(wc.tableName())
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("%s %s (key TEXT NOT NULL, bucket TIMESTAMPTZ NOT NULL, count BIGINT NOT NULL, PRIMARY KEY (key, bucket))", wc.createTable(), wc.tableName())
+	if _, err := wc.host.exec(query); err != nil {
+		return nil, err
+	}
+	if err := wc.setStorageParameters(wc.tableName()); err != nil {
+		return nil, err
+	}
+	if err := wc.register("windowcounter", name, wc.tableName(), !existed); err != nil {
+		return nil, err
+	}
+	if wc.verbose() {
+		wc.host.Logger().Println("Created window counter table " + wc.tableName() + " in database " + host.dbname)
+	}
+	return wc, nil
+}
+
+// SetResolution sets the length of each bucket. Counts are only as precise
+// as the resolution, and a shorter resolution means more rows per key.
+func (wc *WindowCounter) SetResolution(resolution time.Duration) {
+	wc.resolution = resolution
+}
+
+// SetRetention sets how long buckets are kept. This must be at least as long
+// as the longest window that is given to CountLast.
+func (wc *WindowCounter) SetRetention(retention time.Duration) {
+	wc.retention = retention
+}
+
+// Incr counts one event for the given key
+func (wc *WindowCounter) Incr(key string) error {
+	return wc.IncrBy(key, 1)
+}
+
+// IncrBy counts n events for the given key, and prunes the old buckets of the key
+func (wc *WindowCounter) IncrBy(key string, n int64) error {
+	query := fmt.Sprintf("WITH pruned AS (DELETE FROM %s WHERE key = $1 AND bucket < now() - $2 * interval '1 microsecond') INSERT INTO %s AS c (key, bucket, count) VALUES ($1, to_timestamp(floor(extract(epoch FROM now()) / $3) * $3), $4) ON CONFLICT (key, bucket) DO UPDATE SET count = c.count + EXCLUDED.count", wc.tableName(), wc.tableName())
+	_, err := wc.host.exec(query, key, wc.retention.Microseconds(), wc.resolution.Seconds(), n)
+	return err
+}
+
+// CountLast returns the number of events for the given key within the given
+// window, up until now. The bucket that overlaps the start of the window is
+// included, so the count may include events that are up to one resolution
+// older than the window.
+func (wc *WindowCounter) CountLast(key string, window time.Duration) (int64, error) {
+	var count sql.NullInt64
+	query := fmt.Sprintf("SELECT SUM(count) FROM %s WHERE key = $1 AND bucket > now() - $2 * interval '1 microsecond'", wc.tableName())
+	if err := wc.host.queryRow(query, key, (window + wc.resolution).Microseconds()).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count.Int64, nil
+}
+
+// Prune removes all buckets that are older than the retention, for all keys,
+// and returns the number of buckets that were removed
+func (wc *WindowCounter) Prune() (int64, error) {
+	result, err := wc.host.exec(fmt.Sprintf("DELETE FROM %s WHERE bucket < now() - $1 * interval '1 microsecond'", wc.tableName()), wc.retention.Microseconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Del removes all buckets for the given key
+func (wc *WindowCounter) Del(key string) error {
+	_, err := wc.host.exec(fmt.Sprintf("DELETE FROM %s WHERE key = $1", wc.tableName()), key)
+	return err
+}
+
+// Remove the window counter
+func (wc *WindowCounter) Remove() error {
+	if _, err := wc.host.exec(fmt.Sprintf("DROP TABLE %s", wc.tableName())); err != nil {
+		return err
+	}
+	return wc.unregister("windowcounter", wc.tableName())
+}
+
+// Clear all counts
+func (wc *WindowCounter) Clear() error {
+	_, err := wc.host.exec(fmt.Sprintf("TRUNCATE TABLE %s", wc.tableName()))
+	return err
+}
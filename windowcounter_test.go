@@ -0,0 +1,42 @@
+package simplehstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowCounter(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	wc, err := NewWindowCounter(host, "windowcounter_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.Clear()
+	wc.SetResolution(time.Second)
+	for i := 0; i < 3; i++ {
+		if err := wc.Incr("bob"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wc.IncrBy("alice", 5)
+	if n, err := wc.CountLast("bob", time.Minute); err != nil || n != 3 {
+		t.Errorf("Error, expected 3 events for bob, got %d (%v)", n, err)
+	}
+	if n, err := wc.CountLast("carol", time.Minute); err != nil || n != 0 {
+		t.Errorf("Error, expected no events for carol, got %d (%v)", n, err)
+	}
+
+	// Buckets older than the retention are pruned
+	time.Sleep(1100 * time.Millisecond)
+	wc.SetRetention(time.Millisecond)
+	if n, err := wc.Prune(); err != nil || n < 2 {
+		t.Errorf("Error, expected at least two buckets to be pruned, got %d (%v)", n, err)
+	}
+	if n, err := wc.CountLast("alice", time.Minute); err != nil || n != 0 {
+		t.Errorf("Error, expected no events for alice after pruning, got %d (%v)", n, err)
+	}
+
+	wc.Remove()
+}
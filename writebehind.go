@@ -0,0 +1,259 @@
+package simplehstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrBufferClosed is returned when adding to a write-behind buffer that has been closed
+var ErrBufferClosed = errors.New("the buffer has been closed")
+
+// writeBehind sums increments per key in memory, and writes the sums with
+// the given flush function every interval, and when it is closed. The sums
+// are kept if the flush fails, and are retried with the next flush.
+type writeBehind struct {
+	host     *Host
+	flushFn  func(map[string]int64) error
+	flushMut sync.Mutex // only one flush runs at a time
+
+	mut     sync.Mutex
+	pending map[string]int64
+	closed  bool
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newWriteBehind creates a write-behind buffer and starts flushing it every interval
+func newWriteBehind(host *Host, interval time.Duration, flushFn func(map[string]int64) error) *writeBehind {
+	w := &writeBehind{host: host, flushFn: flushFn, pending: make(map[string]int64), done: make(chan struct{}), stopped: make(chan struct{})}
+	go w.run(interval)
+	return w
+}
+
+// run flushes the buffer every interval, until the buffer is closed
+func (w *writeBehind) run(interval time.Duration) {
+	defer close(w.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			if err := w.flush(); err != nil {
+				w.host.Logger().Println("Could not flush write-behind buffer, retrying later:", err)
+			}
+		}
+	}
+}
+
+// add adds n to the sum for the given key
+func (w *writeBehind) add(key string, n int64) error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.closed {
+		return ErrBufferClosed
+	}
+	w.pending[key] += n
+	return nil
+}
+
+// flush writes the sums, and puts them back if that fails
+func (w *writeBehind) flush() error {
+	w.flushMut.Lock()
+	defer w.flushMut.Unlock()
+	w.mut.Lock()
+	pending := w.pending
+	w.pending = make(map[string]int64)
+	w.mut.Unlock()
+	for key, n := range pending {
+		if n == 0 {
+			delete(pending, key)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := w.flushFn(pending); err != nil {
+		w.mut.Lock()
+		for key, n := range pending {
+			w.pending[key] += n
+		}
+		w.mut.Unlock()
+		return err
+	}
+	return nil
+}
+
+// close stops the periodic flushing, and flushes what is left
+func (w *writeBehind) close() error {
+	w.mut.Lock()
+	if w.closed {
+		w.mut.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mut.Unlock()
+	close(w.done)
+	<-w.stopped
+	return w.flush()
+}
+
+// BufferedCounter buffers changes to a Counter in memory, and adds them to
+// the counter with a single statement every interval, for workloads like
+// view counters, where a round trip per increment would dominate the load on
+// the database. Changes that have not been flushed yet are not included in
+// the value of the counter, and are lost if the process exits without
+// calling Close.
+type BufferedCounter struct {
+	w *writeBehind
+}
+
+// Buffered returns a BufferedCounter that flushes the changes to this counter every interval
+func (c *Counter) Buffered(interval time.Duration) *BufferedCounter {
+	return &BufferedCounter{newWriteBehind(c.host, interval, func(pending map[string]int64) error {
+		_, err := c.Add(pending[""])
+		return err
+	})}
+}
+
+// Inc increases the counter by 1
+func (b *BufferedCounter) Inc() error {
+	return b.w.add("", 1)
+}
+
+// Dec decreases the counter by 1
+func (b *BufferedCounter) Dec() error {
+	return b.w.add("", -1)
+}
+
+// Add adds n to the counter, which may be negative
+func (b *BufferedCounter) Add(n int64) error {
+	return b.w.add("", n)
+}
+
+// Flush writes the buffered changes now
+func (b *BufferedCounter) Flush() error {
+	return b.w.flush()
+}
+
+// Close stops the periodic flushing, and writes the buffered changes.
+// This should be called before the process exits.
+func (b *BufferedCounter) Close() error {
+	return b.w.close()
+}
+
+// BufferedCounters buffers increments of the numeric values of keys in a
+// KeyValue in memory, and writes them every interval, with a single UPDATE
+// for all keys, for workloads like view counters per page. Increments that
+// have not been flushed yet are not included in the values of the keys, and
+// are lost if the process exits without calling Close.
+type BufferedCounters struct {
+	w *writeBehind
+}
+
+// BufferedCounters returns a BufferedCounters that flushes the increments of keys in this KeyValue every interval
+func (kv *KeyValue) BufferedCounters(interval time.Duration) *BufferedCounters {
+	return &BufferedCounters{newWriteBehind(kv.host, interval, kv.addAll)}
+}
+
+// Inc increases the numeric value of the key by 1
+func (b *BufferedCounters) Inc(key string) error {
+	return b.w.add(key, 1)
+}
+
+// IncBy adds n to the numeric value of the key, which may be negative
+func (b *BufferedCounters) IncBy(key string, n int64) error {
+	return b.w.add(key, n)
+}
+
+// Flush writes the buffered increments now
+func (b *BufferedCounters) Flush() error {
+	return b.w.flush()
+}
+
+// Close stops the periodic flushing, and writes the buffered increments.
+// This should be called before the process exits.
+func (b *BufferedCounters) Close() error {
+	return b.w.close()
+}
+
+// addAll adds the given amounts to the numeric values of the keys, in a
+// single transaction. Values that are not numbers, and expired keys, count
+// as 0, like for IncBy.
+func (kv *KeyValue) addAll(amounts map[string]int64) error {
+	if err := kv.ensureRow(); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(amounts))
+	for key := range amounts {
+		keys = append(keys, key)
+	}
+	ctx := kv.host.context()
+	transaction, err := kv.host.begin(ctx)
+	if err != nil {
+		return err
+	}
+	values, err := kv.addAllWithTransaction(ctx, transaction, keys, amounts)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s SET attr = attr || hstore($1::text[], $2::text[]), expires = delete(expires, $1::text[])", kv.tableName())
+	if _, err := transaction.ExecContext(ctx, query, pq.Array(keys), pq.Array(values)); err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
+// addAllWithTransaction reads the current values of the keys while holding
+// the row lock, and returns the encoded new values, in the same order as the keys
+func (kv *KeyValue) addAllWithTransaction(ctx context.Context, transaction *txn, keys []string, amounts map[string]int64) ([]string, error) {
+	query := fmt.Sprintf("SELECT k, CASE WHEN (t.expires -> k)::timestamptz <= now() THEN NULL ELSE t.attr -> k END FROM %s AS t CROSS JOIN unnest($1::text[]) AS k FOR UPDATE OF t", kv.tableName())
+	rows, err := transaction.QueryContext(ctx, query, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	var found, stored []string
+	for rows.Next() {
+		var (
+			key   string
+			value sql.NullString
+		)
+		if err := rows.Scan(&key, &value); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if value.Valid {
+			found = append(found, key)
+			stored = append(stored, value.String)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := kv.decodeAll(stored); err != nil {
+		return nil, err
+	}
+	current := make(map[string]int64, len(keys))
+	for i, key := range found {
+		current[key], _ = strconv.ParseInt(stored[i], 10, 64)
+	}
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = strconv.FormatInt(current[key]+amounts[key], 10)
+		if err := kv.encode(&values[i]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
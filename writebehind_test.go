@@ -0,0 +1,73 @@
+package simplehstore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteBehindRetries(t *testing.T) {
+	var (
+		fail    = true
+		flushed = make(map[string]int64)
+	)
+	w := newWriteBehind(&Host{}, time.Hour, func(pending map[string]int64) error {
+		if fail {
+			return errors.New("the database is down")
+		}
+		for key, n := range pending {
+			flushed[key] += n
+		}
+		return nil
+	})
+	w.add("a", 1)
+	w.add("a", 2)
+	w.add("b", 1)
+	w.add("b", -1)
+	if err := w.flush(); err == nil {
+		t.Error("Error, expected the flush to fail")
+	}
+	w.add("a", 1)
+	fail = false
+	if err := w.close(); err != nil {
+		t.Error(err)
+	}
+	if len(flushed) != 1 || flushed["a"] != 4 {
+		t.Errorf("Error, expected only a=4 to be flushed, got %v", flushed)
+	}
+	if err := w.add("a", 1); err != ErrBufferClosed {
+		t.Errorf("Error, expected ErrBufferClosed, got %v", err)
+	}
+}
+
+func TestBufferedCounters(t *testing.T) {
+	host := NewHost(defaultConnectionString)
+	defer host.Close()
+
+	kv, err := NewKeyValue(host, "buffered_counters_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Clear()
+	kv.Set("page1", "10")
+
+	b := kv.BufferedCounters(time.Hour)
+	for i := 0; i < 5; i++ {
+		b.Inc("page1")
+		b.Inc("page2")
+	}
+	if v, _ := kv.Get("page1"); v != "10" {
+		t.Errorf("Error, expected the increments to be buffered, got %s", v)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := kv.Get("page1"); err != nil || v != "15" {
+		t.Errorf("Error, expected 15, got %s (%v)", v, err)
+	}
+	if v, err := kv.Get("page2"); err != nil || v != "5" {
+		t.Errorf("Error, expected 5, got %s (%v)", v, err)
+	}
+
+	kv.Remove()
+}